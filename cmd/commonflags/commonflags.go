@@ -18,6 +18,10 @@
 // It also holds functions to parse and validate these flag values.
 package commonflags
 
+import (
+	"time"
+)
+
 const (
 	CcompilersDefault = "clang,gcc"
 	CcompilersUsage   = `comma-separated list of C compilers, e.g. "clang,gcc"`
@@ -25,6 +29,12 @@ const (
 	FocusDefault = ""
 	FocusUsage   = `comma-separated list of tests or benchmarks (name prefixes) to focus on, e.g. "puffs_gif_decode"`
 
+	FuzzDurationDefault = 30 * time.Second
+	FuzzDurationUsage   = `how long to run each fuzz target for`
+
+	FuzzFindingsDefault = "fuzz-findings"
+	FuzzFindingsUsage   = `the directory to save inputs that crash a fuzz target to`
+
 	MimicDefault = false
 	MimicUsage   = `whether to compare Puffs' output with other libraries' output`
 
@@ -32,6 +42,12 @@ const (
 	RepsMin     = 0
 	RepsMax     = 1000000
 	RepsUsage   = `the number of repetitions per benchmark`
+
+	SanitizeDefault = ""
+	SanitizeUsage   = `comma-separated list of sanitizers to compile with, e.g. "address,undefined"`
+
+	StressIODefault = false
+	StressIOUsage   = `whether to re-run each test with its input and output sliced into 1-byte and random-size chunks`
 )
 
 // IsAlphaNumericIsh returns whether s contains only ASCII alpha-numerics and a
@@ -0,0 +1,202 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cf "github.com/google/puffs/cmd/commonflags"
+)
+
+// fuzzMaxLen is libFuzzer's maximum input length. Larger seeds, such as some
+// of test/testdata's images, are truncated.
+const fuzzMaxLen = 1 << 16
+
+// doFuzz builds and runs the fuzz targets in C code generated by "puffs-c gen
+// -fuzz_targets". See base-fuzz-impl.h in the C code generator.
+func doFuzz(args []string) error {
+	flags := flag.FlagSet{}
+	ccFlag := flags.String("cc", "clang", `the C compiler, which must be clang unless -replay is set`)
+	durationFlag := flags.Duration("duration", cf.FuzzDurationDefault, cf.FuzzDurationUsage)
+	findingsFlag := flags.String("findings", cf.FuzzFindingsDefault, cf.FuzzFindingsUsage)
+	replayFlag := flags.Bool("replay", false, `whether to only run each fuzz target once on the seeds and findings, `+
+		`instead of fuzzing, which works with any C compiler`)
+	seedsFlag := flags.String("seeds", "", `the directory of inputs to start fuzzing from, if any`)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !cf.IsAlphaNumericIsh(*ccFlag) {
+		return fmt.Errorf("bad -cc flag value %q", *ccFlag)
+	}
+	if *durationFlag < time.Second {
+		return fmt.Errorf("bad -duration flag value %v, less than 1s", *durationFlag)
+	}
+	if !*replayFlag && !strings.Contains(filepath.Base(*ccFlag), "clang") {
+		return fmt.Errorf("libFuzzer requires clang, not %q; use -replay to only replay inputs", *ccFlag)
+	}
+
+	failed := false
+	for _, filename := range flags.Args() {
+		targets, err := findPuffsFuzzTargets(filename)
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("%s: no fuzz targets", filename)
+		}
+		for _, target := range targets {
+			f, err := doFuzz1(filename, target, *ccFlag, *durationFlag, *findingsFlag, *seedsFlag, *replayFlag)
+			if err != nil {
+				return err
+			}
+			failed = failed || f
+		}
+	}
+	if failed {
+		return fmt.Errorf("%s: some fuzz targets failed", os.Args[0])
+	}
+	return nil
+}
+
+func doFuzz1(filename string, target string, cc string, duration time.Duration, findings string, seeds string,
+	replay bool) (failed bool, err error) {
+
+	workDir, err := ioutil.TempDir("", "puffs-c")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := os.MkdirAll(findings, 0755); err != nil {
+		return false, err
+	}
+	// The findings for a target are the files starting with this prefix.
+	artifactPrefix := filepath.Join(findings, target+"-")
+
+	out := filepath.Join(workDir, target)
+	ccArgs := []string{"-std=c99", "-g", "-O1", "-DPUFFS_FUZZ_TARGET=" + target}
+	if replay {
+		ccArgs = append(ccArgs, "-DPUFFS_FUZZ_MAIN", "-fsanitize=address,undefined", "-fno-sanitize-recover=all")
+	} else {
+		ccArgs = append(ccArgs, "-fsanitize=fuzzer,address")
+	}
+	ccArgs = append(ccArgs, "-o", out, filename)
+
+	ccCmd := exec.Command(cc, ccArgs...)
+	ccCmd.Stdout = os.Stdout
+	ccCmd.Stderr = os.Stderr
+	if err := ccCmd.Run(); err != nil {
+		return false, err
+	}
+
+	outArgs := []string(nil)
+	if replay {
+		inputs, err := listFuzzInputs(seeds, "")
+		if err != nil {
+			return false, err
+		}
+		found, err := listFuzzInputs(findings, filepath.Base(artifactPrefix))
+		if err != nil {
+			return false, err
+		}
+		outArgs = append(inputs, found...)
+		if len(outArgs) == 0 {
+			fmt.Printf("%-48s no inputs to replay\n", target)
+			return false, nil
+		}
+	} else {
+		corpus := filepath.Join(workDir, "corpus")
+		if err := os.Mkdir(corpus, 0755); err != nil {
+			return false, err
+		}
+		secs := int64((duration + time.Second - 1) / time.Second)
+		outArgs = append(outArgs,
+			fmt.Sprintf("-max_total_time=%d", secs),
+			fmt.Sprintf("-max_len=%d", fuzzMaxLen),
+			"-artifact_prefix="+artifactPrefix,
+			corpus,
+		)
+		if seeds != "" {
+			outArgs = append(outArgs, seeds)
+		}
+	}
+
+	outCmd := exec.Command(out, outArgs...)
+	outCmd.Stdout = os.Stdout
+	outCmd.Stderr = os.Stderr
+	if err := outCmd.Run(); err == nil {
+		if replay {
+			fmt.Printf("%-48s PASS (%d inputs replayed)\n", target, len(outArgs))
+		} else {
+			fmt.Printf("%-48s PASS (fuzzed for %v)\n", target, duration)
+		}
+	} else if _, ok := err.(*exec.ExitError); ok {
+		fmt.Printf("%-48s FAIL (see %s*)\n", target, artifactPrefix)
+		failed = true
+	} else {
+		return false, err
+	}
+	return failed, nil
+}
+
+// findPuffsFuzzTargets returns the fuzz targets listed in filename's "// !!
+// puffs fuzz target: etc" lines.
+func findPuffsFuzzTargets(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := []string(nil)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		t := s.Text()
+		const prefix = "// !! puffs fuzz target:"
+		if strings.HasPrefix(t, prefix) {
+			ret = append(ret, strings.TrimSpace(t[len(prefix):]))
+		}
+	}
+	return ret, s.Err()
+}
+
+// listFuzzInputs returns the regular files under dirname, recursively, whose
+// names start with prefix.
+func listFuzzInputs(dirname string, prefix string) ([]string, error) {
+	if dirname == "" {
+		return nil, nil
+	}
+	ret := []string(nil)
+	err := filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), prefix) {
+			ret = append(ret, path)
+		}
+		return nil
+	})
+	return ret, err
+}
@@ -0,0 +1,57 @@
+// After editing this file, run "go generate" in this directory.
+
+#ifndef PUFFS_BASE_COVERAGE_IMPL_H
+#define PUFFS_BASE_COVERAGE_IMPL_H
+
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file is only part of C code generated by "puffs-c gen -coverage". Such
+// code counts how often each Puffs statement (and each implicit, empty else
+// branch) runs, and how often each coroutine suspension point is resumed. On
+// exit, each package's counters are appended to the coverage profile named by
+// the PUFFS_COVERAGE_OUT environment variable, if set, one "position count"
+// line per counter, such as "std/gif/decode_gif.puffs:123:stmt 45". The "puffs
+// cover" and "puffs test -stress_io" commands read that profile.
+
+#include <stdio.h>
+#include <stdlib.h>
+
+// PUFFS_BASE__COVERAGE_CONSTRUCTOR marks a function that registers, before
+// main runs, an atexit handler that writes that package's counters.
+#if defined(__GNUC__)
+#define PUFFS_BASE__COVERAGE_CONSTRUCTOR __attribute__((constructor))
+#else
+#error "Puffs coverage requires __attribute__((constructor))"
+#endif
+
+static inline void puffs_base__coverage_write(const char** positions,
+                                              uint64_t* counts,
+                                              size_t n) {
+  const char* filename = getenv("PUFFS_COVERAGE_OUT");
+  if (!filename) {
+    return;
+  }
+  FILE* f = fopen(filename, "a");
+  if (!f) {
+    return;
+  }
+  size_t i;
+  for (i = 0; i < n; i++) {
+    fprintf(f, "%s %llu\n", positions[i], (unsigned long long)(counts[i]));
+  }
+  fclose(f);
+}
+
+#endif  // PUFFS_BASE_COVERAGE_IMPL_H
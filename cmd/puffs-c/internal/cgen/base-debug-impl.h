@@ -0,0 +1,55 @@
+// After editing this file, run "go generate" in this directory.
+
+#ifndef PUFFS_BASE_DEBUG_IMPL_H
+#define PUFFS_BASE_DEBUG_IMPL_H
+
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file is only part of C code generated by "puffs-c gen -debug_checks".
+// Such code re-checks, at run time, what the Puffs checker proved at compile
+// time: explicit assertions, while loop pre / inv / post conditions, index
+// bounds and refinement type bounds. A failed check is a bug in the checker
+// (or in puffs-c), not in the Puffs code being compiled, so the program aborts
+// instead of returning an error status.
+//
+// Assertions whose arithmetic could overflow in C, unlike in the ideal integer
+// math that Puffs uses for assertions, are not re-checked.
+//
+// The msg arguments are string literals like "decode_lzw.puffs:123: i < 4096".
+
+#include <stdio.h>
+#include <stdlib.h>
+
+static inline void puffs_base__debug_check(bool ok, const char* msg) {
+  if (!ok) {
+    fprintf(stderr, "puffs: debug check failed: %s\n", msg);
+    abort();
+  }
+}
+
+// puffs_base__debug_check_index returns i, after checking that i < n.
+static inline uint64_t puffs_base__debug_check_index(uint64_t i,
+                                                     uint64_t n,
+                                                     const char* msg) {
+  if (i >= n) {
+    fprintf(stderr,
+            "puffs: debug check failed: %s: index %llu out of range [0..%llu)\n",
+            msg, (unsigned long long)(i), (unsigned long long)(n));
+    abort();
+  }
+  return i;
+}
+
+#endif  // PUFFS_BASE_DEBUG_IMPL_H
@@ -0,0 +1,178 @@
+// After editing this file, run "go generate" in this directory.
+
+#ifndef PUFFS_BASE_FUZZ_IMPL_H
+#define PUFFS_BASE_FUZZ_IMPL_H
+
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file is only part of C code generated by "puffs-c gen -fuzz_targets".
+// Such code also contains a fuzz target function for each public decoder
+// method, a coroutine taking (dst writer1, src reader1), and a libFuzzer
+// entry point, LLVMFuzzerTestOneInput, that calls the fuzz target named by the
+// PUFFS_FUZZ_TARGET macro. Each target is also listed in a "// !! puffs fuzz
+// target: etc" comment, for the "puffs fuzz" command.
+//
+// The fuzz targets feed the src input, and drain the dst output, in chunks of
+// pseudo-random size, to exercise suspending and resuming the coroutines. The
+// chunk sizes are derived from a hash of the input, so that each run on the
+// same input is the same.
+//
+// Defining PUFFS_FUZZ_MAIN adds a main function that runs the fuzz target on
+// each file named by the command line arguments, or on stdin if there are
+// none. This works with fuzzers such as AFL, and with compilers other than
+// clang, and replays libFuzzer's crash files.
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define PUFFS_BASE__FUZZ_DST_LEN 65536
+
+static uint8_t puffs_base__fuzz_dst_array[PUFFS_BASE__FUZZ_DST_LEN];
+
+static inline void puffs_base__fuzz_fail(const char* msg) {
+  fprintf(stderr, "puffs: fuzz target failed: %s\n", msg);
+  abort();
+}
+
+// puffs_base__fuzz_hash returns a 64-bit FNV-1a hash of the input, used to
+// seed the chunk sizes' pseudo-random number generator.
+static inline uint64_t puffs_base__fuzz_hash(const uint8_t* data,
+                                             size_t size) {
+  uint64_t h = 0xcbf29ce484222325ull;
+  size_t i;
+  for (i = 0; i < size; i++) {
+    h ^= data[i];
+    h *= 0x100000001b3ull;
+  }
+  return h ? h : 1;
+}
+
+// puffs_base__fuzz_chunk_size returns a pseudo-random chunk size in the range
+// [1..max], biased towards small sizes, which hit the most suspension points.
+// It steps rng, an xorshift64 generator's state.
+static inline size_t puffs_base__fuzz_chunk_size(uint64_t* rng, size_t max) {
+  uint64_t x = *rng;
+  x ^= x << 13;
+  x ^= x >> 7;
+  x ^= x << 17;
+  *rng = x;
+  uint64_t n = 0;
+  switch (x & 3) {
+    case 0:
+      n = 1;
+      break;
+    case 1:
+      n = 16;
+      break;
+    case 2:
+      n = 4096;
+      break;
+    default:
+      n = max;
+      break;
+  }
+  if (n > max) {
+    n = max;
+  }
+  n = 1 + ((x >> 2) % n);
+  return (size_t)(n);
+}
+
+// puffs_base__fuzz_feed makes up to a chunk more of the input visible to the
+// src reader, closing src once all of the input is visible.
+static inline void puffs_base__fuzz_feed(puffs_base__buf1* src,
+                                         size_t size,
+                                         uint64_t* rng) {
+  if (src->wi < size) {
+    src->wi += puffs_base__fuzz_chunk_size(rng, size - src->wi);
+  }
+  src->closed = src->wi == size;
+}
+
+// puffs_base__fuzz_drain discards what the dst writer has written, and gives
+// it a chunk of room for more. If max_room is set, it gives all the room.
+static inline void puffs_base__fuzz_drain(puffs_base__buf1* dst,
+                                          uint64_t* rng,
+                                          bool max_room) {
+  dst->ri = 0;
+  dst->wi = 0;
+  dst->len = max_room
+                 ? PUFFS_BASE__FUZZ_DST_LEN
+                 : puffs_base__fuzz_chunk_size(rng, PUFFS_BASE__FUZZ_DST_LEN);
+}
+
+#ifdef PUFFS_FUZZ_TARGET
+
+void PUFFS_FUZZ_TARGET(const uint8_t* data, size_t size);
+
+int LLVMFuzzerTestOneInput(const uint8_t* data, size_t size) {
+  PUFFS_FUZZ_TARGET(data, size);
+  return 0;
+}
+
+#ifdef PUFFS_FUZZ_MAIN
+
+static int puffs_base__fuzz_run_file(const char* filename, FILE* f) {
+  size_t len = 0;
+  size_t cap = 4096;
+  uint8_t* ptr = malloc(cap);
+  while (ptr) {
+    len += fread(ptr + len, 1, cap - len, f);
+    if (len < cap) {
+      break;
+    }
+    cap *= 2;
+    uint8_t* p = realloc(ptr, cap);
+    if (!p) {
+      free(ptr);
+    }
+    ptr = p;
+  }
+  if (!ptr || ferror(f)) {
+    fprintf(stderr, "%s: could not read input\n", filename);
+    free(ptr);
+    return 1;
+  }
+  LLVMFuzzerTestOneInput(ptr, len);
+  free(ptr);
+  return 0;
+}
+
+int main(int argc, char** argv) {
+  if (argc < 2) {
+    return puffs_base__fuzz_run_file("stdin", stdin);
+  }
+  int i;
+  for (i = 1; i < argc; i++) {
+    FILE* f = fopen(argv[i], "r");
+    if (!f) {
+      fprintf(stderr, "%s: could not open input\n", argv[i]);
+      return 1;
+    }
+    int ret = puffs_base__fuzz_run_file(argv[i], f);
+    fclose(f);
+    if (ret) {
+      return ret;
+    }
+  }
+  return 0;
+}
+
+#endif  // PUFFS_FUZZ_MAIN
+
+#endif  // PUFFS_FUZZ_TARGET
+
+#endif  // PUFFS_BASE_FUZZ_IMPL_H
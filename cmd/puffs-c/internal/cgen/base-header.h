@@ -53,6 +53,24 @@ typedef struct {
   size_t len;
 } puffs_base__slice_u8;
 
+// puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64 are
+// like puffs_base__slice_u8, but for wider elements. Their len fields count
+// elements, not bytes.
+typedef struct {
+  uint16_t* ptr;
+  size_t len;
+} puffs_base__slice_u16;
+
+typedef struct {
+  uint32_t* ptr;
+  size_t len;
+} puffs_base__slice_u32;
+
+typedef struct {
+  uint64_t* ptr;
+  size_t len;
+} puffs_base__slice_u64;
+
 // puffs_base__buf1 is a 1-dimensional buffer (a pointer and length), plus
 // additional indexes into that buffer, plus an opened / closed flag.
 //
@@ -102,4 +120,41 @@ typedef struct {
   } private_impl;
 } puffs_base__writer1;
 
+// puffs_base__pixel_format describes how a pixel's bytes are laid out in
+// memory. The high 24 bits identify the format. The low 8 bits hold the number
+// of bytes per pixel.
+typedef uint32_t puffs_base__pixel_format;
+
+#define PUFFS_BASE__PIXEL_FORMAT__INVALID 0x00000000
+#define PUFFS_BASE__PIXEL_FORMAT__INDEXED_8 0x01000001
+#define PUFFS_BASE__PIXEL_FORMAT__GRAY_8 0x02000001
+#define PUFFS_BASE__PIXEL_FORMAT__RGB_888 0x03000003
+#define PUFFS_BASE__PIXEL_FORMAT__BGRA_8888 0x04000004
+#define PUFFS_BASE__PIXEL_FORMAT__RGBA_8888 0x05000004
+
+// puffs_base__buf2 is a 2-dimensional buffer of bytes, such as pixel data. Row
+// y starts at ptr + (y * stride) and holds width bytes.
+//
+// A value with all fields NULL or zero is a valid, empty buffer.
+typedef struct {
+  uint8_t* ptr;                     // Pointer.
+  size_t width;                     // Bytes per row.
+  size_t height;                    // Number of rows.
+  size_t stride;                    // Invariant: width <= stride.
+  puffs_base__pixel_format pixfmt;  // Pixel format.
+} puffs_base__buf2;
+
+// puffs_base__bitreader yields the bits of a puffs_base__reader1's bytes, in
+// either Least or Most Significant Bits first order. It buffers up to 63 bits.
+// In LSB-first mode, the next bit is bit 0 of bits, and bits at or above n_bits
+// are zero. In MSB-first mode, the next bit is bit (n_bits - 1), and bits at or
+// above n_bits are unspecified.
+//
+// A value with all fields zero is a valid, empty, LSB-first bitreader.
+typedef struct {
+  uint64_t bits;
+  uint32_t n_bits;
+  bool msb_first;
+} puffs_base__bitreader;
+
 #endif  // PUFFS_BASE_HEADER_H
@@ -97,6 +97,16 @@ static inline uint16_t puffs_base__load_u16le(uint8_t* p) {
   return ((uint16_t)(p[0]) << 0) | ((uint16_t)(p[1]) << 8);
 }
 
+static inline uint32_t puffs_base__load_u24be(uint8_t* p) {
+  return ((uint32_t)(p[0]) << 16) | ((uint32_t)(p[1]) << 8) |
+         ((uint32_t)(p[2]) << 0);
+}
+
+static inline uint32_t puffs_base__load_u24le(uint8_t* p) {
+  return ((uint32_t)(p[0]) << 0) | ((uint32_t)(p[1]) << 8) |
+         ((uint32_t)(p[2]) << 16);
+}
+
 static inline uint32_t puffs_base__load_u32be(uint8_t* p) {
   return ((uint32_t)(p[0]) << 24) | ((uint32_t)(p[1]) << 16) |
          ((uint32_t)(p[2]) << 8) | ((uint32_t)(p[3]) << 0);
@@ -107,6 +117,291 @@ static inline uint32_t puffs_base__load_u32le(uint8_t* p) {
          ((uint32_t)(p[2]) << 16) | ((uint32_t)(p[3]) << 24);
 }
 
+static inline void puffs_base__store_u16be(uint8_t* p, uint16_t x) {
+  p[0] = (uint8_t)(x >> 8);
+  p[1] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u16le(uint8_t* p, uint16_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+}
+
+static inline void puffs_base__store_u24be(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 16);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u24le(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 16);
+}
+
+static inline void puffs_base__store_u32be(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 24);
+  p[1] = (uint8_t)(x >> 16);
+  p[2] = (uint8_t)(x >> 8);
+  p[3] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u32le(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 16);
+  p[3] = (uint8_t)(x >> 24);
+}
+
+// Saturating arithmetic. The "~sat+" and "~sat-" Puffs operators call these
+// functions. The "~+", "~-", etc. wrapping operators don't need helpers.
+
+static inline uint8_t puffs_base__u8__sat_add(uint8_t x, uint8_t y) {
+  uint8_t res = (uint8_t)(x + y);
+  return (res < x) ? UINT8_MAX : res;
+}
+
+static inline uint8_t puffs_base__u8__sat_sub(uint8_t x, uint8_t y) {
+  return (x > y) ? (uint8_t)(x - y) : 0;
+}
+
+static inline uint16_t puffs_base__u16__sat_add(uint16_t x, uint16_t y) {
+  uint16_t res = (uint16_t)(x + y);
+  return (res < x) ? UINT16_MAX : res;
+}
+
+static inline uint16_t puffs_base__u16__sat_sub(uint16_t x, uint16_t y) {
+  return (x > y) ? (uint16_t)(x - y) : 0;
+}
+
+static inline uint32_t puffs_base__u32__sat_add(uint32_t x, uint32_t y) {
+  uint32_t res = (uint32_t)(x + y);
+  return (res < x) ? UINT32_MAX : res;
+}
+
+static inline uint32_t puffs_base__u32__sat_sub(uint32_t x, uint32_t y) {
+  return (x > y) ? (uint32_t)(x - y) : 0;
+}
+
+static inline uint64_t puffs_base__u64__sat_add(uint64_t x, uint64_t y) {
+  uint64_t res = (uint64_t)(x + y);
+  return (res < x) ? UINT64_MAX : res;
+}
+
+static inline uint64_t puffs_base__u64__sat_sub(uint64_t x, uint64_t y) {
+  return (x > y) ? (uint64_t)(x - y) : 0;
+}
+
+static inline int8_t puffs_base__i8__sat_add(int8_t x, int8_t y) {
+  if ((y > 0) && (x > (INT8_MAX - y))) {
+    return INT8_MAX;
+  } else if ((y < 0) && (x < (INT8_MIN - y))) {
+    return INT8_MIN;
+  }
+  return (int8_t)(x + y);
+}
+
+static inline int8_t puffs_base__i8__sat_sub(int8_t x, int8_t y) {
+  if ((y < 0) && (x > (INT8_MAX + y))) {
+    return INT8_MAX;
+  } else if ((y > 0) && (x < (INT8_MIN + y))) {
+    return INT8_MIN;
+  }
+  return (int8_t)(x - y);
+}
+
+static inline int16_t puffs_base__i16__sat_add(int16_t x, int16_t y) {
+  if ((y > 0) && (x > (INT16_MAX - y))) {
+    return INT16_MAX;
+  } else if ((y < 0) && (x < (INT16_MIN - y))) {
+    return INT16_MIN;
+  }
+  return (int16_t)(x + y);
+}
+
+static inline int16_t puffs_base__i16__sat_sub(int16_t x, int16_t y) {
+  if ((y < 0) && (x > (INT16_MAX + y))) {
+    return INT16_MAX;
+  } else if ((y > 0) && (x < (INT16_MIN + y))) {
+    return INT16_MIN;
+  }
+  return (int16_t)(x - y);
+}
+
+static inline int32_t puffs_base__i32__sat_add(int32_t x, int32_t y) {
+  if ((y > 0) && (x > (INT32_MAX - y))) {
+    return INT32_MAX;
+  } else if ((y < 0) && (x < (INT32_MIN - y))) {
+    return INT32_MIN;
+  }
+  return (int32_t)(x + y);
+}
+
+static inline int32_t puffs_base__i32__sat_sub(int32_t x, int32_t y) {
+  if ((y < 0) && (x > (INT32_MAX + y))) {
+    return INT32_MAX;
+  } else if ((y > 0) && (x < (INT32_MIN + y))) {
+    return INT32_MIN;
+  }
+  return (int32_t)(x - y);
+}
+
+static inline int64_t puffs_base__i64__sat_add(int64_t x, int64_t y) {
+  if ((y > 0) && (x > (INT64_MAX - y))) {
+    return INT64_MAX;
+  } else if ((y < 0) && (x < (INT64_MIN - y))) {
+    return INT64_MIN;
+  }
+  return (int64_t)(x + y);
+}
+
+static inline int64_t puffs_base__i64__sat_sub(int64_t x, int64_t y) {
+  if ((y < 0) && (x > (INT64_MAX + y))) {
+    return INT64_MAX;
+  } else if ((y > 0) && (x < (INT64_MIN + y))) {
+    return INT64_MIN;
+  }
+  return (int64_t)(x - y);
+}
+
+// Bit manipulation. The "clz", "ctz", "popcount", "reverse_bits",
+// "rotate_left" and "byte_swap" Puffs methods call these functions. They use
+// compiler built-ins when available, with portable fallbacks otherwise.
+
+static inline uint64_t puffs_base__u64__clz(uint64_t x) {
+#if defined(__GNUC__)
+  return x ? (uint64_t)(__builtin_clzll(x)) : 64;
+#else
+  uint64_t n = 64;
+  while (x) {
+    x >>= 1;
+    n--;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__ctz(uint64_t x) {
+#if defined(__GNUC__)
+  return x ? (uint64_t)(__builtin_ctzll(x)) : 64;
+#else
+  if (!x) {
+    return 64;
+  }
+  uint64_t n = 0;
+  while (!(x & 1)) {
+    x >>= 1;
+    n++;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__popcount(uint64_t x) {
+#if defined(__GNUC__)
+  return (uint64_t)(__builtin_popcountll(x));
+#else
+  uint64_t n = 0;
+  while (x) {
+    x &= x - 1;
+    n++;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__byte_swap(uint64_t x) {
+#if defined(__GNUC__)
+  return __builtin_bswap64(x);
+#else
+  x = ((x & 0x00FF00FF00FF00FFull) << 8) | ((x >> 8) & 0x00FF00FF00FF00FFull);
+  x = ((x & 0x0000FFFF0000FFFFull) << 16) | ((x >> 16) & 0x0000FFFF0000FFFFull);
+  return (x << 32) | (x >> 32);
+#endif
+}
+
+// puffs_base__u64__reverse_bits returns the low n bits of x, in reverse order.
+// n must be in the range [0..64].
+static inline uint64_t puffs_base__u64__reverse_bits(uint64_t x, uint32_t n) {
+  x = ((x & 0x5555555555555555ull) << 1) | ((x >> 1) & 0x5555555555555555ull);
+  x = ((x & 0x3333333333333333ull) << 2) | ((x >> 2) & 0x3333333333333333ull);
+  x = ((x & 0x0F0F0F0F0F0F0F0Full) << 4) | ((x >> 4) & 0x0F0F0F0F0F0F0F0Full);
+  x = puffs_base__u64__byte_swap(x);
+  return n ? (x >> (64 - n)) : 0;
+}
+
+static inline uint64_t puffs_base__u64__rotate_left(uint64_t x, uint32_t n) {
+  return (x << n) | (x >> ((64 - n) & 63));
+}
+
+static inline uint8_t puffs_base__u8__clz(uint8_t x) {
+  return (uint8_t)(puffs_base__u64__clz(x) - 56);
+}
+
+static inline uint8_t puffs_base__u8__ctz(uint8_t x) {
+  return x ? (uint8_t)(puffs_base__u64__ctz(x)) : 8;
+}
+
+static inline uint8_t puffs_base__u8__popcount(uint8_t x) {
+  return (uint8_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint8_t puffs_base__u8__reverse_bits(uint8_t x, uint32_t n) {
+  return (uint8_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint8_t puffs_base__u8__rotate_left(uint8_t x, uint32_t n) {
+  return (uint8_t)((x << n) | (x >> ((8 - n) & 7)));
+}
+
+static inline uint16_t puffs_base__u16__clz(uint16_t x) {
+  return (uint16_t)(puffs_base__u64__clz(x) - 48);
+}
+
+static inline uint16_t puffs_base__u16__ctz(uint16_t x) {
+  return x ? (uint16_t)(puffs_base__u64__ctz(x)) : 16;
+}
+
+static inline uint16_t puffs_base__u16__popcount(uint16_t x) {
+  return (uint16_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint16_t puffs_base__u16__reverse_bits(uint16_t x, uint32_t n) {
+  return (uint16_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint16_t puffs_base__u16__rotate_left(uint16_t x, uint32_t n) {
+  return (uint16_t)((x << n) | (x >> ((16 - n) & 15)));
+}
+
+static inline uint16_t puffs_base__u16__byte_swap(uint16_t x) {
+  return (uint16_t)((x << 8) | (x >> 8));
+}
+
+static inline uint32_t puffs_base__u32__clz(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__clz(x) - 32);
+}
+
+static inline uint32_t puffs_base__u32__ctz(uint32_t x) {
+  return x ? (uint32_t)(puffs_base__u64__ctz(x)) : 32;
+}
+
+static inline uint32_t puffs_base__u32__popcount(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint32_t puffs_base__u32__reverse_bits(uint32_t x, uint32_t n) {
+  return (uint32_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint32_t puffs_base__u32__rotate_left(uint32_t x, uint32_t n) {
+  return (x << n) | (x >> ((32 - n) & 31));
+}
+
+static inline uint32_t puffs_base__u32__byte_swap(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__byte_swap(x) >> 32);
+}
+
 static inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_i(
     puffs_base__slice_u8 s,
     uint64_t i) {
@@ -306,6 +601,230 @@ static inline uint32_t puffs_base__writer1__copy_from_slice32(
   return n;
 }
 
+// The puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64
+// functions below are like their puffs_base__slice_u8 equivalents above. The
+// copy_from_slice functions return the number of elements copied, not bytes.
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_i(
+    puffs_base__slice_u16 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u16){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_j(
+    puffs_base__slice_u16 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u16){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_ij(
+    puffs_base__slice_u16 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u16){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline uint64_t puffs_base__slice_u16__copy_from_slice(
+    puffs_base__slice_u16 dst,
+    puffs_base__slice_u16 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint16_t));
+  }
+  return length;
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_i(
+    puffs_base__slice_u32 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u32){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_j(
+    puffs_base__slice_u32 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u32){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_ij(
+    puffs_base__slice_u32 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u32){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline uint64_t puffs_base__slice_u32__copy_from_slice(
+    puffs_base__slice_u32 dst,
+    puffs_base__slice_u32 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint32_t));
+  }
+  return length;
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_i(
+    puffs_base__slice_u64 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u64){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_j(
+    puffs_base__slice_u64 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u64){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_ij(
+    puffs_base__slice_u64 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u64){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline uint64_t puffs_base__slice_u64__copy_from_slice(
+    puffs_base__slice_u64 dst,
+    puffs_base__slice_u64 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint64_t));
+  }
+  return length;
+}
+
+// puffs_base__buf2__row returns row y of b. The caller needs to prove that
+// y < b.height.
+static inline puffs_base__slice_u8 puffs_base__buf2__row(puffs_base__buf2 b,
+                                                         uint64_t y) {
+  return ((puffs_base__slice_u8){
+      .ptr = b.ptr + (y * b.stride),
+      .len = b.width,
+  });
+}
+
+// puffs_base__buf2__set_u8 sets the byte at column x of row y of b. The caller
+// needs to prove that x < b.width and y < b.height.
+static inline puffs_base__empty_struct puffs_base__buf2__set_u8(
+    puffs_base__buf2 b,
+    uint64_t x,
+    uint64_t y,
+    uint8_t v) {
+  b.ptr[(y * b.stride) + x] = v;
+  return ((puffs_base__empty_struct){});
+}
+
+// puffs_base__bitreader__peek_bits returns the next n bits of b without
+// consuming them. The caller needs to prove that n <= 32 and n <= b.n_bits.
+static inline uint32_t puffs_base__bitreader__peek_bits(puffs_base__bitreader b,
+                                                        uint32_t n) {
+  uint64_t mask = (((uint64_t)(1)) << n) - 1;
+  if (b.msb_first) {
+    return (uint32_t)((b.bits >> (b.n_bits - n)) & mask);
+  }
+  return (uint32_t)(b.bits & mask);
+}
+
+// puffs_base__bitreader__take_bits is like puffs_base__bitreader__peek_bits
+// but also consumes those bits.
+static inline uint32_t puffs_base__bitreader__take_bits(
+    puffs_base__bitreader* b,
+    uint32_t n) {
+  uint32_t ret = puffs_base__bitreader__peek_bits(*b, n);
+  if (!b->msb_first) {
+    b->bits >>= n;
+  }
+  b->n_bits -= n;
+  return ret;
+}
+
+static inline puffs_base__empty_struct puffs_base__bitreader__set_msb_first(
+    puffs_base__bitreader* b,
+    bool msb_first) {
+  b->msb_first = msb_first;
+  return ((puffs_base__empty_struct){});
+}
+
+// puffs_base__bitreader__refill_u8 appends one byte, x, to b. The caller needs
+// to prove that b->n_bits <= 55.
+static inline void puffs_base__bitreader__refill_u8(puffs_base__bitreader* b,
+                                                    uint8_t x) {
+  if (b->msb_first) {
+    b->bits = (b->bits << 8) | ((uint64_t)(x));
+  } else {
+    b->bits |= ((uint64_t)(x)) << b->n_bits;
+  }
+  b->n_bits += 8;
+}
+
+// puffs_base__bitreader__refill_fast reads the fewest whole bytes from p that
+// give b at least n bits, and returns how many bytes that is. It loads those
+// bytes with a single 64-bit read, so the caller needs to prove that there are
+// at least 8 bytes at p, and that b->n_bits < n <= 56. It reads at most 7 bytes.
+static inline size_t puffs_base__bitreader__refill_fast(
+    puffs_base__bitreader* b,
+    uint8_t* p,
+    uint32_t n) {
+  uint32_t n_bytes = (n - b->n_bits + 7) >> 3;
+  uint32_t n_new_bits = 8 * n_bytes;
+  if (b->msb_first) {
+    uint64_t x = ((uint64_t)(puffs_base__load_u32be(p + 0)) << 32) |
+                 ((uint64_t)(puffs_base__load_u32be(p + 4)));
+    b->bits = (b->bits << n_new_bits) | (x >> (64 - n_new_bits));
+  } else {
+    uint64_t x = ((uint64_t)(puffs_base__load_u32le(p + 0))) |
+                 ((uint64_t)(puffs_base__load_u32le(p + 4)) << 32);
+    x &= (((uint64_t)(1)) << n_new_bits) - 1;
+    b->bits |= x << b->n_bits;
+  }
+  b->n_bits += n_new_bits;
+  return n_bytes;
+}
+
 // Note that the *__limit and *__mark methods are private (in base-impl.h) not
 // public (in base-header.h). We assume that, at the boundary between user code
 // and Puffs code, the reader1 and writer1's private_impl fields (including
@@ -334,7 +853,14 @@ static inline puffs_base__empty_struct puffs_base__reader1__mark(
   return ((puffs_base__empty_struct){});
 }
 
-// TODO: static inline puffs_base__writer1 puffs_base__writer1__limit()
+static inline puffs_base__writer1 puffs_base__writer1__limit(
+    puffs_base__writer1* o,
+    uint64_t* ptr_to_len) {
+  puffs_base__writer1 ret = *o;
+  ret.private_impl.limit.ptr_to_len = ptr_to_len;
+  ret.private_impl.limit.next = &o->private_impl.limit;
+  return ret;
+}
 
 static inline puffs_base__empty_struct puffs_base__writer1__mark(
     puffs_base__writer1* o,
@@ -46,7 +46,9 @@ const (
 	cPrefix = "c_" // Coroutine state.
 	fPrefix = "f_" // Struct field.
 	iPrefix = "i_" // Iterate variable.
+	lPrefix = "l_" // Limit length.
 	tPrefix = "t_" // Temporary local variable.
+	uPrefix = "u_" // Union variant.
 	vPrefix = "v_" // Local variable.
 )
 
@@ -57,14 +59,21 @@ const (
 //
 // The generated program is written to stdout.
 func Do(args []string) error {
-	return generate.Do(args, func(pkgName string, tm *t.Map, c *check.Checker, files []*a.File) ([]byte, error) {
+	return generate.Do(args, func(pkgName string, tm *t.Map, c *check.Checker, files []*a.File,
+		opts *generate.Options) ([]byte, error) {
+
 		g := &gen{
-			PKGPREFIX: "PUFFS_" + strings.ToUpper(pkgName) + "__",
-			pkgPrefix: "puffs_" + pkgName + "__",
-			pkgName:   pkgName,
-			tm:        tm,
-			checker:   c,
-			files:     files,
+			PKGPREFIX:      "PUFFS_" + strings.ToUpper(pkgName) + "__",
+			pkgPrefix:      "puffs_" + pkgName + "__",
+			pkgName:        pkgName,
+			debugChecks:    opts.DebugChecks,
+			lineDirectives: opts.LineDirectives,
+			coverage:       opts.Coverage,
+			fuzzTargets:    opts.FuzzTargets,
+			sizeProbes:     opts.SizeProbes,
+			tm:             tm,
+			checker:        c,
+			files:          files,
 		}
 		unformatted, err := g.generate()
 		if err != nil {
@@ -157,6 +166,28 @@ type gen struct {
 	pkgPrefix string // e.g. "puffs_jpeg__"
 	pkgName   string // e.g. "jpeg"
 
+	// debugChecks is whether to re-check, at run time, what the checker
+	// proved at compile time. See base-debug-impl.h.
+	debugChecks bool
+
+	// lineDirectives is whether to write "#line 123 \"foo/bar.puffs\"" before
+	// each function and statement.
+	lineDirectives bool
+
+	// coverage is whether to count how often each statement and implicit else
+	// branch runs, and how often each coroutine suspension point is resumed.
+	// See base-coverage-impl.h.
+	coverage     bool
+	coverageList []string // The positions, such as "foo/bar.puffs:123:stmt".
+
+	// fuzzTargets is whether to write a fuzz target for each public decoder
+	// method. See base-fuzz-impl.h.
+	fuzzTargets bool
+
+	// sizeProbes is whether to write a program that prints the size of each
+	// public struct's parts. See sizes.go.
+	sizeProbes bool
+
 	tm         *t.Map
 	checker    *check.Checker
 	files      []*a.File
@@ -164,6 +195,7 @@ type gen struct {
 	statusMap  map[t.ID]status
 	structList []*a.Struct
 	structMap  map[t.ID]*a.Struct
+	sliceMap   map[t.ID]bool
 	currFunk   funk
 	funks      map[t.QID]funk
 }
@@ -194,6 +226,7 @@ func (g *gen) generate() ([]byte, error) {
 	for _, n := range g.structList {
 		g.structMap[n.Name()] = n
 	}
+	g.gatherSliceStructs()
 
 	g.funks = map[t.QID]funk{}
 	if err := g.forEachFunc(nil, bothPubPri, (*gen).gatherFuncImpl); err != nil {
@@ -257,6 +290,16 @@ func (g *gen) genHeader(b *buffer) error {
 		return err
 	}
 
+	b.writes("// ---------------- Public Enums\n\n")
+	if err := g.forEachEnum(b, pubOnly, (*gen).writeEnum); err != nil {
+		return err
+	}
+
+	b.writes("// ---------------- Unions\n\n")
+	if err := g.forEachUnion(b, bothPubPri, (*gen).writeUnion); err != nil {
+		return err
+	}
+
 	b.writes("// ---------------- Structs\n\n")
 	for _, n := range g.structList {
 		if err := g.writeStruct(b, n); err != nil {
@@ -286,19 +329,28 @@ func (g *gen) genHeader(b *buffer) error {
 func (g *gen) genImpl(b *buffer) error {
 	b.writes(baseImpl)
 	b.writes("\n")
+	if g.debugChecks {
+		b.writes(baseDebugImpl)
+		b.writes("\n")
+	}
+	if g.coverage {
+		b.writes(baseCoverageImpl)
+		b.writes("\n")
+		g.writeCoverageImpl(b)
+	}
 
 	b.writes("// ---------------- Status Codes Implementations\n\n")
 	b.printf("bool %sstatus__is_error(%sstatus s) { return s < 0; }\n\n", g.pkgPrefix, g.pkgPrefix)
 
 	b.printf("const char* %sstatus__strings0[%d] = {\n", g.pkgPrefix, len(builtin.StatusList))
 	for _, z := range builtin.StatusList {
-		b.printf("%q,", g.pkgName+": "+z.Message)
+		b.printf("%s,", cString(g.pkgName+": "+z.Message))
 	}
 	b.writes("};\n\n")
 
 	b.printf("const char* %sstatus__strings1[%d] = {\n", g.pkgPrefix, len(g.statusList))
 	for _, s := range g.statusList {
-		b.printf("%q,", g.pkgName+": "+s.msg)
+		b.printf("%s,", cString(g.pkgName+": "+s.msg))
 	}
 	b.writes("};\n\n")
 
@@ -320,6 +372,15 @@ func (g *gen) genImpl(b *buffer) error {
 		return err
 	}
 
+	b.writes("// ---------------- Slice Implementations\n\n")
+	for _, n := range g.structList {
+		if g.sliceMap[n.Name()] {
+			if err := g.writeSliceImpl(b, n); err != nil {
+				return err
+			}
+		}
+	}
+
 	b.writes("// ---------------- Private Initializer Prototypes\n\n")
 	for _, n := range g.structList {
 		if !n.Public() {
@@ -346,9 +407,52 @@ func (g *gen) genImpl(b *buffer) error {
 		return err
 	}
 
+	if g.fuzzTargets {
+		b.writes(baseFuzzImpl)
+		b.writes("\n")
+		if err := g.writeFuzzImpl(b); err != nil {
+			return err
+		}
+	}
+
+	if g.sizeProbes {
+		if err := g.writeSizeProbes(b); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Coverage counter kinds. Each coverage counter's position, in the coverage
+// profile written by the generated code, ends with one of these, except that
+// resume positions also have the function name and suspension point number.
+const (
+	coverageKindElse      = "else"
+	coverageKindResume    = "resume"
+	coverageKindStatement = "stmt"
+)
+
+func (g *gen) writeCoverageImpl(b *buffer) {
+	b.writes("// ---------------- Coverage\n\n")
+	if len(g.coverageList) == 0 {
+		return
+	}
+	b.printf("static uint64_t %scoverage_counts[%d];\n\n", g.pkgPrefix, len(g.coverageList))
+	b.printf("static const char* %scoverage_positions[%d] = {\n", g.pkgPrefix, len(g.coverageList))
+	for _, s := range g.coverageList {
+		b.printf("%s,\n", cString(s))
+	}
+	b.writes("};\n\n")
+	b.printf("static void %scoverage_write(void) {\n", g.pkgPrefix)
+	b.printf("puffs_base__coverage_write(%scoverage_positions, %scoverage_counts, %d);\n",
+		g.pkgPrefix, g.pkgPrefix, len(g.coverageList))
+	b.writes("}\n\n")
+	b.printf("PUFFS_BASE__COVERAGE_CONSTRUCTOR static void %scoverage_init(void) {\n", g.pkgPrefix)
+	b.printf("atexit(%scoverage_write);\n", g.pkgPrefix)
+	b.writes("}\n\n")
+}
+
 func (g *gen) forEachConst(b *buffer, v visibility, f func(*gen, *buffer, *a.Const) error) error {
 	for _, file := range g.files {
 		for _, tld := range file.TopLevelDecls() {
@@ -365,6 +469,38 @@ func (g *gen) forEachConst(b *buffer, v visibility, f func(*gen, *buffer, *a.Con
 	return nil
 }
 
+func (g *gen) forEachEnum(b *buffer, v visibility, f func(*gen, *buffer, *a.Enum) error) error {
+	for _, file := range g.files {
+		for _, tld := range file.TopLevelDecls() {
+			if tld.Kind() != a.KEnum ||
+				(v == pubOnly && tld.Raw().Flags()&a.FlagsPublic == 0) ||
+				(v == priOnly && tld.Raw().Flags()&a.FlagsPublic != 0) {
+				continue
+			}
+			if err := f(g, b, tld.Enum()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *gen) forEachUnion(b *buffer, v visibility, f func(*gen, *buffer, *a.Union) error) error {
+	for _, file := range g.files {
+		for _, tld := range file.TopLevelDecls() {
+			if tld.Kind() != a.KUnion ||
+				(v == pubOnly && tld.Raw().Flags()&a.FlagsPublic == 0) ||
+				(v == priOnly && tld.Raw().Flags()&a.FlagsPublic != 0) {
+				continue
+			}
+			if err := f(g, b, tld.Union()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (g *gen) forEachFunc(b *buffer, v visibility, f func(*gen, *buffer, *a.Func) error) error {
 	for _, file := range g.files {
 		for _, tld := range file.TopLevelDecls() {
@@ -373,6 +509,10 @@ func (g *gen) forEachFunc(b *buffer, v visibility, f func(*gen, *buffer, *a.Func
 				(v == priOnly && tld.Raw().Flags()&a.FlagsPublic != 0) {
 				continue
 			}
+			if tld.Func().Const() {
+				// Const funcs are evaluated by the checker, not at run time.
+				continue
+			}
 			if err := f(g, b, tld.Func()); err != nil {
 				return err
 			}
@@ -418,6 +558,24 @@ func (g *gen) cName(name string) string {
 	return string(s)
 }
 
+// cString returns a C string literal for the bytes s. Unlike Go's %q, it uses
+// octal escapes, as C's hexadecimal escapes are not limited to two digits.
+func cString(s string) string {
+	buf := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c == '?' {
+			buf = append(buf, '\\', c)
+		} else if ' ' <= c && c <= '~' {
+			buf = append(buf, c)
+		} else {
+			buf = append(buf, '\\', '0'+c>>6, '0'+(c>>3)&7, '0'+c&7)
+		}
+	}
+	buf = append(buf, '"')
+	return string(buf)
+}
+
 func (g *gen) sizeof(typ *a.TypeExpr) (uint32, error) {
 	if typ.Decorator() == 0 {
 		switch typ.Name().Key() {
@@ -463,16 +621,44 @@ func (g *gen) writeConst(b *buffer, n *a.Const) error {
 		return err
 	}
 	b.writes(" = ")
-	if err := g.writeConstList(b, n.Value()); err != nil {
+	if err := g.writeConstList(b, g.checker.Consts()[n.Name()].Value); err != nil {
 		return err
 	}
 	b.writes(";\n\n")
 	return nil
 }
 
+// writeEnum writes an enum's members as #define'd constants. Enums have no C
+// type of their own: an enum-typed value is represented by its backing type.
+func (g *gen) writeEnum(b *buffer, n *a.Enum) error {
+	prefix := strings.ToUpper(g.cName(n.Name().String(g.tm))) + "__"
+	for _, o := range n.Members() {
+		o := o.Arg()
+		cv := o.Value().ConstValue()
+		if cv == nil {
+			return fmt.Errorf("invalid enum member %q", o.Name().String(g.tm))
+		}
+		b.printf("#define %s%s %v\n", prefix, strings.ToUpper(o.Name().String(g.tm)), cv)
+	}
+	b.writes("\n")
+	return nil
+}
+
 func (g *gen) writeConstList(b *buffer, n *a.Expr) error {
 	switch n.ID0().Key() {
 	case 0:
+		if n.ID1().IsStrLiteral() {
+			s, ok := t.Unescape(n.ID1().String(g.tm))
+			if !ok {
+				return fmt.Errorf("invalid const value %q", n.String(g.tm))
+			}
+			b.writeb('{')
+			for i := 0; i < len(s); i++ {
+				b.printf("%d,", s[i])
+			}
+			b.writeb('}')
+			return nil
+		}
 		b.writes(n.ConstValue().String())
 	case t.KeyDollar:
 		b.writeb('{')
@@ -489,6 +675,39 @@ func (g *gen) writeConstList(b *buffer, n *a.Expr) error {
 	return nil
 }
 
+// writeUnion writes a union as a C struct holding a tag and a C union of the
+// variants' fields. Variants without fields are omitted from the C union, as C
+// does not allow empty structs.
+func (g *gen) writeUnion(b *buffer, n *a.Union) error {
+	b.writes("typedef struct {\n")
+	b.writes("uint8_t tag;\n")
+	if g.unionHasFields(n.Name()) {
+		b.writes("union {\n")
+		for _, o := range n.Variants() {
+			o := o.Struct()
+			if len(o.Fields()) == 0 {
+				continue
+			}
+			b.writes("struct {\n")
+			for _, f := range o.Fields() {
+				f := f.Field()
+				if err := g.writeCTypeName(b, f.XType(), fPrefix, f.Name().String(g.tm)); err != nil {
+					return err
+				}
+				b.writes(";\n")
+			}
+			b.printf("} %s%s;\n", uPrefix, o.Name().String(g.tm))
+		}
+		b.writes("} variants;\n")
+	}
+	b.printf("} %s%s;\n\n", g.pkgPrefix, n.Name().String(g.tm))
+	return nil
+}
+
+func (g *gen) unionHasFields(name t.ID) bool {
+	return len(g.checker.Unions()[name].Fields) != 0
+}
+
 func (g *gen) writeStruct(b *buffer, n *a.Struct) error {
 	// For API/ABI compatibility, the very first field in the struct's
 	// private_impl must be the status code. This lets the initializer callee
@@ -521,37 +740,119 @@ func (g *gen) writeStruct(b *buffer, n *a.Struct) error {
 
 	if n.Suspendible() {
 		b.writeb('\n')
-		for _, file := range g.files {
-			for _, tld := range file.TopLevelDecls() {
-				if tld.Kind() != a.KFunc {
-					continue
-				}
-				o := tld.Func()
-				if o.Receiver() != n.Name() || !o.Suspendible() {
-					continue
+		for _, o := range g.coroutineStates(n) {
+			k := g.funks[o.QID()]
+			// TODO: allow max depth > 1 for recursive coroutines.
+			const maxDepth = 1
+			b.writes("struct {\n")
+			if k.coroSuspPoint != 0 {
+				b.writes("uint32_t coro_susp_point;\n")
+				if err := g.writeSavedVars(b, &k); err != nil {
+					return err
 				}
-				k := g.funks[o.QID()]
-				if k.coroSuspPoint == 0 && !k.usesScratch {
-					continue
-				}
-				// TODO: allow max depth > 1 for recursive coroutines.
-				const maxDepth = 1
-				b.writes("struct {\n")
-				if k.coroSuspPoint != 0 {
-					b.writes("uint32_t coro_susp_point;\n")
-					if err := g.writeVars(b, o.Body(), true, true); err != nil {
-						return err
-					}
-				}
-				if k.usesScratch {
-					b.writes("uint64_t scratch;\n")
-				}
-				b.printf("} %s%s[%d];\n", cPrefix, o.Name().String(g.tm), maxDepth)
 			}
+			if k.usesScratch {
+				b.writes("uint64_t scratch;\n")
+			}
+			b.printf("} %s%s[%d];\n", cPrefix, o.Name().String(g.tm), maxDepth)
 		}
 	}
 
 	b.printf("} private_impl;\n } %s%s;\n\n", g.pkgPrefix, structName)
+
+	if g.sliceMap[n.Name()] {
+		b.printf("typedef struct {\n%s%s* ptr;\nsize_t len;\n} %sslice_%s;\n\n",
+			g.pkgPrefix, structName, g.pkgPrefix, structName)
+	}
+	return nil
+}
+
+// coroutineStates returns n's methods that have coroutine state, a
+// "c_foo[1]" field of the struct's private_impl, in source code order.
+func (g *gen) coroutineStates(n *a.Struct) []*a.Func {
+	ret := []*a.Func(nil)
+	for _, file := range g.files {
+		for _, tld := range file.TopLevelDecls() {
+			if tld.Kind() != a.KFunc {
+				continue
+			}
+			o := tld.Func()
+			if o.Receiver() != n.Name() || !o.Suspendible() {
+				continue
+			}
+			if k := g.funks[o.QID()]; k.coroSuspPoint == 0 && !k.usesScratch {
+				continue
+			}
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}
+
+// gatherSliceStructs records which structs are the element type of a slice
+// type, as each such slice type needs its own C typedef and helper functions.
+func (g *gen) gatherSliceStructs() {
+	g.sliceMap = map[t.ID]bool{}
+	visit := func(typ *a.TypeExpr) {
+		for ; typ != nil; typ = typ.Inner() {
+			if typ.Decorator().Key() != t.KeyColon {
+				continue
+			}
+			if o := typ.Inner(); o.Decorator() == 0 && g.structMap[o.Name()] != nil {
+				g.sliceMap[o.Name()] = true
+			}
+		}
+	}
+	for _, file := range g.files {
+		for _, tld := range file.TopLevelDecls() {
+			tld.Walk(func(n *a.Node) error {
+				switch n.Kind() {
+				case a.KExpr:
+					visit(n.Expr().MType())
+				case a.KTypeExpr:
+					visit(n.TypeExpr())
+				}
+				return nil
+			})
+		}
+	}
+}
+
+// writeSliceImpl writes the helper functions for a slice of a struct type,
+// like the puffs_base__slice_u8 functions in base-impl.h.
+func (g *gen) writeSliceImpl(b *buffer, n *a.Struct) error {
+	elemType := g.pkgPrefix + n.Name().String(g.tm)
+	sliceType := g.pkgPrefix + "slice_" + n.Name().String(g.tm)
+
+	b.printf("static inline %s %s__subslice_i(%s s, uint64_t i) {\n", sliceType, sliceType, sliceType)
+	b.writes("if ((i <= SIZE_MAX) && (i <= s.len)) {\n")
+	b.printf("return ((%s){.ptr = s.ptr + i, .len = s.len - i,});\n", sliceType)
+	b.writes("}\n")
+	b.printf("return ((%s){});\n", sliceType)
+	b.writes("}\n\n")
+
+	b.printf("static inline %s %s__subslice_j(%s s, uint64_t j) {\n", sliceType, sliceType, sliceType)
+	b.writes("if ((j <= SIZE_MAX) && (j <= s.len)) {\n")
+	b.printf("return ((%s){.ptr = s.ptr, .len = j});\n", sliceType)
+	b.writes("}\n")
+	b.printf("return ((%s){});\n", sliceType)
+	b.writes("}\n\n")
+
+	b.printf("static inline %s %s__subslice_ij(%s s, uint64_t i, uint64_t j) {\n",
+		sliceType, sliceType, sliceType)
+	b.writes("if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {\n")
+	b.printf("return ((%s){.ptr = s.ptr + i, .len = j - i,});\n", sliceType)
+	b.writes("}\n")
+	b.printf("return ((%s){});\n", sliceType)
+	b.writes("}\n\n")
+
+	b.printf("static inline uint64_t %s__copy_from_slice(%s dst, %s src) {\n", sliceType, sliceType, sliceType)
+	b.writes("size_t length = dst.len < src.len ? dst.len : src.len;\n")
+	b.writes("if (length > 0) {\n")
+	b.printf("memmove(dst.ptr, src.ptr, length * sizeof(%s));\n", elemType)
+	b.writes("}\n")
+	b.writes("return length;\n")
+	b.writes("}\n\n")
 	return nil
 }
 
@@ -609,20 +910,28 @@ func (g *gen) writeInitializerImpl(b *buffer, n *a.Struct) error {
 		}
 	}
 
-	// Call any ctors on sub-structs.
+	// Call any ctors on sub-structs, including each element of an array of
+	// sub-structs. Only suspendible structs have ctors.
 	for _, f := range n.Fields() {
 		f := f.Field()
-		x := f.XType()
-		if x != x.Innermost() {
-			// TODO: arrays of sub-structs.
+		x := f.XType().Innermost()
+		if o := g.structMap[x.Name()]; x.Decorator() != 0 || o == nil || !o.Suspendible() {
 			continue
 		}
-		if g.structMap[x.Name()] == nil {
-			continue
+		lhs := fmt.Sprintf("self->private_impl.%s%s", fPrefix, f.Name().String(g.tm))
+		nLoops := 0
+		for y := f.XType(); y.Decorator().Key() == t.KeyOpenBracket; y = y.Inner() {
+			b.printf("for (size_t i%d = 0; i%d < %v; i%d++) {\n",
+				nLoops, nLoops, y.ArrayLength().ConstValue(), nLoops)
+			lhs += fmt.Sprintf("[i%d]", nLoops)
+			nLoops++
 		}
-		b.printf("%s%s__initialize(&self->private_impl.%s%s,"+
+		b.printf("%s%s__initialize(&%s,"+
 			"PUFFS_VERSION, PUFFS_BASE__ALREADY_ZEROED);\n",
-			g.pkgPrefix, x.Name().String(g.tm), fPrefix, f.Name().String(g.tm))
+			g.pkgPrefix, x.Name().String(g.tm), lhs)
+		for ; nLoops > 0; nLoops-- {
+			b.writes("}\n")
+		}
 	}
 
 	b.writes("}\n\n")
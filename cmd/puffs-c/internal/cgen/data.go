@@ -18,9 +18,11 @@ package cgen
 
 const baseHeader = "" +
 	"#ifndef PUFFS_BASE_HEADER_H\n#define PUFFS_BASE_HEADER_H\n\n// Copyright 2017 The Puffs Authors.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n// you may not use this file except in compliance with the License.\n// You may obtain a copy of the License at\n//\n//    https://www.apache.org/licenses/LICENSE-2.0\n//\n// Unless required by applicable law or agreed to in writing, software\n// distributed under the License is distributed on an \"AS IS\" BASIS,\n// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.\n// See the License for the specific language governing permissions and\n// limitations under the License.\n\n#include <stdbool.h>\n#include <stdint.h>\n#include <string.h>\n\n// Puffs requires a word size of at least 32 bits because it assumes that\n// converting a u32 to usize will never overflow. For example, the size of a\n// decoded image is often represented, explicitly or implicitly in an image\n// file, as a u32, and it is convenient to compare that to a buffer size.\n//\n// Si" +
-	"milarly, the word size is at most 64 bits because it assumes that\n// converting a usize to u64 will never overflow.\n#if __WORDSIZE < 32\n#error \"Puffs requires a word size of at least 32 bits\"\n#elif __WORDSIZE > 64\n#error \"Puffs requires a word size of at most 64 bits\"\n#endif\n\n// PUFFS_VERSION is the major.minor version number as a uint32. The major\n// number is the high 16 bits. The minor number is the low 16 bits.\n//\n// The intention is to bump the version number at least on every API / ABI\n// backwards incompatible change.\n//\n// For now, the API and ABI are simply unstable and can change at any time.\n//\n// TODO: don't hard code this in base-header.h.\n#define PUFFS_VERSION (0x00001)\n\n// puffs_base__slice_u8 is a 1-dimensional buffer (a pointer and length).\n//\n// A value with all fields NULL or zero is a valid, empty slice.\ntypedef struct {\n  uint8_t* ptr;\n  size_t len;\n} puffs_base__slice_u8;\n\n// puffs_base__buf1 is a 1-dimensional buffer (a pointer and length), plus\n// additional indexes into that buffer, p" +
-	"lus an opened / closed flag.\n//\n// A value with all fields NULL or zero is a valid, empty buffer.\ntypedef struct {\n  uint8_t* ptr;  // Pointer.\n  size_t len;    // Length.\n  size_t wi;     // Write index. Invariant: wi <= len.\n  size_t ri;     // Read  index. Invariant: ri <= wi.\n  bool closed;   // No further writes are expected.\n} puffs_base__buf1;\n\n// puffs_base__limit1 provides a limited view of a 1-dimensional byte stream:\n// its first N bytes. That N can be greater than a buffer's current read or\n// write capacity. N decreases naturally over time as bytes are read from or\n// written to the stream.\n//\n// A value with all fields NULL or zero is a valid, unlimited view.\ntypedef struct puffs_base__limit1 {\n  uint64_t* ptr_to_len;             // Pointer to N.\n  struct puffs_base__limit1* next;  // Linked list of limits.\n} puffs_base__limit1;\n\ntypedef struct {\n  // TODO: move buf into private_impl? As it is, it looks like users can modify\n  // the buf field to point to a different buffer, which can turn the l" +
-	"imit and\n  // mark fields into dangling pointers.\n  puffs_base__buf1* buf;\n  // Do not access the private_impl's fields directly. There is no API/ABI\n  // compatibility or safety guarantee if you do so.\n  struct {\n    puffs_base__limit1 limit;\n    uint8_t* mark;\n  } private_impl;\n} puffs_base__reader1;\n\ntypedef struct {\n  // TODO: move buf into private_impl? As it is, it looks like users can modify\n  // the buf field to point to a different buffer, which can turn the limit and\n  // mark fields into dangling pointers.\n  puffs_base__buf1* buf;\n  // Do not access the private_impl's fields directly. There is no API/ABI\n  // compatibility or safety guarantee if you do so.\n  struct {\n    puffs_base__limit1 limit;\n    uint8_t* mark;\n  } private_impl;\n} puffs_base__writer1;\n\n#endif  // PUFFS_BASE_HEADER_H\n" +
+	"milarly, the word size is at most 64 bits because it assumes that\n// converting a usize to u64 will never overflow.\n#if __WORDSIZE < 32\n#error \"Puffs requires a word size of at least 32 bits\"\n#elif __WORDSIZE > 64\n#error \"Puffs requires a word size of at most 64 bits\"\n#endif\n\n// PUFFS_VERSION is the major.minor version number as a uint32. The major\n// number is the high 16 bits. The minor number is the low 16 bits.\n//\n// The intention is to bump the version number at least on every API / ABI\n// backwards incompatible change.\n//\n// For now, the API and ABI are simply unstable and can change at any time.\n//\n// TODO: don't hard code this in base-header.h.\n#define PUFFS_VERSION (0x00001)\n\n// puffs_base__slice_u8 is a 1-dimensional buffer (a pointer and length).\n//\n// A value with all fields NULL or zero is a valid, empty slice.\ntypedef struct {\n  uint8_t* ptr;\n  size_t len;\n} puffs_base__slice_u8;\n\n// puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64 are\n// like puffs_base__slice_u8, but for " +
+	"wider elements. Their len fields count\n// elements, not bytes.\ntypedef struct {\n  uint16_t* ptr;\n  size_t len;\n} puffs_base__slice_u16;\n\ntypedef struct {\n  uint32_t* ptr;\n  size_t len;\n} puffs_base__slice_u32;\n\ntypedef struct {\n  uint64_t* ptr;\n  size_t len;\n} puffs_base__slice_u64;\n\n// puffs_base__buf1 is a 1-dimensional buffer (a pointer and length), plus\n// additional indexes into that buffer, plus an opened / closed flag.\n//\n// A value with all fields NULL or zero is a valid, empty buffer.\ntypedef struct {\n  uint8_t* ptr;  // Pointer.\n  size_t len;    // Length.\n  size_t wi;     // Write index. Invariant: wi <= len.\n  size_t ri;     // Read  index. Invariant: ri <= wi.\n  bool closed;   // No further writes are expected.\n} puffs_base__buf1;\n\n// puffs_base__limit1 provides a limited view of a 1-dimensional byte stream:\n// its first N bytes. That N can be greater than a buffer's current read or\n// write capacity. N decreases naturally over time as bytes are read from or\n// written to the stream.\n//\n// A valu" +
+	"e with all fields NULL or zero is a valid, unlimited view.\ntypedef struct puffs_base__limit1 {\n  uint64_t* ptr_to_len;             // Pointer to N.\n  struct puffs_base__limit1* next;  // Linked list of limits.\n} puffs_base__limit1;\n\ntypedef struct {\n  // TODO: move buf into private_impl? As it is, it looks like users can modify\n  // the buf field to point to a different buffer, which can turn the limit and\n  // mark fields into dangling pointers.\n  puffs_base__buf1* buf;\n  // Do not access the private_impl's fields directly. There is no API/ABI\n  // compatibility or safety guarantee if you do so.\n  struct {\n    puffs_base__limit1 limit;\n    uint8_t* mark;\n  } private_impl;\n} puffs_base__reader1;\n\ntypedef struct {\n  // TODO: move buf into private_impl? As it is, it looks like users can modify\n  // the buf field to point to a different buffer, which can turn the limit and\n  // mark fields into dangling pointers.\n  puffs_base__buf1* buf;\n  // Do not access the private_impl's fields directly. There is no API/ABI\n" +
+	"  // compatibility or safety guarantee if you do so.\n  struct {\n    puffs_base__limit1 limit;\n    uint8_t* mark;\n  } private_impl;\n} puffs_base__writer1;\n\n// puffs_base__pixel_format describes how a pixel's bytes are laid out in\n// memory. The high 24 bits identify the format. The low 8 bits hold the number\n// of bytes per pixel.\ntypedef uint32_t puffs_base__pixel_format;\n\n#define PUFFS_BASE__PIXEL_FORMAT__INVALID 0x00000000\n#define PUFFS_BASE__PIXEL_FORMAT__INDEXED_8 0x01000001\n#define PUFFS_BASE__PIXEL_FORMAT__GRAY_8 0x02000001\n#define PUFFS_BASE__PIXEL_FORMAT__RGB_888 0x03000003\n#define PUFFS_BASE__PIXEL_FORMAT__BGRA_8888 0x04000004\n#define PUFFS_BASE__PIXEL_FORMAT__RGBA_8888 0x05000004\n\n// puffs_base__buf2 is a 2-dimensional buffer of bytes, such as pixel data. Row\n// y starts at ptr + (y * stride) and holds width bytes.\n//\n// A value with all fields NULL or zero is a valid, empty buffer.\ntypedef struct {\n  uint8_t* ptr;                     // Pointer.\n  size_t width;                     // Bytes per row." +
+	"\n  size_t height;                    // Number of rows.\n  size_t stride;                    // Invariant: width <= stride.\n  puffs_base__pixel_format pixfmt;  // Pixel format.\n} puffs_base__buf2;\n\n// puffs_base__bitreader yields the bits of a puffs_base__reader1's bytes, in\n// either Least or Most Significant Bits first order. It buffers up to 63 bits.\n// In LSB-first mode, the next bit is bit 0 of bits, and bits at or above n_bits\n// are zero. In MSB-first mode, the next bit is bit (n_bits - 1), and bits at or\n// above n_bits are unspecified.\n//\n// A value with all fields zero is a valid, empty, LSB-first bitreader.\ntypedef struct {\n  uint64_t bits;\n  uint32_t n_bits;\n  bool msb_first;\n} puffs_base__bitreader;\n\n#endif  // PUFFS_BASE_HEADER_H\n" +
 	""
 
 const baseImpl = "" +
@@ -28,13 +30,49 @@ const baseImpl = "" +
 	"check that initializers are called.\n// It's not foolproof, given C doesn't automatically zero memory before use,\n// but it should catch 99.99% of cases.\n//\n// Its (non-zero) value is arbitrary, based on md5sum(\"puffs\").\n#define PUFFS_BASE__MAGIC (0xCB3699CCU)\n\n// PUFFS_BASE__ALREADY_ZEROED is passed from a container struct's initializer\n// to a containee struct's initializer when the container has already zeroed\n// the containee's memory.\n//\n// Its (non-zero) value is arbitrary, based on md5sum(\"zeroed\").\n#define PUFFS_BASE__ALREADY_ZEROED (0x68602EF1U)\n\n// Use switch cases for coroutine suspension points, similar to the technique\n// in https://www.chiark.greenend.org.uk/~sgtatham/coroutines.html\n//\n// We use trivial macros instead of an explicit assignment and case statement\n// so that clang-format doesn't get confused by the unusual \"case\"s.\n#define PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0 case 0:;\n#define PUFFS_BASE__COROUTINE_SUSPENSION_POINT(n) \\\n  coro_susp_point = n;                            \\\n  case" +
 	" n:;\n\n#define PUFFS_BASE__COROUTINE_SUSPENSION_POINT_MAYBE_SUSPEND(n) \\\n  if (status < 0) {                                             \\\n    goto exit;                                                  \\\n  } else if (status == 0) {                                     \\\n    goto ok;                                                    \\\n  }                                                             \\\n  coro_susp_point = n;                                          \\\n  goto suspend;                                                 \\\n  case n:;\n\n// Clang also defines \"__GNUC__\".\n#if defined(__GNUC__)\n#define PUFFS_BASE__LIKELY(expr) (__builtin_expect(!!(expr), 1))\n#define PUFFS_BASE__UNLIKELY(expr) (__builtin_expect(!!(expr), 0))\n#else\n#define PUFFS_BASE__LIKELY(expr) (expr)\n#define PUFFS_BASE__UNLIKELY(expr) (expr)\n#endif\n\n// Uncomment this #include for printf-debugging.\n// #include <stdio.h>\n\n// ---------------- Static Inline Functions\n//\n// The helpers below are functions, instead of macros, because their argume" +
 	"nts\n// can be an expression that we shouldn't evaluate more than once.\n//\n// They are in base-impl.h and hence copy/pasted into every generated C file,\n// instead of being in some \"base.c\" file, since a design goal is that users of\n// the generated C code can often just #include a single .c file, such as\n// \"gif.c\", without having to additionally include or otherwise build and link\n// a \"base.c\" file.\n//\n// They are static, so that linking multiple puffs .o files won't complain about\n// duplicate function definitions.\n//\n// They are explicitly marked inline, even if modern compilers don't use the\n// inline attribute to guide optimizations such as inlining, to avoid the\n// -Wunused-function warning, and we like to compile with -Wall -Werror.\n\nstatic inline uint16_t puffs_base__load_u16be(uint8_t* p) {\n  return ((uint16_t)(p[0]) << 8) | ((uint16_t)(p[1]) << 0);\n}\n\nstatic inline uint16_t puffs_base__load_u16le(uint8_t* p) {\n  return ((uint16_t)(p[0]) << 0) | ((uint16_t)(p[1]) << 8);\n}\n\nstatic inline uint32_t puf" +
-	"fs_base__load_u32be(uint8_t* p) {\n  return ((uint32_t)(p[0]) << 24) | ((uint32_t)(p[1]) << 16) |\n         ((uint32_t)(p[2]) << 8) | ((uint32_t)(p[3]) << 0);\n}\n\nstatic inline uint32_t puffs_base__load_u32le(uint8_t* p) {\n  return ((uint32_t)(p[0]) << 0) | ((uint32_t)(p[1]) << 8) |\n         ((uint32_t)(p[2]) << 16) | ((uint32_t)(p[3]) << 24);\n}\n\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_i(\n    puffs_base__slice_u8 s,\n    uint64_t i) {\n  if ((i <= SIZE_MAX) && (i <= s.len)) {\n    return ((puffs_base__slice_u8){\n        .ptr = s.ptr + i,\n        .len = s.len - i,\n    });\n  }\n  return ((puffs_base__slice_u8){});\n}\n\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_j(\n    puffs_base__slice_u8 s,\n    uint64_t j) {\n  if ((j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u8){.ptr = s.ptr, .len = j});\n  }\n  return ((puffs_base__slice_u8){});\n}\n\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_ij(\n    puffs_base__slice_u8 s,\n    uint64_t i,\n    uin" +
-	"t64_t j) {\n  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u8){\n        .ptr = s.ptr + i,\n        .len = j - i,\n    });\n  }\n  return ((puffs_base__slice_u8){});\n}\n\n// puffs_base__slice_u8__prefix returns up to the first up_to bytes of s.\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__prefix(\n    puffs_base__slice_u8 s,\n    uint64_t up_to) {\n  if ((uint64_t)(s.len) > up_to) {\n    s.len = up_to;\n  }\n  return s;\n}\n\n// puffs_base__slice_u8__suffix returns up to the last up_to bytes of s.\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8_suffix(\n    puffs_base__slice_u8 s,\n    uint64_t up_to) {\n  if ((uint64_t)(s.len) > up_to) {\n    s.ptr += (uint64_t)(s.len) - up_to;\n    s.len = up_to;\n  }\n  return s;\n}\n\n// puffs_base__slice_u8__copy_from_slice calls memmove(dst.ptr, src.ptr,\n// length) where length is the minimum of dst.len and src.len.\n//\n// Passing a puffs_base__slice_u8 with all fields NULL or zero (a valid, empty\n// slice) is valid and results in a no-op.\ns" +
-	"tatic inline uint64_t puffs_base__slice_u8__copy_from_slice(\n    puffs_base__slice_u8 dst,\n    puffs_base__slice_u8 src) {\n  size_t length = dst.len < src.len ? dst.len : src.len;\n  if (length > 0) {\n    memmove(dst.ptr, src.ptr, length);\n  }\n  return length;\n}\n\nstatic inline uint32_t puffs_base__writer1__copy_from_history32(\n    uint8_t** ptr_ptr,\n    uint8_t* start,  // May be NULL, meaning an unmarked writer1.\n    uint8_t* end,\n    uint32_t distance,\n    uint32_t length) {\n  if (!start || !distance) {\n    return 0;\n  }\n  uint8_t* ptr = *ptr_ptr;\n  if ((size_t)(ptr - start) < (size_t)(distance)) {\n    return 0;\n  }\n  start = ptr - distance;\n  size_t n = end - ptr;\n  if ((size_t)(length) > n) {\n    length = n;\n  } else {\n    n = length;\n  }\n  // TODO: unrolling by 3 seems best for the std/flate benchmarks, but that is\n  // mostly because 3 is the minimum length for the flate format. This function\n  // implementation shouldn't overfit to that one format. Perhaps the\n  // copy_from_history32 Puffs method shoul" +
-	"d also take an unroll hint argument,\n  // and the cgen can look if that argument is the constant expression '3'.\n  //\n  // See also puffs_base__writer1__copy_from_history32__bco below.\n  //\n  // Alternatively, or additionally, have a sloppy_copy_from_history32 method\n  // that copies 8 bytes at a time, possibly writing more than length bytes?\n  for (; n >= 3; n -= 3) {\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n  }\n  for (; n; n--) {\n    *ptr++ = *start++;\n  }\n  *ptr_ptr = ptr;\n  return length;\n}\n\n// puffs_base__writer1__copy_from_history32__bco is a Bounds Check Optimized\n// version of the puffs_base__writer1__copy_from_history32 function above. The\n// caller needs to prove that:\n//  - start    != NULL\n//  - distance != 0\n//  - distance <= (*ptr_ptr - start)\n//  - length   <= (end      - *ptr_ptr)\nstatic inline uint32_t puffs_base__writer1__copy_from_history32__bco(\n    uint8_t** ptr_ptr,\n    uint8_t* start,\n    uint8_t* end,\n    uint32_t distance,\n    uint32_t length) {\n  uint8_t* " +
-	"ptr = *ptr_ptr;\n  start = ptr - distance;\n  uint32_t n = length;\n  for (; n >= 3; n -= 3) {\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n  }\n  for (; n; n--) {\n    *ptr++ = *start++;\n  }\n  *ptr_ptr = ptr;\n  return length;\n}\n\nstatic inline uint32_t puffs_base__writer1__copy_from_reader32(\n    uint8_t** ptr_wptr,\n    uint8_t* wend,\n    uint8_t** ptr_rptr,\n    uint8_t* rend,\n    uint32_t length) {\n  uint8_t* wptr = *ptr_wptr;\n  size_t n = length;\n  if (n > wend - wptr) {\n    n = wend - wptr;\n  }\n  uint8_t* rptr = *ptr_rptr;\n  if (n > rend - rptr) {\n    n = rend - rptr;\n  }\n  if (n > 0) {\n    memmove(wptr, rptr, n);\n    *ptr_wptr += n;\n    *ptr_rptr += n;\n  }\n  return n;\n}\n\nstatic inline uint64_t puffs_base__writer1__copy_from_slice(\n    uint8_t** ptr_wptr,\n    uint8_t* wend,\n    puffs_base__slice_u8 src) {\n  uint8_t* wptr = *ptr_wptr;\n  size_t n = src.len;\n  if (n > wend - wptr) {\n    n = wend - wptr;\n  }\n  if (n > 0) {\n    memmove(wptr, src.ptr, n);\n    *ptr_wptr += n;\n  }\n  return n;\n}\n" +
-	"\nstatic inline uint32_t puffs_base__writer1__copy_from_slice32(\n    uint8_t** ptr_wptr,\n    uint8_t* wend,\n    puffs_base__slice_u8 src,\n    uint32_t length) {\n  uint8_t* wptr = *ptr_wptr;\n  size_t n = src.len;\n  if (n > length) {\n    n = length;\n  }\n  if (n > wend - wptr) {\n    n = wend - wptr;\n  }\n  if (n > 0) {\n    memmove(wptr, src.ptr, n);\n    *ptr_wptr += n;\n  }\n  return n;\n}\n\n// Note that the *__limit and *__mark methods are private (in base-impl.h) not\n// public (in base-header.h). We assume that, at the boundary between user code\n// and Puffs code, the reader1 and writer1's private_impl fields (including\n// limit and mark) are NULL. Otherwise, some internal assumptions break down.\n// For example, limits could be represented as pointers, even though\n// conceptually they are counts, but that pointer-to-count correspondence\n// becomes invalid if a buffer is re-used (e.g. on resuming a coroutine).\n//\n// Admittedly, some of the Puffs test code calls these methods, but that test\n// code is still Puffs code" +
-	", not user code. Other Puffs test code modifies\n// private_impl fields directly.\n\nstatic inline puffs_base__reader1 puffs_base__reader1__limit(\n    puffs_base__reader1* o,\n    uint64_t* ptr_to_len) {\n  puffs_base__reader1 ret = *o;\n  ret.private_impl.limit.ptr_to_len = ptr_to_len;\n  ret.private_impl.limit.next = &o->private_impl.limit;\n  return ret;\n}\n\nstatic inline puffs_base__empty_struct puffs_base__reader1__mark(\n    puffs_base__reader1* o,\n    uint8_t* mark) {\n  o->private_impl.mark = mark;\n  return ((puffs_base__empty_struct){});\n}\n\n// TODO: static inline puffs_base__writer1 puffs_base__writer1__limit()\n\nstatic inline puffs_base__empty_struct puffs_base__writer1__mark(\n    puffs_base__writer1* o,\n    uint8_t* mark) {\n  o->private_impl.mark = mark;\n  return ((puffs_base__empty_struct){});\n}\n\n#endif  // PUFFS_BASE_IMPL_H\n" +
+	"fs_base__load_u24be(uint8_t* p) {\n  return ((uint32_t)(p[0]) << 16) | ((uint32_t)(p[1]) << 8) |\n         ((uint32_t)(p[2]) << 0);\n}\n\nstatic inline uint32_t puffs_base__load_u24le(uint8_t* p) {\n  return ((uint32_t)(p[0]) << 0) | ((uint32_t)(p[1]) << 8) |\n         ((uint32_t)(p[2]) << 16);\n}\n\nstatic inline uint32_t puffs_base__load_u32be(uint8_t* p) {\n  return ((uint32_t)(p[0]) << 24) | ((uint32_t)(p[1]) << 16) |\n         ((uint32_t)(p[2]) << 8) | ((uint32_t)(p[3]) << 0);\n}\n\nstatic inline uint32_t puffs_base__load_u32le(uint8_t* p) {\n  return ((uint32_t)(p[0]) << 0) | ((uint32_t)(p[1]) << 8) |\n         ((uint32_t)(p[2]) << 16) | ((uint32_t)(p[3]) << 24);\n}\n\nstatic inline void puffs_base__store_u16be(uint8_t* p, uint16_t x) {\n  p[0] = (uint8_t)(x >> 8);\n  p[1] = (uint8_t)(x >> 0);\n}\n\nstatic inline void puffs_base__store_u16le(uint8_t* p, uint16_t x) {\n  p[0] = (uint8_t)(x >> 0);\n  p[1] = (uint8_t)(x >> 8);\n}\n\nstatic inline void puffs_base__store_u24be(uint8_t* p, uint32_t x) {\n  p[0] = (uint8_t)(x >> 16);\n  p[1]" +
+	" = (uint8_t)(x >> 8);\n  p[2] = (uint8_t)(x >> 0);\n}\n\nstatic inline void puffs_base__store_u24le(uint8_t* p, uint32_t x) {\n  p[0] = (uint8_t)(x >> 0);\n  p[1] = (uint8_t)(x >> 8);\n  p[2] = (uint8_t)(x >> 16);\n}\n\nstatic inline void puffs_base__store_u32be(uint8_t* p, uint32_t x) {\n  p[0] = (uint8_t)(x >> 24);\n  p[1] = (uint8_t)(x >> 16);\n  p[2] = (uint8_t)(x >> 8);\n  p[3] = (uint8_t)(x >> 0);\n}\n\nstatic inline void puffs_base__store_u32le(uint8_t* p, uint32_t x) {\n  p[0] = (uint8_t)(x >> 0);\n  p[1] = (uint8_t)(x >> 8);\n  p[2] = (uint8_t)(x >> 16);\n  p[3] = (uint8_t)(x >> 24);\n}\n\n// Saturating arithmetic. The \"~sat+\" and \"~sat-\" Puffs operators call these\n// functions. The \"~+\", \"~-\", etc. wrapping operators don't need helpers.\n\nstatic inline uint8_t puffs_base__u8__sat_add(uint8_t x, uint8_t y) {\n  uint8_t res = (uint8_t)(x + y);\n  return (res < x) ? UINT8_MAX : res;\n}\n\nstatic inline uint8_t puffs_base__u8__sat_sub(uint8_t x, uint8_t y) {\n  return (x > y) ? (uint8_t)(x - y) : 0;\n}\n\nstatic inline uint16_t puffs_ba" +
+	"se__u16__sat_add(uint16_t x, uint16_t y) {\n  uint16_t res = (uint16_t)(x + y);\n  return (res < x) ? UINT16_MAX : res;\n}\n\nstatic inline uint16_t puffs_base__u16__sat_sub(uint16_t x, uint16_t y) {\n  return (x > y) ? (uint16_t)(x - y) : 0;\n}\n\nstatic inline uint32_t puffs_base__u32__sat_add(uint32_t x, uint32_t y) {\n  uint32_t res = (uint32_t)(x + y);\n  return (res < x) ? UINT32_MAX : res;\n}\n\nstatic inline uint32_t puffs_base__u32__sat_sub(uint32_t x, uint32_t y) {\n  return (x > y) ? (uint32_t)(x - y) : 0;\n}\n\nstatic inline uint64_t puffs_base__u64__sat_add(uint64_t x, uint64_t y) {\n  uint64_t res = (uint64_t)(x + y);\n  return (res < x) ? UINT64_MAX : res;\n}\n\nstatic inline uint64_t puffs_base__u64__sat_sub(uint64_t x, uint64_t y) {\n  return (x > y) ? (uint64_t)(x - y) : 0;\n}\n\nstatic inline int8_t puffs_base__i8__sat_add(int8_t x, int8_t y) {\n  if ((y > 0) && (x > (INT8_MAX - y))) {\n    return INT8_MAX;\n  } else if ((y < 0) && (x < (INT8_MIN - y))) {\n    return INT8_MIN;\n  }\n  return (int8_t)(x + y);\n}\n\nstatic inli" +
+	"ne int8_t puffs_base__i8__sat_sub(int8_t x, int8_t y) {\n  if ((y < 0) && (x > (INT8_MAX + y))) {\n    return INT8_MAX;\n  } else if ((y > 0) && (x < (INT8_MIN + y))) {\n    return INT8_MIN;\n  }\n  return (int8_t)(x - y);\n}\n\nstatic inline int16_t puffs_base__i16__sat_add(int16_t x, int16_t y) {\n  if ((y > 0) && (x > (INT16_MAX - y))) {\n    return INT16_MAX;\n  } else if ((y < 0) && (x < (INT16_MIN - y))) {\n    return INT16_MIN;\n  }\n  return (int16_t)(x + y);\n}\n\nstatic inline int16_t puffs_base__i16__sat_sub(int16_t x, int16_t y) {\n  if ((y < 0) && (x > (INT16_MAX + y))) {\n    return INT16_MAX;\n  } else if ((y > 0) && (x < (INT16_MIN + y))) {\n    return INT16_MIN;\n  }\n  return (int16_t)(x - y);\n}\n\nstatic inline int32_t puffs_base__i32__sat_add(int32_t x, int32_t y) {\n  if ((y > 0) && (x > (INT32_MAX - y))) {\n    return INT32_MAX;\n  } else if ((y < 0) && (x < (INT32_MIN - y))) {\n    return INT32_MIN;\n  }\n  return (int32_t)(x + y);\n}\n\nstatic inline int32_t puffs_base__i32__sat_sub(int32_t x, int32_t y) {\n  if ((y < 0)" +
+	" && (x > (INT32_MAX + y))) {\n    return INT32_MAX;\n  } else if ((y > 0) && (x < (INT32_MIN + y))) {\n    return INT32_MIN;\n  }\n  return (int32_t)(x - y);\n}\n\nstatic inline int64_t puffs_base__i64__sat_add(int64_t x, int64_t y) {\n  if ((y > 0) && (x > (INT64_MAX - y))) {\n    return INT64_MAX;\n  } else if ((y < 0) && (x < (INT64_MIN - y))) {\n    return INT64_MIN;\n  }\n  return (int64_t)(x + y);\n}\n\nstatic inline int64_t puffs_base__i64__sat_sub(int64_t x, int64_t y) {\n  if ((y < 0) && (x > (INT64_MAX + y))) {\n    return INT64_MAX;\n  } else if ((y > 0) && (x < (INT64_MIN + y))) {\n    return INT64_MIN;\n  }\n  return (int64_t)(x - y);\n}\n\n// Bit manipulation. The \"clz\", \"ctz\", \"popcount\", \"reverse_bits\",\n// \"rotate_left\" and \"byte_swap\" Puffs methods call these functions. They use\n// compiler built-ins when available, with portable fallbacks otherwise.\n\nstatic inline uint64_t puffs_base__u64__clz(uint64_t x) {\n#if defined(__GNUC__)\n  return x ? (uint64_t)(__builtin_clzll(x)) : 64;\n#else\n  uint64_t n = 64;\n  while (x) {\n" +
+	"    x >>= 1;\n    n--;\n  }\n  return n;\n#endif\n}\n\nstatic inline uint64_t puffs_base__u64__ctz(uint64_t x) {\n#if defined(__GNUC__)\n  return x ? (uint64_t)(__builtin_ctzll(x)) : 64;\n#else\n  if (!x) {\n    return 64;\n  }\n  uint64_t n = 0;\n  while (!(x & 1)) {\n    x >>= 1;\n    n++;\n  }\n  return n;\n#endif\n}\n\nstatic inline uint64_t puffs_base__u64__popcount(uint64_t x) {\n#if defined(__GNUC__)\n  return (uint64_t)(__builtin_popcountll(x));\n#else\n  uint64_t n = 0;\n  while (x) {\n    x &= x - 1;\n    n++;\n  }\n  return n;\n#endif\n}\n\nstatic inline uint64_t puffs_base__u64__byte_swap(uint64_t x) {\n#if defined(__GNUC__)\n  return __builtin_bswap64(x);\n#else\n  x = ((x & 0x00FF00FF00FF00FFull) << 8) | ((x >> 8) & 0x00FF00FF00FF00FFull);\n  x = ((x & 0x0000FFFF0000FFFFull) << 16) | ((x >> 16) & 0x0000FFFF0000FFFFull);\n  return (x << 32) | (x >> 32);\n#endif\n}\n\n// puffs_base__u64__reverse_bits returns the low n bits of x, in reverse order.\n// n must be in the range [0..64].\nstatic inline uint64_t puffs_base__u64__reverse_bits(uint64_t " +
+	"x, uint32_t n) {\n  x = ((x & 0x5555555555555555ull) << 1) | ((x >> 1) & 0x5555555555555555ull);\n  x = ((x & 0x3333333333333333ull) << 2) | ((x >> 2) & 0x3333333333333333ull);\n  x = ((x & 0x0F0F0F0F0F0F0F0Full) << 4) | ((x >> 4) & 0x0F0F0F0F0F0F0F0Full);\n  x = puffs_base__u64__byte_swap(x);\n  return n ? (x >> (64 - n)) : 0;\n}\n\nstatic inline uint64_t puffs_base__u64__rotate_left(uint64_t x, uint32_t n) {\n  return (x << n) | (x >> ((64 - n) & 63));\n}\n\nstatic inline uint8_t puffs_base__u8__clz(uint8_t x) {\n  return (uint8_t)(puffs_base__u64__clz(x) - 56);\n}\n\nstatic inline uint8_t puffs_base__u8__ctz(uint8_t x) {\n  return x ? (uint8_t)(puffs_base__u64__ctz(x)) : 8;\n}\n\nstatic inline uint8_t puffs_base__u8__popcount(uint8_t x) {\n  return (uint8_t)(puffs_base__u64__popcount(x));\n}\n\nstatic inline uint8_t puffs_base__u8__reverse_bits(uint8_t x, uint32_t n) {\n  return (uint8_t)(puffs_base__u64__reverse_bits(x, n));\n}\n\nstatic inline uint8_t puffs_base__u8__rotate_left(uint8_t x, uint32_t n) {\n  return (uint8_t)((x << n) " +
+	"| (x >> ((8 - n) & 7)));\n}\n\nstatic inline uint16_t puffs_base__u16__clz(uint16_t x) {\n  return (uint16_t)(puffs_base__u64__clz(x) - 48);\n}\n\nstatic inline uint16_t puffs_base__u16__ctz(uint16_t x) {\n  return x ? (uint16_t)(puffs_base__u64__ctz(x)) : 16;\n}\n\nstatic inline uint16_t puffs_base__u16__popcount(uint16_t x) {\n  return (uint16_t)(puffs_base__u64__popcount(x));\n}\n\nstatic inline uint16_t puffs_base__u16__reverse_bits(uint16_t x, uint32_t n) {\n  return (uint16_t)(puffs_base__u64__reverse_bits(x, n));\n}\n\nstatic inline uint16_t puffs_base__u16__rotate_left(uint16_t x, uint32_t n) {\n  return (uint16_t)((x << n) | (x >> ((16 - n) & 15)));\n}\n\nstatic inline uint16_t puffs_base__u16__byte_swap(uint16_t x) {\n  return (uint16_t)((x << 8) | (x >> 8));\n}\n\nstatic inline uint32_t puffs_base__u32__clz(uint32_t x) {\n  return (uint32_t)(puffs_base__u64__clz(x) - 32);\n}\n\nstatic inline uint32_t puffs_base__u32__ctz(uint32_t x) {\n  return x ? (uint32_t)(puffs_base__u64__ctz(x)) : 32;\n}\n\nstatic inline uint32_t puffs_base__u3" +
+	"2__popcount(uint32_t x) {\n  return (uint32_t)(puffs_base__u64__popcount(x));\n}\n\nstatic inline uint32_t puffs_base__u32__reverse_bits(uint32_t x, uint32_t n) {\n  return (uint32_t)(puffs_base__u64__reverse_bits(x, n));\n}\n\nstatic inline uint32_t puffs_base__u32__rotate_left(uint32_t x, uint32_t n) {\n  return (x << n) | (x >> ((32 - n) & 31));\n}\n\nstatic inline uint32_t puffs_base__u32__byte_swap(uint32_t x) {\n  return (uint32_t)(puffs_base__u64__byte_swap(x) >> 32);\n}\n\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_i(\n    puffs_base__slice_u8 s,\n    uint64_t i) {\n  if ((i <= SIZE_MAX) && (i <= s.len)) {\n    return ((puffs_base__slice_u8){\n        .ptr = s.ptr + i,\n        .len = s.len - i,\n    });\n  }\n  return ((puffs_base__slice_u8){});\n}\n\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_j(\n    puffs_base__slice_u8 s,\n    uint64_t j) {\n  if ((j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u8){.ptr = s.ptr, .len = j});\n  }\n  return ((puffs_base__slice_u8){});\n" +
+	"}\n\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_ij(\n    puffs_base__slice_u8 s,\n    uint64_t i,\n    uint64_t j) {\n  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u8){\n        .ptr = s.ptr + i,\n        .len = j - i,\n    });\n  }\n  return ((puffs_base__slice_u8){});\n}\n\n// puffs_base__slice_u8__prefix returns up to the first up_to bytes of s.\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8__prefix(\n    puffs_base__slice_u8 s,\n    uint64_t up_to) {\n  if ((uint64_t)(s.len) > up_to) {\n    s.len = up_to;\n  }\n  return s;\n}\n\n// puffs_base__slice_u8__suffix returns up to the last up_to bytes of s.\nstatic inline puffs_base__slice_u8 puffs_base__slice_u8_suffix(\n    puffs_base__slice_u8 s,\n    uint64_t up_to) {\n  if ((uint64_t)(s.len) > up_to) {\n    s.ptr += (uint64_t)(s.len) - up_to;\n    s.len = up_to;\n  }\n  return s;\n}\n\n// puffs_base__slice_u8__copy_from_slice calls memmove(dst.ptr, src.ptr,\n// length) where length is the minimum of dst.len and src.len.\n//" +
+	"\n// Passing a puffs_base__slice_u8 with all fields NULL or zero (a valid, empty\n// slice) is valid and results in a no-op.\nstatic inline uint64_t puffs_base__slice_u8__copy_from_slice(\n    puffs_base__slice_u8 dst,\n    puffs_base__slice_u8 src) {\n  size_t length = dst.len < src.len ? dst.len : src.len;\n  if (length > 0) {\n    memmove(dst.ptr, src.ptr, length);\n  }\n  return length;\n}\n\nstatic inline uint32_t puffs_base__writer1__copy_from_history32(\n    uint8_t** ptr_ptr,\n    uint8_t* start,  // May be NULL, meaning an unmarked writer1.\n    uint8_t* end,\n    uint32_t distance,\n    uint32_t length) {\n  if (!start || !distance) {\n    return 0;\n  }\n  uint8_t* ptr = *ptr_ptr;\n  if ((size_t)(ptr - start) < (size_t)(distance)) {\n    return 0;\n  }\n  start = ptr - distance;\n  size_t n = end - ptr;\n  if ((size_t)(length) > n) {\n    length = n;\n  } else {\n    n = length;\n  }\n  // TODO: unrolling by 3 seems best for the std/flate benchmarks, but that is\n  // mostly because 3 is the minimum length for the flate format. Thi" +
+	"s function\n  // implementation shouldn't overfit to that one format. Perhaps the\n  // copy_from_history32 Puffs method should also take an unroll hint argument,\n  // and the cgen can look if that argument is the constant expression '3'.\n  //\n  // See also puffs_base__writer1__copy_from_history32__bco below.\n  //\n  // Alternatively, or additionally, have a sloppy_copy_from_history32 method\n  // that copies 8 bytes at a time, possibly writing more than length bytes?\n  for (; n >= 3; n -= 3) {\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n  }\n  for (; n; n--) {\n    *ptr++ = *start++;\n  }\n  *ptr_ptr = ptr;\n  return length;\n}\n\n// puffs_base__writer1__copy_from_history32__bco is a Bounds Check Optimized\n// version of the puffs_base__writer1__copy_from_history32 function above. The\n// caller needs to prove that:\n//  - start    != NULL\n//  - distance != 0\n//  - distance <= (*ptr_ptr - start)\n//  - length   <= (end      - *ptr_ptr)\nstatic inline uint32_t puffs_base__writer1__copy_from_history32_" +
+	"_bco(\n    uint8_t** ptr_ptr,\n    uint8_t* start,\n    uint8_t* end,\n    uint32_t distance,\n    uint32_t length) {\n  uint8_t* ptr = *ptr_ptr;\n  start = ptr - distance;\n  uint32_t n = length;\n  for (; n >= 3; n -= 3) {\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n    *ptr++ = *start++;\n  }\n  for (; n; n--) {\n    *ptr++ = *start++;\n  }\n  *ptr_ptr = ptr;\n  return length;\n}\n\nstatic inline uint32_t puffs_base__writer1__copy_from_reader32(\n    uint8_t** ptr_wptr,\n    uint8_t* wend,\n    uint8_t** ptr_rptr,\n    uint8_t* rend,\n    uint32_t length) {\n  uint8_t* wptr = *ptr_wptr;\n  size_t n = length;\n  if (n > wend - wptr) {\n    n = wend - wptr;\n  }\n  uint8_t* rptr = *ptr_rptr;\n  if (n > rend - rptr) {\n    n = rend - rptr;\n  }\n  if (n > 0) {\n    memmove(wptr, rptr, n);\n    *ptr_wptr += n;\n    *ptr_rptr += n;\n  }\n  return n;\n}\n\nstatic inline uint64_t puffs_base__writer1__copy_from_slice(\n    uint8_t** ptr_wptr,\n    uint8_t* wend,\n    puffs_base__slice_u8 src) {\n  uint8_t* wptr = *ptr_wptr;\n  size_t n = src.len;\n  if (n > " +
+	"wend - wptr) {\n    n = wend - wptr;\n  }\n  if (n > 0) {\n    memmove(wptr, src.ptr, n);\n    *ptr_wptr += n;\n  }\n  return n;\n}\n\nstatic inline uint32_t puffs_base__writer1__copy_from_slice32(\n    uint8_t** ptr_wptr,\n    uint8_t* wend,\n    puffs_base__slice_u8 src,\n    uint32_t length) {\n  uint8_t* wptr = *ptr_wptr;\n  size_t n = src.len;\n  if (n > length) {\n    n = length;\n  }\n  if (n > wend - wptr) {\n    n = wend - wptr;\n  }\n  if (n > 0) {\n    memmove(wptr, src.ptr, n);\n    *ptr_wptr += n;\n  }\n  return n;\n}\n\n// The puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64\n// functions below are like their puffs_base__slice_u8 equivalents above. The\n// copy_from_slice functions return the number of elements copied, not bytes.\n\nstatic inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_i(\n    puffs_base__slice_u16 s,\n    uint64_t i) {\n  if ((i <= SIZE_MAX) && (i <= s.len)) {\n    return ((puffs_base__slice_u16){\n        .ptr = s.ptr + i,\n        .len = s.len - i,\n    });\n  }\n  return ((puffs_ba" +
+	"se__slice_u16){});\n}\n\nstatic inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_j(\n    puffs_base__slice_u16 s,\n    uint64_t j) {\n  if ((j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u16){.ptr = s.ptr, .len = j});\n  }\n  return ((puffs_base__slice_u16){});\n}\n\nstatic inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_ij(\n    puffs_base__slice_u16 s,\n    uint64_t i,\n    uint64_t j) {\n  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u16){\n        .ptr = s.ptr + i,\n        .len = j - i,\n    });\n  }\n  return ((puffs_base__slice_u16){});\n}\n\nstatic inline uint64_t puffs_base__slice_u16__copy_from_slice(\n    puffs_base__slice_u16 dst,\n    puffs_base__slice_u16 src) {\n  size_t length = dst.len < src.len ? dst.len : src.len;\n  if (length > 0) {\n    memmove(dst.ptr, src.ptr, length * sizeof(uint16_t));\n  }\n  return length;\n}\n\nstatic inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_i(\n    puffs_base__slice_u32 s,\n    uint64_t i) {\n  if (" +
+	"(i <= SIZE_MAX) && (i <= s.len)) {\n    return ((puffs_base__slice_u32){\n        .ptr = s.ptr + i,\n        .len = s.len - i,\n    });\n  }\n  return ((puffs_base__slice_u32){});\n}\n\nstatic inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_j(\n    puffs_base__slice_u32 s,\n    uint64_t j) {\n  if ((j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u32){.ptr = s.ptr, .len = j});\n  }\n  return ((puffs_base__slice_u32){});\n}\n\nstatic inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_ij(\n    puffs_base__slice_u32 s,\n    uint64_t i,\n    uint64_t j) {\n  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u32){\n        .ptr = s.ptr + i,\n        .len = j - i,\n    });\n  }\n  return ((puffs_base__slice_u32){});\n}\n\nstatic inline uint64_t puffs_base__slice_u32__copy_from_slice(\n    puffs_base__slice_u32 dst,\n    puffs_base__slice_u32 src) {\n  size_t length = dst.len < src.len ? dst.len : src.len;\n  if (length > 0) {\n    memmove(dst.ptr, src.ptr, length * sizeof(uint3" +
+	"2_t));\n  }\n  return length;\n}\n\nstatic inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_i(\n    puffs_base__slice_u64 s,\n    uint64_t i) {\n  if ((i <= SIZE_MAX) && (i <= s.len)) {\n    return ((puffs_base__slice_u64){\n        .ptr = s.ptr + i,\n        .len = s.len - i,\n    });\n  }\n  return ((puffs_base__slice_u64){});\n}\n\nstatic inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_j(\n    puffs_base__slice_u64 s,\n    uint64_t j) {\n  if ((j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u64){.ptr = s.ptr, .len = j});\n  }\n  return ((puffs_base__slice_u64){});\n}\n\nstatic inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_ij(\n    puffs_base__slice_u64 s,\n    uint64_t i,\n    uint64_t j) {\n  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {\n    return ((puffs_base__slice_u64){\n        .ptr = s.ptr + i,\n        .len = j - i,\n    });\n  }\n  return ((puffs_base__slice_u64){});\n}\n\nstatic inline uint64_t puffs_base__slice_u64__copy_from_slice(\n    puffs_base__slice_u64 dst,\n    pu" +
+	"ffs_base__slice_u64 src) {\n  size_t length = dst.len < src.len ? dst.len : src.len;\n  if (length > 0) {\n    memmove(dst.ptr, src.ptr, length * sizeof(uint64_t));\n  }\n  return length;\n}\n\n// puffs_base__buf2__row returns row y of b. The caller needs to prove that\n// y < b.height.\nstatic inline puffs_base__slice_u8 puffs_base__buf2__row(puffs_base__buf2 b,\n                                                         uint64_t y) {\n  return ((puffs_base__slice_u8){\n      .ptr = b.ptr + (y * b.stride),\n      .len = b.width,\n  });\n}\n\n// puffs_base__buf2__set_u8 sets the byte at column x of row y of b. The caller\n// needs to prove that x < b.width and y < b.height.\nstatic inline puffs_base__empty_struct puffs_base__buf2__set_u8(\n    puffs_base__buf2 b,\n    uint64_t x,\n    uint64_t y,\n    uint8_t v) {\n  b.ptr[(y * b.stride) + x] = v;\n  return ((puffs_base__empty_struct){});\n}\n\n// puffs_base__bitreader__peek_bits returns the next n bits of b without\n// consuming them. The caller needs to prove that n <= 32 and n <= b.n_bit" +
+	"s.\nstatic inline uint32_t puffs_base__bitreader__peek_bits(puffs_base__bitreader b,\n                                                        uint32_t n) {\n  uint64_t mask = (((uint64_t)(1)) << n) - 1;\n  if (b.msb_first) {\n    return (uint32_t)((b.bits >> (b.n_bits - n)) & mask);\n  }\n  return (uint32_t)(b.bits & mask);\n}\n\n// puffs_base__bitreader__take_bits is like puffs_base__bitreader__peek_bits\n// but also consumes those bits.\nstatic inline uint32_t puffs_base__bitreader__take_bits(\n    puffs_base__bitreader* b,\n    uint32_t n) {\n  uint32_t ret = puffs_base__bitreader__peek_bits(*b, n);\n  if (!b->msb_first) {\n    b->bits >>= n;\n  }\n  b->n_bits -= n;\n  return ret;\n}\n\nstatic inline puffs_base__empty_struct puffs_base__bitreader__set_msb_first(\n    puffs_base__bitreader* b,\n    bool msb_first) {\n  b->msb_first = msb_first;\n  return ((puffs_base__empty_struct){});\n}\n\n// puffs_base__bitreader__refill_u8 appends one byte, x, to b. The caller needs\n// to prove that b->n_bits <= 55.\nstatic inline void puffs_base__bi" +
+	"treader__refill_u8(puffs_base__bitreader* b,\n                                                    uint8_t x) {\n  if (b->msb_first) {\n    b->bits = (b->bits << 8) | ((uint64_t)(x));\n  } else {\n    b->bits |= ((uint64_t)(x)) << b->n_bits;\n  }\n  b->n_bits += 8;\n}\n\n// puffs_base__bitreader__refill_fast reads the fewest whole bytes from p that\n// give b at least n bits, and returns how many bytes that is. It loads those\n// bytes with a single 64-bit read, so the caller needs to prove that there are\n// at least 8 bytes at p, and that b->n_bits < n <= 56. It reads at most 7 bytes.\nstatic inline size_t puffs_base__bitreader__refill_fast(\n    puffs_base__bitreader* b,\n    uint8_t* p,\n    uint32_t n) {\n  uint32_t n_bytes = (n - b->n_bits + 7) >> 3;\n  uint32_t n_new_bits = 8 * n_bytes;\n  if (b->msb_first) {\n    uint64_t x = ((uint64_t)(puffs_base__load_u32be(p + 0)) << 32) |\n                 ((uint64_t)(puffs_base__load_u32be(p + 4)));\n    b->bits = (b->bits << n_new_bits) | (x >> (64 - n_new_bits));\n  } else {\n    uint6" +
+	"4_t x = ((uint64_t)(puffs_base__load_u32le(p + 0))) |\n                 ((uint64_t)(puffs_base__load_u32le(p + 4)) << 32);\n    x &= (((uint64_t)(1)) << n_new_bits) - 1;\n    b->bits |= x << b->n_bits;\n  }\n  b->n_bits += n_new_bits;\n  return n_bytes;\n}\n\n// Note that the *__limit and *__mark methods are private (in base-impl.h) not\n// public (in base-header.h). We assume that, at the boundary between user code\n// and Puffs code, the reader1 and writer1's private_impl fields (including\n// limit and mark) are NULL. Otherwise, some internal assumptions break down.\n// For example, limits could be represented as pointers, even though\n// conceptually they are counts, but that pointer-to-count correspondence\n// becomes invalid if a buffer is re-used (e.g. on resuming a coroutine).\n//\n// Admittedly, some of the Puffs test code calls these methods, but that test\n// code is still Puffs code, not user code. Other Puffs test code modifies\n// private_impl fields directly.\n\nstatic inline puffs_base__reader1 puffs_base__reader1" +
+	"__limit(\n    puffs_base__reader1* o,\n    uint64_t* ptr_to_len) {\n  puffs_base__reader1 ret = *o;\n  ret.private_impl.limit.ptr_to_len = ptr_to_len;\n  ret.private_impl.limit.next = &o->private_impl.limit;\n  return ret;\n}\n\nstatic inline puffs_base__empty_struct puffs_base__reader1__mark(\n    puffs_base__reader1* o,\n    uint8_t* mark) {\n  o->private_impl.mark = mark;\n  return ((puffs_base__empty_struct){});\n}\n\nstatic inline puffs_base__writer1 puffs_base__writer1__limit(\n    puffs_base__writer1* o,\n    uint64_t* ptr_to_len) {\n  puffs_base__writer1 ret = *o;\n  ret.private_impl.limit.ptr_to_len = ptr_to_len;\n  ret.private_impl.limit.next = &o->private_impl.limit;\n  return ret;\n}\n\nstatic inline puffs_base__empty_struct puffs_base__writer1__mark(\n    puffs_base__writer1* o,\n    uint8_t* mark) {\n  o->private_impl.mark = mark;\n  return ((puffs_base__empty_struct){});\n}\n\n#endif  // PUFFS_BASE_IMPL_H\n" +
+	""
+
+const baseDebugImpl = "" +
+	"#ifndef PUFFS_BASE_DEBUG_IMPL_H\n#define PUFFS_BASE_DEBUG_IMPL_H\n\n// Copyright 2017 The Puffs Authors.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n// you may not use this file except in compliance with the License.\n// You may obtain a copy of the License at\n//\n//    https://www.apache.org/licenses/LICENSE-2.0\n//\n// Unless required by applicable law or agreed to in writing, software\n// distributed under the License is distributed on an \"AS IS\" BASIS,\n// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.\n// See the License for the specific language governing permissions and\n// limitations under the License.\n\n// This file is only part of C code generated by \"puffs-c gen -debug_checks\".\n// Such code re-checks, at run time, what the Puffs checker proved at compile\n// time: explicit assertions, while loop pre / inv / post conditions, index\n// bounds and refinement type bounds. A failed check is a bug in the checker\n// (or in puffs-c), not in the Puffs code being compil" +
+	"ed, so the program aborts\n// instead of returning an error status.\n//\n// Assertions whose arithmetic could overflow in C, unlike in the ideal integer\n// math that Puffs uses for assertions, are not re-checked.\n//\n// The msg arguments are string literals like \"decode_lzw.puffs:123: i < 4096\".\n\n#include <stdio.h>\n#include <stdlib.h>\n\nstatic inline void puffs_base__debug_check(bool ok, const char* msg) {\n  if (!ok) {\n    fprintf(stderr, \"puffs: debug check failed: %s\\n\", msg);\n    abort();\n  }\n}\n\n// puffs_base__debug_check_index returns i, after checking that i < n.\nstatic inline uint64_t puffs_base__debug_check_index(uint64_t i,\n                                                     uint64_t n,\n                                                     const char* msg) {\n  if (i >= n) {\n    fprintf(stderr,\n            \"puffs: debug check failed: %s: index %llu out of range [0..%llu)\\n\",\n            msg, (unsigned long long)(i), (unsigned long long)(n));\n    abort();\n  }\n  return i;\n}\n\n#endif  // PUFFS_BASE_DEBUG_IMPL_H" +
+	"\n" +
+	""
+
+const baseCoverageImpl = "" +
+	"#ifndef PUFFS_BASE_COVERAGE_IMPL_H\n#define PUFFS_BASE_COVERAGE_IMPL_H\n\n// Copyright 2017 The Puffs Authors.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n// you may not use this file except in compliance with the License.\n// You may obtain a copy of the License at\n//\n//    https://www.apache.org/licenses/LICENSE-2.0\n//\n// Unless required by applicable law or agreed to in writing, software\n// distributed under the License is distributed on an \"AS IS\" BASIS,\n// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.\n// See the License for the specific language governing permissions and\n// limitations under the License.\n\n// This file is only part of C code generated by \"puffs-c gen -coverage\". Such\n// code counts how often each Puffs statement (and each implicit, empty else\n// branch) runs, and how often each coroutine suspension point is resumed. On\n// exit, each package's counters are appended to the coverage profile named by\n// the PUFFS_COVERAGE_OUT environment variab" +
+	"le, if set, one \"position count\"\n// line per counter, such as \"std/gif/decode_gif.puffs:123:stmt 45\". The \"puffs\n// cover\" and \"puffs test -stress_io\" commands read that profile.\n\n#include <stdio.h>\n#include <stdlib.h>\n\n// PUFFS_BASE__COVERAGE_CONSTRUCTOR marks a function that registers, before\n// main runs, an atexit handler that writes that package's counters.\n#if defined(__GNUC__)\n#define PUFFS_BASE__COVERAGE_CONSTRUCTOR __attribute__((constructor))\n#else\n#error \"Puffs coverage requires __attribute__((constructor))\"\n#endif\n\nstatic inline void puffs_base__coverage_write(const char** positions,\n                                              uint64_t* counts,\n                                              size_t n) {\n  const char* filename = getenv(\"PUFFS_COVERAGE_OUT\");\n  if (!filename) {\n    return;\n  }\n  FILE* f = fopen(filename, \"a\");\n  if (!f) {\n    return;\n  }\n  size_t i;\n  for (i = 0; i < n; i++) {\n    fprintf(f, \"%s %llu\\n\", positions[i], (unsigned long long)(counts[i]));\n  }\n  fclose(f);\n}\n\n#endif  // " +
+	"PUFFS_BASE_COVERAGE_IMPL_H\n" +
+	""
+
+const baseFuzzImpl = "" +
+	"#ifndef PUFFS_BASE_FUZZ_IMPL_H\n#define PUFFS_BASE_FUZZ_IMPL_H\n\n// Copyright 2017 The Puffs Authors.\n//\n// Licensed under the Apache License, Version 2.0 (the \"License\");\n// you may not use this file except in compliance with the License.\n// You may obtain a copy of the License at\n//\n//    https://www.apache.org/licenses/LICENSE-2.0\n//\n// Unless required by applicable law or agreed to in writing, software\n// distributed under the License is distributed on an \"AS IS\" BASIS,\n// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.\n// See the License for the specific language governing permissions and\n// limitations under the License.\n\n// This file is only part of C code generated by \"puffs-c gen -fuzz_targets\".\n// Such code also contains a fuzz target function for each public decoder\n// method, a coroutine taking (dst writer1, src reader1), and a libFuzzer\n// entry point, LLVMFuzzerTestOneInput, that calls the fuzz target named by the\n// PUFFS_FUZZ_TARGET macro. Each target is also listed in a" +
+	" \"// !! puffs fuzz\n// target: etc\" comment, for the \"puffs fuzz\" command.\n//\n// The fuzz targets feed the src input, and drain the dst output, in chunks of\n// pseudo-random size, to exercise suspending and resuming the coroutines. The\n// chunk sizes are derived from a hash of the input, so that each run on the\n// same input is the same.\n//\n// Defining PUFFS_FUZZ_MAIN adds a main function that runs the fuzz target on\n// each file named by the command line arguments, or on stdin if there are\n// none. This works with fuzzers such as AFL, and with compilers other than\n// clang, and replays libFuzzer's crash files.\n\n#include <stdio.h>\n#include <stdlib.h>\n#include <string.h>\n\n#define PUFFS_BASE__FUZZ_DST_LEN 65536\n\nstatic uint8_t puffs_base__fuzz_dst_array[PUFFS_BASE__FUZZ_DST_LEN];\n\nstatic inline void puffs_base__fuzz_fail(const char* msg) {\n  fprintf(stderr, \"puffs: fuzz target failed: %s\\n\", msg);\n  abort();\n}\n\n// puffs_base__fuzz_hash returns a 64-bit FNV-1a hash of the input, used to\n// seed the chunk sizes' p" +
+	"seudo-random number generator.\nstatic inline uint64_t puffs_base__fuzz_hash(const uint8_t* data,\n                                             size_t size) {\n  uint64_t h = 0xcbf29ce484222325ull;\n  size_t i;\n  for (i = 0; i < size; i++) {\n    h ^= data[i];\n    h *= 0x100000001b3ull;\n  }\n  return h ? h : 1;\n}\n\n// puffs_base__fuzz_chunk_size returns a pseudo-random chunk size in the range\n// [1..max], biased towards small sizes, which hit the most suspension points.\n// It steps rng, an xorshift64 generator's state.\nstatic inline size_t puffs_base__fuzz_chunk_size(uint64_t* rng, size_t max) {\n  uint64_t x = *rng;\n  x ^= x << 13;\n  x ^= x >> 7;\n  x ^= x << 17;\n  *rng = x;\n  uint64_t n = 0;\n  switch (x & 3) {\n    case 0:\n      n = 1;\n      break;\n    case 1:\n      n = 16;\n      break;\n    case 2:\n      n = 4096;\n      break;\n    default:\n      n = max;\n      break;\n  }\n  if (n > max) {\n    n = max;\n  }\n  n = 1 + ((x >> 2) % n);\n  return (size_t)(n);\n}\n\n// puffs_base__fuzz_feed makes up to a chunk more of the input " +
+	"visible to the\n// src reader, closing src once all of the input is visible.\nstatic inline void puffs_base__fuzz_feed(puffs_base__buf1* src,\n                                         size_t size,\n                                         uint64_t* rng) {\n  if (src->wi < size) {\n    src->wi += puffs_base__fuzz_chunk_size(rng, size - src->wi);\n  }\n  src->closed = src->wi == size;\n}\n\n// puffs_base__fuzz_drain discards what the dst writer has written, and gives\n// it a chunk of room for more. If max_room is set, it gives all the room.\nstatic inline void puffs_base__fuzz_drain(puffs_base__buf1* dst,\n                                          uint64_t* rng,\n                                          bool max_room) {\n  dst->ri = 0;\n  dst->wi = 0;\n  dst->len = max_room\n                 ? PUFFS_BASE__FUZZ_DST_LEN\n                 : puffs_base__fuzz_chunk_size(rng, PUFFS_BASE__FUZZ_DST_LEN);\n}\n\n#ifdef PUFFS_FUZZ_TARGET\n\nvoid PUFFS_FUZZ_TARGET(const uint8_t* data, size_t size);\n\nint LLVMFuzzerTestOneInput(const uint8_t* data" +
+	", size_t size) {\n  PUFFS_FUZZ_TARGET(data, size);\n  return 0;\n}\n\n#ifdef PUFFS_FUZZ_MAIN\n\nstatic int puffs_base__fuzz_run_file(const char* filename, FILE* f) {\n  size_t len = 0;\n  size_t cap = 4096;\n  uint8_t* ptr = malloc(cap);\n  while (ptr) {\n    len += fread(ptr + len, 1, cap - len, f);\n    if (len < cap) {\n      break;\n    }\n    cap *= 2;\n    uint8_t* p = realloc(ptr, cap);\n    if (!p) {\n      free(ptr);\n    }\n    ptr = p;\n  }\n  if (!ptr || ferror(f)) {\n    fprintf(stderr, \"%s: could not read input\\n\", filename);\n    free(ptr);\n    return 1;\n  }\n  LLVMFuzzerTestOneInput(ptr, len);\n  free(ptr);\n  return 0;\n}\n\nint main(int argc, char** argv) {\n  if (argc < 2) {\n    return puffs_base__fuzz_run_file(\"stdin\", stdin);\n  }\n  int i;\n  for (i = 1; i < argc; i++) {\n    FILE* f = fopen(argv[i], \"r\");\n    if (!f) {\n      fprintf(stderr, \"%s: could not open input\\n\", argv[i]);\n      return 1;\n    }\n    int ret = puffs_base__fuzz_run_file(argv[i], f);\n    fclose(f);\n    if (ret) {\n      return ret;\n    }\n  }\n  return 0;" +
+	"\n}\n\n#endif  // PUFFS_FUZZ_MAIN\n\n#endif  // PUFFS_FUZZ_TARGET\n\n#endif  // PUFFS_BASE_FUZZ_IMPL_H\n" +
 	""
 
 type template_args_short_read struct {
@@ -19,6 +19,7 @@ import (
 	"strings"
 
 	"github.com/google/puffs/lang/builtin"
+	"github.com/google/puffs/lang/check"
 
 	a "github.com/google/puffs/lang/ast"
 	t "github.com/google/puffs/lang/token"
@@ -86,6 +87,12 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 	case 0:
 		if id1 := n.ID1(); id1.Key() == t.KeyThis {
 			b.writes("self")
+		} else if id1.IsStrLiteral() {
+			s, ok := t.Unescape(id1.String(g.tm))
+			if !ok {
+				return fmt.Errorf("invalid string literal %q", id1.String(g.tm))
+			}
+			b.writes(cString(s))
 		} else {
 			if n.GlobalIdent() {
 				b.writes(g.pkgPrefix)
@@ -133,6 +140,56 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 			b.writes(")))")
 			return nil
 		}
+		// TODO: delete this hack that only matches "foo.clz()" etc.
+		if isThatMethod(g.tm, n, t.KeyClz, 0) || isThatMethod(g.tm, n, t.KeyCtz, 0) ||
+			isThatMethod(g.tm, n, t.KeyPopcount, 0) || isThatMethod(g.tm, n, t.KeyReverseBits, 1) ||
+			isThatMethod(g.tm, n, t.KeyRotateLeft, 1) || isThatMethod(g.tm, n, t.KeyByteSwap, 0) {
+			// "x.clz()" in C is "puffs_base__u32__clz(x)", for a u32 typed x,
+			// and "x.rotate_left(n:etc)" is "puffs_base__u32__rotate_left(x, n)".
+			x := n.LHS().Expr().LHS().Expr()
+			b.printf("puffs_base__%s__%s(", n.MType().Name().String(g.tm), n.LHS().Expr().ID1().String(g.tm))
+			if err := g.writeExpr(b, x, rp, parenthesesOptional, depth); err != nil {
+				return err
+			}
+			for _, o := range n.Args() {
+				b.writes(", ")
+				if err := g.writeExpr(b, o.Arg().Value(), rp, parenthesesOptional, depth); err != nil {
+					return err
+				}
+			}
+			b.writeb(')')
+			return nil
+		}
+		// TODO: delete this hack that only matches "foo.n_bits()" etc.
+		if isThatMethod(g.tm, n, t.KeyNBits, 0) || isThatMethod(g.tm, n, t.KeyPeekBits, 1) ||
+			isThatMethod(g.tm, n, t.KeyTakeBits, 1) || isThatMethod(g.tm, n, t.KeySetMSBFirst, 1) {
+			// "br.n_bits()" in C is "br.n_bits", "br.peek_bits(n:etc)" is
+			// "puffs_base__bitreader__peek_bits(br, n)" and the impure methods
+			// take a pointer: "puffs_base__bitreader__take_bits(&br, n)".
+			x := n.LHS().Expr().LHS().Expr()
+			key := n.LHS().Expr().ID1().Key()
+			if key == t.KeyNBits {
+				b.writeb('(')
+				if err := g.writeExpr(b, x, rp, parenthesesMandatory, depth); err != nil {
+					return err
+				}
+				b.writes(".n_bits)")
+				return nil
+			}
+			b.printf("puffs_base__bitreader__%s(", n.LHS().Expr().ID1().String(g.tm))
+			if key != t.KeyPeekBits {
+				b.writeb('&')
+			}
+			if err := g.writeExpr(b, x, rp, parenthesesMandatory, depth); err != nil {
+				return err
+			}
+			b.writes(", ")
+			if err := g.writeExpr(b, n.Args()[0].Arg().Value(), rp, parenthesesOptional, depth); err != nil {
+				return err
+			}
+			b.writeb(')')
+			return nil
+		}
 		if isThatMethod(g.tm, n, t.KeyIsError, 0) || isThatMethod(g.tm, n, t.KeyIsOK, 0) ||
 			isThatMethod(g.tm, n, t.KeyIsSuspension, 0) {
 			if pp == parenthesesMandatory {
@@ -158,9 +215,11 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 			return nil
 		}
 		if isThatMethod(g.tm, n, t.KeySuffix, 1) {
-			// TODO: don't assume that the slice is a slice of u8.
-			b.writes("puffs_base__slice_u8_suffix(")
 			x := n.LHS().Expr().LHS().Expr()
+			if inner := x.MType().Inner(); inner.Decorator() != 0 || inner.Name().Key() != t.KeyU8 {
+				return fmt.Errorf(`TODO: cgen a "foo.suffix" expression for %q`, x.MType().String(g.tm))
+			}
+			b.writes("puffs_base__slice_u8_suffix(")
 			if err := g.writeExpr(b, x, rp, parenthesesOptional, depth); err != nil {
 				return err
 			}
@@ -271,8 +330,12 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 			return nil
 		}
 		if isThatMethod(g.tm, n, t.KeyCopyFromSlice, 1) {
-			b.writes("puffs_base__slice_u8__copy_from_slice(")
 			receiver := n.LHS().Expr().LHS().Expr()
+			sliceType, err := g.sliceTypeName(receiver.MType())
+			if err != nil {
+				return err
+			}
+			b.printf("%s__copy_from_slice(", sliceType)
 			if err := g.writeExpr(b, receiver, rp, parenthesesOptional, depth); err != nil {
 				return err
 			}
@@ -323,6 +386,54 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 			}
 			return nil
 		}
+		if isThatMethod(g.tm, n, t.KeyWidth, 0) || isThatMethod(g.tm, n, t.KeyHeight, 0) ||
+			isThatMethod(g.tm, n, t.KeyPixelFormat, 0) {
+			if pp == parenthesesMandatory {
+				b.writeb('(')
+			}
+			field := ""
+			switch key := n.LHS().Expr().ID1().Key(); key {
+			case t.KeyWidth:
+				field = "width"
+			case t.KeyHeight:
+				field = "height"
+			case t.KeyPixelFormat:
+				field = "pixfmt"
+			default:
+				return fmt.Errorf("unrecognized token.Key (0x%X) for writeExprOther's buf2 field", key)
+			}
+			if field != "pixfmt" {
+				b.writes("(uint64_t)")
+			}
+			b.writeb('(')
+			if err := g.writeExpr(b, n.LHS().Expr().LHS().Expr(), rp, parenthesesMandatory, depth); err != nil {
+				return err
+			}
+			b.printf(".%s)", field)
+			if pp == parenthesesMandatory {
+				b.writeb(')')
+			}
+			return nil
+		}
+		if isThatMethod(g.tm, n, t.KeyRow, 1) || isThatMethod(g.tm, n, t.KeySetU8, 3) {
+			if n.LHS().Expr().ID1().Key() == t.KeyRow {
+				b.writes("puffs_base__buf2__row(")
+			} else {
+				b.writes("puffs_base__buf2__set_u8(")
+			}
+			receiver := n.LHS().Expr().LHS().Expr()
+			if err := g.writeExpr(b, receiver, rp, parenthesesOptional, depth); err != nil {
+				return err
+			}
+			for _, o := range n.Args() {
+				b.writeb(',')
+				if err := g.writeExpr(b, o.Arg().Value(), rp, parenthesesOptional, depth); err != nil {
+					return err
+				}
+			}
+			b.writeb(')')
+			return nil
+		}
 		if isThatMethod(g.tm, n, g.tm.ByName("update").Key(), 1) {
 			// TODO: don't hard-code this.adler.
 			b.printf("%sadler32__update(&self->private_impl.f_adler, ", g.pkgPrefix)
@@ -366,10 +477,12 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 			return err
 		}
 		if lTyp := n.LHS().Expr().MType(); lTyp.Decorator().Key() == t.KeyColon {
-			// TODO: don't assume that the slice is a slice of u8.
 			b.writes(".ptr")
 		}
 		b.writeb('[')
+		if g.debugChecks {
+			return g.writeDebugCheckIndex(b, n, rp, depth)
+		}
 		if err := g.writeExpr(b, n.RHS().Expr(), rp, parenthesesOptional, depth); err != nil {
 			return err
 		}
@@ -381,19 +494,22 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 		lhs := n.LHS().Expr()
 		mhs := n.MHS().Expr()
 		rhs := n.RHS().Expr()
+		sliceType, err := g.sliceTypeName(n.MType())
+		if err != nil {
+			return err
+		}
 		switch {
 		case mhs != nil && rhs == nil:
-			b.writes("puffs_base__slice_u8__subslice_i(")
+			b.printf("%s__subslice_i(", sliceType)
 		case mhs == nil && rhs != nil:
-			b.writes("puffs_base__slice_u8__subslice_j(")
+			b.printf("%s__subslice_j(", sliceType)
 		case mhs != nil && rhs != nil:
-			b.writes("puffs_base__slice_u8__subslice_ij(")
+			b.printf("%s__subslice_ij(", sliceType)
 		}
 
 		lhsIsArray := lhs.MType().Decorator().Key() == t.KeyOpenBracket
 		if lhsIsArray {
-			// TODO: don't assume that the slice is a slice of u8.
-			b.writes("((puffs_base__slice_u8){.ptr=")
+			b.printf("((%s){.ptr=", sliceType)
 		}
 		if err := g.writeExpr(b, lhs, rp, parenthesesOptional, depth); err != nil {
 			return err
@@ -430,6 +546,15 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 		if err := g.writeExpr(b, lhs, rp, parenthesesMandatory, depth); err != nil {
 			return err
 		}
+		if u, ok := g.unionForExpr(lhs); ok {
+			if n.ID1().Key() == t.KeyKind {
+				b.writes(".tag")
+			} else {
+				b.printf(".variants.%s%s.%s%s", uPrefix, u.Variants[n.ID1()].String(g.tm),
+					fPrefix, n.ID1().String(g.tm))
+			}
+			return nil
+		}
 		if key := lhs.MType().Decorator().Key(); key == t.KeyPtr || key == t.KeyNptr {
 			b.writes("->")
 		} else {
@@ -455,6 +580,45 @@ func (g *gen) writeExprOther(b *buffer, n *a.Expr, rp replacementPolicy, pp pare
 	return fmt.Errorf("unrecognized token.Key (0x%X) for writeExprOther", n.ID0().Key())
 }
 
+// writeDebugCheckIndex writes the "i" in "a[i]" as a run time check that i is
+// within a's bounds, followed by the closing ']'. The array or slice a is
+// pure, so evaluating it twice is OK.
+func (g *gen) writeDebugCheckIndex(b *buffer, n *a.Expr, rp replacementPolicy, depth uint32) error {
+	b.writes("puffs_base__debug_check_index(")
+	if err := g.writeExpr(b, n.RHS().Expr(), rp, parenthesesOptional, depth); err != nil {
+		return err
+	}
+	b.writeb(',')
+	if lhs := n.LHS().Expr(); lhs.MType().Decorator().Key() == t.KeyColon {
+		if err := g.writeExpr(b, lhs, replaceNothing, parenthesesMandatory, depth); err != nil {
+			return err
+		}
+		b.writes(".len")
+	} else {
+		b.printf("%v", lhs.MType().ArrayLength().ConstValue())
+	}
+	b.printf(", %s)]", cString(g.debugCheckPosition()+": "+n.String(g.tm)))
+	return nil
+}
+
+// unionForExpr returns the union that is n's type, if n has a union type.
+func (g *gen) unionForExpr(n *a.Expr) (check.Union, bool) {
+	if n.MType().Decorator() != 0 {
+		return check.Union{}, false
+	}
+	u, ok := g.checker.Unions()[n.MType().Name()]
+	return u, ok
+}
+
+// unionForTag returns the union whose tag is n, if n is like "x.kind" for a
+// union-typed x.
+func (g *gen) unionForTag(n *a.Expr) (check.Union, bool) {
+	if n.ID0().Key() != t.KeyDot || n.ID1().Key() != t.KeyKind {
+		return check.Union{}, false
+	}
+	return g.unionForExpr(n.LHS().Expr())
+}
+
 func (g *gen) writeExprUnaryOp(b *buffer, n *a.Expr, rp replacementPolicy, pp parenthesesPolicy, depth uint32) error {
 	b.writes(cOpNames[0xFF&n.ID0().Key()])
 	return g.writeExpr(b, n.RHS().Expr(), rp, parenthesesMandatory, depth)
@@ -462,8 +626,16 @@ func (g *gen) writeExprUnaryOp(b *buffer, n *a.Expr, rp replacementPolicy, pp pa
 
 func (g *gen) writeExprBinaryOp(b *buffer, n *a.Expr, rp replacementPolicy, pp parenthesesPolicy, depth uint32) error {
 	op := n.ID0()
-	if op.Key() == t.KeyXBinaryAs {
+	switch op.Key() {
+	case t.KeyXBinaryAs:
 		return g.writeExprAs(b, n.LHS().Expr(), n.RHS().TypeExpr(), rp, depth)
+	case t.KeyXBinaryTildeAs:
+		return g.writeExprTildeAs(b, n.LHS().Expr(), n.RHS().TypeExpr(), rp, depth)
+	case t.KeyXBinaryTildePlus, t.KeyXBinaryTildeMinus, t.KeyXBinaryTildeStar, t.KeyXBinaryTildeShiftL,
+		t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+		if !tildeOpIsPlainC(op.Key(), n.MType()) {
+			return g.writeExprTildeOp(b, op.Key(), n.MType(), n.LHS().Expr(), n.RHS().Expr(), rp, depth)
+		}
 	}
 	if pp == parenthesesMandatory {
 		b.writeb('(')
@@ -482,6 +654,72 @@ func (g *gen) writeExprBinaryOp(b *buffer, n *a.Expr, rp replacementPolicy, pp p
 	return nil
 }
 
+// tildeOpIsPlainC returns whether the wrapping or saturating op, such as
+// t.KeyXBinaryTildePlus, can be written as the plain C operator for operands
+// of type typ. C's unsigned arithmetic already wraps around for u32 and u64,
+// but narrower types are promoted to (signed) int, and signed overflow is
+// undefined behavior.
+func tildeOpIsPlainC(op t.Key, typ *a.TypeExpr) bool {
+	switch op {
+	case t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+		return false
+	}
+	key := typ.Name().Key()
+	return key == t.KeyU32 || key == t.KeyU64
+}
+
+// writeExprTildeOp writes a wrapping or saturating "lhs op rhs", for operands
+// of type typ, when tildeOpIsPlainC is false. Wrapping ops convert to a wide
+// enough unsigned type, do the arithmetic there and convert back. Saturating
+// ops call helper functions in base-impl.h.
+func (g *gen) writeExprTildeOp(b *buffer, op t.Key, typ *a.TypeExpr, lhs *a.Expr, rhs *a.Expr,
+	rp replacementPolicy, depth uint32) error {
+
+	key := typ.Name().Key()
+	switch op {
+	case t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+		fName := "sat_add"
+		if op == t.KeyXBinaryTildeSatMinus {
+			fName = "sat_sub"
+		}
+		b.printf("puffs_base__%s__%s(", typ.Name().String(g.tm), fName)
+		if err := g.writeExpr(b, lhs, rp, parenthesesOptional, depth); err != nil {
+			return err
+		}
+		b.writes(", ")
+		if err := g.writeExpr(b, rhs, rp, parenthesesOptional, depth); err != nil {
+			return err
+		}
+		b.writeb(')')
+		return nil
+	}
+
+	wide := "uint32_t"
+	if key == t.KeyU64 || key == t.KeyI64 {
+		wide = "uint64_t"
+	}
+	b.printf("((%s)(((%s)(", cTypeNames[key], wide)
+	if err := g.writeExpr(b, lhs, rp, parenthesesOptional, depth); err != nil {
+		return err
+	}
+	b.writes("))")
+	b.writes(cOpNames[0xFF&op])
+	if op == t.KeyXBinaryTildeShiftL {
+		// The shift amount does not need converting.
+		if err := g.writeExpr(b, rhs, rp, parenthesesMandatory, depth); err != nil {
+			return err
+		}
+	} else {
+		b.printf("((%s)(", wide)
+		if err := g.writeExpr(b, rhs, rp, parenthesesOptional, depth); err != nil {
+			return err
+		}
+		b.writes("))")
+	}
+	b.writes("))")
+	return nil
+}
+
 func (g *gen) writeExprAs(b *buffer, lhs *a.Expr, rhs *a.TypeExpr, rp replacementPolicy, depth uint32) error {
 	b.writes("((")
 	// TODO: watch for passing an array type to writeCTypeName? In C, an array
@@ -497,6 +735,33 @@ func (g *gen) writeExprAs(b *buffer, lhs *a.Expr, rhs *a.TypeExpr, rp replacemen
 	return nil
 }
 
+// writeExprTildeAs writes a truncating "lhs ~as rhs". Converting to an
+// unsigned type discards the high bits, as C's conversion is modular. For a
+// signed type, such as i8, converting an out of range value is implementation
+// defined, so, like writeExprTildeOp, it converts to the unsigned type of the
+// same width, such as u8, and then reinterprets that as two's complement.
+func (g *gen) writeExprTildeAs(b *buffer, lhs *a.Expr, rhs *a.TypeExpr, rp replacementPolicy, depth uint32) error {
+	unsigned := ""
+	switch rhs.Name().Key() {
+	case t.KeyI8:
+		unsigned = "uint8_t"
+	case t.KeyI16:
+		unsigned = "uint16_t"
+	case t.KeyI32:
+		unsigned = "uint32_t"
+	case t.KeyI64:
+		unsigned = "uint64_t"
+	default:
+		return g.writeExprAs(b, lhs, rhs, rp, depth)
+	}
+	b.printf("((%s)((%s)(", cTypeNames[rhs.Name().Key()], unsigned)
+	if err := g.writeExpr(b, lhs, rp, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+	b.writes(")))")
+	return nil
+}
+
 func (g *gen) writeExprAssociativeOp(b *buffer, n *a.Expr, rp replacementPolicy, pp parenthesesPolicy, depth uint32) error {
 	if pp == parenthesesMandatory {
 		b.writeb('(')
@@ -519,18 +784,17 @@ func (g *gen) writeExprAssociativeOp(b *buffer, n *a.Expr, rp replacementPolicy,
 func (g *gen) writeCTypeName(b *buffer, n *a.TypeExpr, varNamePrefix string, varName string) error {
 	// It may help to refer to http://unixwiz.net/techtips/reading-cdecl.html
 
-	// TODO: fix this, allow slices of all types, not just of u8's. Also allow
-	// arrays of slices, slices of pointers, etc.
+	// TODO: allow arrays of slices, slices of pointers, etc.
 	if n.Decorator().Key() == t.KeyColon {
-		o := n.Inner()
-		if o.Decorator() == 0 && o.Name().Key() == t.KeyU8 && !o.IsRefined() {
-			b.writes("puffs_base__slice_u8")
-			b.writeb(' ')
-			b.writes(varNamePrefix)
-			b.writes(varName)
-			return nil
+		s, err := g.sliceTypeName(n)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("cannot convert Puffs type %q to C", n.String(g.tm))
+		b.writes(s)
+		b.writeb(' ')
+		b.writes(varNamePrefix)
+		b.writes(varName)
+		return nil
 	}
 
 	// maxNumPointers is an arbitrary implementation restriction.
@@ -583,21 +847,40 @@ func (g *gen) writeCTypeName(b *buffer, n *a.TypeExpr, varNamePrefix string, var
 	return nil
 }
 
+// sliceTypeName returns the C type name of the slice type n, such as
+// "puffs_base__slice_u16" for "[] u16" or "puffs_foo__slice_bar" for "[] bar",
+// where bar is a struct.
+func (g *gen) sliceTypeName(n *a.TypeExpr) (string, error) {
+	if n.Decorator().Key() == t.KeyColon {
+		if o := n.Inner(); o.Decorator() == 0 {
+			switch o.Name().Key() {
+			case t.KeyU8, t.KeyU16, t.KeyU32, t.KeyU64:
+				return "puffs_base__slice_" + o.Name().String(g.tm), nil
+			}
+			if _, ok := g.structMap[o.Name()]; ok {
+				return g.pkgPrefix + "slice_" + o.Name().String(g.tm), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cannot convert Puffs type %q to C", n.String(g.tm))
+}
+
 var cTypeNames = [...]string{
-	t.KeyI8:      "int8_t",
-	t.KeyI16:     "int16_t",
-	t.KeyI32:     "int32_t",
-	t.KeyI64:     "int64_t",
-	t.KeyU8:      "uint8_t",
-	t.KeyU16:     "uint16_t",
-	t.KeyU32:     "uint32_t",
-	t.KeyU64:     "uint64_t",
-	t.KeyUsize:   "size_t",
-	t.KeyBool:    "bool",
-	t.KeyBuf1:    "puffs_base__buf1",
-	t.KeyReader1: "puffs_base__reader1",
-	t.KeyWriter1: "puffs_base__writer1",
-	t.KeyBuf2:    "puffs_base__buf2",
+	t.KeyI8:        "int8_t",
+	t.KeyI16:       "int16_t",
+	t.KeyI32:       "int32_t",
+	t.KeyI64:       "int64_t",
+	t.KeyU8:        "uint8_t",
+	t.KeyU16:       "uint16_t",
+	t.KeyU32:       "uint32_t",
+	t.KeyU64:       "uint64_t",
+	t.KeyUsize:     "size_t",
+	t.KeyBool:      "bool",
+	t.KeyBuf1:      "puffs_base__buf1",
+	t.KeyReader1:   "puffs_base__reader1",
+	t.KeyWriter1:   "puffs_base__writer1",
+	t.KeyBuf2:      "puffs_base__buf2",
+	t.KeyBitreader: "puffs_base__bitreader",
 }
 
 var cOpNames = [256]string{
@@ -615,6 +898,12 @@ var cOpNames = [256]string{
 	t.KeyPercentEq:   " %= ",
 	t.KeyTildePlusEq: " += ",
 
+	t.KeyTildeMinusEq:    " -= ",
+	t.KeyTildeStarEq:     " *= ",
+	t.KeyTildeShiftLEq:   " <<= ",
+	t.KeyTildeSatPlusEq:  " no_such_sat_add_C_operator ",
+	t.KeyTildeSatMinusEq: " no_such_sat_sub_C_operator ",
+
 	t.KeyXUnaryPlus:  " + ",
 	t.KeyXUnaryMinus: " - ",
 	t.KeyXUnaryNot:   " ! ",
@@ -643,6 +932,13 @@ var cOpNames = [256]string{
 	t.KeyXBinaryAs:          " no_such_as_C_operator ",
 	t.KeyXBinaryTildePlus:   " + ",
 
+	t.KeyXBinaryTildeMinus:    " - ",
+	t.KeyXBinaryTildeStar:     " * ",
+	t.KeyXBinaryTildeShiftL:   " << ",
+	t.KeyXBinaryTildeSatPlus:  " no_such_sat_add_C_operator ",
+	t.KeyXBinaryTildeSatMinus: " no_such_sat_sub_C_operator ",
+	t.KeyXBinaryTildeAs:       " no_such_as_C_operator ",
+
 	t.KeyXAssociativePlus: " + ",
 	t.KeyXAssociativeStar: " * ",
 	t.KeyXAssociativeAmp:  " & ",
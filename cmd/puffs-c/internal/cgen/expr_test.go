@@ -0,0 +1,131 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/puffs/lang/check"
+	"github.com/google/puffs/lang/parse"
+
+	a "github.com/google/puffs/lang/ast"
+	"github.com/google/puffs/lang/token"
+)
+
+// writeReturnValue checks src, which should hold a single func whose body is
+// a single return statement, and returns the C code for the returned value.
+func writeReturnValue(t *testing.T, tm *token.Map, src string) string {
+	const filename = "test.puffs"
+
+	tokens, _, err := token.Tokenize(tm, filename, []byte(src))
+	if err != nil {
+		t.Fatalf("%q: Tokenize: %v", src, err)
+	}
+	file, err := parse.Parse(tm, filename, tokens)
+	if err != nil {
+		t.Fatalf("%q: Parse: %v", src, err)
+	}
+	c, err := check.Check(tm, file)
+	if err != nil {
+		t.Fatalf("%q: Check: %v", src, err)
+	}
+
+	for _, tld := range file.TopLevelDecls() {
+		if tld.Kind() != a.KFunc {
+			continue
+		}
+		body := tld.Func().Body()
+		if len(body) != 1 || body[0].Kind() != a.KReturn {
+			t.Fatalf("%q: want a single return statement", src)
+		}
+		g := &gen{tm: tm, checker: c, files: []*a.File{file}}
+		b := new(buffer)
+		if err := g.writeExpr(b, body[0].Return().Value(), replaceNothing, parenthesesOptional, 0); err != nil {
+			t.Fatalf("%q: writeExpr: %v", src, err)
+		}
+		return string(*b)
+	}
+	t.Fatalf("%q: no func", src)
+	return ""
+}
+
+func TestTildeAs(t *testing.T) {
+	testCases := []struct {
+		from, to string
+		x        string
+		want     string
+		wantC    string
+	}{
+		{"u32", "u8", "0x1FF", "255", "((uint8_t )(a_x))"},
+		{"i32", "u8", "-1", "255", "((uint8_t )(a_x))"},
+		{"i32", "i8", "0x17F", "127", "((int8_t)((uint8_t)(a_x)))"},
+		{"i32", "i8", "0x180", "-128", "((int8_t)((uint8_t)(a_x)))"},
+		{"u16", "i8", "0xFFFE", "-2", "((int8_t)((uint8_t)(a_x)))"},
+		{"i64", "i16", "-3", "-3", "((int16_t)((uint16_t)(a_x)))"},
+		{"u32", "i16", "0x12348765", "-30875", "((int16_t)((uint16_t)(a_x)))"},
+		{"u64", "i32", "0xFFFFFFFF80000000", "-2147483648", "((int32_t)((uint32_t)(a_x)))"},
+	}
+
+	cc, ccErr := exec.LookPath("cc")
+	dir := ""
+	if ccErr == nil {
+		d, err := ioutil.TempDir("", "puffs-cgen-test")
+		if err != nil {
+			t.Fatalf("TempDir: %v", err)
+		}
+		defer os.RemoveAll(d)
+		dir = d
+	}
+
+	for i, tc := range testCases {
+		src := "pri struct foo()\n\npri func foo.bar(x " + tc.from + ")(y " + tc.to + ") {\n" +
+			"\treturn in.x ~as " + tc.to + "\n}\n"
+		tm := &token.Map{}
+		got := writeReturnValue(t, tm, src)
+		if got != tc.wantC {
+			t.Errorf("%s ~as %s: got %q, want %q", tc.from, tc.to, got, tc.wantC)
+			continue
+		}
+		if ccErr != nil {
+			continue
+		}
+
+		// Run the C code, as the expected value relies on what C compilers
+		// do, not just on what the C standard says.
+		prog := fmt.Sprintf("#include <stdint.h>\n"+
+			"int main() {\n"+
+			"  %s a_x = (%s)(%sLL);\n"+
+			"  return (%s == %sLL) ? 0 : 1;\n"+
+			"}\n",
+			cTypeNames[tm.ByName(tc.from).Key()], cTypeNames[tm.ByName(tc.from).Key()], tc.x, got, tc.want)
+		cFile := filepath.Join(dir, fmt.Sprintf("test%d.c", i))
+		exe := filepath.Join(dir, fmt.Sprintf("test%d", i))
+		if err := ioutil.WriteFile(cFile, []byte(prog), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if out, err := exec.Command(cc, "-std=c99", "-Wall", "-Werror", "-o", exe, cFile).CombinedOutput(); err != nil {
+			t.Errorf("%s ~as %s: compiling: %v\n%s", tc.from, tc.to, err, out)
+			continue
+		}
+		if err := exec.Command(exe).Run(); err != nil {
+			t.Errorf("%s ~as %s of %s: want %s, got a different value", tc.from, tc.to, tc.x, tc.want)
+		}
+	}
+}
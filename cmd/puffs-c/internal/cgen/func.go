@@ -23,23 +23,32 @@ import (
 )
 
 type funk struct {
-	bHeader      buffer
-	bBodyResume  buffer
-	bBody        buffer
-	bBodySuspend buffer
-	bFooter      buffer
+	bHeader         buffer
+	bResumeCoverage buffer
+	bBodyResume     buffer
+	bBody           buffer
+	bBodySuspend    buffer
+	bFooter         buffer
 
 	astFunc       *a.Func
+	currStatement *a.Node // For debug checks' "foo.puffs:123" positions.
 	cName         string
 	derivedVars   map[t.ID]struct{}
+	savedVars     map[t.ID]struct{} // See findSavedVars.
 	jumpTargets   map[a.Loop]uint32
 	coroSuspPoint uint32
+	switches      uint32
 	tempW         uint32
 	tempR         uint32
+	limits        uint32
 	public        bool
 	suspendible   bool
 	usesScratch   bool
 	shortReads    []string
+
+	// coroSuspPositions are the positions, such as "foo/bar.puffs:123", of
+	// each coroutine suspension point, when counting resumptions for coverage.
+	coroSuspPositions []string
 }
 
 func (k *funk) jumpTarget(n a.Loop) (uint32, error) {
@@ -117,12 +126,14 @@ func (g *gen) writeFuncPrototype(b *buffer, n *a.Func) error {
 func (g *gen) writeFuncImpl(b *buffer, n *a.Func) error {
 	k := g.funks[n.QID()]
 
+	g.writeLineDirective(b, n.Node())
 	if err := g.writeFuncSignature(b, n); err != nil {
 		return err
 	}
 	b.writes("{\n")
 	b.writex(k.bHeader)
 	if k.suspendible && k.coroSuspPoint > 0 {
+		b.writex(k.bResumeCoverage)
 		b.writex(k.bBodyResume)
 	}
 	b.writex(k.bBody)
@@ -142,6 +153,11 @@ func (g *gen) gatherFuncImpl(_ *buffer, n *a.Func) error {
 		suspendible: n.Suspendible(),
 	}
 
+	if g.currFunk.suspendible {
+		if err := g.findSavedVars(); err != nil {
+			return err
+		}
+	}
 	if err := g.writeFuncImplHeader(&g.currFunk.bHeader); err != nil {
 		return err
 	}
@@ -154,6 +170,9 @@ func (g *gen) gatherFuncImpl(_ *buffer, n *a.Func) error {
 	if err := g.writeFuncImplBodySuspend(&g.currFunk.bBodySuspend); err != nil {
 		return err
 	}
+	// The resumption counters are written before the resume code but, as they
+	// are only known after the body is written, gathered after it.
+	g.writeResumeCoverageCounters(&g.currFunk.bResumeCoverage)
 	if err := g.writeFuncImplFooter(&g.currFunk.bFooter); err != nil {
 		return err
 	}
@@ -202,7 +221,7 @@ func (g *gen) writeFuncImplHeader(b *buffer) error {
 	b.writes("\n")
 
 	// Generate the local variables.
-	if err := g.writeVars(b, g.currFunk.astFunc.Body(), false, true); err != nil {
+	if err := g.writeVars(b, g.currFunk.astFunc.Body(), false, true, g.currFunk.suspendible); err != nil {
 		return err
 	}
 	b.writes("\n")
@@ -0,0 +1,131 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgen
+
+import (
+	"sort"
+
+	"github.com/google/puffs/lang/check"
+
+	a "github.com/google/puffs/lang/ast"
+	t "github.com/google/puffs/lang/token"
+)
+
+// fuzzTargetPrefix starts the comment lines that list the fuzz targets, which
+// "puffs-c fuzz" looks for.
+const fuzzTargetPrefix = "// !! puffs fuzz target: "
+
+// fuzzableFuncs returns the public decoder methods: the coroutines whose
+// receiver is a public struct and whose arguments are (dst writer1, src
+// reader1), in source code order.
+func fuzzableFuncs(c *check.Checker) []*a.Func {
+	ret := []*a.Func(nil)
+	for _, f := range c.Funcs() {
+		n := f.Func
+		if !n.Public() || !n.Suspendible() || n.Receiver() == 0 {
+			continue
+		}
+		if s, ok := c.Structs()[n.Receiver()]; !ok || !s.Struct.Public() {
+			continue
+		}
+		in := n.In().Fields()
+		if len(in) != 2 ||
+			!isIOType(in[0].Field().XType(), t.KeyWriter1) ||
+			!isIOType(in[1].Field().XType(), t.KeyReader1) {
+			continue
+		}
+		ret = append(ret, n)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Filename() != ret[j].Filename() {
+			return ret[i].Filename() < ret[j].Filename()
+		}
+		return ret[i].Line() < ret[j].Line()
+	})
+	return ret
+}
+
+func isIOType(n *a.TypeExpr, key t.Key) bool {
+	return n.Decorator() == 0 && n.Name().Key() == key
+}
+
+func (g *gen) writeFuzzImpl(b *buffer) error {
+	b.writes("// ---------------- Fuzz Targets\n\n")
+	funcs := fuzzableFuncs(g.checker)
+
+	// Stop clang-format from re-flowing long lines, which would split a
+	// target's name from its prefix.
+	b.writes("// clang-format off\n")
+	for _, n := range funcs {
+		b.printf("%s%s__fuzz\n", fuzzTargetPrefix, g.funcCName(n))
+	}
+	b.writes("// clang-format on\n\n")
+
+	for _, n := range funcs {
+		if err := g.writeFuzzTarget(b, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gen) writeFuzzTarget(b *buffer, n *a.Func) error {
+	cName := g.funcCName(n)
+	b.printf("void %s__fuzz(const uint8_t* data, size_t size) {\n", cName)
+	b.printf("%s%s self;\n", g.pkgPrefix, n.Receiver().String(g.tm))
+	b.printf("%s%s__initialize(&self, PUFFS_VERSION, 0);\n", g.pkgPrefix, n.Receiver().String(g.tm))
+	b.writes("uint64_t rng = puffs_base__fuzz_hash(data, size);\n")
+	b.writes("puffs_base__buf1 src = {.ptr = (uint8_t*)(data), .len = size};\n")
+	b.writes("puffs_base__buf1 dst = {.ptr = puffs_base__fuzz_dst_array};\n")
+	b.writes("bool max_room = false;\n")
+	b.writes("while (true) {\n")
+	b.writes("puffs_base__fuzz_feed(&src, size, &rng);\n")
+	b.writes("puffs_base__fuzz_drain(&dst, &rng, max_room);\n")
+	b.writes("puffs_base__writer1 dst_writer = {.buf = &dst};\n")
+	b.writes("puffs_base__reader1 src_reader = {.buf = &src};\n")
+	b.writes("size_t old_ri = src.ri;\n")
+	b.printf("%sstatus status = %s(&self, dst_writer, src_reader);\n", g.pkgPrefix, cName)
+
+	b.printf("if (status == %sSUSPENSION_SHORT_READ) {\n", g.PKGPREFIX)
+	b.writes("if (src.closed) {\n")
+	b.writes("puffs_base__fuzz_fail(\"short read from a closed src\");\n")
+	b.writes("}\n")
+	b.writes("max_room = false;\n")
+	b.writes("continue;\n")
+
+	// A short write that makes no progress, even with the most room that the
+	// fuzz target can give, would otherwise loop forever.
+	b.printf("} else if (status == %sSUSPENSION_SHORT_WRITE) {\n", g.PKGPREFIX)
+	b.writes("if ((dst.wi == 0) && (src.ri == old_ri)) {\n")
+	b.writes("if (max_room) {\n")
+	b.writes("puffs_base__fuzz_fail(\"short write made no progress\");\n")
+	b.writes("}\n")
+	b.writes("max_room = true;\n")
+	b.writes("} else {\n")
+	b.writes("max_room = false;\n")
+	b.writes("}\n")
+	b.writes("continue;\n")
+	b.writes("}\n")
+
+	// Errors are expected, for invalid input, but internal errors are bugs.
+	b.printf("const char* msg = %sstatus__string(status);\n", g.pkgPrefix)
+	b.writes("if (strstr(msg, \": internal error\")) {\n")
+	b.writes("puffs_base__fuzz_fail(msg);\n")
+	b.writes("}\n")
+	b.writes("break;\n")
+	b.writes("}\n")
+	b.writes("}\n\n")
+	return nil
+}
@@ -81,6 +81,9 @@ func genBase(out *bytes.Buffer) error {
 	}{
 		{"base-header.h", "baseHeader"},
 		{"base-impl.h", "baseImpl"},
+		{"base-debug-impl.h", "baseDebugImpl"},
+		{"base-coverage-impl.h", "baseCoverageImpl"},
+		{"base-fuzz-impl.h", "baseFuzzImpl"},
 	}
 
 	for _, f := range files {
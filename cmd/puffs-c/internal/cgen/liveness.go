@@ -0,0 +1,313 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgen
+
+import (
+	"fmt"
+
+	a "github.com/google/puffs/lang/ast"
+	t "github.com/google/puffs/lang/token"
+)
+
+// varSet is a set of local variables, by name. Puffs does not allow shadowing,
+// so a name identifies a local variable within a function.
+type varSet map[t.ID]struct{}
+
+func (s varSet) clone() varSet {
+	ret := make(varSet, len(s))
+	for k := range s {
+		ret[k] = struct{}{}
+	}
+	return ret
+}
+
+func (s varSet) addAll(x varSet) {
+	for k := range x {
+		s[k] = struct{}{}
+	}
+}
+
+// findSavedVars sets g.currFunk.savedVars to the local variables that a
+// coroutine saves when it suspends, and loads when it resumes: those that are
+// live across some suspension point, i.e. that might be read after resuming
+// from it before being assigned to.
+//
+// The analysis is a backwards data flow analysis over the function body's
+// statements, iterating each loop to a fixed point. It is conservative: every
+// suspension point within a statement is treated as if it were at the start
+// of that statement, as its sub-expressions might be re-evaluated on resume.
+// Only variables without pointers are saved. Those with pointers, such as
+// slices, are reset on resume instead, regardless of liveness.
+func (g *gen) findSavedVars() error {
+	l := &liveness{
+		g:      g,
+		locals: varSet{},
+		saved:  varSet{},
+		loops:  map[a.Loop]*loopLiveness{},
+	}
+	body := g.currFunk.astFunc.Body()
+	if err := g.visitVars(nil, body, 0, func(g *gen, _ *buffer, n *a.Var) error {
+		if !n.IterateVariable() && !n.XType().HasPointers() {
+			l.locals[n.Name()] = struct{}{}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if _, err := l.block(body, varSet{}, 0); err != nil {
+		return err
+	}
+	g.currFunk.savedVars = l.saved
+	return nil
+}
+
+// loopLiveness is what is live after a loop's break and continue statements.
+type loopLiveness struct {
+	afterBreak    varSet
+	afterContinue varSet
+}
+
+type liveness struct {
+	g      *gen
+	locals varSet
+	saved  varSet
+	loops  map[a.Loop]*loopLiveness
+}
+
+// suspend records that the variables in live are live across a suspension
+// point. While iterating a loop to a fixed point, live might be a subset of its
+// final value, but the final iteration records that final value.
+func (l *liveness) suspend(live varSet) {
+	l.saved.addAll(live)
+}
+
+// reads adds to live the local variables that n reads.
+func (l *liveness) reads(live varSet, n *a.Node) {
+	if n == nil {
+		return
+	}
+	n.Walk(func(o *a.Node) error {
+		if o.Kind() == a.KExpr {
+			if e := o.Expr(); e.ID0() == 0 {
+				if _, ok := l.locals[e.ID1()]; ok {
+					live[e.ID1()] = struct{}{}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (l *liveness) exprReads(live varSet, n *a.Expr) {
+	if n != nil {
+		l.reads(live, n.Node())
+	}
+}
+
+// assertReads adds to live the variables read by asserts, which only apply at
+// run time for debug checks.
+func (l *liveness) assertReads(live varSet, asserts []*a.Node) {
+	if l.g.debugChecks {
+		for _, o := range asserts {
+			l.reads(live, o)
+		}
+	}
+}
+
+// block returns what is live before block, given what is live after it.
+func (l *liveness) block(block []*a.Node, out varSet, depth uint32) (varSet, error) {
+	if depth > a.MaxBodyDepth {
+		return nil, fmt.Errorf("body recursion depth too large")
+	}
+	depth++
+
+	live := out
+	for i := len(block) - 1; i >= 0; i-- {
+		var err error
+		live, err = l.statement(block[i], live, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return live, nil
+}
+
+// statement returns what is live before n, given what is live after it.
+func (l *liveness) statement(n *a.Node, out varSet, depth uint32) (varSet, error) {
+	switch n.Kind() {
+	case a.KAssert:
+		live := out.clone()
+		l.assertReads(live, []*a.Node{n})
+		return live, nil
+
+	case a.KAssign:
+		n := n.Assign()
+		live := out.clone()
+		lhs := n.LHS()
+		if n.Operator().Key() == t.KeyEq && lhs.ID0() == 0 {
+			delete(live, lhs.ID1())
+		} else {
+			l.exprReads(live, lhs)
+		}
+		l.exprReads(live, n.RHS())
+		if lhs.Suspendible() || n.RHS().Suspendible() {
+			l.suspend(live)
+		}
+		return live, nil
+
+	case a.KExpr:
+		n := n.Expr()
+		live := out.clone()
+		l.exprReads(live, n)
+		if n.Suspendible() {
+			l.suspend(live)
+		}
+		return live, nil
+
+	case a.KIf:
+		return l.ifStatement(n.If(), out, depth)
+
+	case a.KIterate:
+		n := n.Iterate()
+		entry := varSet{}
+		for _, o := range n.Variables() {
+			l.exprReads(entry, o.Var().Value())
+		}
+		l.assertReads(entry, n.Asserts())
+		return l.loop(n, n.Body(), entry, out, depth)
+
+	case a.KJump:
+		n := n.Jump()
+		ll := l.loops[n.JumpTarget()]
+		if ll == nil {
+			return nil, fmt.Errorf("internal error: jump outside of its loop")
+		}
+		if n.Keyword().Key() == t.KeyBreak {
+			return ll.afterBreak.clone(), nil
+		}
+		return ll.afterContinue.clone(), nil
+
+	case a.KReturn:
+		n := n.Return()
+		live := varSet{}
+		if v := n.Value(); v != nil {
+			// Returning a suspension, not an error or ok status, is a
+			// suspension point. Resuming continues after the return.
+			if k := v.ID0().Key(); k != t.KeyError && k != t.KeyStatus {
+				l.suspend(out)
+				live = out.clone()
+			}
+			l.exprReads(live, v)
+		}
+		return live, nil
+
+	case a.KSwitch:
+		n := n.Switch()
+		live := varSet{}
+		for _, o := range n.Cases() {
+			x, err := l.block(o.Case().Body(), out, depth)
+			if err != nil {
+				return nil, err
+			}
+			live.addAll(x)
+		}
+		x, err := l.block(n.BodyIfElse(), out, depth)
+		if err != nil {
+			return nil, err
+		}
+		live.addAll(x)
+		l.exprReads(live, n.Scrutinee())
+		if n.Scrutinee().Suspendible() {
+			l.suspend(live)
+		}
+		return live, nil
+
+	case a.KVar:
+		n := n.Var()
+		live := out.clone()
+		delete(live, n.Name())
+		if v := n.Value(); v != nil {
+			l.exprReads(live, v)
+			if v.Suspendible() {
+				l.suspend(live)
+			}
+		}
+		return live, nil
+
+	case a.KWhile:
+		n := n.While()
+		entry := varSet{}
+		l.exprReads(entry, n.Condition())
+		l.assertReads(entry, n.Asserts())
+		live, err := l.loop(n, n.Body(), entry, out, depth)
+		if err != nil {
+			return nil, err
+		}
+		if n.Condition().Suspendible() {
+			l.suspend(live)
+		}
+		return live, nil
+	}
+	return nil, fmt.Errorf("unrecognized ast.Kind (%s) for liveness", n.Kind())
+}
+
+func (l *liveness) ifStatement(n *a.If, out varSet, depth uint32) (varSet, error) {
+	live, err := l.block(n.BodyIfTrue(), out, depth)
+	if err != nil {
+		return nil, err
+	}
+	live = live.clone()
+	otherwise := out
+	if n.ElseIf() != nil {
+		otherwise, err = l.ifStatement(n.ElseIf(), out, depth)
+	} else {
+		otherwise, err = l.block(n.BodyIfFalse(), out, depth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	live.addAll(otherwise)
+	l.exprReads(live, n.Condition())
+	if n.Condition().Suspendible() {
+		l.suspend(live)
+	}
+	return live, nil
+}
+
+// loop returns what is live before the loop n, i.e. at its head, which is also
+// where a continue goes. entry is what the loop head reads, such as a while
+// loop's condition, and out is what is live after the loop.
+func (l *liveness) loop(n a.Loop, body []*a.Node, entry varSet, out varSet, depth uint32) (varSet, error) {
+	head := entry.clone()
+	head.addAll(out)
+	for {
+		l.loops[n] = &loopLiveness{
+			afterBreak:    out,
+			afterContinue: head,
+		}
+		x, err := l.block(body, head, depth)
+		if err != nil {
+			return nil, err
+		}
+		next := head.clone()
+		next.addAll(x)
+		if len(next) == len(head) {
+			break
+		}
+		head = next
+	}
+	delete(l.loops, n)
+	return head, nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgen
+
+// writeSizeProbes writes a main function, guarded by the PUFFS_SIZES_MAIN
+// macro, that prints the sizeof each public struct and of its parts, for the
+// "puffs-c sizes" command. The C compiler, not cgen, knows the sizes, as they
+// include padding and depend on the target.
+//
+// Each line printed is "kind struct_name [part_name] size", where kind is
+// "struct", "field" or "coroutine". The rest of a struct's size, not in its
+// fields or coroutine state, is the private_impl's status, magic and padding.
+func (g *gen) writeSizeProbes(b *buffer) error {
+	b.writes("// ---------------- Size Probes\n\n")
+	b.writes("#ifdef PUFFS_SIZES_MAIN\n\n")
+	b.writes("#include <stdio.h>\n\n")
+	b.writes("int main(void) {\n")
+	for _, n := range g.structList {
+		if !n.Public() {
+			continue
+		}
+		structName := g.pkgPrefix + n.Name().String(g.tm)
+		b.printf("printf(\"struct %s %%zu\\n\", sizeof(%s));\n", structName, structName)
+		for _, o := range n.Fields() {
+			part := fPrefix + o.Field().Name().String(g.tm)
+			b.printf("printf(\"field %s %s %%zu\\n\", sizeof(((%s*)0)->private_impl.%s));\n",
+				structName, part, structName, part)
+		}
+		if n.Suspendible() {
+			for _, o := range g.coroutineStates(n) {
+				part := cPrefix + o.Name().String(g.tm)
+				b.printf("printf(\"coroutine %s %s %%zu\\n\", sizeof(((%s*)0)->private_impl.%s));\n",
+					structName, part, structName, part)
+			}
+		}
+	}
+	b.writes("return 0;\n}\n\n")
+	b.writes("#endif  // PUFFS_SIZES_MAIN\n\n")
+	return nil
+}
@@ -17,8 +17,12 @@ package cgen
 import (
 	"errors"
 	"fmt"
+	"math/big"
+	"path/filepath"
 	"strings"
 
+	"github.com/google/puffs/lang/builtin"
+
 	a "github.com/google/puffs/lang/ast"
 	t "github.com/google/puffs/lang/token"
 )
@@ -34,10 +38,14 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 		return fmt.Errorf("body recursion depth too large")
 	}
 	depth++
+	g.currFunk.currStatement = n
 
 	if n.Kind() == a.KAssert {
-		// Assertions only apply at compile-time.
-		return nil
+		// Assertions only apply at compile-time, other than for debug checks.
+		if g.debugChecks {
+			g.writeLineDirective(b, n)
+		}
+		return g.writeDebugCheckAssert(b, n.Assert(), depth)
 	}
 
 	mightIntroduceTemporaries := false
@@ -59,15 +67,10 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 	}
 
 	if genFilenameLineComments {
-		filename, line := n.Raw().FilenameLine()
-		if i := strings.LastIndexByte(filename, '/'); i >= 0 {
-			filename = filename[i+1:]
-		}
-		if i := strings.LastIndexByte(filename, '\\'); i >= 0 {
-			filename = filename[i+1:]
-		}
-		b.printf("// %s:%d\n", filename, line)
+		b.printf("// %s\n", filenameLine(n))
 	}
+	g.writeLineDirective(b, n)
+	g.writeCoverageCounter(b, n, coverageKindStatement)
 
 	switch n.Kind() {
 	case a.KAssign:
@@ -78,16 +81,42 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 		if err := g.writeSuspendibles(b, n.RHS(), depth); err != nil {
 			return err
 		}
+		if _, ok := g.unionForTag(n.LHS()); ok {
+			if u := n.LHS().LHS().Expr(); g.unionHasFields(u.MType().Name()) {
+				// Changing a union's kind zeroes all of its fields. The union
+				// is pure, so evaluating it twice is OK.
+				x := buffer(nil)
+				if err := g.writeExpr(&x, u, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+					return err
+				}
+				b.printf("memset(&%s.variants, 0, sizeof(%s.variants));\n", x, x)
+			}
+		}
 		if err := g.writeExpr(b, n.LHS(), replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
 			return err
 		}
+		switch op := n.Operator().BinaryForm().Key(); op {
+		case t.KeyXBinaryTildePlus, t.KeyXBinaryTildeMinus, t.KeyXBinaryTildeStar, t.KeyXBinaryTildeShiftL,
+			t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+			if !tildeOpIsPlainC(op, n.LHS().MType()) {
+				// Expand "x ~+= y" to "x = x ~+ y". The LHS is pure, so
+				// evaluating it twice is OK.
+				b.writes(" = ")
+				if err := g.writeExprTildeOp(b, op, n.LHS().MType(), n.LHS(), n.RHS(),
+					replaceCallSuspendibles, depth); err != nil {
+					return err
+				}
+				b.writes(";\n")
+				return g.writeDebugCheckBounds(b, n.LHS(), n.LHS().MType(), depth)
+			}
+		}
 		// TODO: does KeyAmpHatEq need special consideration?
 		b.writes(cOpNames[0xFF&n.Operator().Key()])
 		if err := g.writeExpr(b, n.RHS(), replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
 			return err
 		}
 		b.writes(";\n")
-		return nil
+		return g.writeDebugCheckBounds(b, n.LHS(), n.LHS().MType(), depth)
 
 	case a.KExpr:
 		n := n.Expr()
@@ -141,10 +170,15 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 				}
 				break
 			}
-			n = n.ElseIf()
-			if n == nil {
+			if n.ElseIf() == nil {
+				if g.coverage {
+					// Count the implicit, empty else branch.
+					b.writes("} else {\n")
+					g.writeCoverageCounter(b, n.Node(), coverageKindElse)
+				}
 				break
 			}
+			n = n.ElseIf()
 			b.writes("} else ")
 		}
 		for ; nCloseCurly > 0; nCloseCurly-- {
@@ -165,21 +199,29 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 		name := v.Name().String(g.tm)
 		b.writes("{\n")
 
-		// TODO: don't assume that the slice is a slice of u8. In particular,
-		// the code gen can be subtle if the slice element type has zero size,
-		// such as the empty struct.
-		b.printf("puffs_base__slice_u8 %sslice_%s =", iPrefix, name)
+		// TODO: the code gen can be subtle if the slice element type has zero
+		// size, such as the empty struct.
+		if err := g.writeCTypeName(b, v.Value().MType(), iPrefix, "slice_"+name); err != nil {
+			return err
+		}
+		b.writes(" =")
 		if err := g.writeExpr(b, v.Value(), replaceCallSuspendibles, parenthesesOptional, 0); err != nil {
 			return err
 		}
 		b.writes(";\n")
-		b.printf("uint8_t* %s%s = %sslice_%s.ptr;\n", vPrefix, name, iPrefix, name)
+		if err := g.writeCTypeName(b, v.XType(), vPrefix, name); err != nil {
+			return err
+		}
+		b.printf(" = %sslice_%s.ptr;\n", iPrefix, name)
 		// TODO: look at n.HasContinue() and n.HasBreak().
 
 		unrollCount := int(n.UnrollCount().ConstValue().Int64())
 		if unrollCount != 1 {
-			b.printf("uint8_t* %send0_%s = %sslice_%s.ptr + (%sslice_%s.len / %d) * %d;\n",
-				iPrefix, name, iPrefix, name, iPrefix, name, unrollCount, unrollCount)
+			if err := g.writeCTypeName(b, v.XType(), iPrefix, "end0_"+name); err != nil {
+				return err
+			}
+			b.printf(" = %sslice_%s.ptr + (%sslice_%s.len / %d) * %d;\n",
+				iPrefix, name, iPrefix, name, unrollCount, unrollCount)
 			b.printf("while (%s%s < %send0_%s) {\n", vPrefix, name, iPrefix, name)
 			for i := 0; i < unrollCount; i++ {
 				for _, o := range n.Body() {
@@ -192,8 +234,10 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 			b.writes("}\n")
 		}
 
-		b.printf("uint8_t* %send1_%s = %sslice_%s.ptr + %sslice_%s.len;\n",
-			iPrefix, name, iPrefix, name, iPrefix, name)
+		if err := g.writeCTypeName(b, v.XType(), iPrefix, "end1_"+name); err != nil {
+			return err
+		}
+		b.printf(" = %sslice_%s.ptr + %sslice_%s.len;\n", iPrefix, name, iPrefix, name)
 		b.printf("while (%s%s < %send1_%s) {\n", vPrefix, name, iPrefix, name)
 		for _, o := range n.Body() {
 			if err := g.writeStatement(b, o, depth); err != nil {
@@ -263,6 +307,9 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 		b.writeb(';')
 		return nil
 
+	case a.KSwitch:
+		return g.writeSwitch(b, n.Switch(), depth)
+
 	case a.KVar:
 		n := n.Var()
 		if v := n.Value(); v != nil {
@@ -285,18 +332,35 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 			b.printf("memset(%s%s, 0, sizeof(%s%s));\n", vPrefix, name, vPrefix, name)
 
 		default:
+			if _, ok := g.checker.Unions()[n.XType().Name()]; ok {
+				if n.Value() != nil {
+					return fmt.Errorf("TODO: union initializers")
+				}
+				name := n.Name().String(g.tm)
+				b.printf("memset(&%s%s, 0, sizeof(%s%s));\n", vPrefix, name, vPrefix, name)
+				break
+			}
 			b.printf("%s%s = ", vPrefix, n.Name().String(g.tm))
 			if v := n.Value(); v != nil {
 				if err := g.writeExpr(b, v, replaceCallSuspendibles, parenthesesMandatory, 0); err != nil {
 					return err
 				}
 			} else if n.XType().Decorator().Key() == t.KeyColon {
-				// TODO: don't assume that the slice is a slice of u8.
-				b.printf("((puffs_base__slice_u8){})")
+				sliceType, err := g.sliceTypeName(n.XType())
+				if err != nil {
+					return err
+				}
+				b.printf("((%s){})", sliceType)
+			} else if n.XType().Decorator() == 0 && n.XType().Name().Key() == t.KeyBitreader {
+				b.writes("((puffs_base__bitreader){})")
 			} else {
 				b.writeb('0')
 			}
 			b.writes(";\n")
+			lhs := a.NewExpr(0, 0, n.Name(), nil, nil, nil, nil)
+			if err := g.writeDebugCheckBounds(b, lhs, n.XType(), depth); err != nil {
+				return err
+			}
 		}
 		return nil
 
@@ -311,6 +375,11 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 			}
 			b.printf("label_%d_continue:;\n", jt)
 		}
+		// The pre and inv conditions hold on entry and on every explicit or
+		// implicit continue. The post conditions hold on every exit.
+		if err := g.writeDebugCheckAsserts(b, n.Asserts(), t.KeyPost, depth); err != nil {
+			return err
+		}
 		b.writes("while (")
 		if err := g.writeExpr(b, n.Condition(), replaceCallSuspendibles, parenthesesOptional, 0); err != nil {
 			return err
@@ -321,6 +390,10 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 				return err
 			}
 		}
+		g.currFunk.currStatement = n.Node()
+		if err := g.writeDebugCheckAsserts(b, n.Asserts(), t.KeyPost, depth); err != nil {
+			return err
+		}
 		b.writes("}\n")
 		if n.HasBreak() {
 			jt, err := g.currFunk.jumpTarget(n)
@@ -329,12 +402,347 @@ func (g *gen) writeStatement(b *buffer, n *a.Node, depth uint32) error {
 			}
 			b.printf("label_%d_break:;\n", jt)
 		}
-		return nil
+		return g.writeDebugCheckAsserts(b, n.Asserts(), t.KeyPre, depth)
 
 	}
 	return fmt.Errorf("unrecognized ast.Kind (%s) for writeStatement", n.Kind())
 }
 
+// filenameLine returns n's position as "foo.puffs:123", without the directory.
+func filenameLine(n *a.Node) string {
+	filename, line := n.Raw().FilenameLine()
+	if i := strings.LastIndexByte(filename, '/'); i >= 0 {
+		filename = filename[i+1:]
+	}
+	if i := strings.LastIndexByte(filename, '\\'); i >= 0 {
+		filename = filename[i+1:]
+	}
+	return fmt.Sprintf("%s:%d", filename, line)
+}
+
+// writeLineDirective writes, if g.lineDirectives, a "#line 123 \"foo.puffs\""
+// directive so that what follows maps back to n's position in the Puffs source
+// code.
+func (g *gen) writeLineDirective(b *buffer, n *a.Node) {
+	if !g.lineDirectives {
+		return
+	}
+	if filename, line := n.Raw().FilenameLine(); line != 0 {
+		b.printf("\n#line %d %s\n", line, cString(filepath.ToSlash(filename)))
+	}
+}
+
+// writeCoverageCounter writes, if g.coverage, an increment of a new coverage
+// counter for n's position. kind distinguishes counters for the same position.
+func (g *gen) writeCoverageCounter(b *buffer, n *a.Node, kind string) {
+	if !g.coverage {
+		return
+	}
+	filename, line := n.Raw().FilenameLine()
+	b.printf("%scoverage_counts[%d]++;\n", g.pkgPrefix, len(g.coverageList))
+	g.coverageList = append(g.coverageList, fmt.Sprintf("%s:%d:%s", filepath.ToSlash(filename), line, kind))
+}
+
+// writeResumeCoverageCounters writes, if g.coverage, an increment of the
+// coverage counter for the coroutine suspension point that the current
+// function is resuming from, if any. Each suspension point gets a new counter,
+// whose position also names the function and the suspension point, such as
+// "foo/bar.puffs:123:resume:decoder.decode:4".
+func (g *gen) writeResumeCoverageCounters(b *buffer) {
+	if !g.coverage || len(g.currFunk.coroSuspPositions) == 0 {
+		return
+	}
+	name := g.currFunk.astFunc.Name().String(g.tm)
+	if r := g.currFunk.astFunc.Receiver(); r != 0 {
+		name = r.String(g.tm) + "." + name
+	}
+	// Suspension points are numbered from 1, as 0 means not suspended.
+	b.printf("if (self->private_impl.%s%s[0].coro_susp_point) {\n",
+		cPrefix, g.currFunk.astFunc.Name().String(g.tm))
+	b.printf("%scoverage_counts[%d + self->private_impl.%s%s[0].coro_susp_point]++;\n",
+		g.pkgPrefix, len(g.coverageList)-1, cPrefix, g.currFunk.astFunc.Name().String(g.tm))
+	b.writes("}\n")
+	for i, p := range g.currFunk.coroSuspPositions {
+		g.coverageList = append(g.coverageList, fmt.Sprintf("%s:%s:%s:%d", p, coverageKindResume, name, i+1))
+	}
+}
+
+// debugCheckPosition returns the position of the statement being written, for
+// debug checks on its sub-expressions, which do not record their own position.
+func (g *gen) debugCheckPosition() string {
+	if n := g.currFunk.currStatement; n != nil {
+		return filenameLine(n)
+	}
+	return filenameLine(g.currFunk.astFunc.Node())
+}
+
+// writeDebugCheckAssert writes a run time check, if g.debugChecks, of an
+// assert, pre, inv or post condition that the checker proved.
+func (g *gen) writeDebugCheckAssert(b *buffer, n *a.Assert, depth uint32) error {
+	if !g.debugChecks || n.Keyword().Key() == t.KeyDec {
+		// A dec measure is not a condition. It is proved by the termination
+		// checker, not by bcheck.
+		return nil
+	}
+	cond := n.Condition()
+	if mightOverflowInC(cond) {
+		return nil
+	}
+
+	// A while loop's pre, inv and post conditions do not record their own
+	// position, but the while loop does.
+	pos := g.debugCheckPosition()
+	if _, line := n.Node().Raw().FilenameLine(); line != 0 {
+		pos = filenameLine(n.Node())
+	}
+	b.writes("puffs_base__debug_check(")
+	if err := g.writeExpr(b, cond, replaceNothing, parenthesesOptional, depth); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("%s: %s %s", pos, n.Keyword().String(g.tm), cond.String(g.tm))
+	b.printf(", %s);\n", cString(msg))
+	return nil
+}
+
+// mightOverflowInC returns whether n contains non-constant arithmetic. Such
+// arithmetic never overflows in an assertion's ideal integer math, but might in
+// the C equivalent, so that a debug check could fail spuriously.
+func mightOverflowInC(n *a.Expr) bool {
+	errFound := errors.New("found")
+	return n.Node().Walk(func(o *a.Node) error {
+		if o.Kind() == a.KExpr && o.Expr().ConstValue() == nil {
+			switch o.Expr().ID0().Key() {
+			case t.KeyXBinaryPlus, t.KeyXBinaryMinus, t.KeyXBinaryStar, t.KeyXBinaryShiftL,
+				t.KeyXAssociativePlus, t.KeyXAssociativeStar:
+				return errFound
+			}
+		}
+		return nil
+	}) != nil
+}
+
+// writeDebugCheckAsserts is like writeDebugCheckAssert, for a while loop's
+// pre, inv and post conditions, other than those with the skip keyword.
+func (g *gen) writeDebugCheckAsserts(b *buffer, asserts []*a.Node, skip t.Key, depth uint32) error {
+	for _, o := range asserts {
+		if o.Assert().Keyword().Key() == skip {
+			continue
+		}
+		if err := g.writeDebugCheckAssert(b, o.Assert(), depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDebugCheckBounds writes a run time check, if g.debugChecks, that the
+// pure expression lhs is within the bounds of its refinement type typ, such as
+// "u32[..4095]".
+func (g *gen) writeDebugCheckBounds(b *buffer, lhs *a.Expr, typ *a.TypeExpr, depth uint32) error {
+	if !g.debugChecks || !typ.IsRefined() {
+		return nil
+	}
+	x := buffer(nil)
+	if err := g.writeExpr(&x, lhs, replaceNothing, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+
+	// Skip any bound that is implied by the unrefined C type, as comparing
+	// against that is always true, which compilers can warn about.
+	typMax := (*big.Int)(nil)
+	if typ.IsUnsignedInteger() {
+		if size, err := g.sizeof(typ.Unrefined()); err == nil {
+			typMax = big.NewInt(0).Lsh(one, 8*uint(size))
+			typMax.Sub(typMax, one)
+		}
+	}
+	conds := []string(nil)
+	if o := typ.Min(); o != nil && (!typ.IsUnsignedInteger() || o.ConstValue().Sign() > 0) {
+		conds = append(conds, fmt.Sprintf("(%s >= %v)", x, o.ConstValue()))
+	}
+	if o := typ.Max(); o != nil && (typMax == nil || o.ConstValue().Cmp(typMax) < 0) {
+		conds = append(conds, fmt.Sprintf("(%s <= %v)", x, o.ConstValue()))
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("%s: %s has type %s", g.debugCheckPosition(), lhs.String(g.tm), typ.String(g.tm))
+	b.printf("puffs_base__debug_check(%s, %s);\n", strings.Join(conds, " && "), cString(msg))
+	return nil
+}
+
+// maxEnumeratedCaseRange is the largest case range, such as "0x80..0xFF", that
+// is written as one C case label per value. Larger ranges are tested by an
+// if-else chain in the C switch's default arm.
+const maxEnumeratedCaseRange = 256
+
+func (g *gen) writeSwitch(b *buffer, n *a.Switch, depth uint32) error {
+	scrutinee := n.Scrutinee()
+	if err := g.writeSuspendibles(b, scrutinee, depth); err != nil {
+		return err
+	}
+	sb := &buffer{}
+	if err := g.writeExpr(sb, scrutinee, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+	sc := string(*sb)
+
+	// A coroutine suspension point is a C case label, which would bind to
+	// the innermost C switch. If any arm might suspend, the C switch only
+	// dispatches to arm bodies written after it, via goto.
+	viaGoto := false
+	for _, c := range n.Cases() {
+		viaGoto = viaGoto || g.mightSuspendBlock(c.Case().Body())
+	}
+	viaGoto = viaGoto || g.mightSuspendBlock(n.BodyIfElse())
+	label := uint32(0)
+	if viaGoto {
+		label = g.currFunk.switches
+		g.currFunk.switches++
+	}
+
+	writeArm := func(i int, body []*a.Node) error {
+		if viaGoto {
+			if i < 0 {
+				b.printf("goto label_switch_%d_else;\n", label)
+			} else {
+				b.printf("goto label_switch_%d_case_%d;\n", label, i)
+			}
+			return nil
+		}
+		b.writes("{\n")
+		for _, o := range body {
+			if err := g.writeStatement(b, o, depth); err != nil {
+				return err
+			}
+		}
+		b.writes("}\n")
+		return nil
+	}
+
+	type bigRange struct {
+		lo, hi *big.Int
+		arm    int
+	}
+	bigRanges := []bigRange(nil)
+
+	b.printf("switch (%s) {\n", sc)
+	for i, c := range n.Cases() {
+		c := c.Case()
+		hasLabel := false
+		for _, o := range c.Values() {
+			o := o.Expr()
+			if o.ID0().Key() != t.KeyDotDot {
+				b.printf("case %v:\n", o.ConstValue())
+				hasLabel = true
+				continue
+			}
+			lo, hi := o.LHS().Expr().ConstValue(), o.RHS().Expr().ConstValue()
+			if x := new(big.Int).Sub(hi, lo); x.Cmp(big.NewInt(maxEnumeratedCaseRange)) >= 0 {
+				bigRanges = append(bigRanges, bigRange{lo, hi, i})
+				continue
+			}
+			for x := new(big.Int).Set(lo); x.Cmp(hi) <= 0; x.Add(x, one) {
+				b.printf("case %v:\n", x)
+			}
+			hasLabel = true
+		}
+		if !hasLabel {
+			continue
+		}
+		if err := writeArm(i, c.Body()); err != nil {
+			return err
+		}
+		if !viaGoto {
+			b.writes("break;\n")
+		}
+	}
+
+	if len(bigRanges) > 0 || n.HasElse() {
+		if len(bigRanges) > 0 && scrutinee.Impure() && !scrutinee.CallSuspendible() {
+			return fmt.Errorf("TODO: large case ranges for an impure switch scrutinee %q",
+				scrutinee.String(g.tm))
+		}
+		b.writes("default:\n")
+		for _, r := range bigRanges {
+			if r.lo.Sign() == 0 && scrutinee.MType().IsUnsignedInteger() {
+				// Avoid "comparison is always true" compiler warnings.
+				b.printf("if (%s <= %v) ", sc, r.hi)
+			} else {
+				b.printf("if ((%v <= %s) && (%s <= %v)) ", r.lo, sc, sc, r.hi)
+			}
+			if err := writeArm(r.arm, n.Cases()[r.arm].Case().Body()); err != nil {
+				return err
+			}
+			b.writes("else ")
+		}
+		if n.HasElse() {
+			if err := writeArm(-1, n.BodyIfElse()); err != nil {
+				return err
+			}
+		} else {
+			b.writes("{\n}\n")
+		}
+		if !viaGoto {
+			b.writes("break;\n")
+		}
+	}
+	b.writes("}\n")
+
+	if viaGoto {
+		for i, c := range n.Cases() {
+			b.printf("label_switch_%d_case_%d:;\n{\n", label, i)
+			for _, o := range c.Case().Body() {
+				if err := g.writeStatement(b, o, depth); err != nil {
+					return err
+				}
+			}
+			b.printf("}\ngoto label_switch_%d_end;\n", label)
+		}
+		if n.HasElse() {
+			b.printf("label_switch_%d_else:;\n{\n", label)
+			for _, o := range n.BodyIfElse() {
+				if err := g.writeStatement(b, o, depth); err != nil {
+					return err
+				}
+			}
+			b.writes("}\n")
+		}
+		b.printf("label_switch_%d_end:;\n", label)
+	}
+	return nil
+}
+
+// mightSuspendBlock returns whether writing the block might write a coroutine
+// suspension point.
+func (g *gen) mightSuspendBlock(block []*a.Node) bool {
+	if !g.currFunk.suspendible {
+		return false
+	}
+	for _, o := range block {
+		if o.Walk(func(o *a.Node) error {
+			switch o.Kind() {
+			case a.KExpr:
+				if o.Expr().Suspendible() {
+					return errMightActuallySuspend
+				}
+			case a.KReturn:
+				// Only returning a suspension, not an error or ok status,
+				// writes a coroutine suspension point.
+				if v := o.Return().Value(); v != nil {
+					if k := v.ID0().Key(); k != t.KeyError && k != t.KeyStatus {
+						return errMightActuallySuspend
+					}
+				}
+			}
+			return nil
+		}) != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *gen) writeCoroSuspPoint(b *buffer, maybeSuspend bool) error {
 	const maxCoroSuspPoint = 0xFFFFFFFF
 	g.currFunk.coroSuspPoint++
@@ -347,6 +755,16 @@ func (g *gen) writeCoroSuspPoint(b *buffer, maybeSuspend bool) error {
 		macro = "_MAYBE_SUSPEND"
 	}
 	b.printf("PUFFS_BASE__COROUTINE_SUSPENSION_POINT%s(%d);\n", macro, g.currFunk.coroSuspPoint)
+
+	if g.coverage {
+		n := g.currFunk.currStatement
+		if n == nil {
+			n = g.currFunk.astFunc.Node()
+		}
+		filename, line := n.Raw().FilenameLine()
+		g.currFunk.coroSuspPositions = append(g.currFunk.coroSuspPositions,
+			fmt.Sprintf("%s:%d", filepath.ToSlash(filename), line))
+	}
 	return nil
 }
 
@@ -432,6 +850,12 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 		return nil
 	}
 
+	// A refill reads through the derived vars directly, so there is no need
+	// to save them first.
+	if isThatMethod(g.tm, n, t.KeyRefill, 2) {
+		return g.writeBitreaderRefill(b, n, depth)
+	}
+
 	if err := g.writeSaveExprDerivedVars(b, n); err != nil {
 		return err
 	}
@@ -466,17 +890,14 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 		b.writes("}\n")
 		b.printf("%srptr_src--;\n", bPrefix)
 
-	} else if isInSrc(g.tm, n, t.KeyReadU16BE, 0) {
-		return g.writeReadUXX(b, n, "src", 16, "be")
-
-	} else if isInSrc(g.tm, n, t.KeyReadU16LE, 0) {
-		return g.writeReadUXX(b, n, "src", 16, "le")
+	} else if m, ok := ioMethod(g.tm, n); ok && m.Op != "write" {
+		return g.writeReadUXX(b, n, "src", m)
 
-	} else if isInSrc(g.tm, n, t.KeyReadU32BE, 0) {
-		return g.writeReadUXX(b, n, "src", 32, "be")
+	} else if ok {
+		return g.writeWriteUXX(b, n, "dst", m, depth)
 
-	} else if isInSrc(g.tm, n, t.KeyReadU32LE, 0) {
-		return g.writeReadUXX(b, n, "src", 32, "le")
+	} else if isInSrc(g.tm, n, t.KeyReadMatch, 1) || isInSrc(g.tm, n, t.KeyReadMatch, 2) {
+		return g.writeReadMatch(b, n, depth)
 
 	} else if isInSrc(g.tm, n, t.KeySkip32, 1) {
 		g.currFunk.usesScratch = true
@@ -504,24 +925,13 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 		g.currFunk.shortReads = append(g.currFunk.shortReads, "src")
 		b.printf("%srptr_src += %s;\n", bPrefix, scratchName)
 
-	} else if isInDst(g.tm, n, t.KeyWriteU8, 1) {
-		if !n.ProvenNotToSuspend() {
-			b.printf("if (%swptr_dst == %swend_dst) { status = %sSUSPENSION_SHORT_WRITE;",
-				bPrefix, bPrefix, g.PKGPREFIX)
-			b.writes("goto suspend;")
-			b.writes("}\n")
-		}
-
-		b.printf("*%swptr_dst++ = ", bPrefix)
-		x := n.Args()[0].Arg().Value()
-		if err := g.writeExpr(b, x, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+	} else if isThisMethod(g.tm, n, "decode_header", 1) {
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
 			return err
 		}
-		b.writes(";\n")
-
-	} else if isThisMethod(g.tm, n, "decode_header", 1) {
-		b.printf("status = %s%s__decode_header(self, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix)
+		b.printf("status = %s%s__decode_header(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
@@ -530,24 +940,36 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 		b.writes("if (status) { goto suspend; }\n")
 
 	} else if isThisMethod(g.tm, n, "decode_lsd", 1) {
-		b.printf("status = %s%s__decode_lsd(self, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__decode_lsd(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
 		b.writes("if (status) { goto suspend; }\n")
 
 	} else if isThisMethod(g.tm, n, "decode_extension", 1) {
-		b.printf("status = %s%s__decode_extension(self, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__decode_extension(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
 		b.writes("if (status) { goto suspend; }\n")
 
 	} else if isThisMethod(g.tm, n, "decode_id", 2) {
-		b.printf("status = %s%s__decode_id(self, %sdst, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix, aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__decode_id(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
@@ -561,33 +983,49 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 		temp := g.currFunk.tempW
 		g.currFunk.tempW++
 
-		b.printf("%sstatus %s%d = %s%s__decode_blocks(self, %sdst, %ssrc);\n",
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("%sstatus %s%d = %s%s__decode_blocks(self, %s);\n",
 			g.pkgPrefix, tPrefix, temp,
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix, aPrefix)
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
 		// TODO: check if tPrefix_temp is an error, and return?
 
 	} else if isThisMethod(g.tm, n, "decode_uncompressed", 2) {
-		b.printf("status = %s%s__decode_uncompressed(self, %sdst, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix, aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__decode_uncompressed(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
 		b.writes("if (status) { goto suspend; }\n")
 
 	} else if isThisMethod(g.tm, n, "decode_huffman_fast", 2) {
-		b.printf("status = %s%s__decode_huffman_fast(self, %sdst, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix, aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__decode_huffman_fast(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
 		b.writes("if (status) { goto suspend; }\n")
 
 	} else if isThisMethod(g.tm, n, "decode_huffman_slow", 2) {
-		b.printf("status = %s%s__decode_huffman_slow(self, %sdst, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix, aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__decode_huffman_slow(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
@@ -602,8 +1040,12 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 		b.writes("if (status) { goto suspend; }\n")
 
 	} else if isThisMethod(g.tm, n, "init_dynamic_huffman", 1) {
-		b.printf("status = %s%s__init_dynamic_huffman(self, %ssrc);\n",
-			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), aPrefix)
+		args, err := g.writeIOArgs(b, n, depth)
+		if err != nil {
+			return err
+		}
+		b.printf("status = %s%s__init_dynamic_huffman(self, %s);\n",
+			g.pkgPrefix, g.currFunk.astFunc.Receiver().String(g.tm), args)
 		if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 			return err
 		}
@@ -638,10 +1080,12 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 			temp := g.currFunk.tempW
 			g.currFunk.tempW++
 
-			// TODO: don't hard-code a_dst or a_src.
-			b.printf("%sstatus %s%d = %sflate_decoder__decode(&self->private_impl.f_flate, %sdst, %ssrc);\n",
-				g.pkgPrefix, tPrefix, temp,
-				g.pkgPrefix, aPrefix, aPrefix)
+			args, err := g.writeIOArgs(b, n, depth)
+			if err != nil {
+				return err
+			}
+			b.printf("%sstatus %s%d = %sflate_decoder__decode(&self->private_impl.f_flate, %s);\n",
+				g.pkgPrefix, tPrefix, temp, g.pkgPrefix, args)
 			if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 				return err
 			}
@@ -655,12 +1099,12 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 			temp := g.currFunk.tempW
 			g.currFunk.tempW++
 
-			// TODO: don't hard-code a_dst or v_r or l_rlimit or v_block_size.
-			b.writes("uint64_t l_rlimit0 = v_block_size;\n")
-			b.printf("%sstatus %s%d = %slzw_decoder__decode(&self->private_impl.f_lzw, %sdst,"+
-				"puffs_base__reader1__limit(&%sr, &l_rlimit0));\n",
-				g.pkgPrefix, tPrefix, temp,
-				g.pkgPrefix, aPrefix, vPrefix)
+			args, err := g.writeIOArgs(b, n, depth)
+			if err != nil {
+				return err
+			}
+			b.printf("%sstatus %s%d = %slzw_decoder__decode(&self->private_impl.f_lzw, %s);\n",
+				g.pkgPrefix, tPrefix, temp, g.pkgPrefix, args)
 			if err := g.writeLoadExprDerivedVars(b, n); err != nil {
 				return err
 			}
@@ -679,15 +1123,148 @@ func (g *gen) writeCallSuspendibles(b *buffer, n *a.Expr, depth uint32) error {
 	return nil
 }
 
-func (g *gen) writeReadUXX(b *buffer, n *a.Expr, name string, size uint32, endianness string) error {
-	if size != 16 && size != 32 {
+// writeIOArgs writes any C local variables needed by n's reader1 and writer1
+// arguments, and returns those arguments' C expressions, comma separated.
+func (g *gen) writeIOArgs(b *buffer, n *a.Expr, depth uint32) (string, error) {
+	args := []string(nil)
+	for _, o := range n.Args() {
+		o := o.Arg()
+		// TODO: remove this hack. See writeLoadDerivedVar.
+		if o.Name().String(g.tm) == "dummy" {
+			continue
+		}
+		arg, err := g.writeIOArg(b, o.Value(), depth)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, ", "), nil
+}
+
+// writeIOArg writes any C local variables needed by x, a reader1 or writer1
+// argument, and returns x's C expression. A "foo.limit(l:bar)" argument needs
+// a uint64_t local holding the remaining length, which the callee decrements
+// as it reads or writes. That local is re-initialized from bar each time the
+// call is made, including on resuming a coroutine, so the caller is
+// responsible for reducing bar, e.g. by "foo.since_mark().length()", between
+// calls.
+func (g *gen) writeIOArg(b *buffer, x *a.Expr, depth uint32) (string, error) {
+	var limit *a.Expr
+	if isThatMethod(g.tm, x, t.KeyLimit, 1) {
+		limit = x.Args()[0].Arg().Value()
+		x = x.LHS().Expr().LHS().Expr()
+	}
+
+	cName := ""
+	if x.ID0() == 0 && x.ID1().IsIdent() {
+		cName = vPrefix + x.ID1().String(g.tm)
+	} else if lhs := x.LHS().Expr(); x.ID0().Key() == t.KeyDot && lhs.ID0() == 0 && lhs.ID1().Key() == t.KeyIn {
+		cName = aPrefix + x.ID1().String(g.tm)
+	} else {
+		return "", fmt.Errorf("cannot convert Puffs I/O argument %q to C", x.String(g.tm))
+	}
+	if limit == nil {
+		return cName, nil
+	}
+
+	typeName, lName := "reader1", fmt.Sprintf("%srlimit%d", lPrefix, g.currFunk.limits)
+	if x.MType().Name().Key() == t.KeyWriter1 {
+		typeName, lName = "writer1", fmt.Sprintf("%swlimit%d", lPrefix, g.currFunk.limits)
+	}
+	g.currFunk.limits++
+	b.printf("uint64_t %s = ", lName)
+	if err := g.writeExpr(b, limit, replaceNothing, parenthesesOptional, depth); err != nil {
+		return "", err
+	}
+	b.writes(";\n")
+	return fmt.Sprintf("puffs_base__%s__limit(&%s, &%s)", typeName, cName, lName), nil
+}
+
+// writeBitreaderRefill writes the C code for "br.refill?(src:in.src, n:etc)".
+// When at least 8 bytes are buffered, the fast path fills the bitreader with a
+// single 64-bit load. Otherwise, it reads one byte at a time, suspending on a
+// short read.
+func (g *gen) writeBitreaderRefill(b *buffer, n *a.Expr, depth uint32) error {
+	br := n.LHS().Expr().LHS().Expr()
+	src := n.Args()[0].Arg().Value()
+	if src.ID0().Key() != t.KeyDot || src.LHS().Expr().ID1().Key() != t.KeyIn {
+		return fmt.Errorf("TODO: cgen a %q refill from %q", br.String(g.tm), src.String(g.tm))
+	}
+	name := src.ID1().String(g.tm)
+
+	// The receiver and the "n" argument are pure, so evaluating them more
+	// than once is OK.
+	brBuf, nBuf := buffer(nil), buffer(nil)
+	if err := g.writeExpr(&brBuf, br, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+	if err := g.writeExpr(&nBuf, n.Args()[1].Arg().Value(), replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+
+	b.printf("while (%s.n_bits < %s) {\n", brBuf, nBuf)
+	b.printf("if (PUFFS_BASE__LIKELY(%srend_%s - %srptr_%s >= 8)) {\n", bPrefix, name, bPrefix, name)
+	b.printf("%srptr_%s += puffs_base__bitreader__refill_fast(&%s, %srptr_%s, %s);\n",
+		bPrefix, name, brBuf, bPrefix, name, nBuf)
+	b.writes("break;\n}\n")
+	b.printf("if (PUFFS_BASE__UNLIKELY(%srptr_%s == %srend_%s)) { goto short_read_%s; }\n",
+		bPrefix, name, bPrefix, name, name)
+	g.currFunk.shortReads = append(g.currFunk.shortReads, name)
+	b.printf("puffs_base__bitreader__refill_u8(&%s, *%srptr_%s++);\n", brBuf, bPrefix, name)
+	b.writes("}\n")
+	return nil
+}
+
+// writeReadUXX writes "in.src.read_u16be?()", "in.src.peek_i32le?()", etc.
+// If enough bytes are buffered, or the call is proven not to suspend, it is a
+// single load. Otherwise, a multi-byte read reads one byte at a time,
+// suspending as necessary, and a peek suspends until all of its bytes are
+// buffered.
+func (g *gen) writeReadUXX(b *buffer, n *a.Expr, name string, m builtin.IOMethod) error {
+	size := 8 * m.NBytes
+	if size != 8 && size != 16 && size != 24 && size != 32 {
 		return fmt.Errorf("internal error: bad writeReadUXX size %d", size)
 	}
-	if endianness != "be" && endianness != "le" {
-		return fmt.Errorf("internal error: bad writeReadUXX endianness %q", endianness)
+	endianness := "le"
+	if m.BigEndian {
+		endianness = "be"
+	}
+	load := fmt.Sprintf("*%srptr_%s", bPrefix, name)
+	if size != 8 {
+		load = fmt.Sprintf("puffs_base__load_u%d%s(%srptr_%s)", size, endianness, bPrefix, name)
+	}
+	// Loads return unsigned values. Signed types need an explicit conversion.
+	conv := func(x string) string { return x }
+	if m.Signed {
+		cTyp := buffer(nil)
+		if err := g.writeCTypeName(&cTyp, n.MType(), "", ""); err != nil {
+			return err
+		}
+		conv = func(x string) string { return fmt.Sprintf("((%s)(%s))", cTyp, x) }
 	}
 
-	// TODO: look at n.ProvenNotToSuspend().
+	if m.Op == "peek" || size == 8 || n.ProvenNotToSuspend() {
+		if g.currFunk.tempW > maxTemp {
+			return fmt.Errorf("too many temporary variables required")
+		}
+		temp := g.currFunk.tempW
+		g.currFunk.tempW++
+
+		if !n.ProvenNotToSuspend() {
+			b.printf("if (PUFFS_BASE__UNLIKELY(%srend_%s - %srptr_%s < %d)) { goto short_read_%s; }",
+				bPrefix, name, bPrefix, name, m.NBytes, name)
+			g.currFunk.shortReads = append(g.currFunk.shortReads, name)
+		}
+		if err := g.writeCTypeName(b, n.MType(), tPrefix, fmt.Sprint(temp)); err != nil {
+			return err
+		}
+		b.printf(" = %s;\n", conv(load))
+		if m.Op != "peek" {
+			b.printf("%srptr_%s += %d;\n", bPrefix, name, m.NBytes)
+		}
+		return nil
+	}
 
 	if g.currFunk.tempW > maxTemp-1 {
 		return fmt.Errorf("too many temporary variables required")
@@ -708,9 +1285,9 @@ func (g *gen) writeReadUXX(b *buffer, n *a.Expr, name string, size uint32, endia
 	scratchName := fmt.Sprintf("self->private_impl.%s%s[0].scratch",
 		cPrefix, g.currFunk.astFunc.Name().String(g.tm))
 
-	b.printf("if (PUFFS_BASE__LIKELY(%srend_src - %srptr_src >= %d)) {", bPrefix, bPrefix, size/8)
-	b.printf("%s%d = puffs_base__load_u%d%s(%srptr_src);\n", tPrefix, temp1, size, endianness, bPrefix)
-	b.printf("%srptr_src += %d;\n", bPrefix, size/8)
+	b.printf("if (PUFFS_BASE__LIKELY(%srend_%s - %srptr_%s >= %d)) {", bPrefix, name, bPrefix, name, m.NBytes)
+	b.printf("%s%d = %s;\n", tPrefix, temp1, conv(load))
+	b.printf("%srptr_%s += %d;\n", bPrefix, name, m.NBytes)
 	b.printf("} else {")
 	b.printf("%s = 0;\n", scratchName)
 	if err := g.writeCoroSuspPoint(b, false); err != nil {
@@ -722,13 +1299,15 @@ func (g *gen) writeReadUXX(b *buffer, n *a.Expr, name string, size uint32, endia
 		bPrefix, name, bPrefix, name, name)
 	g.currFunk.shortReads = append(g.currFunk.shortReads, name)
 
+	// The scratch value holds the bytes read so far and, in its otherwise
+	// unused low or high byte, how many bits that is.
 	b.printf("uint32_t %s%d = %s", tPrefix, temp0, scratchName)
 	switch endianness {
 	case "be":
 		b.printf("& 0xFF;")
 		b.printf("%s >>= 8;", scratchName)
 		b.printf("%s <<= 8;", scratchName)
-		b.printf("%s |= ((uint64_t)(*%srptr_%s++)) << (64 - %s%d);",
+		b.printf("%s |= ((uint64_t)(*%srptr_%s++)) << (56 - %s%d);",
 			scratchName, bPrefix, name, tPrefix, temp0)
 	case "le":
 		b.printf(">> 56;")
@@ -741,9 +1320,9 @@ func (g *gen) writeReadUXX(b *buffer, n *a.Expr, name string, size uint32, endia
 	b.printf("if (%s%d == %d) {", tPrefix, temp0, size-8)
 	switch endianness {
 	case "be":
-		b.printf("%s%d = %s >> (64 - %d);", tPrefix, temp1, scratchName, size)
+		b.printf("%s%d = %s;", tPrefix, temp1, conv(fmt.Sprintf("%s >> (64 - %d)", scratchName, size)))
 	case "le":
-		b.printf("%s%d = %s;", tPrefix, temp1, scratchName)
+		b.printf("%s%d = %s;", tPrefix, temp1, conv(scratchName))
 	}
 	b.printf("break;")
 	b.printf("}")
@@ -760,6 +1339,174 @@ func (g *gen) writeReadUXX(b *buffer, n *a.Expr, name string, size uint32, endia
 	return nil
 }
 
+// writeWriteUXX writes "in.dst.write_u16be?(x:etc)", etc. If there is enough
+// room, or the call is proven not to suspend, it is a single store. Otherwise,
+// it writes one byte at a time, suspending as necessary. The scratch value's
+// low 32 bits hold the value being written and its high 32 bits count the
+// bytes written so far.
+func (g *gen) writeWriteUXX(b *buffer, n *a.Expr, name string, m builtin.IOMethod, depth uint32) error {
+	size := 8 * m.NBytes
+	if size != 8 && size != 16 && size != 24 && size != 32 {
+		return fmt.Errorf("internal error: bad writeWriteUXX size %d", size)
+	}
+	x := n.Args()[0].Arg().Value()
+
+	if size == 8 {
+		if !n.ProvenNotToSuspend() {
+			b.printf("if (%swptr_%s == %swend_%s) { status = %sSUSPENSION_SHORT_WRITE;",
+				bPrefix, name, bPrefix, name, g.PKGPREFIX)
+			b.writes("goto suspend;")
+			b.writes("}\n")
+		}
+		b.printf("*%swptr_%s++ = ", bPrefix, name)
+		if err := g.writeExpr(b, x, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+			return err
+		}
+		b.writes(";\n")
+		return nil
+	}
+
+	endianness := "le"
+	if m.BigEndian {
+		endianness = "be"
+	}
+	uTyp := fmt.Sprintf("uint%d_t", size)
+	if size == 24 {
+		uTyp = "uint32_t"
+	}
+
+	if g.currFunk.tempW > maxTemp-1 {
+		return fmt.Errorf("too many temporary variables required")
+	}
+	// temp0 and temp1 are read by code generated in this function.
+	temp0 := g.currFunk.tempW + 0
+	temp1 := g.currFunk.tempW + 1
+	g.currFunk.tempW += 2
+
+	b.printf("%s %s%d = ((%s)(", uTyp, tPrefix, temp0, uTyp)
+	if err := g.writeExpr(b, x, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+	b.writes("));\n")
+	g.currFunk.tempR += 2
+
+	store := func() {
+		b.printf("puffs_base__store_u%d%s(%swptr_%s, %s%d);\n", size, endianness, bPrefix, name, tPrefix, temp0)
+		b.printf("%swptr_%s += %d;\n", bPrefix, name, m.NBytes)
+	}
+	if n.ProvenNotToSuspend() {
+		store()
+		return nil
+	}
+
+	g.currFunk.usesScratch = true
+	// TODO: don't hard-code [0], and allow recursive coroutines.
+	scratchName := fmt.Sprintf("self->private_impl.%s%s[0].scratch",
+		cPrefix, g.currFunk.astFunc.Name().String(g.tm))
+
+	b.printf("if (PUFFS_BASE__LIKELY(%swend_%s - %swptr_%s >= %d)) {", bPrefix, name, bPrefix, name, m.NBytes)
+	store()
+	b.printf("} else {")
+	b.printf("%s = %s%d;\n", scratchName, tPrefix, temp0)
+	if err := g.writeCoroSuspPoint(b, false); err != nil {
+		return err
+	}
+	b.printf("while (true) {")
+	b.printf("if (%swptr_%s == %swend_%s) { status = %sSUSPENSION_SHORT_WRITE;",
+		bPrefix, name, bPrefix, name, g.PKGPREFIX)
+	b.writes("goto suspend;")
+	b.writes("}\n")
+	b.printf("uint32_t %s%d = %s >> 32;", tPrefix, temp1, scratchName)
+	switch endianness {
+	case "be":
+		b.printf("*%swptr_%s++ = ((uint8_t)(%s >> (%d - (8 * %s%d))));",
+			bPrefix, name, scratchName, size-8, tPrefix, temp1)
+	case "le":
+		b.printf("*%swptr_%s++ = ((uint8_t)(%s >> (8 * %s%d)));",
+			bPrefix, name, scratchName, tPrefix, temp1)
+	}
+	b.printf("if (%s%d == %d) { break; }", tPrefix, temp1, m.NBytes-1)
+	b.printf("%s += ((uint64_t)(1)) << 32;", scratchName)
+	b.writes("}}\n")
+	return nil
+}
+
+// writeReadMatch writes "in.src.read_match?(s:etc)", which reads len(s) bytes
+// and compares them to s. If enough bytes are buffered, it is a memcmp call.
+// Otherwise, it compares one byte at a time, suspending as necessary. The
+// scratch variable's low 32 bits count the bytes read so far, and bit 32 is
+// set after a mismatch.
+func (g *gen) writeReadMatch(b *buffer, n *a.Expr, depth uint32) error {
+	x := n.Args()[0].Arg().Value()
+	length := x.MType().ArrayLength().ConstValue()
+	if length == nil || length.Sign() <= 0 || length.Cmp(maxReadMatchLength) > 0 {
+		return fmt.Errorf("internal error: bad read_match length for %q", x.String(g.tm))
+	}
+
+	if g.currFunk.tempW > maxTemp {
+		return fmt.Errorf("too many temporary variables required")
+	}
+	temp := g.currFunk.tempW
+	g.currFunk.tempW++
+	if len(n.Args()) == 2 {
+		// The temporary is read by code generated in this function.
+		g.currFunk.tempR++
+	}
+	b.printf("bool %s%d;", tPrefix, temp)
+
+	if !n.ProvenNotToSuspend() {
+		b.printf("if (PUFFS_BASE__LIKELY(%srend_src - %srptr_src >= %v)) {", bPrefix, bPrefix, length)
+	}
+	b.printf("%s%d = !memcmp(%srptr_src, ", tPrefix, temp, bPrefix)
+	if err := g.writeExpr(b, x, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+		return err
+	}
+	b.printf(", %v);\n", length)
+	b.printf("%srptr_src += %v;\n", bPrefix, length)
+
+	if !n.ProvenNotToSuspend() {
+		g.currFunk.usesScratch = true
+		// TODO: don't hard-code [0], and allow recursive coroutines.
+		scratchName := fmt.Sprintf("self->private_impl.%s%s[0].scratch",
+			cPrefix, g.currFunk.astFunc.Name().String(g.tm))
+
+		b.printf("} else {")
+		b.printf("%s = 0;\n", scratchName)
+		if err := g.writeCoroSuspPoint(b, false); err != nil {
+			return err
+		}
+		b.printf("while (true) {")
+		b.printf("if (PUFFS_BASE__UNLIKELY(%srptr_src == %srend_src)) { goto short_read_src; }",
+			bPrefix, bPrefix)
+		g.currFunk.shortReads = append(g.currFunk.shortReads, "src")
+		b.printf("if (*%srptr_src++ != ", bPrefix)
+		if err := g.writeExpr(b, x, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+			return err
+		}
+		b.printf("[(uint32_t)(%s)]) { %s |= ((uint64_t)1) << 32; }", scratchName, scratchName)
+		b.printf("%s++;", scratchName)
+		b.printf("if ((uint32_t)(%s) == %v) {", scratchName, length)
+		b.printf("%s%d = !(%s >> 32);", tPrefix, temp, scratchName)
+		b.printf("break;")
+		b.printf("}")
+		b.writes("}}\n")
+	}
+
+	if len(n.Args()) == 2 {
+		e := n.Args()[1].Arg().Value()
+		b.printf("if (!%s%d) { status = ", tPrefix, temp)
+		if err := g.writeExpr(b, e, replaceCallSuspendibles, parenthesesMandatory, depth); err != nil {
+			return err
+		}
+		b.writes("; goto exit; }\n")
+	}
+	return nil
+}
+
+// maxReadMatchLength is the largest length that fits in the low 32 bits of
+// writeReadMatch's scratch variable.
+var maxReadMatchLength = big.NewInt(0xFFFFFFFF)
+
 func isInSrc(tm *t.Map, n *a.Expr, methodName t.Key, nArgs int) bool {
 	callSuspendible := methodName != t.KeySinceMark &&
 		methodName != t.KeyMark &&
@@ -820,6 +1567,38 @@ func isThisMethod(tm *t.Map, n *a.Expr, methodName string, nArgs int) bool {
 	return n.ID0() == 0 && n.ID1().Key() == t.KeyThis
 }
 
+// ioMethod returns the built-in I/O method that n calls, if n is
+// "in.src.read_u16be?()", "in.dst.write_u8?(x:etc)" or similar.
+func ioMethod(tm *t.Map, n *a.Expr) (m builtin.IOMethod, ok bool) {
+	if n.ID0().Key() != t.KeyOpenParen || !n.CallSuspendible() {
+		return builtin.IOMethod{}, false
+	}
+	lhs := n.LHS().Expr()
+	if lhs.ID0().Key() != t.KeyDot {
+		return builtin.IOMethod{}, false
+	}
+	m, ok = builtin.IOMethodMap[lhs.ID1().String(tm)]
+	if !ok {
+		return builtin.IOMethod{}, false
+	}
+	receiver, nArgs := "src", 0
+	if m.Op == "write" {
+		receiver, nArgs = "dst", 1
+	}
+	if len(n.Args()) != nArgs {
+		return builtin.IOMethod{}, false
+	}
+	lhs = lhs.LHS().Expr()
+	if lhs.ID0().Key() != t.KeyDot || lhs.ID1() != tm.ByName(receiver) {
+		return builtin.IOMethod{}, false
+	}
+	lhs = lhs.LHS().Expr()
+	if lhs.ID0() != 0 || lhs.ID1().Key() != t.KeyIn {
+		return builtin.IOMethod{}, false
+	}
+	return m, true
+}
+
 // isThatMethod is like isThisMethod but for foo.bar(etc), not this.bar(etc).
 func isThatMethod(tm *t.Map, n *a.Expr, methodName t.Key, nArgs int) bool {
 	if k := n.ID0().Key(); k != t.KeyOpenParen && k != t.KeyTry {
@@ -35,6 +35,14 @@ func (g *gen) needDerivedVar(name t.ID) bool {
 			if q.ID0().Key() != t.KeyOpenParen {
 				return nil
 			}
+			// Also look for p matching "foo.refill?(src:in.name, n:etc)".
+			if isThatMethod(g.tm, q, t.KeyRefill, 2) {
+				if v := q.Args()[0].Arg().Value(); v.ID0().Key() == t.KeyDot && v.ID1() == name {
+					if v = v.LHS().Expr(); v.ID0() == 0 && v.ID1().Key() == t.KeyIn {
+						return errNeedDerivedVar
+					}
+				}
+			}
 			q = q.LHS().Expr()
 			if q.ID0().Key() != t.KeyDot {
 				return nil
@@ -222,11 +230,17 @@ func (g *gen) writeLoadExprDerivedVars(b *buffer, n *a.Expr) error {
 	}
 	for _, o := range n.Args() {
 		o := o.Arg()
+		v := o.Value()
+		// A limited reader1 or writer1 shares its derived variables with
+		// the unlimited one.
+		if isThatMethod(g.tm, v, t.KeyLimit, 1) {
+			v = v.LHS().Expr().LHS().Expr()
+		}
 		// TODO: don't hard-code these.
-		if s := o.Value().String(g.tm); s != "in.dst" && s != "in.src" {
+		if s := v.String(g.tm); s != "in.dst" && s != "in.src" {
 			continue
 		}
-		if err := g.writeLoadDerivedVar(b, o.Name(), o.Value().MType(), false); err != nil {
+		if err := g.writeLoadDerivedVar(b, o.Name(), v.MType(), false); err != nil {
 			return err
 		}
 	}
@@ -242,11 +256,17 @@ func (g *gen) writeSaveExprDerivedVars(b *buffer, n *a.Expr) error {
 	}
 	for _, o := range n.Args() {
 		o := o.Arg()
+		v := o.Value()
+		// A limited reader1 or writer1 shares its derived variables with
+		// the unlimited one.
+		if isThatMethod(g.tm, v, t.KeyLimit, 1) {
+			v = v.LHS().Expr().LHS().Expr()
+		}
 		// TODO: don't hard-code these.
-		if s := o.Value().String(g.tm); s != "in.dst" && s != "in.src" {
+		if s := v.String(g.tm); s != "in.dst" && s != "in.src" {
 			continue
 		}
-		if err := g.writeSaveDerivedVar(b, o.Name(), o.Value().MType(), false); err != nil {
+		if err := g.writeSaveDerivedVar(b, o.Name(), v.MType(), false); err != nil {
 			return err
 		}
 	}
@@ -271,6 +291,17 @@ func (g *gen) visitVars(b *buffer, block []*a.Node, depth uint32, f func(*gen, *
 				}
 			}
 
+		case a.KSwitch:
+			o := o.Switch()
+			for _, c := range o.Cases() {
+				if err := g.visitVars(b, c.Case().Body(), depth, f); err != nil {
+					return err
+				}
+			}
+			if err := g.visitVars(b, o.BodyIfElse(), depth, f); err != nil {
+				return err
+			}
+
 		case a.KVar:
 			if err := f(g, b, o.Var()); err != nil {
 				return err
@@ -308,8 +339,11 @@ func (g *gen) writeResumeSuspend1(b *buffer, n *a.Var, suspend bool) error {
 				rhs = cTypeNames[key]
 			}
 		case t.KeyColon:
-			// TODO: don't assume that the slice is a slice of u8.
-			rhs = "puffs_base__slice_u8"
+			s, err := g.sliceTypeName(typ)
+			if err != nil {
+				return err
+			}
+			rhs = s
 		}
 		if rhs != "" {
 			b.printf("%s = ((%s){});\n", local, rhs)
@@ -317,6 +351,10 @@ func (g *gen) writeResumeSuspend1(b *buffer, n *a.Var, suspend bool) error {
 		}
 
 	} else {
+		if _, ok := g.currFunk.savedVars[n.Name()]; !ok {
+			// n is not live across any suspension point.
+			return nil
+		}
 		lhs := local
 		// TODO: don't hard-code [0], and allow recursive coroutines.
 		rhs := fmt.Sprintf("self->private_impl.%s%s[0].%s", cPrefix, g.currFunk.astFunc.Name().String(g.tm), lhs)
@@ -351,17 +389,43 @@ func (g *gen) writeResumeSuspend(b *buffer, block []*a.Node, suspend bool) error
 	})
 }
 
-func (g *gen) writeVars(b *buffer, block []*a.Node, skipPointerTypes bool, skipIterateVariables bool) error {
+// writeSavedVars writes the declarations of the local variables in k's body
+// that are saved across coroutine suspension points, the fields of k's
+// coroutine state.
+func (g *gen) writeSavedVars(b *buffer, k *funk) error {
+	return g.visitVars(b, k.astFunc.Body(), 0, func(g *gen, b *buffer, n *a.Var) error {
+		if _, ok := k.savedVars[n.Name()]; !ok {
+			return nil
+		}
+		if err := g.writeCTypeName(b, n.XType(), vPrefix, n.Name().String(g.tm)); err != nil {
+			return err
+		}
+		b.writes(";\n")
+		return nil
+	})
+}
+
+// writeVars writes the declarations of the local variables in block. If
+// zeroInit is set, variables of numeric, bool or status type are initialized
+// to zero. Suspendible functions need this, as a coroutine suspension saves
+// the variables in savedVars, which are conservatively live and so might not
+// have been assigned to yet, and C compilers can warn (and with -Werror, fail)
+// about reading them.
+func (g *gen) writeVars(b *buffer, block []*a.Node, skipPointerTypes bool, skipIterateVariables bool, zeroInit bool) error {
 	return g.visitVars(b, block, 0, func(g *gen, b *buffer, n *a.Var) error {
-		if skipPointerTypes && n.XType().HasPointers() {
+		typ := n.XType()
+		if skipPointerTypes && typ.HasPointers() {
 			return nil
 		}
 		if skipIterateVariables && n.IterateVariable() {
 			return nil
 		}
-		if err := g.writeCTypeName(b, n.XType(), vPrefix, n.Name().String(g.tm)); err != nil {
+		if err := g.writeCTypeName(b, typ, vPrefix, n.Name().String(g.tm)); err != nil {
 			return err
 		}
+		if zeroInit && typ.Decorator() == 0 && (typ.IsNumType() || typ.IsBool() || typ.Name().Key() == t.KeyStatus) {
+			b.writes(" = 0")
+		}
 		b.writes(";\n")
 		return nil
 	})
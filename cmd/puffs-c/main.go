@@ -37,10 +37,14 @@ func main1() error {
 	switch os.Args[1] {
 	case "bench":
 		return doBench(args)
+	case "fuzz":
+		return doFuzz(args)
 	case "gen":
 		return cgen.Do(args)
 	case "genlib":
 		return doGenlib(args)
+	case "sizes":
+		return doSizes(args)
 	case "test":
 		return doTest(args)
 	}
@@ -0,0 +1,381 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	cf "github.com/google/puffs/cmd/commonflags"
+)
+
+// sizesReport is the output of "puffs-c sizes", as tables and optionally as
+// JSON. The JSON form can be checked in, and passed back as a -baseline.
+type sizesReport struct {
+	Packages []*packageSizes `json:"packages"`
+}
+
+type packageSizes struct {
+	Package string         `json:"package"` // e.g. "std/gif".
+	Structs []*structSizes `json:"structs"`
+	Objects []*objectSizes `json:"objects"`
+}
+
+// structSizes is a public struct's C sizeof, broken down by its parts: the
+// user fields, the coroutine state and the rest of its private_impl, which is
+// the status, magic and padding.
+type structSizes struct {
+	Name        string            `json:"name"`
+	Sizeof      uint64            `json:"sizeof"`
+	Fields      uint64            `json:"fields"`
+	Coroutines  uint64            `json:"coroutines"`
+	PrivateImpl uint64            `json:"private_impl"`
+	Parts       map[string]uint64 `json:"parts"` // e.g. "f_lzw" or "c_decode".
+}
+
+// objectSizes is the size of an object file, compiled from the generated C
+// code by one C compiler at one optimization level.
+type objectSizes struct {
+	CC      string         `json:"cc"`
+	Opt     string         `json:"opt"` // e.g. "-O2".
+	Text    uint64         `json:"text"`
+	Data    uint64         `json:"data"`
+	Symbols []*symbolSizes `json:"symbols"`
+}
+
+type symbolSizes struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // Either "text" or "data".
+	Size uint64 `json:"size"`
+}
+
+// doSizes reports the struct and binary sizes of C code generated by
+// "puffs-c gen -size_probes". See sizes.go in the C code generator.
+func doSizes(args []string) error {
+	flags := flag.FlagSet{}
+	baselineFlag := flags.String("baseline", "", `the JSON report, if any, to compare against, `+
+		`failing if any size regressed`)
+	ccompilersFlag := flags.String("ccompilers", cf.CcompilersDefault, cf.CcompilersUsage)
+	gendirFlag := flags.String("gendir", "", `directory containing the generated C code, `+
+		`used to name each file's package, e.g. "std/gif"`)
+	jsonFlag := flags.String("json", "", `the JSON report file to write, if any, e.g. "sizes.json"`)
+	optsFlag := flags.String("opts", "-O2,-Os", `comma-separated list of C compiler optimization flags`)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !cf.IsAlphaNumericIsh(*ccompilersFlag) {
+		return fmt.Errorf("bad -ccompilers flag value %q", *ccompilersFlag)
+	}
+	if !cf.IsAlphaNumericIsh(*optsFlag) {
+		return fmt.Errorf("bad -opts flag value %q", *optsFlag)
+	}
+	ccs, opts := splitCommaSeparated(*ccompilersFlag), splitCommaSeparated(*optsFlag)
+	for _, opt := range opts {
+		if !strings.HasPrefix(opt, "-O") {
+			return fmt.Errorf("bad -opts flag value %q: %q does not start with \"-O\"", *optsFlag, opt)
+		}
+	}
+
+	r := &sizesReport{}
+	for _, filename := range flags.Args() {
+		p, err := doSizes1(filename, *gendirFlag, ccs, opts)
+		if err != nil {
+			return err
+		}
+		r.Packages = append(r.Packages, p)
+	}
+
+	if err := r.writeTables(os.Stdout); err != nil {
+		return err
+	}
+	if *jsonFlag != "" {
+		enc, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*jsonFlag, append(enc, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("sizes wrote:    %s\n", *jsonFlag)
+	}
+
+	if *baselineFlag != "" {
+		baseline, err := readSizesReport(*baselineFlag)
+		if err != nil {
+			return err
+		}
+		if regressions := r.compare(baseline); len(regressions) > 0 {
+			for _, s := range regressions {
+				fmt.Fprintf(os.Stderr, "regressed: %s\n", s)
+			}
+			return fmt.Errorf("%s: some sizes regressed", os.Args[0])
+		}
+	}
+	return nil
+}
+
+func splitCommaSeparated(s string) []string {
+	ret := []string(nil)
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
+
+func doSizes1(filename string, gendir string, ccs []string, opts []string) (*packageSizes, error) {
+	workDir, err := ioutil.TempDir("", "puffs-c")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	p := &packageSizes{Package: filename}
+	if gendir != "" {
+		rel, err := filepath.Rel(gendir, filename)
+		if err != nil {
+			return nil, err
+		}
+		p.Package = filepath.ToSlash(strings.TrimSuffix(rel, ".c"))
+	}
+
+	for i, cc := range ccs {
+		// Struct sizes depend on the target, not on the C compiler, so only
+		// the first one runs the size probes.
+		if i == 0 {
+			if p.Structs, err = runSizeProbes(workDir, filename, cc); err != nil {
+				return nil, err
+			}
+		}
+		for _, opt := range opts {
+			o, err := measureObject(workDir, filename, cc, opt)
+			if err != nil {
+				return nil, err
+			}
+			p.Objects = append(p.Objects, o)
+		}
+	}
+	return p, nil
+}
+
+func runCommand(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// runSizeProbes compiles and runs the generated code's PUFFS_SIZES_MAIN
+// program, parsing its "kind struct_name [part_name] size" lines.
+func runSizeProbes(workDir string, filename string, cc string) ([]*structSizes, error) {
+	out := filepath.Join(workDir, "sizes")
+	if _, err := runCommand(cc, "-std=c99", "-DPUFFS_SIZES_MAIN", "-o", out, filename); err != nil {
+		return nil, err
+	}
+	stdout, err := runCommand(out)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*structSizes(nil)
+	m := map[string]*structSizes{}
+	s := bufio.NewScanner(bytes.NewReader(stdout))
+	for s.Scan() {
+		text := s.Text()
+		bad := fmt.Errorf("%s: bad size probe line %q", filename, text)
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, bad
+		}
+		size, err := strconv.ParseUint(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			return nil, bad
+		}
+
+		if fields[0] == "struct" && len(fields) == 3 {
+			x := &structSizes{Name: fields[1], Sizeof: size, Parts: map[string]uint64{}}
+			ret = append(ret, x)
+			m[x.Name] = x
+			continue
+		}
+		x := m[fields[1]]
+		if x == nil || len(fields) != 4 {
+			return nil, bad
+		}
+		switch fields[0] {
+		case "field":
+			x.Fields += size
+		case "coroutine":
+			x.Coroutines += size
+		default:
+			return nil, bad
+		}
+		x.Parts[fields[2]] = size
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, x := range ret {
+		if x.Fields+x.Coroutines > x.Sizeof {
+			return nil, fmt.Errorf("%s: %s's parts are larger than its sizeof", filename, x.Name)
+		}
+		x.PrivateImpl = x.Sizeof - x.Fields - x.Coroutines
+	}
+	return ret, nil
+}
+
+// measureObject compiles the generated code to an object file and reads the
+// sizes of its functions and data from its symbol table.
+func measureObject(workDir string, filename string, cc string, opt string) (*objectSizes, error) {
+	out := filepath.Join(workDir, "sizes.o")
+	if _, err := runCommand(cc, "-std=c99", opt, "-c", "-o", out, filename); err != nil {
+		return nil, err
+	}
+	// Each line is "value size type name", e.g.
+	// "0000000000000a40 00000000000001c3 T puffs_gif__decoder__decode".
+	// Symbols without a size, such as undefined ones, have fewer columns.
+	stdout, err := runCommand("nm", "-S", "--defined-only", out)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &objectSizes{CC: cc, Opt: opt}
+	s := bufio.NewScanner(bytes.NewReader(stdout))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		kind := ""
+		switch strings.ToUpper(fields[2]) {
+		case "T":
+			kind = "text"
+		case "B", "D", "R":
+			kind = "data"
+		default:
+			continue
+		}
+		size, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad nm output line %q", s.Text())
+		}
+		if kind == "text" {
+			o.Text += size
+		} else {
+			o.Data += size
+		}
+		o.Symbols = append(o.Symbols, &symbolSizes{Name: fields[3], Kind: kind, Size: size})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(o.Symbols, func(i, j int) bool { return o.Symbols[i].Name < o.Symbols[j].Name })
+	return o, nil
+}
+
+func readSizesReport(filename string) (*sizesReport, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &sizesReport{}
+	if err := json.Unmarshal(src, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return r, nil
+}
+
+// flatten returns r's sizes keyed by what they measure, such as "std/gif gcc
+// -O2 text puffs_gif__decoder__decode".
+func (r *sizesReport) flatten() map[string]uint64 {
+	ret := map[string]uint64{}
+	for _, p := range r.Packages {
+		for _, x := range p.Structs {
+			ret[fmt.Sprintf("%s struct %s", p.Package, x.Name)] = x.Sizeof
+		}
+		for _, o := range p.Objects {
+			prefix := fmt.Sprintf("%s %s %s", p.Package, o.CC, o.Opt)
+			ret[prefix+" text"] = o.Text
+			ret[prefix+" data"] = o.Data
+			for _, x := range o.Symbols {
+				ret[fmt.Sprintf("%s %s %s", prefix, x.Kind, x.Name)] = x.Size
+			}
+		}
+	}
+	return ret
+}
+
+// compare returns the sizes that grew since the baseline. Sizes that are not
+// in the baseline, such as those of new functions, are not regressions by
+// themselves, but they do count towards their object's total size.
+func (r *sizesReport) compare(baseline *sizesReport) []string {
+	ret := []string(nil)
+	old := baseline.flatten()
+	for k, n := range r.flatten() {
+		if o, ok := old[k]; ok && n > o {
+			ret = append(ret, fmt.Sprintf("%s: %d -> %d (+%d)", k, o, n, n-o))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (r *sizesReport) writeTables(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "package\tstruct\tsizeof\tfields\tcoroutines\tprivate_impl\n")
+	for _, p := range r.Packages {
+		for _, x := range p.Structs {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n",
+				p.Package, x.Name, x.Sizeof, x.Fields, x.Coroutines, x.PrivateImpl)
+		}
+	}
+	fmt.Fprintf(tw, "\n")
+
+	fmt.Fprintf(tw, "package\tcc\topt\ttext\tdata\n")
+	for _, p := range r.Packages {
+		for _, o := range p.Objects {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", p.Package, o.CC, o.Opt, o.Text, o.Data)
+		}
+	}
+	fmt.Fprintf(tw, "\n")
+
+	// List the symbols largest first, which are the most worth shrinking.
+	fmt.Fprintf(tw, "package\tcc\topt\tkind\tsize\tsymbol\n")
+	for _, p := range r.Packages {
+		for _, o := range p.Objects {
+			symbols := append([]*symbolSizes(nil), o.Symbols...)
+			sort.SliceStable(symbols, func(i, j int) bool { return symbols[i].Size > symbols[j].Size })
+			for _, x := range symbols {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", p.Package, o.CC, o.Opt, x.Kind, x.Size, x.Name)
+			}
+		}
+	}
+	return tw.Flush()
+}
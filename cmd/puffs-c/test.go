@@ -23,6 +23,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	cf "github.com/google/puffs/cmd/commonflags"
 )
@@ -33,9 +34,18 @@ func doTest(args []string) error  { return doBenchTest(args, false) }
 func doBenchTest(args []string, bench bool) error {
 	flags := flag.FlagSet{}
 	ccompilersFlag := flags.String("ccompilers", cf.CcompilersDefault, cf.CcompilersUsage)
+	differentialFlag := flags.Bool("differential", false, `whether to run differential tests, comparing the Puffs `+
+		`decoders with the mimic libraries on random and mutated inputs, instead of the regular tests`)
+	durationFlag := flags.Duration("duration", cf.FuzzDurationDefault, `how long to run each differential test for`)
+	findingsFlag := flags.String("findings", cf.FuzzFindingsDefault, cf.FuzzFindingsUsage)
 	focusFlag := flags.String("focus", cf.FocusDefault, cf.FocusUsage)
+	gendirFlag := flags.String("gendir", "", "directory containing the generated C code to test, "+
+		"instead of the code #include'd by the test program")
 	mimicFlag := flags.Bool("mimic", cf.MimicDefault, cf.MimicUsage)
 	repsFlag := flags.Int("reps", cf.RepsDefault, cf.RepsUsage)
+	sanitizeFlag := flags.String("sanitize", cf.SanitizeDefault, cf.SanitizeUsage)
+	seedsFlag := flags.String("seeds", "", `the directory of inputs to start differential testing from, if any`)
+	stressIOFlag := flags.Bool("stress_io", cf.StressIODefault, cf.StressIOUsage)
 
 	if err := flags.Parse(args); err != nil {
 		return err
@@ -50,12 +60,31 @@ func doBenchTest(args []string, bench bool) error {
 	if *repsFlag < cf.RepsMin || cf.RepsMax < *repsFlag {
 		return fmt.Errorf("bad -reps flag value %d, outside the range [%d..%d]", *repsFlag, cf.RepsMin, cf.RepsMax)
 	}
+	sanitizers, err := parseSanitizers(*sanitizeFlag)
+	if err != nil {
+		return err
+	}
+
+	if bench && *stressIOFlag {
+		return fmt.Errorf("-stress_io is not supported when benchmarking")
+	}
+
+	var diff *differentialOptions
+	if *differentialFlag {
+		if bench {
+			return fmt.Errorf("-differential is not supported when benchmarking")
+		}
+		if diff, err = newDifferentialOptions(*durationFlag, *findingsFlag, *seedsFlag); err != nil {
+			return err
+		}
+	}
 
 	args = flags.Args()
 
 	failed := false
 	for _, arg := range args {
-		f, err := doBenchTest1(arg, bench, *ccompilersFlag, *focusFlag, *mimicFlag, *repsFlag)
+		f, err := doBenchTest1(arg, bench, *ccompilersFlag, *focusFlag, *gendirFlag, *mimicFlag, *repsFlag,
+			sanitizers, *stressIOFlag, diff)
 		if err != nil {
 			return err
 		}
@@ -71,7 +100,8 @@ func doBenchTest(args []string, bench bool) error {
 	return nil
 }
 
-func doBenchTest1(filename string, bench bool, ccompilers string, focus string, mimic bool, reps int) (failed bool, err error) {
+func doBenchTest1(filename string, bench bool, ccompilers string, focus string, gendir string, mimic bool, reps int,
+	sanitizers []string, stressIO bool, diff *differentialOptions) (failed bool, err error) {
 	workDir, err := ioutil.TempDir("", "puffs-c")
 	if err != nil {
 		return false, err
@@ -80,16 +110,36 @@ func doBenchTest1(filename string, bench bool, ccompilers string, focus string,
 
 	in := filename + ".c"
 	out := filepath.Join(workDir, "a.out")
+	if gendir != "" {
+		if in, err = redirectGenIncludes(workDir, in, gendir); err != nil {
+			return false, err
+		}
+	}
 
-	ccArgs := []string(nil)
+	ccArgs := []string{"-Wall", "-Werror"}
 	if bench {
 		ccArgs = append(ccArgs, "-O3")
-	} else {
-		// TODO: set these flags even if we pass -O3.
-		ccArgs = append(ccArgs, "-Wall", "-Werror")
+	}
+	if len(sanitizers) > 0 {
+		// Stop at the first finding, instead of carrying on, so that it fails
+		// the test. Defining PUFFS_TESTLIB_SANITIZE makes testlib.c report
+		// which test was running.
+		ccArgs = append(ccArgs,
+			"-fsanitize="+strings.Join(sanitizers, ","),
+			"-fno-sanitize-recover=all",
+			"-fno-omit-frame-pointer",
+			"-g",
+			"-DPUFFS_TESTLIB_SANITIZE",
+		)
+	}
+	if gendir != "" {
+		// The test program's other #include's are relative to its original
+		// directory, not to the rewritten copy in workDir.
+		ccArgs = append(ccArgs, "-iquote", filepath.Dir(filename))
 	}
 	ccArgs = append(ccArgs, "-std=c99", "-o", out, in)
-	if mimic {
+	// Differential tests compare against the mimic libraries.
+	if mimic || diff != nil {
 		extra, err := findPuffsMimicCflags(in)
 		if err != nil {
 			return false, err
@@ -102,6 +152,9 @@ func doBenchTest1(filename string, bench bool, ccompilers string, focus string,
 		if cc == "" {
 			continue
 		}
+		if err := checkSanitizers(cc, sanitizers); err != nil {
+			return false, err
+		}
 
 		ccCmd := exec.Command(cc, ccArgs...)
 		ccCmd.Stdout = os.Stdout
@@ -117,10 +170,19 @@ func doBenchTest1(filename string, bench bool, ccompilers string, focus string,
 		if focus != "" {
 			outArgs = append(outArgs, fmt.Sprintf("-focus=%s", focus))
 		}
+		if stressIO {
+			outArgs = append(outArgs, "-stress_io")
+		}
+		if diff != nil {
+			outArgs = append(outArgs, diff.args()...)
+		}
 		outCmd := exec.Command(out, outArgs...)
 		outCmd.Stdout = os.Stdout
 		outCmd.Stderr = os.Stderr
 		outCmd.Dir = filepath.Dir(filename)
+		if len(sanitizers) > 0 {
+			outCmd.Env = append(os.Environ(), sanitizerEnv()...)
+		}
 		if err := outCmd.Run(); err == nil {
 			// No-op.
 		} else if _, ok := err.(*exec.ExitError); ok {
@@ -132,6 +194,113 @@ func doBenchTest1(filename string, bench bool, ccompilers string, focus string,
 	return failed, nil
 }
 
+// knownSanitizers maps from the sanitizers that -sanitize accepts to whether
+// they are clang-only.
+var knownSanitizers = map[string]bool{
+	"address":   false,
+	"leak":      false,
+	"memory":    true,
+	"thread":    false,
+	"undefined": false,
+}
+
+func parseSanitizers(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	sanitizers := []string(nil)
+	for _, x := range strings.Split(s, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
+		}
+		if _, ok := knownSanitizers[x]; !ok {
+			return nil, fmt.Errorf("bad -sanitize flag value %q: unknown sanitizer %q", s, x)
+		}
+		sanitizers = append(sanitizers, x)
+	}
+	return sanitizers, nil
+}
+
+func checkSanitizers(cc string, sanitizers []string) error {
+	if strings.Contains(filepath.Base(cc), "clang") {
+		return nil
+	}
+	for _, x := range sanitizers {
+		if knownSanitizers[x] {
+			return fmt.Errorf("the %q sanitizer requires clang, not %q", x, cc)
+		}
+	}
+	return nil
+}
+
+// sanitizerEnv returns the environment variables that make a sanitizer
+// finding abort the test program, so that testlib.c's SIGABRT handler can
+// print which test failed. Any options already in the environment come later,
+// and so take priority.
+func sanitizerEnv() []string {
+	env := []string(nil)
+	for _, x := range [...]struct{ name, opts string }{
+		{"ASAN_OPTIONS", "abort_on_error=1"},
+		{"LSAN_OPTIONS", "abort_on_error=1"},
+		{"MSAN_OPTIONS", "abort_on_error=1"},
+		{"TSAN_OPTIONS", "abort_on_error=1:halt_on_error=1"},
+		{"UBSAN_OPTIONS", "abort_on_error=1:print_stacktrace=1"},
+	} {
+		opts := x.opts
+		if old := os.Getenv(x.name); old != "" {
+			opts += ":" + old
+		}
+		env = append(env, x.name+"="+opts)
+	}
+	return env
+}
+
+// differentialOptions are the test program's arguments for differential
+// testing. The file names are absolute, as the test program runs in its own
+// directory.
+type differentialOptions struct {
+	duration time.Duration
+	findings string
+	seeds    []string
+}
+
+func newDifferentialOptions(duration time.Duration, findings string, seeds string) (*differentialOptions, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("bad -duration flag value %v", duration)
+	}
+	findings, err := filepath.Abs(findings)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(findings, 0755); err != nil {
+		return nil, err
+	}
+	if seeds != "" {
+		if seeds, err = filepath.Abs(seeds); err != nil {
+			return nil, err
+		}
+	}
+	inputs, err := listFuzzInputs(seeds, "")
+	if err != nil {
+		return nil, err
+	}
+	return &differentialOptions{
+		duration: duration,
+		findings: findings,
+		seeds:    inputs,
+	}, nil
+}
+
+func (d *differentialOptions) args() []string {
+	ret := []string{
+		"-differential",
+		fmt.Sprintf("-millis=%d", int64(d.duration/time.Millisecond)),
+		fmt.Sprintf("-findings=%s", d.findings),
+	}
+	return append(ret, d.seeds...)
+}
+
 func findPuffsMimicCflags(filename string) ([]string, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -150,3 +319,29 @@ func findPuffsMimicCflags(filename string) ([]string, error) {
 	}
 	return nil, s.Err()
 }
+
+// redirectGenIncludes copies the test program in to workDir, replacing each
+// #include of generated code, such as "../../../gen/c/std/gif.c", with the
+// equivalent file under gendir. It returns the copy's filename.
+func redirectGenIncludes(workDir string, in string, gendir string) (string, error) {
+	src, err := ioutil.ReadFile(in)
+	if err != nil {
+		return "", err
+	}
+	const prefix, genC = `#include "`, "gen/c/"
+	lines := strings.Split(string(src), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, `"`) {
+			continue
+		}
+		p := line[len(prefix) : len(line)-1]
+		if j := strings.Index(p, genC); j >= 0 && (j == 0 || p[j-1] == '/') {
+			lines[i] = fmt.Sprintf("#include %q", filepath.Join(gendir, filepath.FromSlash(p[j+len(genC):])))
+		}
+	}
+	out := filepath.Join(workDir, filepath.Base(in))
+	if err := ioutil.WriteFile(out, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return "", err
+	}
+	return out, nil
+}
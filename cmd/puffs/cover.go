@@ -0,0 +1,313 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cf "github.com/google/puffs/cmd/commonflags"
+)
+
+func doCover(puffsRoot string, args []string) error {
+	flags := flag.NewFlagSet("cover", flag.ExitOnError)
+	ccompilersFlag := flags.String("ccompilers", cf.CcompilersDefault, cf.CcompilersUsage)
+	focusFlag := flags.String("focus", cf.FocusDefault, cf.FocusUsage)
+	htmlFlag := flags.String("html", "", `the HTML report file to write, if any, e.g. "cover.html"`)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !cf.IsAlphaNumericIsh(*ccompilersFlag) {
+		return fmt.Errorf("bad -ccompilers flag value %q", *ccompilersFlag)
+	}
+	if !cf.IsAlphaNumericIsh(*focusFlag) {
+		return fmt.Errorf("bad -focus flag value %q", *focusFlag)
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{"std/..."}
+	}
+
+	workDir, err := ioutil.TempDir("", "puffs-cover")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	profile := filepath.Join(workDir, "profile")
+
+	cmdArgs := []string{"test"}
+	if *focusFlag != "" {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("-focus=%s", *focusFlag))
+	}
+
+	// Coverage is only implemented for the C code generator. The generated
+	// code appends its counters to the profile when the test program exits.
+	b := btHelper{
+		puffsRoot:  puffsRoot,
+		langs:      []string{"c"},
+		cmdArgs:    cmdArgs,
+		ccompilers: *ccompilersFlag,
+		genRoot:    filepath.Join(workDir, "gen"),
+		env:        []string{"PUFFS_COVERAGE_OUT=" + profile},
+	}
+
+	failed := false
+	for _, arg := range args {
+		recursive := strings.HasSuffix(arg, "/...")
+		if recursive {
+			arg = arg[:len(arg)-4]
+		}
+		if _, err := gen(nil, puffsRoot, b.genRoot, arg, b.langs, []string{"-coverage"}, recursive); err != nil {
+			return err
+		}
+		f, err := b.benchTest(arg, recursive)
+		if err != nil {
+			return err
+		}
+		failed = failed || f
+	}
+
+	c, err := readCoverageProfile(profile)
+	if err != nil {
+		return err
+	}
+	if err := c.writeReport(os.Stdout, puffsRoot, false); err != nil {
+		return err
+	}
+	if *htmlFlag != "" {
+		buf := &bytes.Buffer{}
+		if err := c.writeReport(buf, puffsRoot, true); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*htmlFlag, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("cover wrote:    %s\n", *htmlFlag)
+	}
+
+	if failed {
+		return fmt.Errorf("puffs cover: some tests failed")
+	}
+	return nil
+}
+
+// lineCoverage is the coverage of one line of Puffs source code, summed over
+// every counter for that line and every run of a test program.
+type lineCoverage struct {
+	count     uint64 // The number of statements run.
+	elseCount uint64 // The number of implicit else branches taken.
+	hasStmt   bool   // Whether the line has a statement.
+	hasElse   bool   // Whether the line has an if with an implicit else.
+}
+
+// coverage maps from filenames, such as "std/gif/decode_gif.puffs", to line
+// numbers to that line's coverage. Lines without counters have no entry.
+type coverage map[string]map[uint32]*lineCoverage
+
+// readCoverageProfile reads the "position count" lines, such as
+// "std/gif/decode_gif.puffs:123:stmt 45", that generated code writes. See
+// base-coverage-impl.h in the C code generator.
+func readCoverageProfile(filename string) (coverage, error) {
+	c := coverage{}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		text := s.Text()
+		bad := fmt.Errorf("bad coverage profile line %q", text)
+		i := strings.LastIndexByte(text, ' ')
+		if i < 0 {
+			return nil, bad
+		}
+		count, err := strconv.ParseUint(text[i+1:], 10, 64)
+		if err != nil {
+			return nil, bad
+		}
+		position := strings.Split(text[:i], ":")
+		if len(position) == 5 && position[2] == "resume" {
+			// Resumed suspension points are reported by "puffs test
+			// -stress_io". See readResumeProfile.
+			continue
+		}
+		if len(position) != 3 {
+			return nil, bad
+		}
+		line, err := strconv.ParseUint(position[1], 10, 32)
+		if err != nil {
+			return nil, bad
+		}
+
+		lines := c[position[0]]
+		if lines == nil {
+			lines = map[uint32]*lineCoverage{}
+			c[position[0]] = lines
+		}
+		l := lines[uint32(line)]
+		if l == nil {
+			l = &lineCoverage{}
+			lines[uint32(line)] = l
+		}
+		switch position[2] {
+		case "else":
+			l.elseCount += count
+			l.hasElse = true
+		case "stmt":
+			l.count += count
+			l.hasStmt = true
+		default:
+			return nil, bad
+		}
+	}
+	return c, s.Err()
+}
+
+const coverageLegend = "" +
+	"Each line of Puffs source code is prefixed by how many statements on that line\n" +
+	"ran, or \"-\" if it has none. \"#####\" means that none ran. A \"!\" suffix means\n" +
+	"that an if statement's implicit else branch was never taken.\n"
+
+// writeReport writes a summary and then the annotated source code of each
+// file, either as plain text or as HTML.
+func (c coverage) writeReport(w io.Writer, puffsRoot string, asHTML bool) error {
+	filenames := []string(nil)
+	for filename := range c {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	buf := &bytes.Buffer{}
+	if asHTML {
+		buf.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\">\n" +
+			"<title>Puffs Coverage</title>\n<style>\n" +
+			".hit { background-color: #dfd; }\n" +
+			".miss { background-color: #fdd; }\n" +
+			".else { background-color: #ffd; }\n" +
+			"</style></head><body>\n<pre>\n")
+		buf.WriteString(html.EscapeString(coverageLegend))
+		buf.WriteString("</pre>\n")
+	}
+
+	// Write the summary.
+	if asHTML {
+		buf.WriteString("<ul>\n")
+	}
+	for _, filename := range filenames {
+		summary := c.summary(filename)
+		if asHTML {
+			fmt.Fprintf(buf, "<li><a href=\"#%s\">%s</a></li>\n",
+				html.EscapeString(filename), html.EscapeString(summary))
+		} else {
+			fmt.Fprintf(buf, "%s\n", summary)
+		}
+	}
+	if asHTML {
+		buf.WriteString("</ul>\n")
+	} else {
+		buf.WriteString("\n")
+		buf.WriteString(coverageLegend)
+	}
+
+	// Write the annotated source code.
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filepath.Join(puffsRoot, filepath.FromSlash(filename)))
+		if err != nil {
+			return err
+		}
+		if asHTML {
+			fmt.Fprintf(buf, "<h2 id=\"%s\">%s</h2>\n<pre>\n",
+				html.EscapeString(filename), html.EscapeString(filename))
+		} else {
+			fmt.Fprintf(buf, "\n%s:\n", filename)
+		}
+		lines := c[filename]
+		for i, text := range strings.Split(strings.TrimSuffix(string(src), "\n"), "\n") {
+			annotation, class := "-", ""
+			if l := lines[uint32(i+1)]; l != nil {
+				if !l.hasStmt {
+					// No-op.
+				} else if l.count == 0 {
+					annotation, class = "#####", "miss"
+				} else {
+					annotation, class = strconv.FormatUint(l.count, 10), "hit"
+				}
+				if l.hasElse && l.elseCount == 0 {
+					annotation, class = annotation+"!", "else"
+				}
+			}
+			text = fmt.Sprintf("%10s:%5d:%s", annotation, i+1, text)
+			if !asHTML {
+				fmt.Fprintf(buf, "%s\n", text)
+			} else if class == "" {
+				fmt.Fprintf(buf, "%s\n", html.EscapeString(text))
+			} else {
+				fmt.Fprintf(buf, "<span class=\"%s\">%s</span>\n", class, html.EscapeString(text))
+			}
+		}
+		if asHTML {
+			buf.WriteString("</pre>\n")
+		}
+	}
+
+	if asHTML {
+		buf.WriteString("</body></html>\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// summary returns a one line summary of the coverage of a file.
+func (c coverage) summary(filename string) string {
+	lines := c[filename]
+	nStmt, nHit, nElse, nElseMissed := 0, 0, 0, 0
+	for _, l := range lines {
+		if l.hasStmt {
+			nStmt++
+			if l.count > 0 {
+				nHit++
+			}
+		}
+		if l.hasElse {
+			nElse++
+			if l.elseCount == 0 {
+				nElseMissed++
+			}
+		}
+	}
+	percent := 100.0
+	if nStmt > 0 {
+		percent = 100 * float64(nHit) / float64(nStmt)
+	}
+	return fmt.Sprintf("%s: %5.1f%% of %d lines with statements, %d of %d implicit else branches never taken",
+		filename, percent, nStmt, nElseMissed, nElse)
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	cf "github.com/google/puffs/cmd/commonflags"
+)
+
+func doFuzz(puffsRoot string, args []string) error {
+	flags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+	ccFlag := flags.String("cc", "clang", `the C compiler, which must be clang unless -replay is set`)
+	durationFlag := flags.Duration("duration", cf.FuzzDurationDefault, cf.FuzzDurationUsage)
+	findingsFlag := flags.String("findings", cf.FuzzFindingsDefault, cf.FuzzFindingsUsage)
+	replayFlag := flags.Bool("replay", false, `whether to only run each fuzz target once on the seeds and findings, `+
+		`instead of fuzzing, which works with any C compiler`)
+	seedsFlag := flags.String("seeds", filepath.Join(puffsRoot, "test", "testdata"),
+		`the directory of inputs to start fuzzing from`)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !cf.IsAlphaNumericIsh(*ccFlag) {
+		return fmt.Errorf("bad -cc flag value %q", *ccFlag)
+	}
+	if !*replayFlag && !strings.Contains(filepath.Base(*ccFlag), "clang") {
+		return fmt.Errorf("libFuzzer requires clang, not %q; use -replay to only replay inputs", *ccFlag)
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{"std/..."}
+	}
+
+	// Fuzz targets are only implemented for the C code generator. They are
+	// generated, alongside the code being fuzzed, into a temporary directory.
+	genRoot, err := ioutil.TempDir("", "puffs-fuzz")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(genRoot)
+	genArgs := []string{"-fuzz_targets", "-line_directives"}
+
+	affected := []string(nil)
+	for _, arg := range args {
+		recursive := strings.HasSuffix(arg, "/...")
+		if recursive {
+			arg = arg[:len(arg)-4]
+		}
+		affected, err = gen(affected, puffsRoot, genRoot, arg, []string{"c"}, genArgs, recursive)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmdArgs := []string{"fuzz",
+		fmt.Sprintf("-cc=%s", *ccFlag),
+		fmt.Sprintf("-duration=%v", *durationFlag),
+		fmt.Sprintf("-findings=%s", *findingsFlag),
+		fmt.Sprintf("-seeds=%s", *seedsFlag),
+	}
+	if *replayFlag {
+		cmdArgs = append(cmdArgs, "-replay")
+	}
+	for _, dirname := range affected {
+		cmdArgs = append(cmdArgs, filepath.Join(genRoot, "c", filepath.FromSlash(dirname)+".c"))
+	}
+
+	cmd := exec.Command("puffs-c", cmdArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err == nil {
+		// No-op.
+	} else if _, ok := err.(*exec.ExitError); ok {
+		return fmt.Errorf("puffs fuzz: some fuzz targets failed")
+	} else {
+		return err
+	}
+	return nil
+}
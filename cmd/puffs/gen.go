@@ -24,6 +24,8 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/google/puffs/lang/generate"
 )
 
 func doGen(puffsRoot string, args []string) error    { return doGenGenlib(puffsRoot, args, false) }
@@ -32,6 +34,10 @@ func doGenlib(puffsRoot string, args []string) error { return doGenGenlib(puffsR
 func doGenGenlib(puffsRoot string, args []string, genlib bool) error {
 	flags := flag.NewFlagSet("gen", flag.ExitOnError)
 	langsFlag := flags.String("langs", langsDefault, langsUsage)
+	requireTerminationFlag := flags.Bool("require_termination", generate.RequireTerminationDefault,
+		generate.RequireTerminationUsage)
+	debugChecksFlag := flags.Bool("debug_checks", generate.DebugChecksDefault, generate.DebugChecksUsage)
+	lineDirectivesFlag := flags.Bool("line_directives", generate.LineDirectivesDefault, generate.LineDirectivesUsage)
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -39,6 +45,16 @@ func doGenGenlib(puffsRoot string, args []string, genlib bool) error {
 	if err != nil {
 		return err
 	}
+	genArgs := []string(nil)
+	if *requireTerminationFlag {
+		genArgs = append(genArgs, "-require_termination")
+	}
+	if *debugChecksFlag {
+		genArgs = append(genArgs, "-debug_checks")
+	}
+	if *lineDirectivesFlag {
+		genArgs = append(genArgs, "-line_directives")
+	}
 	args = flags.Args()
 	if len(args) == 0 {
 		args = []string{"std/..."}
@@ -51,7 +67,7 @@ func doGenGenlib(puffsRoot string, args []string, genlib bool) error {
 			arg = arg[:len(arg)-4]
 		}
 		var err error
-		affected, err = gen(affected, puffsRoot, arg, langs, recursive)
+		affected, err = gen(affected, puffsRoot, filepath.Join(puffsRoot, "gen"), arg, langs, genArgs, recursive)
 		if err != nil {
 			return err
 		}
@@ -65,13 +81,16 @@ func doGenGenlib(puffsRoot string, args []string, genlib bool) error {
 	return nil
 }
 
-func gen(affected []string, puffsRoot, dirname string, langs []string, recursive bool) (retAffected []string, retErr error) {
+// gen generates code for the packages under dirname, writing it under genRoot,
+// which is typically puffsRoot/gen. genArgs are additional arguments, such as
+// "-require_termination", for each "puffs-foo gen" command.
+func gen(affected []string, puffsRoot, genRoot, dirname string, langs []string, genArgs []string, recursive bool) (retAffected []string, retErr error) {
 	filenames, dirnames, err := listDir(puffsRoot, dirname, recursive)
 	if err != nil {
 		return nil, err
 	}
 	if len(filenames) > 0 {
-		if err := genDir(puffsRoot, dirname, filenames, langs); err != nil {
+		if err := genDir(puffsRoot, genRoot, dirname, filenames, langs, genArgs); err != nil {
 			return nil, err
 		}
 		affected = append(affected, dirname)
@@ -79,7 +98,7 @@ func gen(affected []string, puffsRoot, dirname string, langs []string, recursive
 	if len(dirnames) > 0 {
 		for _, d := range dirnames {
 			var err error
-			affected, err = gen(affected, puffsRoot, dirname+"/"+d, langs, recursive)
+			affected, err = gen(affected, puffsRoot, genRoot, dirname+"/"+d, langs, genArgs, recursive)
 			if err != nil {
 				return nil, err
 			}
@@ -88,7 +107,7 @@ func gen(affected []string, puffsRoot, dirname string, langs []string, recursive
 	return affected, nil
 }
 
-func genDir(puffsRoot string, dirname string, filenames []string, langs []string) error {
+func genDir(puffsRoot string, genRoot string, dirname string, filenames []string, langs []string, genArgs []string) error {
 	// TODO: skip the generation if the output file already exists and its
 	// mtime is newer than all inputs and the puffs-gen-foo command.
 
@@ -96,16 +115,21 @@ func genDir(puffsRoot string, dirname string, filenames []string, langs []string
 	if !validName(packageName) {
 		return fmt.Errorf(`invalid package %q, not in [a-z0-9]+`, packageName)
 	}
-	cmdArgs := []string{"gen", "-package_name", packageName}
+	cmdArgs := []string{"gen"}
+	cmdArgs = append(cmdArgs, genArgs...)
+	cmdArgs = append(cmdArgs, "-package_name", packageName)
+	// The filenames are relative to puffsRoot, such as
+	// "std/gif/decode_gif.puffs", as they can end up in the generated code's
+	// #line directives.
 	for _, filename := range filenames {
-		cmdArgs = append(cmdArgs,
-			filepath.Join(puffsRoot, filepath.FromSlash(dirname), filename))
+		cmdArgs = append(cmdArgs, filepath.Join(filepath.FromSlash(dirname), filename))
 	}
 
 	for _, lang := range langs {
 		command := "puffs-" + lang
 		stdout := &bytes.Buffer{}
 		cmd := exec.Command(command, cmdArgs...)
+		cmd.Dir = puffsRoot
 		cmd.Stdin = nil
 		cmd.Stdout = stdout
 		cmd.Stderr = os.Stderr
@@ -117,7 +141,7 @@ func genDir(puffsRoot string, dirname string, filenames []string, langs []string
 			return err
 		}
 		out := stdout.Bytes()
-		if err := genFile(puffsRoot, dirname, lang, out); err != nil {
+		if err := genFile(genRoot, dirname, lang, out); err != nil {
 			return err
 		}
 
@@ -130,7 +154,7 @@ func genDir(puffsRoot string, dirname string, filenames []string, langs []string
 		} else {
 			out = out[:i]
 		}
-		if err := genFile(puffsRoot, dirname, "h", out); err != nil {
+		if err := genFile(genRoot, dirname, "h", out); err != nil {
 			return err
 		}
 	}
@@ -139,8 +163,8 @@ func genDir(puffsRoot string, dirname string, filenames []string, langs []string
 
 var cHeaderEndsHere = []byte("\n// C HEADER ENDS HERE.\n\n")
 
-func genFile(puffsRoot string, dirname string, lang string, out []byte) error {
-	outFilename := filepath.Join(puffsRoot, "gen", lang, filepath.FromSlash(dirname)+"."+lang)
+func genFile(genRoot string, dirname string, lang string, out []byte) error {
+	outFilename := filepath.Join(genRoot, lang, filepath.FromSlash(dirname)+"."+lang)
 	if existing, err := ioutil.ReadFile(outFilename); err == nil && bytes.Equal(existing, out) {
 		fmt.Println("gen unchanged: ", outFilename)
 		return nil
@@ -31,8 +31,11 @@ var commands = []struct {
 	do   func(puffsRoot string, args []string) error
 }{
 	{"bench", doBench},
+	{"cover", doCover},
+	{"fuzz", doFuzz},
 	{"gen", doGen},
 	{"genlib", doGenlib},
+	{"sizes", doSizes},
 	{"test", doTest},
 }
 
@@ -46,8 +49,11 @@ Usage:
 The commands are:
 
 	bench   benchmark packages
+	cover   report test coverage of packages
+	fuzz    fuzz packages' decoders
 	gen     generate code for packages and dependencies
 	genlib  generate software libraries
+	sizes   report struct and binary sizes of packages
 	test    test packages
 `)
 }
@@ -123,6 +129,9 @@ const (
 
 	skipgenDefault = false
 	skipgenUsage   = `whether to skip automatically generating code when testing`
+
+	testLineDirectivesDefault = true
+	testLineDirectivesUsage   = `whether to test code generated with #line directives, unless skipping generating code`
 )
 
 func parseLangs(commaSeparated string) ([]string, error) {
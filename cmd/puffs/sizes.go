@@ -0,0 +1,101 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	cf "github.com/google/puffs/cmd/commonflags"
+)
+
+func doSizes(puffsRoot string, args []string) error {
+	flags := flag.NewFlagSet("sizes", flag.ExitOnError)
+	baselineFlag := flags.String("baseline", "", `the JSON report, if any, to compare against, `+
+		`failing if any size regressed, e.g. one written by -json`)
+	ccompilersFlag := flags.String("ccompilers", cf.CcompilersDefault, cf.CcompilersUsage)
+	jsonFlag := flags.String("json", "", `the JSON report file to write, if any, e.g. "sizes.json"`)
+	optsFlag := flags.String("opts", "-O2,-Os", `comma-separated list of C compiler optimization flags`)
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !cf.IsAlphaNumericIsh(*ccompilersFlag) {
+		return fmt.Errorf("bad -ccompilers flag value %q", *ccompilersFlag)
+	}
+	if !cf.IsAlphaNumericIsh(*optsFlag) {
+		return fmt.Errorf("bad -opts flag value %q", *optsFlag)
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		args = []string{"std/..."}
+	}
+
+	// Size probes are only implemented for the C code generator. They are
+	// generated, alongside the code being measured, into a temporary
+	// directory.
+	genRoot, err := ioutil.TempDir("", "puffs-sizes")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(genRoot)
+	genArgs := []string{"-size_probes"}
+
+	affected := []string(nil)
+	for _, arg := range args {
+		recursive := strings.HasSuffix(arg, "/...")
+		if recursive {
+			arg = arg[:len(arg)-4]
+		}
+		affected, err = gen(affected, puffsRoot, genRoot, arg, []string{"c"}, genArgs, recursive)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmdArgs := []string{"sizes",
+		fmt.Sprintf("-ccompilers=%s", *ccompilersFlag),
+		fmt.Sprintf("-gendir=%s", filepath.Join(genRoot, "c")),
+		fmt.Sprintf("-opts=%s", *optsFlag),
+	}
+	if *baselineFlag != "" {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("-baseline=%s", *baselineFlag))
+	}
+	if *jsonFlag != "" {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("-json=%s", *jsonFlag))
+	}
+	for _, dirname := range affected {
+		cmdArgs = append(cmdArgs, filepath.Join(genRoot, "c", filepath.FromSlash(dirname)+".c"))
+	}
+
+	cmd := exec.Command("puffs-c", cmdArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err == nil {
+		// No-op.
+	} else if _, ok := err.(*exec.ExitError); ok {
+		return fmt.Errorf("puffs sizes: some sizes regressed, or could not be measured")
+	} else {
+		return err
+	}
+	return nil
+}
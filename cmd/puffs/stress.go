@@ -0,0 +1,132 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// resumeFunc is a coroutine, such as "decoder.decode" in
+// "std/gif/decode_gif.puffs".
+type resumeFunc struct {
+	filename string
+	name     string
+}
+
+// resumePoint is one of a coroutine's suspension points, numbered from 1.
+type resumePoint struct {
+	number uint32
+	line   uint32
+	count  uint64 // The number of times it was resumed.
+}
+
+// resumeCoverage maps from coroutines to their suspension points, summed over
+// every run of a test program.
+type resumeCoverage map[resumeFunc]map[uint32]*resumePoint
+
+// readResumeProfile reads the "position count" lines, such as
+// "std/gif/decode_gif.puffs:123:resume:decoder.decode:4 56", of a coverage
+// profile. Other lines are for "puffs cover", and are ignored.
+func readResumeProfile(filename string) (resumeCoverage, error) {
+	r := resumeCoverage{}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		text := s.Text()
+		bad := fmt.Errorf("bad coverage profile line %q", text)
+		i := strings.LastIndexByte(text, ' ')
+		if i < 0 {
+			return nil, bad
+		}
+		position := strings.Split(text[:i], ":")
+		if len(position) != 5 || position[2] != "resume" {
+			continue
+		}
+		count, err := strconv.ParseUint(text[i+1:], 10, 64)
+		if err != nil {
+			return nil, bad
+		}
+		line, err := strconv.ParseUint(position[1], 10, 32)
+		if err != nil {
+			return nil, bad
+		}
+		number, err := strconv.ParseUint(position[4], 10, 32)
+		if err != nil {
+			return nil, bad
+		}
+
+		k := resumeFunc{filename: position[0], name: position[3]}
+		points := r[k]
+		if points == nil {
+			points = map[uint32]*resumePoint{}
+			r[k] = points
+		}
+		p := points[uint32(number)]
+		if p == nil {
+			p = &resumePoint{number: uint32(number), line: uint32(line)}
+			points[uint32(number)] = p
+		}
+		p.count += count
+	}
+	return r, s.Err()
+}
+
+// writeReport writes, for each coroutine, how many of its suspension points
+// were resumed, and where the others are.
+func (r resumeCoverage) writeReport(w io.Writer) {
+	funcs := make([]resumeFunc, 0, len(r))
+	for k := range r {
+		funcs = append(funcs, k)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].filename != funcs[j].filename {
+			return funcs[i].filename < funcs[j].filename
+		}
+		return funcs[i].name < funcs[j].name
+	})
+
+	for _, k := range funcs {
+		points := make([]*resumePoint, 0, len(r[k]))
+		for _, p := range r[k] {
+			points = append(points, p)
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].number < points[j].number })
+
+		never := []string(nil)
+		for _, p := range points {
+			if p.count == 0 {
+				never = append(never, fmt.Sprintf("#%d (line %d)", p.number, p.line))
+			}
+		}
+		fmt.Fprintf(w, "stress_io: %s %s: %d of %d suspension points resumed\n",
+			k.filename, k.name, len(points)-len(never), len(points))
+		if len(never) > 0 {
+			fmt.Fprintf(w, "    never resumed: %s\n", strings.Join(never, ", "))
+		}
+	}
+}
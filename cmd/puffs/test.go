@@ -17,11 +17,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/google/puffs/lang/generate"
+
 	cf "github.com/google/puffs/cmd/commonflags"
 )
 
@@ -31,11 +34,21 @@ func doTest(puffsRoot string, args []string) error  { return doBenchTest(puffsRo
 func doBenchTest(puffsRoot string, args []string, bench bool) error {
 	flags := flag.NewFlagSet("test", flag.ExitOnError)
 	ccompilersFlag := flags.String("ccompilers", cf.CcompilersDefault, cf.CcompilersUsage)
+	debugChecksFlag := flags.Bool("debug_checks", generate.DebugChecksDefault, generate.DebugChecksUsage)
+	differentialFlag := flags.Bool("differential", false, `whether to run differential tests, comparing the Puffs `+
+		`decoders with the mimic libraries on random and mutated inputs, instead of the regular tests`)
+	durationFlag := flags.Duration("duration", cf.FuzzDurationDefault, `how long to run each differential test for`)
+	findingsFlag := flags.String("findings", cf.FuzzFindingsDefault, cf.FuzzFindingsUsage)
 	focusFlag := flags.String("focus", cf.FocusDefault, cf.FocusUsage)
 	langsFlag := flags.String("langs", langsDefault, langsUsage)
+	lineDirectivesFlag := flags.Bool("line_directives", testLineDirectivesDefault, testLineDirectivesUsage)
 	mimicFlag := flags.Bool("mimic", cf.MimicDefault, cf.MimicUsage)
 	repsFlag := flags.Int("reps", cf.RepsDefault, cf.RepsUsage)
+	sanitizeFlag := flags.String("sanitize", cf.SanitizeDefault, cf.SanitizeUsage)
+	seedsFlag := flags.String("seeds", filepath.Join(puffsRoot, "test", "testdata"),
+		`the directory of inputs to start differential testing from`)
 	skipgenFlag := flags.Bool("skipgen", skipgenDefault, skipgenUsage)
+	stressIOFlag := flags.Bool("stress_io", cf.StressIODefault, cf.StressIOUsage)
 
 	if err := flags.Parse(args); err != nil {
 		return err
@@ -54,6 +67,21 @@ func doBenchTest(puffsRoot string, args []string, bench bool) error {
 	if *repsFlag < cf.RepsMin || cf.RepsMax < *repsFlag {
 		return fmt.Errorf("bad -reps flag value %d, outside the range [%d..%d]", *repsFlag, cf.RepsMin, cf.RepsMax)
 	}
+	if !cf.IsAlphaNumericIsh(*sanitizeFlag) {
+		return fmt.Errorf("bad -sanitize flag value %q", *sanitizeFlag)
+	}
+	if bench && *differentialFlag {
+		return fmt.Errorf("-differential is not supported when benchmarking")
+	}
+	if bench && *stressIOFlag {
+		return fmt.Errorf("-stress_io is not supported when benchmarking")
+	}
+	if *debugChecksFlag && *skipgenFlag {
+		return fmt.Errorf("the -debug_checks and -skipgen flags are incompatible")
+	}
+	if *stressIOFlag && *skipgenFlag {
+		return fmt.Errorf("the -stress_io and -skipgen flags are incompatible")
+	}
 
 	args = flags.Args()
 	if len(args) == 0 {
@@ -72,6 +100,19 @@ func doBenchTest(puffsRoot string, args []string, bench bool) error {
 	if *mimicFlag {
 		cmdArgs = append(cmdArgs, "-mimic")
 	}
+	if *differentialFlag {
+		cmdArgs = append(cmdArgs, "-differential",
+			fmt.Sprintf("-duration=%v", *durationFlag),
+			fmt.Sprintf("-findings=%s", *findingsFlag),
+			fmt.Sprintf("-seeds=%s", *seedsFlag),
+		)
+	}
+	if *sanitizeFlag != "" {
+		cmdArgs = append(cmdArgs, fmt.Sprintf("-sanitize=%s", *sanitizeFlag))
+	}
+	if *stressIOFlag {
+		cmdArgs = append(cmdArgs, "-stress_io")
+	}
 
 	b := btHelper{
 		puffsRoot:  puffsRoot,
@@ -80,6 +121,35 @@ func doBenchTest(puffsRoot string, args []string, bench bool) error {
 		ccompilers: *ccompilersFlag,
 	}
 
+	// Test (or bench) code generated with test-only options, such as
+	// "-debug_checks", into a temporary directory, leaving the checked-in
+	// generated code alone.
+	testGenArgs := []string(nil)
+	if *debugChecksFlag {
+		testGenArgs = append(testGenArgs, "-debug_checks")
+	}
+	if *lineDirectivesFlag {
+		testGenArgs = append(testGenArgs, "-line_directives")
+	}
+	if *stressIOFlag {
+		// Coverage counters include which coroutine suspension points were
+		// resumed, for the report below.
+		testGenArgs = append(testGenArgs, "-coverage")
+	}
+	if !*skipgenFlag && len(testGenArgs) > 0 {
+		genRoot, err := ioutil.TempDir("", "puffs-gen")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(genRoot)
+		b.genRoot = genRoot
+	}
+	profile := ""
+	if *stressIOFlag {
+		profile = filepath.Join(b.genRoot, "profile")
+		b.env = append(b.env, "PUFFS_COVERAGE_OUT="+profile)
+	}
+
 	failed := false
 	for _, arg := range args {
 		recursive := strings.HasSuffix(arg, "/...")
@@ -89,7 +159,12 @@ func doBenchTest(puffsRoot string, args []string, bench bool) error {
 
 		// Ensure that we are testing the latest version of the generated code.
 		if !*skipgenFlag {
-			if _, err := gen(nil, puffsRoot, arg, langs, recursive); err != nil {
+			if _, err := gen(nil, puffsRoot, filepath.Join(puffsRoot, "gen"), arg, langs, nil, recursive); err != nil {
+				return err
+			}
+		}
+		if b.genRoot != "" {
+			if _, err := gen(nil, puffsRoot, b.genRoot, arg, langs, testGenArgs, recursive); err != nil {
 				return err
 			}
 		}
@@ -101,6 +176,13 @@ func doBenchTest(puffsRoot string, args []string, bench bool) error {
 		}
 		failed = failed || f
 	}
+	if profile != "" {
+		r, err := readResumeProfile(profile)
+		if err != nil {
+			return err
+		}
+		r.writeReport(os.Stdout)
+	}
 	if failed {
 		s0, s1 := "test", "tests"
 		if bench {
@@ -116,6 +198,8 @@ type btHelper struct {
 	langs      []string
 	cmdArgs    []string
 	ccompilers string
+	genRoot    string   // If non-empty, the generated code to use instead of puffsRoot/gen.
+	env        []string // Additional environment variables, such as "FOO=bar".
 }
 
 func (b *btHelper) benchTest(dirname string, recursive bool) (failed bool, err error) {
@@ -154,8 +238,14 @@ func (b *btHelper) benchTestDir(dirname string) (failed bool, err error) {
 		if lang == "c" {
 			args = append(args, fmt.Sprintf("-ccompilers=%s", b.ccompilers))
 		}
+		if b.genRoot != "" {
+			args = append(args, fmt.Sprintf("-gendir=%s", filepath.Join(b.genRoot, lang)))
+		}
 		args = append(args, filepath.Join(b.puffsRoot, "test", lang, filepath.FromSlash(dirname)))
 		cmd := exec.Command(command, args...)
+		if len(b.env) > 0 {
+			cmd.Env = append(os.Environ(), b.env...)
+		}
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err == nil {
@@ -56,6 +56,24 @@ typedef struct {
   size_t len;
 } puffs_base__slice_u8;
 
+// puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64 are
+// like puffs_base__slice_u8, but for wider elements. Their len fields count
+// elements, not bytes.
+typedef struct {
+  uint16_t* ptr;
+  size_t len;
+} puffs_base__slice_u16;
+
+typedef struct {
+  uint32_t* ptr;
+  size_t len;
+} puffs_base__slice_u32;
+
+typedef struct {
+  uint64_t* ptr;
+  size_t len;
+} puffs_base__slice_u64;
+
 // puffs_base__buf1 is a 1-dimensional buffer (a pointer and length), plus
 // additional indexes into that buffer, plus an opened / closed flag.
 //
@@ -105,6 +123,43 @@ typedef struct {
   } private_impl;
 } puffs_base__writer1;
 
+// puffs_base__pixel_format describes how a pixel's bytes are laid out in
+// memory. The high 24 bits identify the format. The low 8 bits hold the number
+// of bytes per pixel.
+typedef uint32_t puffs_base__pixel_format;
+
+#define PUFFS_BASE__PIXEL_FORMAT__INVALID 0x00000000
+#define PUFFS_BASE__PIXEL_FORMAT__INDEXED_8 0x01000001
+#define PUFFS_BASE__PIXEL_FORMAT__GRAY_8 0x02000001
+#define PUFFS_BASE__PIXEL_FORMAT__RGB_888 0x03000003
+#define PUFFS_BASE__PIXEL_FORMAT__BGRA_8888 0x04000004
+#define PUFFS_BASE__PIXEL_FORMAT__RGBA_8888 0x05000004
+
+// puffs_base__buf2 is a 2-dimensional buffer of bytes, such as pixel data. Row
+// y starts at ptr + (y * stride) and holds width bytes.
+//
+// A value with all fields NULL or zero is a valid, empty buffer.
+typedef struct {
+  uint8_t* ptr;                     // Pointer.
+  size_t width;                     // Bytes per row.
+  size_t height;                    // Number of rows.
+  size_t stride;                    // Invariant: width <= stride.
+  puffs_base__pixel_format pixfmt;  // Pixel format.
+} puffs_base__buf2;
+
+// puffs_base__bitreader yields the bits of a puffs_base__reader1's bytes, in
+// either Least or Most Significant Bits first order. It buffers up to 63 bits.
+// In LSB-first mode, the next bit is bit 0 of bits, and bits at or above n_bits
+// are zero. In MSB-first mode, the next bit is bit (n_bits - 1), and bits at or
+// above n_bits are unspecified.
+//
+// A value with all fields zero is a valid, empty, LSB-first bitreader.
+typedef struct {
+  uint64_t bits;
+  uint32_t n_bits;
+  bool msb_first;
+} puffs_base__bitreader;
+
 #endif  // PUFFS_BASE_HEADER_H
 
 #ifdef __cplusplus
@@ -179,6 +234,10 @@ const char* puffs_flate__status__string(puffs_flate__status s);
 
 // ---------------- Public Consts
 
+// ---------------- Public Enums
+
+// ---------------- Unions
+
 // ---------------- Structs
 
 typedef struct {
@@ -205,23 +264,18 @@ typedef struct {
     struct {
       uint32_t coro_susp_point;
       puffs_flate__status v_z;
-      uint64_t v_n_copied;
-      uint32_t v_already_full;
     } c_decode[1];
     struct {
       uint32_t coro_susp_point;
       uint32_t v_final;
-      uint32_t v_type;
     } c_decode_blocks[1];
     struct {
       uint32_t coro_susp_point;
       uint32_t v_length;
-      uint32_t v_n_copied;
       uint64_t scratch;
     } c_decode_uncompressed[1];
     struct {
       uint32_t coro_susp_point;
-      uint32_t v_i;
     } c_init_fixed_huffman[1];
     struct {
       uint32_t coro_susp_point;
@@ -233,7 +287,6 @@ typedef struct {
       uint32_t v_i;
       uint32_t v_mask;
       uint32_t v_table_entry;
-      uint32_t v_table_entry_n_bits;
       uint32_t v_n_extra_bits;
       uint8_t v_rep_symbol;
       uint32_t v_rep_count;
@@ -250,7 +303,6 @@ typedef struct {
       uint32_t v_redir_mask;
       uint32_t v_length;
       uint32_t v_distance;
-      uint32_t v_n_copied;
       uint32_t v_hlen;
       uint32_t v_hdist;
     } c_decode_huffman_slow[1];
@@ -291,9 +343,7 @@ typedef struct {
 
     struct {
       uint32_t coro_susp_point;
-      uint16_t v_x;
       uint32_t v_checksum;
-      puffs_flate__status v_z;
       uint64_t scratch;
     } c_decode[1];
   } private_impl;
@@ -436,6 +486,16 @@ static inline uint16_t puffs_base__load_u16le(uint8_t* p) {
   return ((uint16_t)(p[0]) << 0) | ((uint16_t)(p[1]) << 8);
 }
 
+static inline uint32_t puffs_base__load_u24be(uint8_t* p) {
+  return ((uint32_t)(p[0]) << 16) | ((uint32_t)(p[1]) << 8) |
+         ((uint32_t)(p[2]) << 0);
+}
+
+static inline uint32_t puffs_base__load_u24le(uint8_t* p) {
+  return ((uint32_t)(p[0]) << 0) | ((uint32_t)(p[1]) << 8) |
+         ((uint32_t)(p[2]) << 16);
+}
+
 static inline uint32_t puffs_base__load_u32be(uint8_t* p) {
   return ((uint32_t)(p[0]) << 24) | ((uint32_t)(p[1]) << 16) |
          ((uint32_t)(p[2]) << 8) | ((uint32_t)(p[3]) << 0);
@@ -446,6 +506,291 @@ static inline uint32_t puffs_base__load_u32le(uint8_t* p) {
          ((uint32_t)(p[2]) << 16) | ((uint32_t)(p[3]) << 24);
 }
 
+static inline void puffs_base__store_u16be(uint8_t* p, uint16_t x) {
+  p[0] = (uint8_t)(x >> 8);
+  p[1] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u16le(uint8_t* p, uint16_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+}
+
+static inline void puffs_base__store_u24be(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 16);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u24le(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 16);
+}
+
+static inline void puffs_base__store_u32be(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 24);
+  p[1] = (uint8_t)(x >> 16);
+  p[2] = (uint8_t)(x >> 8);
+  p[3] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u32le(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 16);
+  p[3] = (uint8_t)(x >> 24);
+}
+
+// Saturating arithmetic. The "~sat+" and "~sat-" Puffs operators call these
+// functions. The "~+", "~-", etc. wrapping operators don't need helpers.
+
+static inline uint8_t puffs_base__u8__sat_add(uint8_t x, uint8_t y) {
+  uint8_t res = (uint8_t)(x + y);
+  return (res < x) ? UINT8_MAX : res;
+}
+
+static inline uint8_t puffs_base__u8__sat_sub(uint8_t x, uint8_t y) {
+  return (x > y) ? (uint8_t)(x - y) : 0;
+}
+
+static inline uint16_t puffs_base__u16__sat_add(uint16_t x, uint16_t y) {
+  uint16_t res = (uint16_t)(x + y);
+  return (res < x) ? UINT16_MAX : res;
+}
+
+static inline uint16_t puffs_base__u16__sat_sub(uint16_t x, uint16_t y) {
+  return (x > y) ? (uint16_t)(x - y) : 0;
+}
+
+static inline uint32_t puffs_base__u32__sat_add(uint32_t x, uint32_t y) {
+  uint32_t res = (uint32_t)(x + y);
+  return (res < x) ? UINT32_MAX : res;
+}
+
+static inline uint32_t puffs_base__u32__sat_sub(uint32_t x, uint32_t y) {
+  return (x > y) ? (uint32_t)(x - y) : 0;
+}
+
+static inline uint64_t puffs_base__u64__sat_add(uint64_t x, uint64_t y) {
+  uint64_t res = (uint64_t)(x + y);
+  return (res < x) ? UINT64_MAX : res;
+}
+
+static inline uint64_t puffs_base__u64__sat_sub(uint64_t x, uint64_t y) {
+  return (x > y) ? (uint64_t)(x - y) : 0;
+}
+
+static inline int8_t puffs_base__i8__sat_add(int8_t x, int8_t y) {
+  if ((y > 0) && (x > (INT8_MAX - y))) {
+    return INT8_MAX;
+  } else if ((y < 0) && (x < (INT8_MIN - y))) {
+    return INT8_MIN;
+  }
+  return (int8_t)(x + y);
+}
+
+static inline int8_t puffs_base__i8__sat_sub(int8_t x, int8_t y) {
+  if ((y < 0) && (x > (INT8_MAX + y))) {
+    return INT8_MAX;
+  } else if ((y > 0) && (x < (INT8_MIN + y))) {
+    return INT8_MIN;
+  }
+  return (int8_t)(x - y);
+}
+
+static inline int16_t puffs_base__i16__sat_add(int16_t x, int16_t y) {
+  if ((y > 0) && (x > (INT16_MAX - y))) {
+    return INT16_MAX;
+  } else if ((y < 0) && (x < (INT16_MIN - y))) {
+    return INT16_MIN;
+  }
+  return (int16_t)(x + y);
+}
+
+static inline int16_t puffs_base__i16__sat_sub(int16_t x, int16_t y) {
+  if ((y < 0) && (x > (INT16_MAX + y))) {
+    return INT16_MAX;
+  } else if ((y > 0) && (x < (INT16_MIN + y))) {
+    return INT16_MIN;
+  }
+  return (int16_t)(x - y);
+}
+
+static inline int32_t puffs_base__i32__sat_add(int32_t x, int32_t y) {
+  if ((y > 0) && (x > (INT32_MAX - y))) {
+    return INT32_MAX;
+  } else if ((y < 0) && (x < (INT32_MIN - y))) {
+    return INT32_MIN;
+  }
+  return (int32_t)(x + y);
+}
+
+static inline int32_t puffs_base__i32__sat_sub(int32_t x, int32_t y) {
+  if ((y < 0) && (x > (INT32_MAX + y))) {
+    return INT32_MAX;
+  } else if ((y > 0) && (x < (INT32_MIN + y))) {
+    return INT32_MIN;
+  }
+  return (int32_t)(x - y);
+}
+
+static inline int64_t puffs_base__i64__sat_add(int64_t x, int64_t y) {
+  if ((y > 0) && (x > (INT64_MAX - y))) {
+    return INT64_MAX;
+  } else if ((y < 0) && (x < (INT64_MIN - y))) {
+    return INT64_MIN;
+  }
+  return (int64_t)(x + y);
+}
+
+static inline int64_t puffs_base__i64__sat_sub(int64_t x, int64_t y) {
+  if ((y < 0) && (x > (INT64_MAX + y))) {
+    return INT64_MAX;
+  } else if ((y > 0) && (x < (INT64_MIN + y))) {
+    return INT64_MIN;
+  }
+  return (int64_t)(x - y);
+}
+
+// Bit manipulation. The "clz", "ctz", "popcount", "reverse_bits",
+// "rotate_left" and "byte_swap" Puffs methods call these functions. They use
+// compiler built-ins when available, with portable fallbacks otherwise.
+
+static inline uint64_t puffs_base__u64__clz(uint64_t x) {
+#if defined(__GNUC__)
+  return x ? (uint64_t)(__builtin_clzll(x)) : 64;
+#else
+  uint64_t n = 64;
+  while (x) {
+    x >>= 1;
+    n--;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__ctz(uint64_t x) {
+#if defined(__GNUC__)
+  return x ? (uint64_t)(__builtin_ctzll(x)) : 64;
+#else
+  if (!x) {
+    return 64;
+  }
+  uint64_t n = 0;
+  while (!(x & 1)) {
+    x >>= 1;
+    n++;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__popcount(uint64_t x) {
+#if defined(__GNUC__)
+  return (uint64_t)(__builtin_popcountll(x));
+#else
+  uint64_t n = 0;
+  while (x) {
+    x &= x - 1;
+    n++;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__byte_swap(uint64_t x) {
+#if defined(__GNUC__)
+  return __builtin_bswap64(x);
+#else
+  x = ((x & 0x00FF00FF00FF00FFull) << 8) | ((x >> 8) & 0x00FF00FF00FF00FFull);
+  x = ((x & 0x0000FFFF0000FFFFull) << 16) | ((x >> 16) & 0x0000FFFF0000FFFFull);
+  return (x << 32) | (x >> 32);
+#endif
+}
+
+// puffs_base__u64__reverse_bits returns the low n bits of x, in reverse order.
+// n must be in the range [0..64].
+static inline uint64_t puffs_base__u64__reverse_bits(uint64_t x, uint32_t n) {
+  x = ((x & 0x5555555555555555ull) << 1) | ((x >> 1) & 0x5555555555555555ull);
+  x = ((x & 0x3333333333333333ull) << 2) | ((x >> 2) & 0x3333333333333333ull);
+  x = ((x & 0x0F0F0F0F0F0F0F0Full) << 4) | ((x >> 4) & 0x0F0F0F0F0F0F0F0Full);
+  x = puffs_base__u64__byte_swap(x);
+  return n ? (x >> (64 - n)) : 0;
+}
+
+static inline uint64_t puffs_base__u64__rotate_left(uint64_t x, uint32_t n) {
+  return (x << n) | (x >> ((64 - n) & 63));
+}
+
+static inline uint8_t puffs_base__u8__clz(uint8_t x) {
+  return (uint8_t)(puffs_base__u64__clz(x) - 56);
+}
+
+static inline uint8_t puffs_base__u8__ctz(uint8_t x) {
+  return x ? (uint8_t)(puffs_base__u64__ctz(x)) : 8;
+}
+
+static inline uint8_t puffs_base__u8__popcount(uint8_t x) {
+  return (uint8_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint8_t puffs_base__u8__reverse_bits(uint8_t x, uint32_t n) {
+  return (uint8_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint8_t puffs_base__u8__rotate_left(uint8_t x, uint32_t n) {
+  return (uint8_t)((x << n) | (x >> ((8 - n) & 7)));
+}
+
+static inline uint16_t puffs_base__u16__clz(uint16_t x) {
+  return (uint16_t)(puffs_base__u64__clz(x) - 48);
+}
+
+static inline uint16_t puffs_base__u16__ctz(uint16_t x) {
+  return x ? (uint16_t)(puffs_base__u64__ctz(x)) : 16;
+}
+
+static inline uint16_t puffs_base__u16__popcount(uint16_t x) {
+  return (uint16_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint16_t puffs_base__u16__reverse_bits(uint16_t x, uint32_t n) {
+  return (uint16_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint16_t puffs_base__u16__rotate_left(uint16_t x, uint32_t n) {
+  return (uint16_t)((x << n) | (x >> ((16 - n) & 15)));
+}
+
+static inline uint16_t puffs_base__u16__byte_swap(uint16_t x) {
+  return (uint16_t)((x << 8) | (x >> 8));
+}
+
+static inline uint32_t puffs_base__u32__clz(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__clz(x) - 32);
+}
+
+static inline uint32_t puffs_base__u32__ctz(uint32_t x) {
+  return x ? (uint32_t)(puffs_base__u64__ctz(x)) : 32;
+}
+
+static inline uint32_t puffs_base__u32__popcount(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint32_t puffs_base__u32__reverse_bits(uint32_t x, uint32_t n) {
+  return (uint32_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint32_t puffs_base__u32__rotate_left(uint32_t x, uint32_t n) {
+  return (x << n) | (x >> ((32 - n) & 31));
+}
+
+static inline uint32_t puffs_base__u32__byte_swap(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__byte_swap(x) >> 32);
+}
+
 static inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_i(
     puffs_base__slice_u8 s,
     uint64_t i) {
@@ -645,6 +990,230 @@ static inline uint32_t puffs_base__writer1__copy_from_slice32(
   return n;
 }
 
+// The puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64
+// functions below are like their puffs_base__slice_u8 equivalents above. The
+// copy_from_slice functions return the number of elements copied, not bytes.
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_i(
+    puffs_base__slice_u16 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u16){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_j(
+    puffs_base__slice_u16 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u16){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_ij(
+    puffs_base__slice_u16 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u16){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline uint64_t puffs_base__slice_u16__copy_from_slice(
+    puffs_base__slice_u16 dst,
+    puffs_base__slice_u16 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint16_t));
+  }
+  return length;
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_i(
+    puffs_base__slice_u32 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u32){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_j(
+    puffs_base__slice_u32 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u32){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_ij(
+    puffs_base__slice_u32 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u32){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline uint64_t puffs_base__slice_u32__copy_from_slice(
+    puffs_base__slice_u32 dst,
+    puffs_base__slice_u32 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint32_t));
+  }
+  return length;
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_i(
+    puffs_base__slice_u64 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u64){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_j(
+    puffs_base__slice_u64 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u64){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_ij(
+    puffs_base__slice_u64 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u64){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline uint64_t puffs_base__slice_u64__copy_from_slice(
+    puffs_base__slice_u64 dst,
+    puffs_base__slice_u64 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint64_t));
+  }
+  return length;
+}
+
+// puffs_base__buf2__row returns row y of b. The caller needs to prove that
+// y < b.height.
+static inline puffs_base__slice_u8 puffs_base__buf2__row(puffs_base__buf2 b,
+                                                         uint64_t y) {
+  return ((puffs_base__slice_u8){
+      .ptr = b.ptr + (y * b.stride),
+      .len = b.width,
+  });
+}
+
+// puffs_base__buf2__set_u8 sets the byte at column x of row y of b. The caller
+// needs to prove that x < b.width and y < b.height.
+static inline puffs_base__empty_struct puffs_base__buf2__set_u8(
+    puffs_base__buf2 b,
+    uint64_t x,
+    uint64_t y,
+    uint8_t v) {
+  b.ptr[(y * b.stride) + x] = v;
+  return ((puffs_base__empty_struct){});
+}
+
+// puffs_base__bitreader__peek_bits returns the next n bits of b without
+// consuming them. The caller needs to prove that n <= 32 and n <= b.n_bits.
+static inline uint32_t puffs_base__bitreader__peek_bits(puffs_base__bitreader b,
+                                                        uint32_t n) {
+  uint64_t mask = (((uint64_t)(1)) << n) - 1;
+  if (b.msb_first) {
+    return (uint32_t)((b.bits >> (b.n_bits - n)) & mask);
+  }
+  return (uint32_t)(b.bits & mask);
+}
+
+// puffs_base__bitreader__take_bits is like puffs_base__bitreader__peek_bits
+// but also consumes those bits.
+static inline uint32_t puffs_base__bitreader__take_bits(
+    puffs_base__bitreader* b,
+    uint32_t n) {
+  uint32_t ret = puffs_base__bitreader__peek_bits(*b, n);
+  if (!b->msb_first) {
+    b->bits >>= n;
+  }
+  b->n_bits -= n;
+  return ret;
+}
+
+static inline puffs_base__empty_struct puffs_base__bitreader__set_msb_first(
+    puffs_base__bitreader* b,
+    bool msb_first) {
+  b->msb_first = msb_first;
+  return ((puffs_base__empty_struct){});
+}
+
+// puffs_base__bitreader__refill_u8 appends one byte, x, to b. The caller needs
+// to prove that b->n_bits <= 55.
+static inline void puffs_base__bitreader__refill_u8(puffs_base__bitreader* b,
+                                                    uint8_t x) {
+  if (b->msb_first) {
+    b->bits = (b->bits << 8) | ((uint64_t)(x));
+  } else {
+    b->bits |= ((uint64_t)(x)) << b->n_bits;
+  }
+  b->n_bits += 8;
+}
+
+// puffs_base__bitreader__refill_fast reads the fewest whole bytes from p that
+// give b at least n bits, and returns how many bytes that is. It loads those
+// bytes with a single 64-bit read, so the caller needs to prove that there are
+// at least 8 bytes at p, and that b->n_bits < n <= 56. It reads at most 7 bytes.
+static inline size_t puffs_base__bitreader__refill_fast(
+    puffs_base__bitreader* b,
+    uint8_t* p,
+    uint32_t n) {
+  uint32_t n_bytes = (n - b->n_bits + 7) >> 3;
+  uint32_t n_new_bits = 8 * n_bytes;
+  if (b->msb_first) {
+    uint64_t x = ((uint64_t)(puffs_base__load_u32be(p + 0)) << 32) |
+                 ((uint64_t)(puffs_base__load_u32be(p + 4)));
+    b->bits = (b->bits << n_new_bits) | (x >> (64 - n_new_bits));
+  } else {
+    uint64_t x = ((uint64_t)(puffs_base__load_u32le(p + 0))) |
+                 ((uint64_t)(puffs_base__load_u32le(p + 4)) << 32);
+    x &= (((uint64_t)(1)) << n_new_bits) - 1;
+    b->bits |= x << b->n_bits;
+  }
+  b->n_bits += n_new_bits;
+  return n_bytes;
+}
+
 // Note that the *__limit and *__mark methods are private (in base-impl.h) not
 // public (in base-header.h). We assume that, at the boundary between user code
 // and Puffs code, the reader1 and writer1's private_impl fields (including
@@ -673,7 +1242,14 @@ static inline puffs_base__empty_struct puffs_base__reader1__mark(
   return ((puffs_base__empty_struct){});
 }
 
-// TODO: static inline puffs_base__writer1 puffs_base__writer1__limit()
+static inline puffs_base__writer1 puffs_base__writer1__limit(
+    puffs_base__writer1* o,
+    uint64_t* ptr_to_len) {
+  puffs_base__writer1 ret = *o;
+  ret.private_impl.limit.ptr_to_len = ptr_to_len;
+  ret.private_impl.limit.next = &o->private_impl.limit;
+  return ret;
+}
 
 static inline puffs_base__empty_struct puffs_base__writer1__mark(
     puffs_base__writer1* o,
@@ -788,6 +1364,8 @@ static const uint32_t puffs_flate__dcode_magic_numbers[32] = {
     134217728,  134217728,
 };
 
+// ---------------- Slice Implementations
+
 // ---------------- Private Initializer Prototypes
 
 void puffs_flate__adler32__initialize(puffs_flate__adler32* self,
@@ -905,10 +1483,10 @@ puffs_flate__status puffs_flate__flate_decoder__decode(
   }
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  puffs_flate__status v_z;
+  puffs_flate__status v_z = 0;
   puffs_base__slice_u8 v_written;
-  uint64_t v_n_copied;
-  uint32_t v_already_full;
+  uint64_t v_n_copied = 0;
+  uint32_t v_already_full = 0;
 
   uint8_t* b_wptr_dst = NULL;
   uint8_t* b_wstart_dst = NULL;
@@ -933,8 +1511,6 @@ puffs_flate__status puffs_flate__flate_decoder__decode(
   if (coro_susp_point) {
     v_z = self->private_impl.c_decode[0].v_z;
     v_written = ((puffs_base__slice_u8){});
-    v_n_copied = self->private_impl.c_decode[0].v_n_copied;
-    v_already_full = self->private_impl.c_decode[0].v_already_full;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -1016,8 +1592,6 @@ puffs_flate__status puffs_flate__flate_decoder__decode(
 suspend:
   self->private_impl.c_decode[0].coro_susp_point = coro_susp_point;
   self->private_impl.c_decode[0].v_z = v_z;
-  self->private_impl.c_decode[0].v_n_copied = v_n_copied;
-  self->private_impl.c_decode[0].v_already_full = v_already_full;
 
 exit:
   if (a_dst.buf) {
@@ -1043,8 +1617,8 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_blocks(
     puffs_base__reader1 a_src) {
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint32_t v_final;
-  uint32_t v_type;
+  uint32_t v_final = 0;
+  uint32_t v_type = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -1066,7 +1640,6 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_blocks(
       self->private_impl.c_decode_blocks[0].coro_susp_point;
   if (coro_susp_point) {
     v_final = self->private_impl.c_decode_blocks[0].v_final;
-    v_type = self->private_impl.c_decode_blocks[0].v_type;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -1200,7 +1773,6 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_blocks(
 suspend:
   self->private_impl.c_decode_blocks[0].coro_susp_point = coro_susp_point;
   self->private_impl.c_decode_blocks[0].v_final = v_final;
-  self->private_impl.c_decode_blocks[0].v_type = v_type;
 
 exit:
   if (a_src.buf) {
@@ -1233,8 +1805,8 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_uncompressed(
     puffs_base__reader1 a_src) {
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint32_t v_length;
-  uint32_t v_n_copied;
+  uint32_t v_length = 0;
+  uint32_t v_n_copied = 0;
 
   uint8_t* b_wptr_dst = NULL;
   uint8_t* b_wstart_dst = NULL;
@@ -1274,7 +1846,6 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_uncompressed(
       self->private_impl.c_decode_uncompressed[0].coro_susp_point;
   if (coro_susp_point) {
     v_length = self->private_impl.c_decode_uncompressed[0].v_length;
-    v_n_copied = self->private_impl.c_decode_uncompressed[0].v_n_copied;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -1350,7 +1921,6 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_uncompressed(
 suspend:
   self->private_impl.c_decode_uncompressed[0].coro_susp_point = coro_susp_point;
   self->private_impl.c_decode_uncompressed[0].v_length = v_length;
-  self->private_impl.c_decode_uncompressed[0].v_n_copied = v_n_copied;
 
 exit:
   if (a_dst.buf) {
@@ -1393,12 +1963,11 @@ static puffs_flate__status puffs_flate__flate_decoder__init_fixed_huffman(
     puffs_flate__flate_decoder* self) {
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint32_t v_i;
+  uint32_t v_i = 0;
 
   uint32_t coro_susp_point =
       self->private_impl.c_init_fixed_huffman[0].coro_susp_point;
   if (coro_susp_point) {
-    v_i = self->private_impl.c_init_fixed_huffman[0].v_i;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -1444,7 +2013,6 @@ static puffs_flate__status puffs_flate__flate_decoder__init_fixed_huffman(
   goto suspend;
 suspend:
   self->private_impl.c_init_fixed_huffman[0].coro_susp_point = coro_susp_point;
-  self->private_impl.c_init_fixed_huffman[0].v_i = v_i;
 
 exit:
   return status;
@@ -1455,18 +2023,18 @@ static puffs_flate__status puffs_flate__flate_decoder__init_dynamic_huffman(
     puffs_base__reader1 a_src) {
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint32_t v_bits;
-  uint32_t v_n_bits;
-  uint32_t v_n_lit;
-  uint32_t v_n_dist;
-  uint32_t v_n_clen;
-  uint32_t v_i;
-  uint32_t v_mask;
-  uint32_t v_table_entry;
-  uint32_t v_table_entry_n_bits;
-  uint32_t v_n_extra_bits;
-  uint8_t v_rep_symbol;
-  uint32_t v_rep_count;
+  uint32_t v_bits = 0;
+  uint32_t v_n_bits = 0;
+  uint32_t v_n_lit = 0;
+  uint32_t v_n_dist = 0;
+  uint32_t v_n_clen = 0;
+  uint32_t v_i = 0;
+  uint32_t v_mask = 0;
+  uint32_t v_table_entry = 0;
+  uint32_t v_table_entry_n_bits = 0;
+  uint32_t v_n_extra_bits = 0;
+  uint8_t v_rep_symbol = 0;
+  uint32_t v_rep_count = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -1495,8 +2063,6 @@ static puffs_flate__status puffs_flate__flate_decoder__init_dynamic_huffman(
     v_i = self->private_impl.c_init_dynamic_huffman[0].v_i;
     v_mask = self->private_impl.c_init_dynamic_huffman[0].v_mask;
     v_table_entry = self->private_impl.c_init_dynamic_huffman[0].v_table_entry;
-    v_table_entry_n_bits =
-        self->private_impl.c_init_dynamic_huffman[0].v_table_entry_n_bits;
     v_n_extra_bits =
         self->private_impl.c_init_dynamic_huffman[0].v_n_extra_bits;
     v_rep_symbol = self->private_impl.c_init_dynamic_huffman[0].v_rep_symbol;
@@ -1684,8 +2250,6 @@ suspend:
   self->private_impl.c_init_dynamic_huffman[0].v_i = v_i;
   self->private_impl.c_init_dynamic_huffman[0].v_mask = v_mask;
   self->private_impl.c_init_dynamic_huffman[0].v_table_entry = v_table_entry;
-  self->private_impl.c_init_dynamic_huffman[0].v_table_entry_n_bits =
-      v_table_entry_n_bits;
   self->private_impl.c_init_dynamic_huffman[0].v_n_extra_bits = v_n_extra_bits;
   self->private_impl.c_init_dynamic_huffman[0].v_rep_symbol = v_rep_symbol;
   self->private_impl.c_init_dynamic_huffman[0].v_rep_count = v_rep_count;
@@ -1724,30 +2288,30 @@ static puffs_flate__status puffs_flate__flate_decoder__init_huff(
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
   uint16_t v_counts[16];
-  uint32_t v_i;
-  uint32_t v_remaining;
+  uint32_t v_i = 0;
+  uint32_t v_remaining = 0;
   uint16_t v_offsets[16];
-  uint32_t v_n_symbols;
-  uint32_t v_count;
+  uint32_t v_n_symbols = 0;
+  uint32_t v_count = 0;
   uint16_t v_symbols[320];
-  uint32_t v_min_cl;
-  uint32_t v_max_cl;
-  uint32_t v_initial_high_bits;
-  uint32_t v_prev_cl;
-  uint32_t v_prev_redirect_key;
-  uint32_t v_top;
-  uint32_t v_next_top;
-  uint32_t v_code;
-  uint32_t v_key;
-  uint32_t v_value;
-  uint32_t v_cl;
-  uint32_t v_tmp;
-  uint32_t v_redirect_key;
-  uint32_t v_j;
-  uint32_t v_reversed_key;
-  uint32_t v_symbol;
-  uint32_t v_high_bits;
-  uint32_t v_delta;
+  uint32_t v_min_cl = 0;
+  uint32_t v_max_cl = 0;
+  uint32_t v_initial_high_bits = 0;
+  uint32_t v_prev_cl = 0;
+  uint32_t v_prev_redirect_key = 0;
+  uint32_t v_top = 0;
+  uint32_t v_next_top = 0;
+  uint32_t v_code = 0;
+  uint32_t v_key = 0;
+  uint32_t v_value = 0;
+  uint32_t v_cl = 0;
+  uint32_t v_tmp = 0;
+  uint32_t v_redirect_key = 0;
+  uint32_t v_j = 0;
+  uint32_t v_reversed_key = 0;
+  uint32_t v_symbol = 0;
+  uint32_t v_high_bits = 0;
+  uint32_t v_delta = 0;
 
   memset(v_counts, 0, sizeof(v_counts));
   v_i = a_n_codes0;
@@ -2001,19 +2565,19 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_huffman_fast(
     puffs_base__reader1 a_src) {
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint32_t v_bits;
-  uint32_t v_n_bits;
-  uint32_t v_table_entry;
-  uint32_t v_table_entry_n_bits;
-  uint32_t v_lmask;
-  uint32_t v_dmask;
-  uint32_t v_redir_top;
-  uint32_t v_redir_mask;
-  uint32_t v_length;
-  uint32_t v_distance;
-  uint32_t v_n_copied;
-  uint32_t v_hlen;
-  uint32_t v_hdist;
+  uint32_t v_bits = 0;
+  uint32_t v_n_bits = 0;
+  uint32_t v_table_entry = 0;
+  uint32_t v_table_entry_n_bits = 0;
+  uint32_t v_lmask = 0;
+  uint32_t v_dmask = 0;
+  uint32_t v_redir_top = 0;
+  uint32_t v_redir_mask = 0;
+  uint32_t v_length = 0;
+  uint32_t v_distance = 0;
+  uint32_t v_n_copied = 0;
+  uint32_t v_hlen = 0;
+  uint32_t v_hdist = 0;
 
   uint8_t* b_wptr_dst = NULL;
   uint8_t* b_wstart_dst = NULL;
@@ -2385,19 +2949,19 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_huffman_slow(
     puffs_base__reader1 a_src) {
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint32_t v_bits;
-  uint32_t v_n_bits;
-  uint32_t v_table_entry;
-  uint32_t v_table_entry_n_bits;
-  uint32_t v_lmask;
-  uint32_t v_dmask;
-  uint32_t v_redir_top;
-  uint32_t v_redir_mask;
-  uint32_t v_length;
-  uint32_t v_distance;
-  uint32_t v_n_copied;
-  uint32_t v_hlen;
-  uint32_t v_hdist;
+  uint32_t v_bits = 0;
+  uint32_t v_n_bits = 0;
+  uint32_t v_table_entry = 0;
+  uint32_t v_table_entry_n_bits = 0;
+  uint32_t v_lmask = 0;
+  uint32_t v_dmask = 0;
+  uint32_t v_redir_top = 0;
+  uint32_t v_redir_mask = 0;
+  uint32_t v_length = 0;
+  uint32_t v_distance = 0;
+  uint32_t v_n_copied = 0;
+  uint32_t v_hlen = 0;
+  uint32_t v_hdist = 0;
 
   uint8_t* b_wptr_dst = NULL;
   uint8_t* b_wstart_dst = NULL;
@@ -2447,7 +3011,6 @@ static puffs_flate__status puffs_flate__flate_decoder__decode_huffman_slow(
     v_redir_mask = self->private_impl.c_decode_huffman_slow[0].v_redir_mask;
     v_length = self->private_impl.c_decode_huffman_slow[0].v_length;
     v_distance = self->private_impl.c_decode_huffman_slow[0].v_distance;
-    v_n_copied = self->private_impl.c_decode_huffman_slow[0].v_n_copied;
     v_hlen = self->private_impl.c_decode_huffman_slow[0].v_hlen;
     v_hdist = self->private_impl.c_decode_huffman_slow[0].v_hdist;
   }
@@ -2780,7 +3343,6 @@ suspend:
   self->private_impl.c_decode_huffman_slow[0].v_redir_mask = v_redir_mask;
   self->private_impl.c_decode_huffman_slow[0].v_length = v_length;
   self->private_impl.c_decode_huffman_slow[0].v_distance = v_distance;
-  self->private_impl.c_decode_huffman_slow[0].v_n_copied = v_n_copied;
   self->private_impl.c_decode_huffman_slow[0].v_hlen = v_hlen;
   self->private_impl.c_decode_huffman_slow[0].v_hdist = v_hdist;
 
@@ -2836,9 +3398,9 @@ puffs_flate__status puffs_flate__zlib_decoder__decode(
   }
   puffs_flate__status status = PUFFS_FLATE__STATUS_OK;
 
-  uint16_t v_x;
-  uint32_t v_checksum;
-  puffs_flate__status v_z;
+  uint16_t v_x = 0;
+  uint32_t v_checksum = 0;
+  puffs_flate__status v_z = 0;
 
   uint8_t* b_wptr_dst = NULL;
   uint8_t* b_wstart_dst = NULL;
@@ -2876,9 +3438,7 @@ puffs_flate__status puffs_flate__zlib_decoder__decode(
 
   uint32_t coro_susp_point = self->private_impl.c_decode[0].coro_susp_point;
   if (coro_susp_point) {
-    v_x = self->private_impl.c_decode[0].v_x;
     v_checksum = self->private_impl.c_decode[0].v_checksum;
-    v_z = self->private_impl.c_decode[0].v_z;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -2900,7 +3460,7 @@ puffs_flate__status puffs_flate__zlib_decoder__decode(
           self->private_impl.c_decode[0].scratch >>= 8;
           self->private_impl.c_decode[0].scratch <<= 8;
           self->private_impl.c_decode[0].scratch |= ((uint64_t)(*b_rptr_src++))
-                                                    << (64 - t_0);
+                                                    << (56 - t_0);
           if (t_0 == 8) {
             t_1 = self->private_impl.c_decode[0].scratch >> (64 - 16);
             break;
@@ -2993,7 +3553,7 @@ puffs_flate__status puffs_flate__zlib_decoder__decode(
         self->private_impl.c_decode[0].scratch >>= 8;
         self->private_impl.c_decode[0].scratch <<= 8;
         self->private_impl.c_decode[0].scratch |= ((uint64_t)(*b_rptr_src++))
-                                                  << (64 - t_3);
+                                                  << (56 - t_3);
         if (t_3 == 24) {
           t_4 = self->private_impl.c_decode[0].scratch >> (64 - 32);
           break;
@@ -3016,9 +3576,7 @@ puffs_flate__status puffs_flate__zlib_decoder__decode(
   goto suspend;
 suspend:
   self->private_impl.c_decode[0].coro_susp_point = coro_susp_point;
-  self->private_impl.c_decode[0].v_x = v_x;
   self->private_impl.c_decode[0].v_checksum = v_checksum;
-  self->private_impl.c_decode[0].v_z = v_z;
 
 exit:
   if (a_dst.buf) {
@@ -56,6 +56,24 @@ typedef struct {
   size_t len;
 } puffs_base__slice_u8;
 
+// puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64 are
+// like puffs_base__slice_u8, but for wider elements. Their len fields count
+// elements, not bytes.
+typedef struct {
+  uint16_t* ptr;
+  size_t len;
+} puffs_base__slice_u16;
+
+typedef struct {
+  uint32_t* ptr;
+  size_t len;
+} puffs_base__slice_u32;
+
+typedef struct {
+  uint64_t* ptr;
+  size_t len;
+} puffs_base__slice_u64;
+
 // puffs_base__buf1 is a 1-dimensional buffer (a pointer and length), plus
 // additional indexes into that buffer, plus an opened / closed flag.
 //
@@ -105,6 +123,43 @@ typedef struct {
   } private_impl;
 } puffs_base__writer1;
 
+// puffs_base__pixel_format describes how a pixel's bytes are laid out in
+// memory. The high 24 bits identify the format. The low 8 bits hold the number
+// of bytes per pixel.
+typedef uint32_t puffs_base__pixel_format;
+
+#define PUFFS_BASE__PIXEL_FORMAT__INVALID 0x00000000
+#define PUFFS_BASE__PIXEL_FORMAT__INDEXED_8 0x01000001
+#define PUFFS_BASE__PIXEL_FORMAT__GRAY_8 0x02000001
+#define PUFFS_BASE__PIXEL_FORMAT__RGB_888 0x03000003
+#define PUFFS_BASE__PIXEL_FORMAT__BGRA_8888 0x04000004
+#define PUFFS_BASE__PIXEL_FORMAT__RGBA_8888 0x05000004
+
+// puffs_base__buf2 is a 2-dimensional buffer of bytes, such as pixel data. Row
+// y starts at ptr + (y * stride) and holds width bytes.
+//
+// A value with all fields NULL or zero is a valid, empty buffer.
+typedef struct {
+  uint8_t* ptr;                     // Pointer.
+  size_t width;                     // Bytes per row.
+  size_t height;                    // Number of rows.
+  size_t stride;                    // Invariant: width <= stride.
+  puffs_base__pixel_format pixfmt;  // Pixel format.
+} puffs_base__buf2;
+
+// puffs_base__bitreader yields the bits of a puffs_base__reader1's bytes, in
+// either Least or Most Significant Bits first order. It buffers up to 63 bits.
+// In LSB-first mode, the next bit is bit 0 of bits, and bits at or above n_bits
+// are zero. In MSB-first mode, the next bit is bit (n_bits - 1), and bits at or
+// above n_bits are unspecified.
+//
+// A value with all fields zero is a valid, empty, LSB-first bitreader.
+typedef struct {
+  uint64_t bits;
+  uint32_t n_bits;
+  bool msb_first;
+} puffs_base__bitreader;
+
 #endif  // PUFFS_BASE_HEADER_H
 
 #ifdef __cplusplus
@@ -153,6 +208,10 @@ const char* puffs_gif__status__string(puffs_gif__status s);
 
 // ---------------- Public Consts
 
+// ---------------- Public Enums
+
+// ---------------- Unions
+
 // ---------------- Structs
 
 typedef struct {
@@ -179,12 +238,10 @@ typedef struct {
       uint32_t v_save_code;
       uint32_t v_prev_code;
       uint32_t v_width;
-      uint32_t v_bits;
-      uint32_t v_n_bits;
+      puffs_base__bitreader v_br;
       uint32_t v_code;
       uint32_t v_s;
       uint32_t v_c;
-      uint64_t v_n_copied;
     } c_decode[1];
   } private_impl;
 } puffs_gif__lzw_decoder;
@@ -209,12 +266,10 @@ typedef struct {
 
     struct {
       uint32_t coro_susp_point;
-      uint8_t v_c;
     } c_decode[1];
     struct {
       uint32_t coro_susp_point;
-      uint8_t v_c[6];
-      uint32_t v_i;
+      uint64_t scratch;
     } c_decode_header[1];
     struct {
       uint32_t coro_susp_point;
@@ -224,7 +279,6 @@ typedef struct {
     } c_decode_lsd[1];
     struct {
       uint32_t coro_susp_point;
-      uint8_t v_label;
       uint8_t v_block_size;
       uint64_t scratch;
     } c_decode_extension[1];
@@ -232,10 +286,7 @@ typedef struct {
       uint32_t coro_susp_point;
       uint8_t v_c[9];
       uint32_t v_i;
-      bool v_interlace;
-      uint8_t v_lw;
       uint64_t v_block_size;
-      puffs_gif__status v_z;
     } c_decode_id[1];
   } private_impl;
 } puffs_gif__decoder;
@@ -378,6 +429,16 @@ static inline uint16_t puffs_base__load_u16le(uint8_t* p) {
   return ((uint16_t)(p[0]) << 0) | ((uint16_t)(p[1]) << 8);
 }
 
+static inline uint32_t puffs_base__load_u24be(uint8_t* p) {
+  return ((uint32_t)(p[0]) << 16) | ((uint32_t)(p[1]) << 8) |
+         ((uint32_t)(p[2]) << 0);
+}
+
+static inline uint32_t puffs_base__load_u24le(uint8_t* p) {
+  return ((uint32_t)(p[0]) << 0) | ((uint32_t)(p[1]) << 8) |
+         ((uint32_t)(p[2]) << 16);
+}
+
 static inline uint32_t puffs_base__load_u32be(uint8_t* p) {
   return ((uint32_t)(p[0]) << 24) | ((uint32_t)(p[1]) << 16) |
          ((uint32_t)(p[2]) << 8) | ((uint32_t)(p[3]) << 0);
@@ -388,6 +449,291 @@ static inline uint32_t puffs_base__load_u32le(uint8_t* p) {
          ((uint32_t)(p[2]) << 16) | ((uint32_t)(p[3]) << 24);
 }
 
+static inline void puffs_base__store_u16be(uint8_t* p, uint16_t x) {
+  p[0] = (uint8_t)(x >> 8);
+  p[1] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u16le(uint8_t* p, uint16_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+}
+
+static inline void puffs_base__store_u24be(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 16);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u24le(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 16);
+}
+
+static inline void puffs_base__store_u32be(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 24);
+  p[1] = (uint8_t)(x >> 16);
+  p[2] = (uint8_t)(x >> 8);
+  p[3] = (uint8_t)(x >> 0);
+}
+
+static inline void puffs_base__store_u32le(uint8_t* p, uint32_t x) {
+  p[0] = (uint8_t)(x >> 0);
+  p[1] = (uint8_t)(x >> 8);
+  p[2] = (uint8_t)(x >> 16);
+  p[3] = (uint8_t)(x >> 24);
+}
+
+// Saturating arithmetic. The "~sat+" and "~sat-" Puffs operators call these
+// functions. The "~+", "~-", etc. wrapping operators don't need helpers.
+
+static inline uint8_t puffs_base__u8__sat_add(uint8_t x, uint8_t y) {
+  uint8_t res = (uint8_t)(x + y);
+  return (res < x) ? UINT8_MAX : res;
+}
+
+static inline uint8_t puffs_base__u8__sat_sub(uint8_t x, uint8_t y) {
+  return (x > y) ? (uint8_t)(x - y) : 0;
+}
+
+static inline uint16_t puffs_base__u16__sat_add(uint16_t x, uint16_t y) {
+  uint16_t res = (uint16_t)(x + y);
+  return (res < x) ? UINT16_MAX : res;
+}
+
+static inline uint16_t puffs_base__u16__sat_sub(uint16_t x, uint16_t y) {
+  return (x > y) ? (uint16_t)(x - y) : 0;
+}
+
+static inline uint32_t puffs_base__u32__sat_add(uint32_t x, uint32_t y) {
+  uint32_t res = (uint32_t)(x + y);
+  return (res < x) ? UINT32_MAX : res;
+}
+
+static inline uint32_t puffs_base__u32__sat_sub(uint32_t x, uint32_t y) {
+  return (x > y) ? (uint32_t)(x - y) : 0;
+}
+
+static inline uint64_t puffs_base__u64__sat_add(uint64_t x, uint64_t y) {
+  uint64_t res = (uint64_t)(x + y);
+  return (res < x) ? UINT64_MAX : res;
+}
+
+static inline uint64_t puffs_base__u64__sat_sub(uint64_t x, uint64_t y) {
+  return (x > y) ? (uint64_t)(x - y) : 0;
+}
+
+static inline int8_t puffs_base__i8__sat_add(int8_t x, int8_t y) {
+  if ((y > 0) && (x > (INT8_MAX - y))) {
+    return INT8_MAX;
+  } else if ((y < 0) && (x < (INT8_MIN - y))) {
+    return INT8_MIN;
+  }
+  return (int8_t)(x + y);
+}
+
+static inline int8_t puffs_base__i8__sat_sub(int8_t x, int8_t y) {
+  if ((y < 0) && (x > (INT8_MAX + y))) {
+    return INT8_MAX;
+  } else if ((y > 0) && (x < (INT8_MIN + y))) {
+    return INT8_MIN;
+  }
+  return (int8_t)(x - y);
+}
+
+static inline int16_t puffs_base__i16__sat_add(int16_t x, int16_t y) {
+  if ((y > 0) && (x > (INT16_MAX - y))) {
+    return INT16_MAX;
+  } else if ((y < 0) && (x < (INT16_MIN - y))) {
+    return INT16_MIN;
+  }
+  return (int16_t)(x + y);
+}
+
+static inline int16_t puffs_base__i16__sat_sub(int16_t x, int16_t y) {
+  if ((y < 0) && (x > (INT16_MAX + y))) {
+    return INT16_MAX;
+  } else if ((y > 0) && (x < (INT16_MIN + y))) {
+    return INT16_MIN;
+  }
+  return (int16_t)(x - y);
+}
+
+static inline int32_t puffs_base__i32__sat_add(int32_t x, int32_t y) {
+  if ((y > 0) && (x > (INT32_MAX - y))) {
+    return INT32_MAX;
+  } else if ((y < 0) && (x < (INT32_MIN - y))) {
+    return INT32_MIN;
+  }
+  return (int32_t)(x + y);
+}
+
+static inline int32_t puffs_base__i32__sat_sub(int32_t x, int32_t y) {
+  if ((y < 0) && (x > (INT32_MAX + y))) {
+    return INT32_MAX;
+  } else if ((y > 0) && (x < (INT32_MIN + y))) {
+    return INT32_MIN;
+  }
+  return (int32_t)(x - y);
+}
+
+static inline int64_t puffs_base__i64__sat_add(int64_t x, int64_t y) {
+  if ((y > 0) && (x > (INT64_MAX - y))) {
+    return INT64_MAX;
+  } else if ((y < 0) && (x < (INT64_MIN - y))) {
+    return INT64_MIN;
+  }
+  return (int64_t)(x + y);
+}
+
+static inline int64_t puffs_base__i64__sat_sub(int64_t x, int64_t y) {
+  if ((y < 0) && (x > (INT64_MAX + y))) {
+    return INT64_MAX;
+  } else if ((y > 0) && (x < (INT64_MIN + y))) {
+    return INT64_MIN;
+  }
+  return (int64_t)(x - y);
+}
+
+// Bit manipulation. The "clz", "ctz", "popcount", "reverse_bits",
+// "rotate_left" and "byte_swap" Puffs methods call these functions. They use
+// compiler built-ins when available, with portable fallbacks otherwise.
+
+static inline uint64_t puffs_base__u64__clz(uint64_t x) {
+#if defined(__GNUC__)
+  return x ? (uint64_t)(__builtin_clzll(x)) : 64;
+#else
+  uint64_t n = 64;
+  while (x) {
+    x >>= 1;
+    n--;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__ctz(uint64_t x) {
+#if defined(__GNUC__)
+  return x ? (uint64_t)(__builtin_ctzll(x)) : 64;
+#else
+  if (!x) {
+    return 64;
+  }
+  uint64_t n = 0;
+  while (!(x & 1)) {
+    x >>= 1;
+    n++;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__popcount(uint64_t x) {
+#if defined(__GNUC__)
+  return (uint64_t)(__builtin_popcountll(x));
+#else
+  uint64_t n = 0;
+  while (x) {
+    x &= x - 1;
+    n++;
+  }
+  return n;
+#endif
+}
+
+static inline uint64_t puffs_base__u64__byte_swap(uint64_t x) {
+#if defined(__GNUC__)
+  return __builtin_bswap64(x);
+#else
+  x = ((x & 0x00FF00FF00FF00FFull) << 8) | ((x >> 8) & 0x00FF00FF00FF00FFull);
+  x = ((x & 0x0000FFFF0000FFFFull) << 16) | ((x >> 16) & 0x0000FFFF0000FFFFull);
+  return (x << 32) | (x >> 32);
+#endif
+}
+
+// puffs_base__u64__reverse_bits returns the low n bits of x, in reverse order.
+// n must be in the range [0..64].
+static inline uint64_t puffs_base__u64__reverse_bits(uint64_t x, uint32_t n) {
+  x = ((x & 0x5555555555555555ull) << 1) | ((x >> 1) & 0x5555555555555555ull);
+  x = ((x & 0x3333333333333333ull) << 2) | ((x >> 2) & 0x3333333333333333ull);
+  x = ((x & 0x0F0F0F0F0F0F0F0Full) << 4) | ((x >> 4) & 0x0F0F0F0F0F0F0F0Full);
+  x = puffs_base__u64__byte_swap(x);
+  return n ? (x >> (64 - n)) : 0;
+}
+
+static inline uint64_t puffs_base__u64__rotate_left(uint64_t x, uint32_t n) {
+  return (x << n) | (x >> ((64 - n) & 63));
+}
+
+static inline uint8_t puffs_base__u8__clz(uint8_t x) {
+  return (uint8_t)(puffs_base__u64__clz(x) - 56);
+}
+
+static inline uint8_t puffs_base__u8__ctz(uint8_t x) {
+  return x ? (uint8_t)(puffs_base__u64__ctz(x)) : 8;
+}
+
+static inline uint8_t puffs_base__u8__popcount(uint8_t x) {
+  return (uint8_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint8_t puffs_base__u8__reverse_bits(uint8_t x, uint32_t n) {
+  return (uint8_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint8_t puffs_base__u8__rotate_left(uint8_t x, uint32_t n) {
+  return (uint8_t)((x << n) | (x >> ((8 - n) & 7)));
+}
+
+static inline uint16_t puffs_base__u16__clz(uint16_t x) {
+  return (uint16_t)(puffs_base__u64__clz(x) - 48);
+}
+
+static inline uint16_t puffs_base__u16__ctz(uint16_t x) {
+  return x ? (uint16_t)(puffs_base__u64__ctz(x)) : 16;
+}
+
+static inline uint16_t puffs_base__u16__popcount(uint16_t x) {
+  return (uint16_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint16_t puffs_base__u16__reverse_bits(uint16_t x, uint32_t n) {
+  return (uint16_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint16_t puffs_base__u16__rotate_left(uint16_t x, uint32_t n) {
+  return (uint16_t)((x << n) | (x >> ((16 - n) & 15)));
+}
+
+static inline uint16_t puffs_base__u16__byte_swap(uint16_t x) {
+  return (uint16_t)((x << 8) | (x >> 8));
+}
+
+static inline uint32_t puffs_base__u32__clz(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__clz(x) - 32);
+}
+
+static inline uint32_t puffs_base__u32__ctz(uint32_t x) {
+  return x ? (uint32_t)(puffs_base__u64__ctz(x)) : 32;
+}
+
+static inline uint32_t puffs_base__u32__popcount(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__popcount(x));
+}
+
+static inline uint32_t puffs_base__u32__reverse_bits(uint32_t x, uint32_t n) {
+  return (uint32_t)(puffs_base__u64__reverse_bits(x, n));
+}
+
+static inline uint32_t puffs_base__u32__rotate_left(uint32_t x, uint32_t n) {
+  return (x << n) | (x >> ((32 - n) & 31));
+}
+
+static inline uint32_t puffs_base__u32__byte_swap(uint32_t x) {
+  return (uint32_t)(puffs_base__u64__byte_swap(x) >> 32);
+}
+
 static inline puffs_base__slice_u8 puffs_base__slice_u8__subslice_i(
     puffs_base__slice_u8 s,
     uint64_t i) {
@@ -587,6 +933,230 @@ static inline uint32_t puffs_base__writer1__copy_from_slice32(
   return n;
 }
 
+// The puffs_base__slice_u16, puffs_base__slice_u32 and puffs_base__slice_u64
+// functions below are like their puffs_base__slice_u8 equivalents above. The
+// copy_from_slice functions return the number of elements copied, not bytes.
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_i(
+    puffs_base__slice_u16 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u16){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_j(
+    puffs_base__slice_u16 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u16){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline puffs_base__slice_u16 puffs_base__slice_u16__subslice_ij(
+    puffs_base__slice_u16 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u16){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u16){});
+}
+
+static inline uint64_t puffs_base__slice_u16__copy_from_slice(
+    puffs_base__slice_u16 dst,
+    puffs_base__slice_u16 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint16_t));
+  }
+  return length;
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_i(
+    puffs_base__slice_u32 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u32){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_j(
+    puffs_base__slice_u32 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u32){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline puffs_base__slice_u32 puffs_base__slice_u32__subslice_ij(
+    puffs_base__slice_u32 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u32){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u32){});
+}
+
+static inline uint64_t puffs_base__slice_u32__copy_from_slice(
+    puffs_base__slice_u32 dst,
+    puffs_base__slice_u32 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint32_t));
+  }
+  return length;
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_i(
+    puffs_base__slice_u64 s,
+    uint64_t i) {
+  if ((i <= SIZE_MAX) && (i <= s.len)) {
+    return ((puffs_base__slice_u64){
+        .ptr = s.ptr + i,
+        .len = s.len - i,
+    });
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_j(
+    puffs_base__slice_u64 s,
+    uint64_t j) {
+  if ((j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u64){.ptr = s.ptr, .len = j});
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline puffs_base__slice_u64 puffs_base__slice_u64__subslice_ij(
+    puffs_base__slice_u64 s,
+    uint64_t i,
+    uint64_t j) {
+  if ((i <= j) && (j <= SIZE_MAX) && (j <= s.len)) {
+    return ((puffs_base__slice_u64){
+        .ptr = s.ptr + i,
+        .len = j - i,
+    });
+  }
+  return ((puffs_base__slice_u64){});
+}
+
+static inline uint64_t puffs_base__slice_u64__copy_from_slice(
+    puffs_base__slice_u64 dst,
+    puffs_base__slice_u64 src) {
+  size_t length = dst.len < src.len ? dst.len : src.len;
+  if (length > 0) {
+    memmove(dst.ptr, src.ptr, length * sizeof(uint64_t));
+  }
+  return length;
+}
+
+// puffs_base__buf2__row returns row y of b. The caller needs to prove that
+// y < b.height.
+static inline puffs_base__slice_u8 puffs_base__buf2__row(puffs_base__buf2 b,
+                                                         uint64_t y) {
+  return ((puffs_base__slice_u8){
+      .ptr = b.ptr + (y * b.stride),
+      .len = b.width,
+  });
+}
+
+// puffs_base__buf2__set_u8 sets the byte at column x of row y of b. The caller
+// needs to prove that x < b.width and y < b.height.
+static inline puffs_base__empty_struct puffs_base__buf2__set_u8(
+    puffs_base__buf2 b,
+    uint64_t x,
+    uint64_t y,
+    uint8_t v) {
+  b.ptr[(y * b.stride) + x] = v;
+  return ((puffs_base__empty_struct){});
+}
+
+// puffs_base__bitreader__peek_bits returns the next n bits of b without
+// consuming them. The caller needs to prove that n <= 32 and n <= b.n_bits.
+static inline uint32_t puffs_base__bitreader__peek_bits(puffs_base__bitreader b,
+                                                        uint32_t n) {
+  uint64_t mask = (((uint64_t)(1)) << n) - 1;
+  if (b.msb_first) {
+    return (uint32_t)((b.bits >> (b.n_bits - n)) & mask);
+  }
+  return (uint32_t)(b.bits & mask);
+}
+
+// puffs_base__bitreader__take_bits is like puffs_base__bitreader__peek_bits
+// but also consumes those bits.
+static inline uint32_t puffs_base__bitreader__take_bits(
+    puffs_base__bitreader* b,
+    uint32_t n) {
+  uint32_t ret = puffs_base__bitreader__peek_bits(*b, n);
+  if (!b->msb_first) {
+    b->bits >>= n;
+  }
+  b->n_bits -= n;
+  return ret;
+}
+
+static inline puffs_base__empty_struct puffs_base__bitreader__set_msb_first(
+    puffs_base__bitreader* b,
+    bool msb_first) {
+  b->msb_first = msb_first;
+  return ((puffs_base__empty_struct){});
+}
+
+// puffs_base__bitreader__refill_u8 appends one byte, x, to b. The caller needs
+// to prove that b->n_bits <= 55.
+static inline void puffs_base__bitreader__refill_u8(puffs_base__bitreader* b,
+                                                    uint8_t x) {
+  if (b->msb_first) {
+    b->bits = (b->bits << 8) | ((uint64_t)(x));
+  } else {
+    b->bits |= ((uint64_t)(x)) << b->n_bits;
+  }
+  b->n_bits += 8;
+}
+
+// puffs_base__bitreader__refill_fast reads the fewest whole bytes from p that
+// give b at least n bits, and returns how many bytes that is. It loads those
+// bytes with a single 64-bit read, so the caller needs to prove that there are
+// at least 8 bytes at p, and that b->n_bits < n <= 56. It reads at most 7 bytes.
+static inline size_t puffs_base__bitreader__refill_fast(
+    puffs_base__bitreader* b,
+    uint8_t* p,
+    uint32_t n) {
+  uint32_t n_bytes = (n - b->n_bits + 7) >> 3;
+  uint32_t n_new_bits = 8 * n_bytes;
+  if (b->msb_first) {
+    uint64_t x = ((uint64_t)(puffs_base__load_u32be(p + 0)) << 32) |
+                 ((uint64_t)(puffs_base__load_u32be(p + 4)));
+    b->bits = (b->bits << n_new_bits) | (x >> (64 - n_new_bits));
+  } else {
+    uint64_t x = ((uint64_t)(puffs_base__load_u32le(p + 0))) |
+                 ((uint64_t)(puffs_base__load_u32le(p + 4)) << 32);
+    x &= (((uint64_t)(1)) << n_new_bits) - 1;
+    b->bits |= x << b->n_bits;
+  }
+  b->n_bits += n_new_bits;
+  return n_bytes;
+}
+
 // Note that the *__limit and *__mark methods are private (in base-impl.h) not
 // public (in base-header.h). We assume that, at the boundary between user code
 // and Puffs code, the reader1 and writer1's private_impl fields (including
@@ -615,7 +1185,14 @@ static inline puffs_base__empty_struct puffs_base__reader1__mark(
   return ((puffs_base__empty_struct){});
 }
 
-// TODO: static inline puffs_base__writer1 puffs_base__writer1__limit()
+static inline puffs_base__writer1 puffs_base__writer1__limit(
+    puffs_base__writer1* o,
+    uint64_t* ptr_to_len) {
+  puffs_base__writer1 ret = *o;
+  ret.private_impl.limit.ptr_to_len = ptr_to_len;
+  ret.private_impl.limit.next = &o->private_impl.limit;
+  return ret;
+}
 
 static inline puffs_base__empty_struct puffs_base__writer1__mark(
     puffs_base__writer1* o,
@@ -675,6 +1252,8 @@ const char* puffs_gif__status__string(puffs_gif__status s) {
 
 // ---------------- Private Consts
 
+// ---------------- Slice Implementations
+
 // ---------------- Private Initializer Prototypes
 
 // ---------------- Private Function Prototypes
@@ -749,7 +1328,7 @@ puffs_gif__status puffs_gif__decoder__decode(puffs_gif__decoder* self,
   }
   puffs_gif__status status = PUFFS_GIF__STATUS_OK;
 
-  uint8_t v_c;
+  uint8_t v_c = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -769,7 +1348,6 @@ puffs_gif__status puffs_gif__decoder__decode(puffs_gif__decoder* self,
 
   uint32_t coro_susp_point = self->private_impl.c_decode[0].coro_susp_point;
   if (coro_susp_point) {
-    v_c = self->private_impl.c_decode[0].v_c;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -819,7 +1397,18 @@ puffs_gif__status puffs_gif__decoder__decode(puffs_gif__decoder* self,
         uint8_t t_0 = *b_rptr_src++;
         v_c = t_0;
       }
-      if (v_c == 33) {
+      switch (v_c) {
+        case 33:
+          goto label_switch_0_case_0;
+        case 44:
+          goto label_switch_0_case_1;
+        case 59:
+          goto label_switch_0_case_2;
+        default:
+          goto label_switch_0_else;
+      }
+    label_switch_0_case_0:;
+      {
         PUFFS_BASE__COROUTINE_SUSPENSION_POINT(4);
         if (a_src.buf) {
           size_t n = b_rptr_src - (a_src.buf->ptr + a_src.buf->ri);
@@ -838,7 +1427,10 @@ puffs_gif__status puffs_gif__decoder__decode(puffs_gif__decoder* self,
         if (status) {
           goto suspend;
         }
-      } else if (v_c == 44) {
+      }
+      goto label_switch_0_end;
+    label_switch_0_case_1:;
+      {
         PUFFS_BASE__COROUTINE_SUSPENSION_POINT(5);
         if (a_src.buf) {
           size_t n = b_rptr_src - (a_src.buf->ptr + a_src.buf->ri);
@@ -857,13 +1449,20 @@ puffs_gif__status puffs_gif__decoder__decode(puffs_gif__decoder* self,
         if (status) {
           goto suspend;
         }
-      } else if (v_c == 59) {
+      }
+      goto label_switch_0_end;
+    label_switch_0_case_2:;
+      {
         status = PUFFS_GIF__STATUS_OK;
         goto ok;
-      } else {
+      }
+      goto label_switch_0_end;
+    label_switch_0_else:;
+      {
         status = PUFFS_GIF__ERROR_BAD_GIF_BLOCK;
         goto exit;
       }
+    label_switch_0_end:;
     }
 
     goto ok;
@@ -875,7 +1474,6 @@ puffs_gif__status puffs_gif__decoder__decode(puffs_gif__decoder* self,
   goto suspend;
 suspend:
   self->private_impl.c_decode[0].coro_susp_point = coro_susp_point;
-  self->private_impl.c_decode[0].v_c = v_c;
 
 exit:
   if (a_src.buf) {
@@ -908,8 +1506,7 @@ static puffs_gif__status puffs_gif__decoder__decode_header(
     puffs_base__reader1 a_src) {
   puffs_gif__status status = PUFFS_GIF__STATUS_OK;
 
-  uint8_t v_c[6];
-  uint32_t v_i;
+  uint8_t v_c = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -930,27 +1527,73 @@ static puffs_gif__status puffs_gif__decoder__decode_header(
   uint32_t coro_susp_point =
       self->private_impl.c_decode_header[0].coro_susp_point;
   if (coro_susp_point) {
-    memcpy(v_c, self->private_impl.c_decode_header[0].v_c, sizeof(v_c));
-    v_i = self->private_impl.c_decode_header[0].v_i;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
 
-    memset(v_c, 0, sizeof(v_c));
-    v_i = 0;
-    while (v_i < 6) {
-      {
-        PUFFS_BASE__COROUTINE_SUSPENSION_POINT(1);
+    PUFFS_BASE__COROUTINE_SUSPENSION_POINT(1);
+    bool t_0;
+    if (PUFFS_BASE__LIKELY(b_rend_src - b_rptr_src >= 4)) {
+      t_0 = !memcmp(b_rptr_src, "GIF8", 4);
+      b_rptr_src += 4;
+    } else {
+      self->private_impl.c_decode_header[0].scratch = 0;
+      PUFFS_BASE__COROUTINE_SUSPENSION_POINT(2);
+      while (true) {
         if (PUFFS_BASE__UNLIKELY(b_rptr_src == b_rend_src)) {
           goto short_read_src;
         }
-        uint8_t t_0 = *b_rptr_src++;
-        v_c[v_i] = t_0;
+        if (*b_rptr_src++ !=
+            "GIF8"[(uint32_t)(self->private_impl.c_decode_header[0].scratch)]) {
+          self->private_impl.c_decode_header[0].scratch |= ((uint64_t)1) << 32;
+        }
+        self->private_impl.c_decode_header[0].scratch++;
+        if ((uint32_t)(self->private_impl.c_decode_header[0].scratch) == 4) {
+          t_0 = !(self->private_impl.c_decode_header[0].scratch >> 32);
+          break;
+        }
       }
-      v_i += 1;
     }
-    if ((v_c[0] != 71) || (v_c[1] != 73) || (v_c[2] != 70) || (v_c[3] != 56) ||
-        ((v_c[4] != 55) && (v_c[4] != 57)) || (v_c[5] != 97)) {
+    if (!t_0) {
+      status = PUFFS_GIF__ERROR_BAD_GIF_HEADER;
+      goto exit;
+    }
+    {
+      PUFFS_BASE__COROUTINE_SUSPENSION_POINT(3);
+      if (PUFFS_BASE__UNLIKELY(b_rptr_src == b_rend_src)) {
+        goto short_read_src;
+      }
+      uint8_t t_1 = *b_rptr_src++;
+      v_c = t_1;
+    }
+    if ((v_c != 55) && (v_c != 57)) {
+      status = PUFFS_GIF__ERROR_BAD_GIF_HEADER;
+      goto exit;
+    }
+    PUFFS_BASE__COROUTINE_SUSPENSION_POINT(4);
+    bool t_2;
+    if (PUFFS_BASE__LIKELY(b_rend_src - b_rptr_src >= 1)) {
+      t_2 = !memcmp(b_rptr_src, "a", 1);
+      b_rptr_src += 1;
+    } else {
+      self->private_impl.c_decode_header[0].scratch = 0;
+      PUFFS_BASE__COROUTINE_SUSPENSION_POINT(5);
+      while (true) {
+        if (PUFFS_BASE__UNLIKELY(b_rptr_src == b_rend_src)) {
+          goto short_read_src;
+        }
+        if (*b_rptr_src++ !=
+            "a"[(uint32_t)(self->private_impl.c_decode_header[0].scratch)]) {
+          self->private_impl.c_decode_header[0].scratch |= ((uint64_t)1) << 32;
+        }
+        self->private_impl.c_decode_header[0].scratch++;
+        if ((uint32_t)(self->private_impl.c_decode_header[0].scratch) == 1) {
+          t_2 = !(self->private_impl.c_decode_header[0].scratch >> 32);
+          break;
+        }
+      }
+    }
+    if (!t_2) {
       status = PUFFS_GIF__ERROR_BAD_GIF_HEADER;
       goto exit;
     }
@@ -964,8 +1607,6 @@ static puffs_gif__status puffs_gif__decoder__decode_header(
   goto suspend;
 suspend:
   self->private_impl.c_decode_header[0].coro_susp_point = coro_susp_point;
-  memcpy(self->private_impl.c_decode_header[0].v_c, v_c, sizeof(v_c));
-  self->private_impl.c_decode_header[0].v_i = v_i;
 
 exit:
   if (a_src.buf) {
@@ -998,8 +1639,8 @@ static puffs_gif__status puffs_gif__decoder__decode_lsd(
   puffs_gif__status status = PUFFS_GIF__STATUS_OK;
 
   uint8_t v_c[7];
-  uint32_t v_i;
-  uint32_t v_gct_size;
+  uint32_t v_i = 0;
+  uint32_t v_gct_size = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -1119,8 +1760,8 @@ static puffs_gif__status puffs_gif__decoder__decode_extension(
     puffs_base__reader1 a_src) {
   puffs_gif__status status = PUFFS_GIF__STATUS_OK;
 
-  uint8_t v_label;
-  uint8_t v_block_size;
+  uint8_t v_label = 0;
+  uint8_t v_block_size = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -1141,7 +1782,6 @@ static puffs_gif__status puffs_gif__decoder__decode_extension(
   uint32_t coro_susp_point =
       self->private_impl.c_decode_extension[0].coro_susp_point;
   if (coro_susp_point) {
-    v_label = self->private_impl.c_decode_extension[0].v_label;
     v_block_size = self->private_impl.c_decode_extension[0].v_block_size;
   }
   switch (coro_susp_point) {
@@ -1155,13 +1795,18 @@ static puffs_gif__status puffs_gif__decoder__decode_extension(
       uint8_t t_0 = *b_rptr_src++;
       v_label = t_0;
     }
-    if (v_label == 1) {
-    } else if (v_label == 249) {
-    } else if (v_label == 254) {
-    } else if (v_label == 255) {
-    } else {
-      status = PUFFS_GIF__ERROR_BAD_GIF_EXTENSION_LABEL;
-      goto exit;
+    switch (v_label) {
+      case 1:
+      case 249:
+      case 254:
+      case 255: {
+      }
+        break;
+      default: {
+        status = PUFFS_GIF__ERROR_BAD_GIF_EXTENSION_LABEL;
+        goto exit;
+      }
+        break;
     }
     while (true) {
       {
@@ -1199,7 +1844,6 @@ static puffs_gif__status puffs_gif__decoder__decode_extension(
   goto suspend;
 suspend:
   self->private_impl.c_decode_extension[0].coro_susp_point = coro_susp_point;
-  self->private_impl.c_decode_extension[0].v_label = v_label;
   self->private_impl.c_decode_extension[0].v_block_size = v_block_size;
 
 exit:
@@ -1234,12 +1878,12 @@ static puffs_gif__status puffs_gif__decoder__decode_id(
   puffs_gif__status status = PUFFS_GIF__STATUS_OK;
 
   uint8_t v_c[9];
-  uint32_t v_i;
-  bool v_interlace;
-  uint8_t v_lw;
-  uint64_t v_block_size;
+  uint32_t v_i = 0;
+  bool v_interlace = 0;
+  uint8_t v_lw = 0;
+  uint64_t v_block_size = 0;
   puffs_base__reader1 v_r;
-  puffs_gif__status v_z;
+  puffs_gif__status v_z = 0;
 
   uint8_t* b_rptr_src = NULL;
   uint8_t* b_rstart_src = NULL;
@@ -1261,11 +1905,8 @@ static puffs_gif__status puffs_gif__decoder__decode_id(
   if (coro_susp_point) {
     memcpy(v_c, self->private_impl.c_decode_id[0].v_c, sizeof(v_c));
     v_i = self->private_impl.c_decode_id[0].v_i;
-    v_interlace = self->private_impl.c_decode_id[0].v_interlace;
-    v_lw = self->private_impl.c_decode_id[0].v_lw;
     v_block_size = self->private_impl.c_decode_id[0].v_block_size;
     v_r = ((puffs_base__reader1){});
-    v_z = self->private_impl.c_decode_id[0].v_z;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -1384,10 +2025,7 @@ suspend:
   self->private_impl.c_decode_id[0].coro_susp_point = coro_susp_point;
   memcpy(self->private_impl.c_decode_id[0].v_c, v_c, sizeof(v_c));
   self->private_impl.c_decode_id[0].v_i = v_i;
-  self->private_impl.c_decode_id[0].v_interlace = v_interlace;
-  self->private_impl.c_decode_id[0].v_lw = v_lw;
   self->private_impl.c_decode_id[0].v_block_size = v_block_size;
-  self->private_impl.c_decode_id[0].v_z = v_z;
 
 exit:
   if (a_src.buf) {
@@ -1447,18 +2085,17 @@ puffs_gif__status puffs_gif__lzw_decoder__decode(puffs_gif__lzw_decoder* self,
   }
   puffs_gif__status status = PUFFS_GIF__STATUS_OK;
 
-  uint32_t v_clear_code;
-  uint32_t v_end_code;
-  uint32_t v_save_code;
-  uint32_t v_prev_code;
-  uint32_t v_width;
-  uint32_t v_bits;
-  uint32_t v_n_bits;
-  uint32_t v_code;
-  uint32_t v_s;
-  uint32_t v_c;
+  uint32_t v_clear_code = 0;
+  uint32_t v_end_code = 0;
+  uint32_t v_save_code = 0;
+  uint32_t v_prev_code = 0;
+  uint32_t v_width = 0;
+  puffs_base__bitreader v_br;
+  uint32_t v_code = 0;
+  uint32_t v_s = 0;
+  uint32_t v_c = 0;
   puffs_base__slice_u8 v_expansion;
-  uint64_t v_n_copied;
+  uint64_t v_n_copied = 0;
 
   uint8_t* b_wptr_dst = NULL;
   uint8_t* b_wstart_dst = NULL;
@@ -1501,13 +2138,11 @@ puffs_gif__status puffs_gif__lzw_decoder__decode(puffs_gif__lzw_decoder* self,
     v_save_code = self->private_impl.c_decode[0].v_save_code;
     v_prev_code = self->private_impl.c_decode[0].v_prev_code;
     v_width = self->private_impl.c_decode[0].v_width;
-    v_bits = self->private_impl.c_decode[0].v_bits;
-    v_n_bits = self->private_impl.c_decode[0].v_n_bits;
+    v_br = self->private_impl.c_decode[0].v_br;
     v_code = self->private_impl.c_decode[0].v_code;
     v_s = self->private_impl.c_decode[0].v_s;
     v_c = self->private_impl.c_decode[0].v_c;
     v_expansion = ((puffs_base__slice_u8){});
-    v_n_copied = self->private_impl.c_decode[0].v_n_copied;
   }
   switch (coro_susp_point) {
     PUFFS_BASE__COROUTINE_SUSPENSION_POINT_0;
@@ -1517,24 +2152,22 @@ puffs_gif__status puffs_gif__lzw_decoder__decode(puffs_gif__lzw_decoder* self,
     v_save_code = v_end_code;
     v_prev_code = 0;
     v_width = (self->private_impl.f_literal_width + 1);
-    v_bits = 0;
-    v_n_bits = 0;
+    v_br = ((puffs_base__bitreader){});
   label_0_continue:;
     while (true) {
-      while (v_n_bits < v_width) {
-        {
-          PUFFS_BASE__COROUTINE_SUSPENSION_POINT(1);
-          if (PUFFS_BASE__UNLIKELY(b_rptr_src == b_rend_src)) {
-            goto short_read_src;
-          }
-          uint8_t t_0 = *b_rptr_src++;
-          v_bits |= (((uint32_t)(t_0)) << v_n_bits);
+      PUFFS_BASE__COROUTINE_SUSPENSION_POINT(1);
+      while (v_br.n_bits < v_width) {
+        if (PUFFS_BASE__LIKELY(b_rend_src - b_rptr_src >= 8)) {
+          b_rptr_src +=
+              puffs_base__bitreader__refill_fast(&v_br, b_rptr_src, v_width);
+          break;
+        }
+        if (PUFFS_BASE__UNLIKELY(b_rptr_src == b_rend_src)) {
+          goto short_read_src;
         }
-        v_n_bits += 8;
+        puffs_base__bitreader__refill_u8(&v_br, *b_rptr_src++);
       }
-      v_code = ((v_bits) & ((1 << (v_width)) - 1));
-      v_bits >>= v_width;
-      v_n_bits -= v_width;
+      v_code = puffs_base__bitreader__take_bits(&v_br, v_width);
       if (v_code < v_clear_code) {
         PUFFS_BASE__COROUTINE_SUSPENSION_POINT(2);
         if (b_wptr_dst == b_wend_dst) {
@@ -1622,12 +2255,10 @@ suspend:
   self->private_impl.c_decode[0].v_save_code = v_save_code;
   self->private_impl.c_decode[0].v_prev_code = v_prev_code;
   self->private_impl.c_decode[0].v_width = v_width;
-  self->private_impl.c_decode[0].v_bits = v_bits;
-  self->private_impl.c_decode[0].v_n_bits = v_n_bits;
+  self->private_impl.c_decode[0].v_br = v_br;
   self->private_impl.c_decode[0].v_code = v_code;
   self->private_impl.c_decode[0].v_s = v_s;
   self->private_impl.c_decode[0].v_c = v_c;
-  self->private_impl.c_decode[0].v_n_copied = v_n_copied;
 
 exit:
   if (a_dst.buf) {
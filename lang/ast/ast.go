@@ -35,7 +35,9 @@ const (
 	KArg
 	KAssert
 	KAssign
+	KCase
 	KConst
+	KEnum
 	KExpr
 	KField
 	KFile
@@ -47,7 +49,9 @@ const (
 	KReturn
 	KStatus
 	KStruct
+	KSwitch
 	KTypeExpr
+	KUnion
 	KUse
 	KVar
 	KWhile
@@ -66,7 +70,9 @@ var kindStrings = [...]string{
 	KArg:       "KArg",
 	KAssert:    "KAssert",
 	KAssign:    "KAssign",
+	KCase:      "KCase",
 	KConst:     "KConst",
+	KEnum:      "KEnum",
 	KExpr:      "KExpr",
 	KField:     "KField",
 	KFile:      "KFile",
@@ -78,7 +84,9 @@ var kindStrings = [...]string{
 	KReturn:    "KReturn",
 	KStatus:    "KStatus",
 	KStruct:    "KStruct",
+	KSwitch:    "KSwitch",
 	KTypeExpr:  "KTypeExpr",
+	KUnion:     "KUnion",
 	KUse:       "KUse",
 	KVar:       "KVar",
 	KWhile:     "KWhile",
@@ -96,6 +104,7 @@ const (
 	FlagsHasBreak        = Flags(0x00000040)
 	FlagsHasContinue     = Flags(0x00000080)
 	FlagsGlobalIdent     = Flags(0x00000100)
+	FlagsConst           = Flags(0x00000200)
 )
 
 // flagsThatMatterForEq is the bitwise or of all flags that matter for the
@@ -150,7 +159,9 @@ func (n *Node) SetTypeChecked()   { n.flags |= FlagsTypeChecked }
 func (n *Node) Arg() *Arg             { return (*Arg)(n) }
 func (n *Node) Assert() *Assert       { return (*Assert)(n) }
 func (n *Node) Assign() *Assign       { return (*Assign)(n) }
+func (n *Node) Case() *Case           { return (*Case)(n) }
 func (n *Node) Const() *Const         { return (*Const)(n) }
+func (n *Node) Enum() *Enum           { return (*Enum)(n) }
 func (n *Node) Expr() *Expr           { return (*Expr)(n) }
 func (n *Node) Field() *Field         { return (*Field)(n) }
 func (n *Node) File() *File           { return (*File)(n) }
@@ -163,7 +174,9 @@ func (n *Node) Raw() *Raw             { return (*Raw)(n) }
 func (n *Node) Return() *Return       { return (*Return)(n) }
 func (n *Node) Status() *Status       { return (*Status)(n) }
 func (n *Node) Struct() *Struct       { return (*Struct)(n) }
+func (n *Node) Switch() *Switch       { return (*Switch)(n) }
 func (n *Node) TypeExpr() *TypeExpr   { return (*TypeExpr)(n) }
+func (n *Node) Union() *Union         { return (*Union)(n) }
 func (n *Node) Use() *Use             { return (*Use)(n) }
 func (n *Node) Var() *Var             { return (*Var)(n) }
 func (n *Node) While() *While         { return (*While)(n) }
@@ -232,7 +245,7 @@ const MaxExprDepth = 255
 //  - FlagsSuspendible     is if it or a sub-expr is FlagsCallSuspendible
 //  - FlagsCallImpure      is "f(x)" vs "f!(x)"
 //  - FlagsCallSuspendible is "f(x)" vs "f?(x)", it implies FlagsCallImpure
-//  - ID0:   <0|operator|IDOpenParen|IDOpenBracket|IDColon|IDDot>
+//  - ID0:   <0|operator|IDOpenParen|IDOpenBracket|IDColon|IDDot|IDDotDot>
 //  - ID1:   <0|ident|literal>
 //  - LHS:   <nil|Expr>
 //  - MHS:   <nil|Expr>
@@ -262,6 +275,8 @@ const MaxExprDepth = 255
 //
 // For lists, like "$(0, 1, 2)", ID0 is IDDollar.
 //
+// For switch case ranges, like "LHS..RHS", ID0 is IDDotDot.
+//
 // For statuses, like `error "foo"` and `"suspension "bar"`, ID0 is the keyword
 // and ID1 is the message.
 type Expr Node
@@ -316,8 +331,9 @@ func NewExpr(flags Flags, operator t.ID, nameLiteralSelector t.ID, lhs *Node, mh
 	}
 }
 
-// Assert is "assert RHS via ID1(args)", "pre etc", "inv etc" or "post etc":
-//  - ID0:   <IDAssert|IDPre|IDInv|IDPost>
+// Assert is "assert RHS via ID1(args)", "pre etc", "inv etc", "dec etc" or
+// "post etc":
+//  - ID0:   <IDAssert|IDPre|IDInv|IDDec|IDPost>
 //  - ID1:   <string literal> reason
 //  - RHS:   <Expr>
 //  - List0: <Arg> reason arguments
@@ -460,6 +476,9 @@ func NewIterate(label t.ID, unrollCount *Expr, variables []*Node, asserts []*Nod
 //  - List1: <Assert> asserts
 //  - List2: <Statement> loop body
 //
+// At most one of the asserts is a "dec etc" termination measure. Its RHS is
+// an integer-typed expression, not a boolean-typed condition.
+//
 // TODO: should we be able to unroll while loops too?
 type While Node
 
@@ -471,6 +490,16 @@ func (n *While) Condition() *Expr  { return n.mhs.Expr() }
 func (n *While) Asserts() []*Node  { return n.list1 }
 func (n *While) Body() []*Node     { return n.list2 }
 
+// Measure returns the "dec etc" termination measure, or nil if there is none.
+func (n *While) Measure() *Expr {
+	for _, o := range n.list1 {
+		if o := o.Assert(); o.Keyword().Key() == t.KeyDec {
+			return o.Condition()
+		}
+	}
+	return nil
+}
+
 func (n *While) SetHasBreak()    { n.flags |= FlagsHasBreak }
 func (n *While) SetHasContinue() { n.flags |= FlagsHasContinue }
 
@@ -507,6 +536,52 @@ func NewIf(condition *Expr, elseIf *If, bodyIfTrue []*Node, bodyIfFalse []*Node)
 	}
 }
 
+// Switch is "switch MHS { List0 }" or "switch MHS { List0 else { List1 } }":
+//  - ID0:   <0|IDElse>
+//  - MHS:   <Expr> scrutinee
+//  - List0: <Case> cases
+//  - List1: <Statement> else body
+//
+// Without an else, the cases must cover every value of the scrutinee's type.
+type Switch Node
+
+func (n *Switch) Node() *Node         { return (*Node)(n) }
+func (n *Switch) HasElse() bool       { return n.id0 != 0 }
+func (n *Switch) Scrutinee() *Expr    { return n.mhs.Expr() }
+func (n *Switch) Cases() []*Node      { return n.list0 }
+func (n *Switch) BodyIfElse() []*Node { return n.list1 }
+
+func NewSwitch(scrutinee *Expr, cases []*Node, hasElse bool, bodyIfElse []*Node) *Switch {
+	id0 := t.ID(0)
+	if hasElse {
+		id0 = t.IDElse
+	}
+	return &Switch{
+		kind:  KSwitch,
+		id0:   id0,
+		mhs:   scrutinee.Node(),
+		list0: cases,
+		list1: bodyIfElse,
+	}
+}
+
+// Case is "case List0 { List1 }", an arm of a switch:
+//  - List0: <Expr> values, such as "0x21" or "0x80..0xFF"
+//  - List1: <Statement> body
+type Case Node
+
+func (n *Case) Node() *Node     { return (*Node)(n) }
+func (n *Case) Values() []*Node { return n.list0 }
+func (n *Case) Body() []*Node   { return n.list1 }
+
+func NewCase(values []*Node, body []*Node) *Case {
+	return &Case{
+		kind:  KCase,
+		list0: values,
+		list1: body,
+	}
+}
+
 // Return is "return LHS":
 //  - LHS:   <nil|Expr>
 type Return Node
@@ -606,6 +681,11 @@ func (n *TypeExpr) IsUnsignedInteger() bool {
 		n.id1.Key() == t.KeyU32 || n.id1.Key() == t.KeyU64) // TODO: t.KeyUsize?
 }
 
+func (n *TypeExpr) IsSignedInteger() bool {
+	return n.id0 == 0 && (n.id1.Key() == t.KeyI8 || n.id1.Key() == t.KeyI16 ||
+		n.id1.Key() == t.KeyI32 || n.id1.Key() == t.KeyI64)
+}
+
 func (n *TypeExpr) HasPointers() bool {
 	for ; n != nil; n = n.Inner() {
 		switch n.id0.Key() {
@@ -621,6 +701,13 @@ func (n *TypeExpr) HasPointers() bool {
 	return false
 }
 
+// Resolve replaces, in place, a named type such as an enum "block_type" with
+// the type that it stands for, such as "u32[0..2]". An enum type is an alias,
+// not a distinct type.
+func (n *TypeExpr) Resolve(o *TypeExpr) {
+	n.id0, n.id1, n.lhs, n.mhs, n.rhs = o.id0, o.id1, o.lhs, o.mhs, o.rhs
+}
+
 func (n *TypeExpr) Unrefined() *TypeExpr {
 	if !n.IsRefined() {
 		return n
@@ -649,6 +736,7 @@ const MaxBodyDepth = 255
 //  - FlagsImpure      is "ID1" vs "ID1!"
 //  - FlagsSuspendible is "ID1" vs "ID1?", it implies FlagsImpure
 //  - FlagsPublic      is "pub" vs "pri"
+//  - FlagsConst       is "const func" vs "func"
 //  - ID0:   <0|receiver>
 //  - ID1:   name
 //  - LHS:   <Struct> in-parameters
@@ -673,6 +761,7 @@ func (n *Func) Pure() bool        { return n.flags&FlagsImpure == 0 }
 func (n *Func) Impure() bool      { return n.flags&FlagsImpure != 0 }
 func (n *Func) Suspendible() bool { return n.flags&FlagsSuspendible != 0 }
 func (n *Func) Public() bool      { return n.flags&FlagsPublic != 0 }
+func (n *Func) Const() bool       { return n.flags&FlagsConst != 0 }
 func (n *Func) Filename() string  { return n.filename }
 func (n *Func) Line() uint32      { return n.line }
 func (n *Func) QID() t.QID        { return t.QID{n.id0, n.id1} }
@@ -749,6 +838,33 @@ func NewConst(flags Flags, filename string, line uint32, name t.ID, xType *TypeE
 	}
 }
 
+// Enum is "enum ID1 LHS(List0)":
+//  - FlagsPublic      is "pub" vs "pri"
+//  - ID1:   name
+//  - LHS:   <TypeExpr> backing integer type
+//  - List0: <Arg> members, each "name = value"
+type Enum Node
+
+func (n *Enum) Node() *Node      { return (*Node)(n) }
+func (n *Enum) Public() bool     { return n.flags&FlagsPublic != 0 }
+func (n *Enum) Filename() string { return n.filename }
+func (n *Enum) Line() uint32     { return n.line }
+func (n *Enum) Name() t.ID       { return n.id1 }
+func (n *Enum) XType() *TypeExpr { return n.lhs.TypeExpr() }
+func (n *Enum) Members() []*Node { return n.list0 }
+
+func NewEnum(flags Flags, filename string, line uint32, name t.ID, xType *TypeExpr, members []*Node) *Enum {
+	return &Enum{
+		kind:     KEnum,
+		flags:    flags,
+		filename: filename,
+		line:     line,
+		id1:      name,
+		lhs:      xType.Node(),
+		list0:    members,
+	}
+}
+
 // Struct is "struct ID1(List0)":
 //  - FlagsSuspendible is "ID1" vs "ID1?"
 //  - FlagsPublic      is "pub" vs "pri"
@@ -775,6 +891,33 @@ func NewStruct(flags Flags, filename string, line uint32, name t.ID, fields []*N
 	}
 }
 
+// Union is "union ID1(List0)":
+//  - FlagsPublic      is "pub" vs "pri"
+//  - ID1:   name
+//  - List0: <Struct> variants
+//
+// A union value holds exactly one of its variants at a time, as selected by its
+// implicit "kind" tag. Field names are unique across all of the variants.
+type Union Node
+
+func (n *Union) Node() *Node       { return (*Node)(n) }
+func (n *Union) Public() bool      { return n.flags&FlagsPublic != 0 }
+func (n *Union) Filename() string  { return n.filename }
+func (n *Union) Line() uint32      { return n.line }
+func (n *Union) Name() t.ID        { return n.id1 }
+func (n *Union) Variants() []*Node { return n.list0 }
+
+func NewUnion(flags Flags, filename string, line uint32, name t.ID, variants []*Node) *Union {
+	return &Union{
+		kind:     KUnion,
+		flags:    flags,
+		filename: filename,
+		line:     line,
+		id1:      name,
+		list0:    variants,
+	}
+}
+
 // PackageID is "packageid ID1":
 //  - ID1:   <string literal> package ID
 type PackageID Node
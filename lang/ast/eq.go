@@ -45,7 +45,7 @@ func (n *Expr) Eq(o *Expr) bool {
 		return false
 	}
 
-	if n.id0.Key() == t.KeyXBinaryAs {
+	if k := n.id0.Key(); k == t.KeyXBinaryAs || k == t.KeyXBinaryTildeAs {
 		if !n.rhs.TypeExpr().Eq(o.rhs.TypeExpr()) {
 			return false
 		}
@@ -71,7 +71,8 @@ func (n *Expr) Mentions(o *Expr) bool {
 	if n.Eq(o) ||
 		n.lhs.Expr().Mentions(o) ||
 		n.mhs.Expr().Mentions(o) ||
-		(n.id0.Key() != t.KeyXBinaryAs && n.rhs.Expr().Mentions(o)) {
+		(n.id0.Key() != t.KeyXBinaryAs && n.id0.Key() != t.KeyXBinaryTildeAs &&
+			n.rhs.Expr().Mentions(o)) {
 		return true
 	}
 	for _, x := range n.list0 {
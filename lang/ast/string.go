@@ -50,6 +50,8 @@ func (n *Expr) appendString(buf []byte, tm *t.Map, parenthesize bool, depth uint
 				buf = n.lhs.Expr().appendString(buf, tm, true, depth)
 				if n.flags&FlagsSuspendible != 0 {
 					buf = append(buf, '?')
+				} else if n.flags&FlagsCallImpure != 0 {
+					buf = append(buf, '!')
 				}
 				buf = append(buf, '(')
 				for i, o := range n.list0 {
@@ -81,6 +83,11 @@ func (n *Expr) appendString(buf []byte, tm *t.Map, parenthesize bool, depth uint
 				buf = append(buf, '.')
 				buf = append(buf, tm.ByID(n.id1)...)
 
+			case t.KeyDotDot:
+				buf = n.lhs.Expr().appendString(buf, tm, true, depth)
+				buf = append(buf, ".."...)
+				buf = n.rhs.Expr().appendString(buf, tm, true, depth)
+
 			case t.KeyDollar:
 				buf = append(buf, "$("...)
 				for i, o := range n.list0 {
@@ -102,7 +109,7 @@ func (n *Expr) appendString(buf []byte, tm *t.Map, parenthesize bool, depth uint
 			}
 			buf = n.lhs.Expr().appendString(buf, tm, true, depth)
 			buf = append(buf, opStrings[0xFF&n.id0.Key()]...)
-			if n.id0.Key() == t.KeyXBinaryAs {
+			if k := n.id0.Key(); k == t.KeyXBinaryAs || k == t.KeyXBinaryTildeAs {
 				buf = append(buf, n.rhs.TypeExpr().String(tm)...)
 			} else {
 				buf = n.rhs.Expr().appendString(buf, tm, true, depth)
@@ -159,6 +166,13 @@ var opStrings = [256]string{
 	t.KeyXBinaryAs:          " as ",
 	t.KeyXBinaryTildePlus:   " ~+ ",
 
+	t.KeyXBinaryTildeMinus:    " ~- ",
+	t.KeyXBinaryTildeStar:     " ~* ",
+	t.KeyXBinaryTildeShiftL:   " ~<< ",
+	t.KeyXBinaryTildeSatPlus:  " ~sat+ ",
+	t.KeyXBinaryTildeSatMinus: " ~sat- ",
+	t.KeyXBinaryTildeAs:       " ~as ",
+
 	t.KeyXAssociativePlus: " + ",
 	t.KeyXAssociativeStar: " * ",
 	t.KeyXAssociativeAmp:  " & ",
@@ -33,6 +33,10 @@ func TestString(t *testing.T) {
 		"f(a:i, b:j) + 1",
 		"f(a:i, b:j)(c:k)",
 		"f(a:i, b:j)(c:k, d:l, e:m + 2) + 3",
+		"f!()",
+		"f?()",
+		"x.y!(a:i)",
+		"x.y?(a:i) + 1",
 
 		"x[i]",
 		"x[i][j]",
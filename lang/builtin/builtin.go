@@ -16,6 +16,8 @@
 package builtin
 
 import (
+	"fmt"
+
 	t "github.com/google/puffs/lang/token"
 )
 
@@ -56,6 +58,70 @@ func init() {
 	}
 }
 
+// IOMethod is a built-in reader1 or writer1 method that peeks at, reads or
+// writes a fixed width integer, such as "read_u16be" or "write_i32le".
+type IOMethod struct {
+	Name string
+
+	// Op is "peek", "read" or "write". Peeking is like reading, except that it
+	// does not advance the reader1.
+	Op string
+
+	Signed    bool
+	NBytes    uint32
+	BigEndian bool
+}
+
+// TypeName is the Puffs type of the integer peeked at, read or written, such
+// as "u16" or "i32". The 3 byte methods use 32 bit types.
+func (m IOMethod) TypeName() string {
+	nBits := 8 * m.NBytes
+	if nBits == 24 {
+		nBits = 32
+	}
+	if m.Signed {
+		return fmt.Sprintf("i%d", nBits)
+	}
+	return fmt.Sprintf("u%d", nBits)
+}
+
+// IOMethodMap maps from names such as "read_u16be" to their IOMethod.
+//
+// TODO: 64 bit methods. The generated C code's slow path for reads packs a
+// byte count into its 64 bit scratch value, alongside the bytes read so far.
+var IOMethodMap = map[string]IOMethod{}
+
+func init() {
+	for _, op := range [...]string{"peek", "read", "write"} {
+		for _, typ := range [...]string{"u8", "i8", "u16", "i16", "u24", "u32", "i32"} {
+			m := IOMethod{
+				Op:     op,
+				Signed: typ[0] == 'i',
+			}
+			switch typ[1:] {
+			case "8":
+				m.NBytes = 1
+			case "16":
+				m.NBytes = 2
+			case "24":
+				m.NBytes = 3
+			case "32":
+				m.NBytes = 4
+			}
+			if m.NBytes == 1 {
+				m.Name = op + "_" + typ
+				IOMethodMap[m.Name] = m
+				continue
+			}
+			for _, endianness := range [...]string{"be", "le"} {
+				m.Name = op + "_" + typ + endianness
+				m.BigEndian = endianness == "be"
+				IOMethodMap[m.Name] = m
+			}
+		}
+	}
+}
+
 func TrimQuotes(s string) string {
 	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
 		return s[1 : len(s)-1]
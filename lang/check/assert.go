@@ -243,7 +243,7 @@ func parseBinaryOp(n *a.Expr) (op t.ID, lhs *a.Expr, rhs *a.Expr) {
 		return 0, nil, nil
 	}
 	op = n.ID0()
-	if op.Key() == t.KeyAs {
+	if op.Key() == t.KeyXBinaryAs || op.Key() == t.KeyXBinaryTildeAs {
 		return 0, nil, nil
 	}
 	return op, n.LHS().Expr(), n.RHS().Expr()
@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/google/puffs/lang/builtin"
+
 	a "github.com/google/puffs/lang/ast"
 	t "github.com/google/puffs/lang/token"
 )
@@ -222,7 +224,7 @@ func (q *checker) bcheckBlock(block []*a.Node) error {
 		if err := q.bcheckStatement(o); err != nil {
 			return err
 		}
-		if k := o.Kind(); k == a.KJump || k == a.KReturn {
+		if k := o.Kind(); k == a.KJump || (k == a.KReturn && !isSuspension(o)) {
 			break
 		}
 	}
@@ -266,7 +268,7 @@ func (q *checker) bcheckStatement(n *a.Node) error {
 			skip = t.KeyPre
 		}
 		for _, o := range n.JumpTarget().Asserts() {
-			if o.Assert().Keyword().Key() == skip {
+			if k := o.Assert().Keyword().Key(); k == skip || k == t.KeyDec {
 				continue
 			}
 			if err := q.bcheckAssert(o.Assert()); err != nil {
@@ -277,7 +279,14 @@ func (q *checker) bcheckStatement(n *a.Node) error {
 		return nil
 
 	case a.KReturn:
-		// TODO.
+		if isSuspension(n) {
+			// The coroutine resumes after returning a suspension, so checking
+			// continues with the next statement.
+			return q.dropSuspensionFacts()
+		}
+
+	case a.KSwitch:
+		return q.bcheckSwitch(n.Switch())
 
 	case a.KVar:
 		return q.bcheckVar(n.Var())
@@ -329,7 +338,8 @@ func (q *checker) bcheckAssert(n *a.Assert) error {
 		} else {
 			err = fmt.Errorf("no such reason %s", reasonID.String(q.tm))
 		}
-	} else if condition.ID0().IsBinaryOp() && condition.ID0().Key() != t.KeyAs {
+	} else if k := condition.ID0().Key(); condition.ID0().IsBinaryOp() &&
+		k != t.KeyXBinaryAs && k != t.KeyXBinaryTildeAs {
 		err = q.proveBinaryOp(condition.ID0().Key(), condition.LHS().Expr(), condition.RHS().Expr())
 	}
 
@@ -353,9 +363,14 @@ func (q *checker) bcheckAssignment(lhs *a.Expr, op t.ID, rhs *a.Expr) error {
 	}
 	// TODO: check lhs and rhs are pure expressions.
 	if op == t.IDEq {
-		// Drop any facts involving lhs.
+		// Drop any facts involving lhs. Assigning to a union's tag also zeroes
+		// the union's fields, so drop any facts involving the union too.
+		dropped := lhs
+		if _, ok := q.unionForTag(lhs); ok {
+			dropped = lhs.LHS().Expr()
+		}
 		if err := q.facts.update(func(x *a.Expr) (*a.Expr, error) {
-			if x.Mentions(lhs) {
+			if x.Mentions(dropped) {
 				return nil, nil
 			}
 			return x, nil
@@ -467,7 +482,9 @@ func (q *checker) bcheckAssignment1(lhs *a.Expr, op t.ID, rhs *a.Expr) error {
 
 // terminates returns whether a block of statements terminates. In other words,
 // whether the block is non-empty and its final statement is a "return",
-// "break", "continue" or an "if-else" chain where all branches terminate.
+// "break", "continue" or an "if-else" chain or "switch" where all branches
+// terminate. Returning a suspension does not terminate, as the coroutine
+// resumes after it.
 //
 // TODO: strengthen this to include "while" statements? For inspiration, the Go
 // spec has https://golang.org/ref/spec#Terminating_statements
@@ -475,7 +492,9 @@ func terminates(body []*a.Node) bool {
 	if len(body) > 0 {
 		n := body[len(body)-1]
 		switch n.Kind() {
-		case a.KReturn, a.KJump:
+		case a.KReturn:
+			return !isSuspension(n)
+		case a.KJump:
 			return true
 		case a.KIf:
 			n := n.If()
@@ -492,6 +511,14 @@ func terminates(body []*a.Node) bool {
 					return len(bif) > 0
 				}
 			}
+		case a.KSwitch:
+			n := n.Switch()
+			for _, c := range n.Cases() {
+				if !terminates(c.Case().Body()) {
+					return false
+				}
+			}
+			return !n.HasElse() || terminates(n.BodyIfElse())
 		}
 		return false
 	}
@@ -579,10 +606,99 @@ func (q *checker) bcheckIf(n *a.If) error {
 	return q.unify(branches)
 }
 
+func (q *checker) bcheckSwitch(n *a.Switch) error {
+	scrutinee := n.Scrutinee()
+	if _, _, err := q.bcheckExpr(scrutinee, 0); err != nil {
+		return err
+	}
+	sMin, sMax, err := q.bcheckTypeExpr(scrutinee.MType())
+	if err != nil {
+		return err
+	}
+
+	// Check that the case values are within the scrutinee's bounds and, if
+	// there is no else, that they cover every value within those bounds.
+	next := sMin
+	for _, r := range caseRanges(n) {
+		if (sMin != nil && r.lo.Cmp(sMin) < 0) || (sMax != nil && r.hi.Cmp(sMax) > 0) {
+			return fmt.Errorf("check: case value %q is not within bounds [%v..%v]",
+				r.value.String(q.tm), sMin, sMax)
+		}
+		if !n.HasElse() && next != nil && r.lo.Cmp(next) > 0 {
+			return fmt.Errorf("check: switch on %q does not cover %v and has no else",
+				scrutinee.String(q.tm), next)
+		}
+		next = add1(r.hi)
+	}
+	if !n.HasElse() && sMax != nil && next.Cmp(sMax) <= 0 {
+		return fmt.Errorf("check: switch on %q does not cover %v and has no else",
+			scrutinee.String(q.tm), next)
+	}
+
+	branches := [][]*a.Expr(nil)
+	snap := snapshot(q.facts)
+	for _, c := range n.Cases() {
+		c := c.Case()
+		q.facts = append(q.facts[:0], snap...)
+		// Check the case body, assuming "x == k" for a single value k or
+		// "lo <= x" and "x <= hi" for the hull of multiple values or ranges.
+		if scrutinee.Pure() {
+			for _, o := range caseFacts(scrutinee, c.Values()) {
+				q.facts.appendFact(o)
+			}
+		}
+		if err := q.bcheckBlock(c.Body()); err != nil {
+			return err
+		}
+		if !terminates(c.Body()) {
+			branches = append(branches, snapshot(q.facts))
+		}
+	}
+	if n.HasElse() {
+		q.facts = append(q.facts[:0], snap...)
+		if err := q.bcheckBlock(n.BodyIfElse()); err != nil {
+			return err
+		}
+		if !terminates(n.BodyIfElse()) {
+			branches = append(branches, snapshot(q.facts))
+		}
+	}
+	return q.unify(branches)
+}
+
+// caseFacts returns the facts that hold within a switch case's body.
+func caseFacts(scrutinee *a.Expr, values []*a.Node) []*a.Expr {
+	lo, hi := (*a.Expr)(nil), (*a.Expr)(nil)
+	for _, o := range values {
+		o := o.Expr()
+		oLo, oHi := o, o
+		if o.ID0().Key() == t.KeyDotDot {
+			oLo, oHi = o.LHS().Expr(), o.RHS().Expr()
+		}
+		if lo == nil || oLo.ConstValue().Cmp(lo.ConstValue()) < 0 {
+			lo = oLo
+		}
+		if hi == nil || oHi.ConstValue().Cmp(hi.ConstValue()) > 0 {
+			hi = oHi
+		}
+	}
+	if lo == hi {
+		o := a.NewExpr(a.FlagsTypeChecked, t.IDXBinaryEqEq, 0, scrutinee.Node(), nil, lo.Node(), nil)
+		o.SetMType(typeExprBool)
+		return []*a.Expr{o}
+	}
+	o0 := a.NewExpr(a.FlagsTypeChecked, t.IDXBinaryGreaterEq, 0, scrutinee.Node(), nil, lo.Node(), nil)
+	o0.SetMType(typeExprBool)
+	o1 := a.NewExpr(a.FlagsTypeChecked, t.IDXBinaryLessEq, 0, scrutinee.Node(), nil, hi.Node(), nil)
+	o1.SetMType(typeExprBool)
+	return []*a.Expr{o0, o1}
+}
+
 func (q *checker) bcheckWhile(n *a.While) error {
-	// Check the pre and inv conditions on entry.
+	// Check the pre and inv conditions on entry. The dec measure, if any, is
+	// not a condition. It is checked by proveTermination.
 	for _, o := range n.Asserts() {
-		if o.Assert().Keyword().Key() == t.KeyPost {
+		if k := o.Assert().Keyword().Key(); k == t.KeyPost || k == t.KeyDec {
 			continue
 		}
 		if err := q.bcheckAssert(o.Assert()); err != nil {
@@ -611,7 +727,7 @@ func (q *checker) bcheckWhile(n *a.While) error {
 	} else {
 		q.facts = q.facts[:0]
 		for _, o := range n.Asserts() {
-			if o.Assert().Keyword().Key() == t.KeyPost {
+			if k := o.Assert().Keyword().Key(); k == t.KeyPost || k == t.KeyDec {
 				continue
 			}
 			q.facts.appendFact(o.Assert().Condition())
@@ -637,7 +753,7 @@ func (q *checker) bcheckWhile(n *a.While) error {
 		// Assume the pre and inv conditions...
 		q.facts = q.facts[:0]
 		for _, o := range n.Asserts() {
-			if o.Assert().Keyword().Key() == t.KeyPost {
+			if k := o.Assert().Keyword().Key(); k == t.KeyPost || k == t.KeyDec {
 				continue
 			}
 			q.facts.appendFact(o.Assert().Condition())
@@ -654,7 +770,7 @@ func (q *checker) bcheckWhile(n *a.While) error {
 		// body.
 		if !terminates(n.Body()) {
 			for _, o := range n.Asserts() {
-				if o.Assert().Keyword().Key() == t.KeyPost {
+				if k := o.Assert().Keyword().Key(); k == t.KeyPost || k == t.KeyDec {
 					continue
 				}
 				if err := q.bcheckAssert(o.Assert()); err != nil {
@@ -667,7 +783,7 @@ func (q *checker) bcheckWhile(n *a.While) error {
 	// Assume the inv and post conditions.
 	q.facts = q.facts[:0]
 	for _, o := range n.Asserts() {
-		if o.Assert().Keyword().Key() == t.KeyPre {
+		if k := o.Assert().Keyword().Key(); k == t.KeyPre || k == t.KeyDec {
 			continue
 		}
 		q.facts.appendFact(o.Assert().Condition())
@@ -703,6 +819,11 @@ func (q *checker) bcheckExpr(n *a.Expr, depth uint32) (*big.Int, *big.Int, error
 	if err != nil {
 		return nil, nil, err
 	}
+	if n.CallImpure() {
+		if err := q.dropUnionTagFacts(callRoots(n)); err != nil {
+			return nil, nil, err
+		}
+	}
 	nMin, nMax, err = q.facts.refine(n, nMin, nMax, q.tm)
 	if err != nil {
 		return nil, nil, err
@@ -731,8 +852,36 @@ func (q *checker) bcheckExpr1(n *a.Expr, depth uint32) (*big.Int, *big.Int, erro
 	case t.FlagsUnaryOp:
 		return q.bcheckExprUnaryOp(n, depth)
 	case t.FlagsBinaryOp:
-		if n.ID0().Key() == t.KeyXBinaryAs {
-			return q.bcheckExpr(n.LHS().Expr(), depth)
+		switch n.ID0().Key() {
+		case t.KeyXBinaryAs:
+			lMin, lMax, err := q.bcheckExpr(n.LHS().Expr(), depth)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Converting to a refined type, such as an enum type, must be
+			// proven to stay within that type's bounds.
+			if rTyp := n.RHS().TypeExpr(); rTyp.IsRefined() {
+				rMin, rMax, err := q.bcheckTypeExpr(rTyp)
+				if err != nil {
+					return nil, nil, err
+				}
+				if lMin == nil || lMax == nil || lMin.Cmp(rMin) < 0 || lMax.Cmp(rMax) > 0 {
+					return nil, nil, fmt.Errorf("check: cannot convert expression %q, with bounds [%v..%v], "+
+						"as type %q", n.LHS().Expr().String(q.tm), lMin, lMax, rTyp.String(q.tm))
+				}
+			}
+			return lMin, lMax, nil
+		case t.KeyXBinaryTildeAs:
+			lMin, lMax, err := q.bcheckExpr(n.LHS().Expr(), depth)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Truncation is a no-op if the value already fits.
+			b := numTypeBounds[n.RHS().TypeExpr().Name().Key()]
+			if lMin.Cmp(b[0]) >= 0 && lMax.Cmp(b[1]) <= 0 {
+				return lMin, lMax, nil
+			}
+			return b[0], b[1], nil
 		}
 		return q.bcheckExprBinaryOp(n.ID0().Key(), n.LHS().Expr(), n.RHS().Expr(), depth)
 	case t.FlagsAssociativeOp:
@@ -752,16 +901,20 @@ func (q *checker) bcheckExprOther(n *a.Expr, depth uint32) (*big.Int, *big.Int,
 			return nil, nil, err
 		}
 
-		// TODO: delete this hack that only matches "in.src.read_u8?()" etc.
-		if isInSrc(q.tm, n, t.KeyReadU8, 0) || isInSrc(q.tm, n, t.KeyUnreadU8, 0) ||
-			isInSrc(q.tm, n, t.KeyReadU16BE, 0) || isInSrc(q.tm, n, t.KeyReadU32BE, 0) ||
-			isInSrc(q.tm, n, t.KeyReadU32BE, 0) || isInSrc(q.tm, n, t.KeyReadU32LE, 0) ||
+		// TODO: delete this hack that only matches "in.src.read_u16be?()" etc.
+		if m, ok := ioMethod(q.tm, n); ok {
+			return q.bcheckIOMethod(n, m, depth)
+		}
+
+		// TODO: delete this hack that only matches "in.src.unread_u8?()" etc.
+		if isInSrc(q.tm, n, t.KeyUnreadU8, 0) ||
 			isInSrc(q.tm, n, t.KeySkip32, 1) || isInSrc(q.tm, n, t.KeySinceMark, 0) ||
-			isInSrc(q.tm, n, t.KeyMark, 0) || isInSrc(q.tm, n, t.KeyLimit, 1) ||
+			isInSrc(q.tm, n, t.KeyMark, 0) ||
 			isInDst(q.tm, n, t.KeyCopyFromSlice, 1) || isInDst(q.tm, n, t.KeyCopyFromSlice32, 2) ||
 			isInDst(q.tm, n, t.KeyCopyFromReader32, 2) || isInDst(q.tm, n, t.KeyCopyFromHistory32, 2) ||
-			isInDst(q.tm, n, t.KeyWriteU8, 1) || isInDst(q.tm, n, t.KeySinceMark, 0) ||
+			isInDst(q.tm, n, t.KeySinceMark, 0) ||
 			isInDst(q.tm, n, t.KeyMark, 0) || isInDst(q.tm, n, t.KeyIsMarked, 0) ||
+			isInSrc(q.tm, n, t.KeyReadMatch, 1) || isInSrc(q.tm, n, t.KeyReadMatch, 2) ||
 			isThisMethod(q.tm, n, "decode_header", 1) || isThisMethod(q.tm, n, "decode_lsd", 1) ||
 			isThisMethod(q.tm, n, "decode_extension", 1) || isThisMethod(q.tm, n, "decode_id", 2) ||
 			isThisMethod(q.tm, n, "decode_uncompressed", 2) || isThisMethod(q.tm, n, "decode_blocks", 2) ||
@@ -803,6 +956,19 @@ func (q *checker) bcheckExprOther(n *a.Expr, depth uint32) (*big.Int, *big.Int,
 			}
 			return zero, bitMask(int(aMax.Int64())), nil
 		}
+		// TODO: delete this hack that only matches "foo.clz()" etc.
+		if isThatMethod(q.tm, n, t.KeyClz, 0) || isThatMethod(q.tm, n, t.KeyCtz, 0) ||
+			isThatMethod(q.tm, n, t.KeyPopcount, 0) || isThatMethod(q.tm, n, t.KeyReverseBits, 1) ||
+			isThatMethod(q.tm, n, t.KeyRotateLeft, 1) || isThatMethod(q.tm, n, t.KeyByteSwap, 0) {
+			return q.bcheckBitMethod(n, depth)
+		}
+		// TODO: delete this hack that only matches "foo.take_bits!(etc)" etc.
+		// for a bitreader foo.
+		if isThatMethod(q.tm, n, t.KeyNBits, 0) || isThatMethod(q.tm, n, t.KeyPeekBits, 1) ||
+			isThatMethod(q.tm, n, t.KeyTakeBits, 1) || isThatMethod(q.tm, n, t.KeyRefill, 2) ||
+			isThatMethod(q.tm, n, t.KeySetMSBFirst, 1) {
+			return q.bcheckBitreaderMethod(n, depth)
+		}
 		// TODO: delete this hack that only matches "foo.is_suspension(etc)".
 		if isThatMethod(q.tm, n, t.KeyIsError, 0) || isThatMethod(q.tm, n, t.KeyIsOK, 0) ||
 			isThatMethod(q.tm, n, t.KeyIsSuspension, 0) || isThatMethod(q.tm, n, t.KeySuffix, 1) {
@@ -842,6 +1008,17 @@ func (q *checker) bcheckExprOther(n *a.Expr, depth uint32) (*big.Int, *big.Int,
 		if isThatMethod(q.tm, n, t.KeyLength, 0) || isThatMethod(q.tm, n, t.KeyAvailable, 0) {
 			break
 		}
+		// TODO: delete this hack that only matches "foo.width()", "foo.row(etc)"
+		// etc. for a buf2 foo.
+		if isBuf2MethodName(q.tm, n) && isBuf2(n.LHS().Expr().LHS().Expr().MType()) {
+			if isThatMethod(q.tm, n, t.KeyRow, 1) || isThatMethod(q.tm, n, t.KeySetU8, 3) {
+				if err := q.bcheckBuf2Method(n, depth); err != nil {
+					return nil, nil, err
+				}
+				return nil, nil, nil
+			}
+			break
+		}
 		return nil, nil, fmt.Errorf("check: unrecognized token.Key (0x%X) for bcheckExprOther", n.ID0().Key())
 
 	case t.KeyOpenBracket:
@@ -924,6 +1101,9 @@ func (q *checker) bcheckExprOther(n *a.Expr, depth uint32) (*big.Int, *big.Int,
 		if _, _, err := q.bcheckExpr(n.LHS().Expr(), depth); err != nil {
 			return nil, nil, err
 		}
+		if err := q.bcheckUnionField(n); err != nil {
+			return nil, nil, err
+		}
 
 	case t.KeyError, t.KeyStatus, t.KeySuspension:
 		// No-op.
@@ -934,6 +1114,379 @@ func (q *checker) bcheckExprOther(n *a.Expr, depth uint32) (*big.Int, *big.Int,
 	return q.bcheckTypeExpr(n.MType())
 }
 
+// bcheckIOMethod returns the bounds of a call to a built-in I/O method m. A
+// write's argument must fit in the integer type written. For example, the
+// argument to "in.dst.write_u24be?(x:etc)" must be within [0..0xFFFFFF].
+func (q *checker) bcheckIOMethod(n *a.Expr, m builtin.IOMethod, depth uint32) (*big.Int, *big.Int, error) {
+	nBits := 8 * m.NBytes
+	tMin, tMax := zero, bitMask(int(nBits))
+	if m.Signed {
+		tMax = bitMask(int(nBits - 1))
+		tMin = big.NewInt(0).Sub(minusOne, tMax)
+	}
+	if m.Op != "write" {
+		return tMin, tMax, nil
+	}
+
+	x := n.Args()[0].Arg().Value()
+	xMin, xMax, err := q.bcheckExpr(x, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if xMin.Cmp(tMin) < 0 || xMax.Cmp(tMax) > 0 {
+		return nil, nil, fmt.Errorf("check: %s argument %q, with bounds [%v..%v], is not within [%v..%v]",
+			m.Name, x.String(q.tm), xMin, xMax, tMin, tMax)
+	}
+	return q.bcheckTypeExpr(n.MType())
+}
+
+// bcheckBitMethod returns the bounds of a call to a bit manipulation method of
+// an unsigned integer, such as "foo.clz()", given foo's bounds.
+func (q *checker) bcheckBitMethod(n *a.Expr, depth uint32) (*big.Int, *big.Int, error) {
+	foo := n.LHS().Expr().LHS().Expr()
+	xMin, xMax, err := q.bcheckExpr(foo, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	typMax := numTypeBounds[foo.MType().Name().Key()][1]
+	width := typMax.BitLen()
+	method := n.LHS().Expr().ID1()
+
+	switch method.Key() {
+	case t.KeyClz:
+		return big.NewInt(int64(width - xMax.BitLen())), big.NewInt(int64(width - xMin.BitLen())), nil
+
+	case t.KeyCtz:
+		if xMin.Cmp(xMax) == 0 {
+			if xMin.Sign() == 0 {
+				w := big.NewInt(int64(width))
+				return w, w, nil
+			}
+			z := big.NewInt(int64(xMin.TrailingZeroBits()))
+			return z, z, nil
+		}
+		if xMin.Sign() == 0 {
+			return zero, big.NewInt(int64(width)), nil
+		}
+		return zero, big.NewInt(int64(xMax.BitLen() - 1)), nil
+
+	case t.KeyPopcount:
+		if xMin.Cmp(xMax) == 0 {
+			c := 0
+			for i := 0; i < xMin.BitLen(); i++ {
+				c += int(xMin.Bit(i))
+			}
+			z := big.NewInt(int64(c))
+			return z, z, nil
+		}
+		return btoi(xMin.Sign() > 0), big.NewInt(int64(xMax.BitLen())), nil
+
+	case t.KeyByteSwap:
+		if xMax.Sign() == 0 {
+			return zero, zero, nil
+		}
+		return zero, typMax, nil
+	}
+
+	// The remaining methods, reverse_bits and rotate_left, take an n argument.
+	a := n.Args()[0].Arg().Value()
+	aMin, aMax, err := q.bcheckExpr(a, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit := width
+	if method.Key() == t.KeyRotateLeft {
+		limit = width - 1
+	}
+	if aMin.Sign() < 0 || aMax.Cmp(big.NewInt(int64(limit))) > 0 {
+		return nil, nil, fmt.Errorf("check: %s argument %q, with bounds [%v..%v], is not within [0..%d]",
+			method.String(q.tm), a.String(q.tm), aMin, aMax, limit)
+	}
+	if xMax.Sign() == 0 {
+		return zero, zero, nil
+	}
+	if method.Key() == t.KeyReverseBits {
+		return zero, bitMask(int(aMax.Int64())), nil
+	}
+	if aMax.Sign() == 0 {
+		return xMin, xMax, nil
+	}
+	return zero, typMax, nil
+}
+
+// bcheckUnionField checks that, if n is like "x.length" for a union-typed x,
+// then the variant holding that field is x's current kind. In other words,
+// that "x.kind == u.stored" is provable.
+func (q *checker) bcheckUnionField(n *a.Expr) error {
+	lhs := n.LHS().Expr()
+	lTyp := lhs.MType()
+	if lTyp.Decorator() != 0 || n.ID1().Key() == t.KeyKind {
+		return nil
+	}
+	u, ok := q.c.unions[lTyp.Name()]
+	if !ok {
+		return nil
+	}
+	variant := u.Variants[n.ID1()]
+	tag := a.NewExpr(a.FlagsTypeChecked, t.IDDot, t.IDKind, lhs.Node(), nil, nil, nil)
+	tag.SetMType(u.TagType)
+	value, err := q.constExpr(u.Tags[variant])
+	if err != nil {
+		return err
+	}
+	if err := q.proveBinaryOp(t.KeyXBinaryEqEq, tag, value); err != nil {
+		if err == errFailed {
+			return fmt.Errorf("check: cannot access %q: cannot prove that %q is %q",
+				n.String(q.tm), tag.String(q.tm), variant.String(q.tm))
+		}
+		return err
+	}
+	return nil
+}
+
+// dropUnionTagFacts drops any facts that mention a union's tag, such as
+// "this.s.kind", reachable from one of roots, such as "this". Impure calls can
+// change those tags.
+func (q *checker) dropUnionTagFacts(roots map[t.ID]bool) error {
+	return q.facts.update(func(x *a.Expr) (*a.Expr, error) {
+		found := false
+		x.Node().Walk(func(o *a.Node) error {
+			if o.Kind() != a.KExpr {
+				return nil
+			}
+			if o := o.Expr(); roots[exprRoot(o)] {
+				if _, ok := q.unionForTag(o); ok {
+					found = true
+				}
+			}
+			return nil
+		})
+		if found {
+			return nil, nil
+		}
+		return x, nil
+	})
+}
+
+// isSuspension returns whether n is a "return suspension etc" statement.
+func isSuspension(n *a.Node) bool {
+	if n.Kind() != a.KReturn {
+		return false
+	}
+	v := n.Return().Value()
+	return v != nil && v.ID0().Key() == t.KeySuspension
+}
+
+// dropSuspensionFacts drops any facts that might not hold after resuming from
+// a suspension: those that mention "this", "in" or "out", which the caller can
+// change, or a local variable with pointers, such as a slice, which is reset
+// on resume instead of saved.
+func (q *checker) dropSuspensionFacts() error {
+	return q.facts.update(func(x *a.Expr) (*a.Expr, error) {
+		found := false
+		x.Node().Walk(func(o *a.Node) error {
+			if o.Kind() != a.KExpr {
+				return nil
+			}
+			if o := o.Expr(); o.ID0() == 0 {
+				switch o.ID1().Key() {
+				case t.KeyThis, t.KeyIn, t.KeyOut:
+					found = true
+				default:
+					if typ := o.MType(); typ != nil && typ.HasPointers() {
+						found = true
+					}
+				}
+			}
+			return nil
+		})
+		if found {
+			return nil, nil
+		}
+		return x, nil
+	})
+}
+
+// callRoots returns the roots, as per exprRoot, of what the call n could
+// change: "this", n's receiver and n's arguments.
+func callRoots(n *a.Expr) map[t.ID]bool {
+	roots := map[t.ID]bool{t.IDThis: true}
+	if f := n.LHS().Expr(); f.ID0().Key() == t.KeyDot {
+		roots[exprRoot(f.LHS().Expr())] = true
+	}
+	for _, o := range n.Args() {
+		roots[exprRoot(o.Arg().Value())] = true
+	}
+	return roots
+}
+
+// exprRoot returns the "x" in "x.y[i].z".
+func exprRoot(n *a.Expr) t.ID {
+	for {
+		switch n.ID0().Key() {
+		case t.KeyDot, t.KeyOpenBracket, t.KeyColon:
+			n = n.LHS().Expr()
+			continue
+		}
+		return n.ID1()
+	}
+}
+
+// bcheckBuf2Method checks that "foo.row(y:etc)" and "foo.set_u8(x:etc, y:etc,
+// v:etc)" stay within the buf2 foo: that y is less than "foo.height()" and, for
+// set_u8, that x is less than "foo.width()".
+func (q *checker) bcheckBuf2Method(n *a.Expr, depth uint32) error {
+	foo := n.LHS().Expr().LHS().Expr()
+	args := n.Args()
+	for _, o := range args {
+		if _, _, err := q.bcheckExpr(o.Arg().Value(), depth); err != nil {
+			return err
+		}
+	}
+
+	if n.LHS().Expr().ID1().Key() == t.KeyRow {
+		y := args[0].Arg().Value()
+		if err := proveReasonRequirement(q, t.IDXBinaryLessEq, zeroExpr, y); err != nil {
+			return err
+		}
+		return proveReasonRequirement(q, t.IDXBinaryLessThan, y, makeBuf2MethodExpr(foo, t.IDHeight))
+	}
+
+	x, y, v := args[0].Arg().Value(), args[1].Arg().Value(), args[2].Arg().Value()
+	if err := proveReasonRequirement(q, t.IDXBinaryLessEq, zeroExpr, x); err != nil {
+		return err
+	}
+	if err := proveReasonRequirement(q, t.IDXBinaryLessThan, x, makeBuf2MethodExpr(foo, t.IDWidth)); err != nil {
+		return err
+	}
+	if err := proveReasonRequirement(q, t.IDXBinaryLessEq, zeroExpr, y); err != nil {
+		return err
+	}
+	if err := proveReasonRequirement(q, t.IDXBinaryLessThan, y, makeBuf2MethodExpr(foo, t.IDHeight)); err != nil {
+		return err
+	}
+	vMin, vMax, err := q.bcheckExpr(v, depth)
+	if err != nil {
+		return err
+	}
+	if vMin == nil || vMax == nil || vMin.Sign() < 0 || vMax.Cmp(big.NewInt(0xFF)) > 0 {
+		return fmt.Errorf("check: set_u8 value %q, with bounds [%v..%v], is not within [0..255]",
+			v.String(q.tm), vMin, vMax)
+	}
+	return nil
+}
+
+// makeBuf2MethodExpr returns "foo.width()" or "foo.height()" for a buf2 foo.
+func makeBuf2MethodExpr(foo *a.Expr, method t.ID) *a.Expr {
+	x := a.NewExpr(a.FlagsTypeChecked, t.IDDot, method, foo.Node(), nil, nil, nil)
+	x.SetMType(typeExprPlaceholder) // HACK.
+	x = a.NewExpr(a.FlagsTypeChecked, t.IDOpenParen, 0, x.Node(), nil, nil, nil)
+	x.SetMType(typeExprU64)
+	return x
+}
+
+// A bitreader holds at most 63 bits. Refilling a whole byte at a time, until it
+// holds at least 56 bits, therefore never overflows.
+var (
+	maxBitreaderNBits  = big.NewInt(63)
+	maxBitreaderRefill = big.NewInt(56)
+	maxBitreaderTake   = big.NewInt(32)
+)
+
+// bcheckBitreaderMethod returns the bounds of a call to a bitreader method.
+// Peeking at or taking n bits requires proving that "foo.n_bits() >= n".
+// Refilling to n bits adds that fact, and taking n bits updates it.
+func (q *checker) bcheckBitreaderMethod(n *a.Expr, depth uint32) (*big.Int, *big.Int, error) {
+	foo := n.LHS().Expr().LHS().Expr()
+	method := n.LHS().Expr().ID1()
+	switch method.Key() {
+	case t.KeyNBits:
+		return zero, maxBitreaderNBits, nil
+	case t.KeySetMSBFirst:
+		if _, _, err := q.bcheckExpr(n.Args()[0].Arg().Value(), depth); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, nil
+	}
+
+	k := n.Args()[len(n.Args())-1].Arg().Value()
+	kMin, kMax, err := q.bcheckExpr(k, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit := maxBitreaderTake
+	if method.Key() == t.KeyRefill {
+		limit = maxBitreaderRefill
+	}
+	if kMin == nil || kMax == nil || kMin.Sign() < 0 || kMax.Cmp(limit) > 0 {
+		return nil, nil, fmt.Errorf("check: %s argument %q, with bounds [%v..%v], is not within [0..%v]",
+			method.String(q.tm), k.String(q.tm), kMin, kMax, limit)
+	}
+	nBits := makeBitreaderNBitsExpr(foo)
+
+	if method.Key() == t.KeyRefill {
+		// Refilling only adds bits, so "foo.n_bits() >= etc" facts still hold.
+		if err := q.facts.update(func(x *a.Expr) (*a.Expr, error) {
+			if xOp, xLHS, _ := parseBinaryOp(x); xOp.Key() == t.KeyXBinaryGreaterEq && xLHS.Eq(nBits) {
+				return x, nil
+			}
+			if x.Mentions(nBits) {
+				return nil, nil
+			}
+			return x, nil
+		}); err != nil {
+			return nil, nil, err
+		}
+		o := a.NewExpr(a.FlagsTypeChecked, t.IDXBinaryGreaterEq, 0, nBits.Node(), nil, k.Node(), nil)
+		o.SetMType(typeExprBool)
+		q.facts.appendFact(o)
+		return nil, nil, nil
+	}
+
+	if err := proveReasonRequirement(q, t.IDXBinaryGreaterEq, nBits, k); err != nil {
+		return nil, nil, err
+	}
+
+	if method.Key() == t.KeyTakeBits {
+		// Taking k bits turns "foo.n_bits() >= c" into "foo.n_bits() >= c - k"
+		// when that is a positive constant. Other facts about foo.n_bits() are
+		// dropped.
+		if err := q.facts.update(func(x *a.Expr) (*a.Expr, error) {
+			xOp, xLHS, xRHS := parseBinaryOp(x)
+			if xOp.Key() != t.KeyXBinaryGreaterEq || !xLHS.Eq(nBits) {
+				if x.Mentions(nBits) {
+					return nil, nil
+				}
+				return x, nil
+			}
+			oRHS := a.NewExpr(a.FlagsTypeChecked, t.IDXBinaryMinus, 0, xRHS.Node(), nil, k.Node(), nil)
+			oRHS.SetMType(xRHS.MType())
+			oRHS, err := simplify(q.tm, oRHS)
+			if err != nil {
+				return nil, err
+			}
+			if cv := oRHS.ConstValue(); cv == nil || cv.Sign() <= 0 {
+				return nil, nil
+			}
+			o := a.NewExpr(a.FlagsTypeChecked, t.IDXBinaryGreaterEq, 0, xLHS.Node(), nil, oRHS.Node(), nil)
+			o.SetMType(x.MType())
+			return o, nil
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return zero, bitMask(int(kMax.Int64())), nil
+}
+
+// makeBitreaderNBitsExpr returns "foo.n_bits()" for a bitreader foo.
+func makeBitreaderNBitsExpr(foo *a.Expr) *a.Expr {
+	x := a.NewExpr(a.FlagsTypeChecked, t.IDDot, t.IDNBits, foo.Node(), nil, nil, nil)
+	x.SetMType(typeExprPlaceholder) // HACK.
+	x = a.NewExpr(a.FlagsTypeChecked, t.IDOpenParen, 0, x.Node(), nil, nil, nil)
+	x.SetMType(typeExprU32)
+	return x
+}
+
 func makeSliceLengthExpr(slice *a.Expr) *a.Expr {
 	x := a.NewExpr(a.FlagsTypeChecked, t.IDDot, t.IDLength, slice.Node(), nil, nil, nil)
 	x.SetMType(typeExprPlaceholder) // HACK.
@@ -1087,16 +1640,40 @@ func (q *checker) bcheckExprBinaryOp1(op t.Key, lhs *a.Expr, lMin *big.Int, lMax
 		t.KeyXBinaryGreaterEq, t.KeyXBinaryGreaterThan, t.KeyXBinaryAnd, t.KeyXBinaryOr:
 		return zero, one, nil
 
-	case t.KeyXBinaryAs:
+	case t.KeyXBinaryAs, t.KeyXBinaryTildeAs:
 		// Unreachable, as this is checked by the caller.
 
-	case t.KeyXBinaryTildePlus:
-		typ := lhs.MType()
-		if typ.IsIdeal() {
-			typ = rhs.MType()
+	case t.KeyXBinaryTildePlus, t.KeyXBinaryTildeMinus, t.KeyXBinaryTildeStar:
+		b := numTypeBounds[nonIdealType(lhs.MType(), rhs.MType()).Name().Key()]
+		return b[0], b[1], nil
+
+	case t.KeyXBinaryTildeShiftL:
+		b := numTypeBounds[lhs.MType().Name().Key()]
+		nBits := b[1].BitLen()
+		if b[0].Sign() < 0 {
+			nBits++
+		}
+		// Unlike "<<", the shift must be less than the number of bits in the
+		// type, as C leaves larger shifts undefined.
+		if rMin.Cmp(zero) < 0 {
+			return nil, nil, fmt.Errorf("check: shift op argument %q is possibly negative", rhs.String(q.tm))
+		}
+		if rMax.Cmp(big.NewInt(int64(nBits))) >= 0 {
+			return nil, nil, fmt.Errorf("check: shift %q out of range", rhs.String(q.tm))
 		}
-		b := numTypeBounds[typ.Name().Key()]
 		return b[0], b[1], nil
+
+	case t.KeyXBinaryTildeSatPlus:
+		b := numTypeBounds[nonIdealType(lhs.MType(), rhs.MType()).Name().Key()]
+		nMin := big.NewInt(0).Add(lMin, rMin)
+		nMax := big.NewInt(0).Add(lMax, rMax)
+		return min(max(nMin, b[0]), b[1]), min(max(nMax, b[0]), b[1]), nil
+
+	case t.KeyXBinaryTildeSatMinus:
+		b := numTypeBounds[nonIdealType(lhs.MType(), rhs.MType()).Name().Key()]
+		nMin := big.NewInt(0).Sub(lMin, rMax)
+		nMax := big.NewInt(0).Sub(lMax, rMin)
+		return min(max(nMin, b[0]), b[1]), min(max(nMax, b[0]), b[1]), nil
 	}
 	return nil, nil, fmt.Errorf("check: unrecognized token.Key (0x%X) for bcheckExprBinaryOp", op)
 }
@@ -1139,7 +1716,7 @@ func (q *checker) bcheckTypeExpr(n *a.TypeExpr) (*big.Int, *big.Int, error) {
 		return nil, nil, nil
 	}
 	switch n.Name().Key() {
-	case t.KeyReader1, t.KeyWriter1:
+	case t.KeyReader1, t.KeyWriter1, t.KeyBuf2, t.KeyBitreader:
 		return nil, nil, nil
 	}
 
@@ -71,9 +71,7 @@ var (
 	typeExprSliceU8 = a.NewTypeExpr(t.IDColon, 0, nil, nil, typeExprU8)
 
 	// TODO: delete this.
-	typeExprPlaceholder   = a.NewTypeExpr(0, t.IDU8, nil, nil, nil)
-	typeExprPlaceholder16 = a.NewTypeExpr(0, t.IDU16, nil, nil, nil)
-	typeExprPlaceholder32 = a.NewTypeExpr(0, t.IDU32, nil, nil, nil)
+	typeExprPlaceholder = a.NewTypeExpr(0, t.IDU8, nil, nil, nil)
 )
 
 // TypeMap maps from variable names (as token IDs) to types.
@@ -82,6 +80,22 @@ type TypeMap map[t.ID]*a.TypeExpr
 type Const struct {
 	ID    t.ID // ID of the const name.
 	Const *a.Const
+
+	// Value is the const's value. It is Const.Value() unless that calls const
+	// funcs, in which case it is the list or number that those calls evaluate
+	// to.
+	Value *a.Expr
+}
+
+type Enum struct {
+	ID   t.ID // ID of the enum name.
+	Enum *a.Enum
+
+	// XType is the refined integer type that the enum type stands for, such
+	// as "u32[0..2]".
+	XType *a.TypeExpr
+	// Values maps from member names (as token IDs) to their values.
+	Values map[t.ID]*big.Int
 }
 
 type Func struct {
@@ -100,7 +114,41 @@ type Struct struct {
 	Struct *a.Struct
 }
 
+type Union struct {
+	ID    t.ID // ID of the union name.
+	Union *a.Union
+
+	// TagType is the refined type of the union's "kind" tag, such as
+	// "u8[0..2]".
+	TagType *a.TypeExpr
+	// Tags maps from variant names (as token IDs) to their tag values.
+	Tags map[t.ID]*big.Int
+	// Fields maps from field names (as token IDs) to their fields, across all
+	// of the variants.
+	Fields map[t.ID]*a.Field
+	// Variants maps from field names (as token IDs) to the name of the variant
+	// that holds that field.
+	Variants map[t.ID]t.ID
+}
+
+// Options are optional settings for CheckWithOptions. A nil *Options is
+// equivalent to a zero-valued Options.
+type Options struct {
+	// RequireTermination is whether it is an error for a while loop to not be
+	// provably terminating, even if it has no explicit "dec" measure.
+	RequireTermination bool
+}
+
 func Check(tm *t.Map, files ...*a.File) (*Checker, error) {
+	return CheckWithOptions(tm, nil, files...)
+}
+
+// CheckWithOptions is like Check but with optional settings.
+func CheckWithOptions(tm *t.Map, opts *Options, files ...*a.File) (*Checker, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
 	for _, f := range files {
 		if f == nil {
 			return nil, errors.New("check: Check given a nil *ast.File")
@@ -128,9 +176,13 @@ func Check(tm *t.Map, files ...*a.File) (*Checker, error) {
 		reasonMap: rMap,
 		packageID: base38.Max + 1,
 		consts:    map[t.ID]Const{},
+		enums:     map[t.ID]Enum{},
 		funcs:     map[t.QID]Func{},
 		statuses:  map[t.ID]Status{},
 		structs:   map[t.ID]Struct{},
+		unions:    map[t.ID]Union{},
+
+		requireTermination: opts.RequireTermination,
 	}
 
 	for _, phase := range phases {
@@ -162,12 +214,16 @@ var phases = [...]struct {
 	{a.KPackageID, (*Checker).checkPackageID},
 	{a.KUse, (*Checker).checkUse},
 	{a.KStatus, (*Checker).checkStatus},
+	{a.KEnum, (*Checker).checkEnum},
 	{a.KConst, (*Checker).checkConst},
+	{a.KUnion, (*Checker).checkUnion},
 	{a.KStruct, (*Checker).checkStructDecl},
 	{a.KInvalid, (*Checker).checkStructCycles},
 	{a.KStruct, (*Checker).checkStructFields},
 	{a.KFunc, (*Checker).checkFuncSignature},
 	{a.KFunc, (*Checker).checkFuncContract},
+	{a.KFunc, (*Checker).checkConstFuncBody},
+	{a.KConst, (*Checker).checkConstFuncCalls},
 	{a.KFunc, (*Checker).checkFuncBody},
 	{a.KStruct, (*Checker).checkFieldMethodCollisions},
 	// TODO: check consts, funcs, structs and uses for name collisions.
@@ -184,19 +240,25 @@ type Checker struct {
 	packageID      uint32
 	otherPackageID *a.PackageID
 
+	requireTermination bool
+
 	consts   map[t.ID]Const
+	enums    map[t.ID]Enum
 	funcs    map[t.QID]Func
 	statuses map[t.ID]Status
 	structs  map[t.ID]Struct
+	unions   map[t.ID]Union
 
 	unsortedStructs []*a.Struct
 }
 
 func (c *Checker) PackageID() uint32         { return c.packageID }
 func (c *Checker) Consts() map[t.ID]Const    { return c.consts }
+func (c *Checker) Enums() map[t.ID]Enum      { return c.enums }
 func (c *Checker) Funcs() map[t.QID]Func     { return c.funcs }
 func (c *Checker) Statuses() map[t.ID]Status { return c.statuses }
 func (c *Checker) Structs() map[t.ID]Struct  { return c.structs }
+func (c *Checker) Unions() map[t.ID]Union    { return c.unions }
 
 func (c *Checker) checkPackageID(node *a.Node) error {
 	n := node.PackageID()
@@ -280,55 +342,394 @@ func (c *Checker) checkConst(node *a.Node) error {
 	if err := q.tcheckTypeExpr(n.XType(), 0); err != nil {
 		return fmt.Errorf("%v in const %q", err, id.String(c.tm))
 	}
+	nMin, nMax, err := q.constBounds(n)
+	if err != nil {
+		return err
+	}
+	if callsConstFunc(n.Value()) {
+		// The value is checked by checkConstFuncCalls, once the const funcs
+		// themselves have been checked.
+		return nil
+	}
 	if err := q.tcheckExpr(n.Value(), 0); err != nil {
 		return fmt.Errorf("%v in const %q", err, id.String(c.tm))
 	}
+	if err := c.checkConstElement(n.Value(), n.XType(), nMin, nMax); err != nil {
+		return fmt.Errorf("check: %v for %q", err, id.String(c.tm))
+	}
+	c.consts[id] = Const{
+		ID:    id,
+		Const: n,
+		Value: n.Value(),
+	}
+	n.Node().SetTypeChecked()
+	return nil
+}
 
+// checkConstFuncCalls checks a const whose value calls const funcs. Those calls
+// are evaluated, and the resultant value is checked just like any other const
+// value.
+func (c *Checker) checkConstFuncCalls(node *a.Node) error {
+	n := node.Const()
+	id := n.Name()
+	if !callsConstFunc(n.Value()) {
+		return nil
+	}
+	q := &checker{
+		c:  c,
+		tm: c.tm,
+	}
+	if err := q.tcheckExpr(n.Value(), 0); err != nil {
+		return fmt.Errorf("%v in const %q", err, id.String(c.tm))
+	}
+	v, err := q.evalConstValue(n.Value())
+	if err != nil {
+		return fmt.Errorf("%v in const %q", err, id.String(c.tm))
+	}
+	if typ := n.Value().MType(); typ != typeExprList && !typ.IsIdeal() && !n.XType().EqIgnoringRefinements(typ) {
+		return fmt.Errorf("check: cannot assign %q of type %q to const %q of type %q",
+			n.Value().String(c.tm), typ.String(c.tm), id.String(c.tm), n.XType().String(c.tm))
+	}
+	value, err := q.evalValueExpr(v)
+	if err != nil {
+		return err
+	}
+	nMin, nMax, err := q.constBounds(n)
+	if err != nil {
+		return err
+	}
+	if err := c.checkConstElement(value, n.XType(), nMin, nMax); err != nil {
+		return fmt.Errorf("check: %v for %q", err, id.String(c.tm))
+	}
+	c.consts[id] = Const{
+		ID:    id,
+		Const: n,
+		Value: value,
+	}
+	n.Node().SetTypeChecked()
+	return nil
+}
+
+// constBounds returns the bounds of the innermost element type of n's type,
+// such as [0..255] for a "[4] u8" const.
+func (q *checker) constBounds(n *a.Const) (nMin *big.Int, nMax *big.Int, err error) {
 	nLists := 0
 	typ := n.XType()
 	for typ.Decorator().Key() == t.KeyOpenBracket {
 		if nLists == a.MaxTypeExprDepth {
-			return fmt.Errorf("check: type expression recursion depth too large")
+			return nil, nil, fmt.Errorf("check: type expression recursion depth too large")
 		}
 		nLists++
 		typ = typ.Inner()
 	}
 	if typ.Decorator() != 0 {
-		return fmt.Errorf("check: invalid const type %q for %q", n.XType().String(c.tm), id.String(c.tm))
+		return nil, nil, fmt.Errorf("check: invalid const type %q for %q",
+			n.XType().String(q.tm), n.Name().String(q.tm))
 	}
-	nMin, nMax, err := q.bcheckTypeExpr(typ)
+	nMin, nMax, err = q.bcheckTypeExpr(typ)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	if nMin == nil || nMax == nil {
-		return fmt.Errorf("check: invalid const type %q for %q", n.XType().String(c.tm), id.String(c.tm))
-	}
-	if err := c.checkConstElement(n.Value(), nMin, nMax, nLists); err != nil {
-		return fmt.Errorf("check: %v for %q", err, id.String(c.tm))
+		return nil, nil, fmt.Errorf("check: invalid const type %q for %q",
+			n.XType().String(q.tm), n.Name().String(q.tm))
 	}
-	n.Node().SetTypeChecked()
-	return nil
+	return nMin, nMax, nil
 }
 
-func (c *Checker) checkConstElement(n *a.Expr, nMin *big.Int, nMax *big.Int, nLists int) error {
-	if nLists > 0 {
-		nLists--
+// callsConstFunc returns whether n contains a function call. The only calls
+// allowed in a const value are to const funcs.
+func callsConstFunc(n *a.Expr) bool {
+	found := false
+	n.Node().Walk(func(o *a.Node) error {
+		if o.Kind() == a.KExpr && o.Expr().ID0().Key() == t.KeyOpenParen {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+// checkConstElement checks that n is a valid value of type typ, for a const
+// whose innermost element type is bounded by nMin and nMax. An array's value
+// is a list with exactly one element per array element, and each of those is
+// checked recursively. An array of bytes can also be a string literal.
+func (c *Checker) checkConstElement(n *a.Expr, typ *a.TypeExpr, nMin *big.Int, nMax *big.Int) error {
+	if typ.Decorator().Key() == t.KeyOpenBracket {
+		length, inner := typ.ArrayLength().ConstValue(), typ.Inner()
+		if n.ID0() == 0 && n.ID1().IsStrLiteral() {
+			if inner.Decorator() != 0 || inner.Name().Key() != t.KeyU8 {
+				// Only arrays of bytes can be initialized by string literals.
+				length = nil
+			}
+			return c.checkConstString(n, nMin, nMax, length)
+		}
 		if n.ID0().Key() != t.KeyDollar {
 			return fmt.Errorf("invalid const value %q", n.String(c.tm))
 		}
+		if length.Cmp(big.NewInt(int64(len(n.Args())))) != 0 {
+			return fmt.Errorf("invalid const value %q: length %d does not match the array length %v",
+				n.String(c.tm), len(n.Args()), length)
+		}
 		for _, o := range n.Args() {
-			if err := c.checkConstElement(o.Expr(), nMin, nMax, nLists); err != nil {
+			if err := c.checkConstElement(o.Expr(), inner, nMin, nMax); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
+	if n.ID0().Key() == t.KeyDollar {
+		return fmt.Errorf("invalid const value %q: a list needs an array type", n.String(c.tm))
+	}
 	if cv := n.ConstValue(); cv == nil || cv.Cmp(nMin) < 0 || cv.Cmp(nMax) > 0 {
 		return fmt.Errorf("invalid const value %q not within [%v..%v]", n.String(c.tm), nMin, nMax)
 	}
 	return nil
 }
 
+// checkConstString checks that the string literal n, such as "GIF8", is a
+// valid value for an array of length bytes, each bounded by nMin and nMax.
+func (c *Checker) checkConstString(n *a.Expr, nMin *big.Int, nMax *big.Int, length *big.Int) error {
+	s, ok := t.Unescape(n.ID1().String(c.tm))
+	if !ok {
+		return fmt.Errorf("invalid const value %q", n.String(c.tm))
+	}
+	if length == nil {
+		return fmt.Errorf("invalid const value %q: a string literal needs a [N] u8 type", n.String(c.tm))
+	}
+	if length.Cmp(big.NewInt(int64(len(s)))) != 0 {
+		return fmt.Errorf("invalid const value %q: length %d does not match the array length %v",
+			n.String(c.tm), len(s), length)
+	}
+	for i := 0; i < len(s); i++ {
+		if x := big.NewInt(int64(s[i])); x.Cmp(nMin) < 0 || x.Cmp(nMax) > 0 {
+			return fmt.Errorf("invalid const value %q: byte 0x%02X not within [%v..%v]",
+				n.String(c.tm), s[i], nMin, nMax)
+		}
+	}
+	return nil
+}
+
+func (c *Checker) checkEnum(node *a.Node) error {
+	n := node.Enum()
+	id := n.Name()
+	if other, ok := c.enums[id]; ok {
+		return &Error{
+			Err:           fmt.Errorf("check: duplicate enum %q", id.String(c.tm)),
+			Filename:      n.Filename(),
+			Line:          n.Line(),
+			OtherFilename: other.Enum.Filename(),
+			OtherLine:     other.Enum.Line(),
+		}
+	}
+	e, err := c.checkEnumMembers(n)
+	if err != nil {
+		return &Error{
+			Err:      fmt.Errorf("%v in enum %q", err, id.String(c.tm)),
+			Filename: n.Filename(),
+			Line:     n.Line(),
+		}
+	}
+	c.enums[id] = e
+	n.Node().SetTypeChecked()
+	return nil
+}
+
+func (c *Checker) checkEnumMembers(n *a.Enum) (Enum, error) {
+	q := &checker{
+		c:  c,
+		tm: c.tm,
+	}
+	typ := n.XType()
+	if err := q.tcheckTypeExpr(typ, 0); err != nil {
+		return Enum{}, err
+	}
+	if typ.IsRefined() || (!typ.IsUnsignedInteger() && !typ.IsSignedInteger()) {
+		return Enum{}, fmt.Errorf("check: type %q is not an unrefined integer type", typ.String(c.tm))
+	}
+	b := numTypeBounds[typ.Name().Key()]
+
+	values := map[t.ID]*big.Int{}
+	names := map[string]t.ID{}
+	nMin, nMax := (*big.Int)(nil), (*big.Int)(nil)
+	for _, o := range n.Members() {
+		o := o.Arg()
+		name, value := o.Name(), o.Value()
+		if _, ok := values[name]; ok {
+			return Enum{}, fmt.Errorf("check: duplicate member %q", name.String(c.tm))
+		}
+		if err := q.tcheckExpr(value, 0); err != nil {
+			return Enum{}, err
+		}
+		cv := value.ConstValue()
+		if cv == nil {
+			return Enum{}, fmt.Errorf("check: value %q of member %q is not constant",
+				value.String(c.tm), name.String(c.tm))
+		}
+		if cv.Cmp(b[0]) < 0 || cv.Cmp(b[1]) > 0 {
+			return Enum{}, fmt.Errorf("check: value %v of member %q is not within bounds [%v..%v]",
+				cv, name.String(c.tm), b[0], b[1])
+		}
+		if other, ok := names[cv.String()]; ok {
+			return Enum{}, fmt.Errorf("check: members %q and %q have the same value %v",
+				other.String(c.tm), name.String(c.tm), cv)
+		}
+		values[name] = cv
+		names[cv.String()] = name
+		if nMin == nil || cv.Cmp(nMin) < 0 {
+			nMin = cv
+		}
+		if nMax == nil || cv.Cmp(nMax) > 0 {
+			nMax = cv
+		}
+		o.Node().SetTypeChecked()
+	}
+	if len(values) == 0 {
+		return Enum{}, fmt.Errorf("check: no members")
+	}
+	// An enum type is its members' range, so that range must not have any
+	// gaps that name no member.
+	if n := big.NewInt(0).Sub(nMax, nMin); n.Cmp(big.NewInt(int64(len(values)-1))) != 0 {
+		return Enum{}, fmt.Errorf("check: member values in [%v..%v] are not contiguous", nMin, nMax)
+	}
+
+	minExpr, err := q.constExpr(nMin)
+	if err != nil {
+		return Enum{}, err
+	}
+	maxExpr, err := q.constExpr(nMax)
+	if err != nil {
+		return Enum{}, err
+	}
+	xType := a.NewTypeExpr(0, typ.Name(), minExpr, maxExpr, nil)
+	xType.Node().SetTypeChecked()
+	return Enum{
+		ID:     n.Name(),
+		Enum:   n,
+		XType:  xType,
+		Values: values,
+	}, nil
+}
+
+// maxUnionVariants is the maximum number of variants in a union, so that its
+// tag fits in a u8.
+const maxUnionVariants = 256
+
+func (c *Checker) checkUnion(node *a.Node) error {
+	n := node.Union()
+	id := n.Name()
+	if other, ok := c.unions[id]; ok {
+		return &Error{
+			Err:           fmt.Errorf("check: duplicate union %q", id.String(c.tm)),
+			Filename:      n.Filename(),
+			Line:          n.Line(),
+			OtherFilename: other.Union.Filename(),
+			OtherLine:     other.Union.Line(),
+		}
+	}
+	if _, ok := c.enums[id]; ok {
+		return &Error{
+			Err:      fmt.Errorf("check: union %q has the same name as an enum", id.String(c.tm)),
+			Filename: n.Filename(),
+			Line:     n.Line(),
+		}
+	}
+	u, err := c.checkUnionVariants(n)
+	if err != nil {
+		return &Error{
+			Err:      fmt.Errorf("%v in union %q", err, id.String(c.tm)),
+			Filename: n.Filename(),
+			Line:     n.Line(),
+		}
+	}
+	c.unions[id] = u
+	n.Node().SetTypeChecked()
+	return nil
+}
+
+func (c *Checker) checkUnionVariants(n *a.Union) (Union, error) {
+	q := &checker{
+		c:  c,
+		tm: c.tm,
+	}
+	variants := n.Variants()
+	if len(variants) == 0 {
+		return Union{}, fmt.Errorf("check: no variants")
+	}
+	if len(variants) > maxUnionVariants {
+		return Union{}, fmt.Errorf("check: too many variants")
+	}
+
+	u := Union{
+		ID:       n.Name(),
+		Union:    n,
+		Tags:     map[t.ID]*big.Int{},
+		Fields:   map[t.ID]*a.Field{},
+		Variants: map[t.ID]t.ID{},
+	}
+	for i, o := range variants {
+		v := o.Struct()
+		if _, ok := u.Tags[v.Name()]; ok {
+			return Union{}, fmt.Errorf("check: duplicate variant %q", v.Name().String(c.tm))
+		}
+		u.Tags[v.Name()] = big.NewInt(int64(i))
+
+		if err := c.checkFields(v.Fields(), true); err != nil {
+			return Union{}, fmt.Errorf("%v in variant %q", err, v.Name().String(c.tm))
+		}
+		for _, f := range v.Fields() {
+			f := f.Field()
+			if err := c.checkUnionField(f); err != nil {
+				return Union{}, fmt.Errorf("%v in variant %q", err, v.Name().String(c.tm))
+			}
+			if other, ok := u.Variants[f.Name()]; ok {
+				return Union{}, fmt.Errorf("check: field %q is in both variants %q and %q",
+					f.Name().String(c.tm), other.String(c.tm), v.Name().String(c.tm))
+			}
+			u.Fields[f.Name()] = f
+			u.Variants[f.Name()] = v.Name()
+		}
+		v.Node().SetTypeChecked()
+	}
+
+	maxExpr, err := q.constExpr(big.NewInt(int64(len(variants) - 1)))
+	if err != nil {
+		return Union{}, err
+	}
+	u.TagType = a.NewTypeExpr(0, t.IDU8, zeroExpr, maxExpr, nil)
+	u.TagType.Node().SetTypeChecked()
+	return u, nil
+}
+
+// checkUnionField checks that a union's field can be zero-initialized, as
+// changing a union's kind zeroes all of its fields. Such fields hold numbers
+// or bools, or arrays of them, but not structs, other unions or pointers.
+func (c *Checker) checkUnionField(f *a.Field) error {
+	if f.Name().Key() == t.KeyKind {
+		return fmt.Errorf("check: field %q is reserved for the union's tag", f.Name().String(c.tm))
+	}
+	if f.DefaultValue() != nil {
+		return fmt.Errorf("check: cannot set default value for union field %q", f.Name().String(c.tm))
+	}
+	typ := f.XType().Innermost()
+	if typ.IsBool() {
+		return nil
+	}
+	if !typ.IsNumType() {
+		return fmt.Errorf("check: type %q not allowed for union field %q",
+			f.XType().String(c.tm), f.Name().String(c.tm))
+	}
+	nMin, nMax, err := typeBounds(c.tm, typ)
+	if err != nil {
+		return err
+	}
+	if (nMin != nil && nMin.Sign() > 0) || (nMax != nil && nMax.Sign() < 0) {
+		return fmt.Errorf("check: type %q, for union field %q, does not include zero",
+			f.XType().String(c.tm), f.Name().String(c.tm))
+	}
+	return nil
+}
+
 func (c *Checker) checkStructDecl(node *a.Node) error {
 	n := node.Struct()
 	id := n.Name()
@@ -428,6 +829,15 @@ func (c *Checker) checkFuncSignature(node *a.Node) error {
 		}
 	}
 	n.Out().Node().SetTypeChecked()
+	if n.Const() {
+		if err := c.checkConstFuncSignature(n); err != nil {
+			return &Error{
+				Err:      err,
+				Filename: n.Filename(),
+				Line:     n.Line(),
+			}
+		}
+	}
 
 	// TODO: check somewhere that, if n.Out() is non-empty (or we are
 	// suspendible), that we end with a return statement? Or is that an
@@ -474,6 +884,21 @@ func (c *Checker) checkFuncSignature(node *a.Node) error {
 	return nil
 }
 
+// checkConstFuncSignature checks that n, a const func, is pure and has no
+// receiver and exactly one out-param, which holds its result.
+func (c *Checker) checkConstFuncSignature(n *a.Func) error {
+	if n.Receiver() != 0 {
+		return fmt.Errorf("check: const func %q cannot have a receiver", n.QID().String(c.tm))
+	}
+	if n.Impure() {
+		return fmt.Errorf("check: const func %q is not pure", n.Name().String(c.tm))
+	}
+	if len(n.Out().Fields()) != 1 {
+		return fmt.Errorf("check: const func %q does not have exactly one out-param", n.Name().String(c.tm))
+	}
+	return nil
+}
+
 func (c *Checker) checkFuncContract(node *a.Node) error {
 	n := node.Func()
 	if len(n.Asserts()) == 0 {
@@ -491,8 +916,26 @@ func (c *Checker) checkFuncContract(node *a.Node) error {
 	return nil
 }
 
+// checkConstFuncBody checks const funcs' bodies, before any other funcs', so
+// that checkConstFuncCalls can evaluate them.
+func (c *Checker) checkConstFuncBody(node *a.Node) error {
+	n := node.Func()
+	if !n.Const() {
+		return nil
+	}
+	return c.checkFuncBody1(n)
+}
+
 func (c *Checker) checkFuncBody(node *a.Node) error {
 	n := node.Func()
+	if n.Const() {
+		// Const funcs were already checked by checkConstFuncBody.
+		return nil
+	}
+	return c.checkFuncBody1(n)
+}
+
+func (c *Checker) checkFuncBody1(n *a.Func) error {
 	q := &checker{
 		c:         c,
 		tm:        c.tm,
@@ -524,13 +967,35 @@ func (c *Checker) checkFuncBody(node *a.Node) error {
 		}
 	}
 
-	if err := q.bcheckBlock(n.Body()); err != nil {
-		return &Error{
-			Err:      err,
-			Filename: q.errFilename,
-			Line:     q.errLine,
-			TMap:     c.tm,
-			Facts:    q.facts,
+	if n.Const() {
+		// Const funcs are not bounds checked. Instead, their values are
+		// checked as they are evaluated, and the evaluator's fuel limit
+		// guarantees termination.
+		if err := q.checkConstFuncStatements(n); err != nil {
+			return &Error{
+				Err:      err,
+				Filename: q.errFilename,
+				Line:     q.errLine,
+			}
+		}
+
+	} else {
+		if err := q.bcheckBlock(n.Body()); err != nil {
+			return &Error{
+				Err:      err,
+				Filename: q.errFilename,
+				Line:     q.errLine,
+				TMap:     c.tm,
+				Facts:    q.facts,
+			}
+		}
+
+		if err := q.proveTermination(n.Body()); err != nil {
+			return &Error{
+				Err:      err,
+				Filename: q.errFilename,
+				Line:     q.errLine,
+			}
 		}
 	}
 
@@ -565,6 +1030,45 @@ func (c *Checker) checkFuncBody(node *a.Node) error {
 	return nil
 }
 
+// checkConstFuncStatements checks that n, a const func, only uses what the
+// const func evaluator supports: numbers, booleans and arrays of those, but not
+// iterate loops or returning a status.
+func (q *checker) checkConstFuncStatements(n *a.Func) error {
+	return n.Node().Walk(func(o *a.Node) error {
+		typ := (*a.TypeExpr)(nil)
+		switch o.Kind() {
+		case a.KField:
+			q.errFilename, q.errLine = n.Filename(), n.Line()
+			typ = o.Field().XType()
+		case a.KIterate:
+			q.errFilename, q.errLine = o.Raw().FilenameLine()
+			return fmt.Errorf("check: const func %q cannot use iterate", n.Name().String(q.tm))
+		case a.KReturn:
+			if o.Return().Value() != nil {
+				q.errFilename, q.errLine = o.Raw().FilenameLine()
+				return fmt.Errorf("check: const func %q cannot return a value", n.Name().String(q.tm))
+			}
+		case a.KVar:
+			q.errFilename, q.errLine = o.Raw().FilenameLine()
+			typ = o.Var().XType()
+		}
+		if typ != nil && !isConstFuncType(typ) {
+			return fmt.Errorf("check: const func %q cannot use the type %q",
+				n.Name().String(q.tm), typ.String(q.tm))
+		}
+		return nil
+	})
+}
+
+// isConstFuncType returns whether typ is a number, a boolean or an array of
+// those, which are the types that const funcs can use.
+func isConstFuncType(typ *a.TypeExpr) bool {
+	for typ.Decorator().Key() == t.KeyOpenBracket {
+		typ = typ.Inner()
+	}
+	return typ.Decorator() == 0 && (typ.IsNumType() || typ.IsBool())
+}
+
 func (c *Checker) checkFieldMethodCollisions(node *a.Node) error {
 	n := node.Struct()
 	for _, o := range n.Fields() {
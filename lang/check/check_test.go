@@ -54,6 +54,26 @@ func compareToPuffsfmt(tm *token.Map, tokens []token.Token, comments []string, s
 	return nil
 }
 
+// checkSource tokenizes, parses and checks src. Test cases are meant to
+// exercise the checker, so it is a test failure if tokenizing or parsing fails.
+func checkSource(t *testing.T, opts *Options, src string) (*token.Map, *Checker, error) {
+	const filename = "test.puffs"
+	tm := &token.Map{}
+
+	tokens, _, err := token.Tokenize(tm, filename, []byte(src))
+	if err != nil {
+		t.Fatalf("%q: Tokenize: %v", src, err)
+	}
+
+	file, err := parse.Parse(tm, filename, tokens)
+	if err != nil {
+		t.Fatalf("%q: Parse: %v", src, err)
+	}
+
+	c, err := CheckWithOptions(tm, opts, file)
+	return tm, c, err
+}
+
 func TestCheck(t *testing.T) {
 	const filename = "test.puffs"
 	src := strings.TrimSpace(`
@@ -160,6 +180,10 @@ func TestConstValues(t *testing.T) {
 	testCases := map[string]int64{
 		"var i i32 = 42": 42,
 
+		"var i i32 = 1_000":       1000,
+		"var i i32 = 0b1000_0000": 128,
+		"var i i32 = 0Xab_cd":     0xABCD,
+
 		"var i i32 = +7": +7,
 		"var i i32 = -7": -7,
 
@@ -187,6 +211,10 @@ func TestConstValues(t *testing.T) {
 
 		"var b bool = false and true": 0,
 		"var b bool = false  or true": 1,
+
+		"var i u8 = 0x1234 ~as u8": 0x34,
+		"var i i8 =   0xFF ~as i8": -1,
+		"var i i8 =   -129 ~as i8": 127,
 	}
 
 	tm := &token.Map{}
@@ -277,3 +305,725 @@ func TestBitMask(t *testing.T) {
 		}
 	}
 }
+
+func TestTildeOps(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		{"var x u8\nx ~+= 200\nx ~*= 3", true},
+		{"var x u8\nx = x ~sat- 7", true},
+		{"var x i32\nx = x ~- 0x7FFFFFFF", true},
+		{"var x i32\nx = x ~<< 31", true},
+		{"var x u8[..10]\nx ~+= 1", false},
+		{"var x u8\nx = x ~<< 7", true},
+		{"var x u8\nx = x ~<< 8", false},
+		{"var b bool\nb ~+= true", false},
+		{"var x u8\nvar y u16 = x ~sat+ 1", false},
+		{"var x u8 = 200\nvar y u8 = x ~sat+ 100", true},
+		{"var x u8 = 200\nvar y u8 = x + 100", false},
+		{"var x u8 = 100\nvar y u8[..199] = x ~sat+ 100", false},
+		{"var x u32\nvar y u8 = x ~as u8", true},
+		{"var x u32\nvar y u8[..10] = x ~as u8[..10]", false},
+	}
+
+	for _, tc := range testCases {
+		src := "pri func foo()() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestBitMethods(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		// Counting bits.
+		{"var c u32[..32] = in.x.clz()", true},
+		{"var c u32[..31] = in.x.clz()", false},
+		{"var c u8[..8] = in.y.clz()", true},
+		{"var v u32[..255] = in.y as u32\nvar c u32[..24] = v.clz()", false},
+		{"var v u32[..255] = in.y as u32\nvar c u32[24..32] = v.clz()", true},
+		{"var c u32[..31]\nif in.x > 0 {\nc = in.x.ctz()\n}", true},
+		{"var c u32[..31] = in.x.ctz()", false},
+		{"var v u32[..0xFFFF] = in.x & 0xFFFF\nvar c u32[..15] = v.popcount()", false},
+		{"var v u32[..0xFFFF] = in.x & 0xFFFF\nvar c u32[..16] = v.popcount()", true},
+		{"var v u32[0x0F0F..0x0F0F] = 0x0F0F\nvar c u32[8..8] = v.popcount()", true},
+		{"var c u32 = in.x.popcount(n:1)", false},
+		{"var c i32 = in.i.popcount()", false},
+
+		// Reversing and rotating bits.
+		{"var r u32[..511] = in.x.reverse_bits(n:9)", true},
+		{"var r u32[..255] = in.x.reverse_bits(n:9)", false},
+		{"var r u32 = in.x.reverse_bits(n:32)", true},
+		{"var r u32 = in.x.reverse_bits(n:33)", false},
+		{"var r u32 = in.x.reverse_bits(x:9)", false},
+		{"var r u32 = in.x.reverse_bits(n:in.x)", false},
+		{"var r u32 = in.x.rotate_left(n:31)", true},
+		{"var r u32 = in.x.rotate_left(n:32)", false},
+		{"var r u8 = in.y.rotate_left(n:in.x & 7)", true},
+
+		// Swapping bytes.
+		{"var s u32 = in.x.byte_swap()", true},
+		{"var s u8 = in.y.byte_swap()", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npri func foo(x u32, y u8, i i32)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestReadMatch(t *testing.T) {
+	testCases := []struct {
+		decls  string
+		body   string
+		wantOK bool
+	}{
+		{"", `var b bool = in.src.read_match?(s:"GIF8")`, true},
+		{"", `in.src.read_match?(s:"\x89PNG\r\n", err:error "bad magic")`, true},
+		{"", `in.src.read_match?(s:"")`, false},
+		{"", `in.src.read_match?(x:"GIF8")`, false},
+		{"", `in.src.read_match?(s:"GIF8", err:"bad magic")`, false},
+		{"", `in.src.read_match?(s:"GIF8", err:error "no such error")`, false},
+		{"", "var x u8\nin.src.read_match?(s:x)", false},
+
+		{`pri const m[4] u8 = "GIF8"`, "var b bool = in.src.read_match?(s:m)", true},
+		{`pri const m[2][2] u8 = $("ab", "cd")`, "", true},
+		{`pri const m[3] u8 = "GIF8"`, "", false},
+		{`pri const m[4] u8[..0x7F] = "\x89PNG"`, "", false},
+		{`pri const m[4] u32 = "GIF8"`, "", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npub error \"bad magic\"\n" + tc.decls + "\n" +
+			"pri func foo?(src reader1)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q %q: got ok=%t (err=%v), want ok=%t", tc.decls, tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestIOMethods(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		{"var x u16 = in.src.read_u16be?()", true},
+		{"var x i16 = in.src.read_i16le?()", true},
+		{"var x u32[..0xFFFFFF] = in.src.read_u24be?()", true},
+		{"var x i32 = in.src.peek_i32le?()", true},
+		{"var x i8 = in.src.peek_i8?()", true},
+		{"var x u8 = in.src.peek_u8?()", true},
+		{"var x u16 = in.src.read_u24le?()", false},
+		{"var x u16 = in.src.read_i16be?()", false},
+		{"var x u16 = in.src.peek_u16be()", false},
+		{"var x u16 = in.dst.read_u16be?()", false},
+		{"var x u64 = in.src.read_u64be?()", false},
+
+		{"in.dst.write_u16le?(x:0xFFFF)", true},
+		{"in.dst.write_u16le?(x:0x10000)", false},
+		{"in.dst.write_i16be?(x:0 - 0x8000)", true},
+		{"in.dst.write_i16be?(x:0x8000)", false},
+		{"in.dst.write_u24be?(x:0xFFFFFF)", true},
+		{"in.dst.write_u24be?(x:0x1000000)", false},
+		{"var v i8 = 0 - 1\nin.dst.write_i8?(x:v)", true},
+		{"var v i8 = 0 - 1\nin.dst.write_u8?(x:v)", false},
+		{"in.dst.write_u32le?(y:0)", false},
+		{"in.src.write_u32le?(x:0)", false},
+
+		{"if in.src.available() >= 4 {\nvar x u16 = in.src.read_u16be?()\nassert in.src.available() >= 2\n}", true},
+		{"if in.src.available() >= 4 {\nvar x u32 = in.src.read_u24be?()\nassert in.src.available() >= 2\n}", false},
+		{"if in.src.available() >= 4 {\nvar x u32 = in.src.peek_u32le?()\nassert in.src.available() >= 4\n}", true},
+		{"if in.dst.available() >= 5 {\nin.dst.write_u24le?(x:0)\nassert in.dst.available() >= 2\n}", true},
+		{"if in.dst.available() >= 5 {\nin.dst.write_u24le?(x:0)\nassert in.dst.available() >= 3\n}", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\n" +
+			"pri func foo?(dst writer1, src reader1)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		{"var w writer1 = in.dst.limit(l:10)", true},
+		{"var r reader1 = in.src.limit(l:10)", true},
+		{"var n u64\nvar w writer1 = in.dst.limit(l:n)", true},
+		{"var n u64[..100]\nvar w writer1 = in.dst.limit(l:n)", true},
+		{"var r reader1 = in.src\nvar s reader1 = r.limit(l:10)", true},
+		{"var r reader1 = in.dst.limit(l:10)", false},
+		{"var w writer1 = in.dst.limit(n:10)", false},
+		{"var n u32\nvar w writer1 = in.dst.limit(l:n)", false},
+		{"var w writer1 = in.dst.limit!(l:10)", false},
+		{"var w writer1 = in.dst.limit?(l:10)", false},
+		{"var x u64\nvar y u64 = x.limit(l:10)", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\n" +
+			"pri func foo?(dst writer1, src reader1)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestConstLists(t *testing.T) {
+	testCases := []struct {
+		decls  string
+		body   string
+		wantOK bool
+	}{
+		{"pri const m[2][3] u8 = $($(1, 2, 3), $(4, 5, 6))", "", true},
+		{"pri const m[2][3] u8 = $($(1, 2, 3), $(4, 5, 6))", "var x u8 = m[in.i][in.j]", true},
+		{"pri const m[2][3] u8 = $($(1, 2, 3), $(4, 5, 6))", "var x u8 = m[in.i][3]", false},
+		{"pri const m[2][3] u8 = $($(1, 2, 3), $(4, 5))", "", false},
+		{"pri const m[2][3] u8 = $($(1, 2, 3))", "", false},
+		{"pri const m[2][3] u8 = $($(1, 2, 256), $(4, 5, 6))", "", false},
+		{"pri const m[2][3] u8[..5] = $($(0, 1, 2), $(3, 4, 6))", "", false},
+		{"pri const m[2][3] u8 = $(1, 2)", "", false},
+		{"pri const m[2] u8 = $($(1), $(2))", "", false},
+		{"pri const m[2][2][2] u16 = $($($(1, 2), $(3, 4)), $($(5, 6), $(7, 0xFFFF)))", "", true},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\n" + tc.decls + "\n" +
+			"pri func foo(i u32[..1], j u32[..2])() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q %q: got ok=%t (err=%v), want ok=%t", tc.decls, tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestConstFuncs(t *testing.T) {
+	testCases := []struct {
+		decls string
+		// want is x's value, or "" if checking should fail.
+		want string
+	}{
+		{"pri const x u32 = double(n:21)", "42"},
+		{"pri const x u32 = double(n:21) + 1", "43"},
+		{"pri const x[3] u32 = $(double(n:1), double(n:2), 5)", "$(2, 4, 5)"},
+		{"pri const x[4] u8 = squares(n:4)", "$(0, 1, 4, 9)"},
+		{"pri const x[4] u8 = squares(n:3)", "$(0, 1, 4, 0)"},
+		{"pri const x u8 = sum(a:\"\\x01\\x02\\x03\\x04\")", "10"},
+		{"pri const y[4] u8 = $(5, 6, 7, 8)\npri const x u8 = sum(a:y)", "26"},
+		{"pri const x u32 = wrap(n:0xFFFFFFFF)", "1"},
+		{"pri const x u32 = fib(n:20)", "6765"},
+		{"pri const x u32 = labeled()", "12"},
+		{"pri const x u32 = sw(n:5) + sw(n:300)", "3"},
+
+		// The result must fit the const's type.
+		{"pri const x u8 = double(n:200)", ""},
+		{"pri const x[3] u8 = squares(n:3)", ""},
+		// Arithmetic must not overflow.
+		{"pri const x u32 = double(n:0x80000000)", ""},
+		// Arguments must fit their in-params' types.
+		{"pri const x[4] u8 = squares(n:5)", ""},
+		// Indexes must be in range.
+		{"pri const x u32 = oob()", ""},
+		// Evaluation must terminate.
+		{"pri const x u32 = forever()", ""},
+		{"pri const x u32 = recurse(n:0)", ""},
+		// Asserts are checked when evaluated.
+		{"pri const x u32 = asserts(n:1)", "1"},
+		{"pri const x u32 = asserts(n:2)", ""},
+		// Const funcs can only be called from consts and const funcs.
+		{"pri const x u32 = 0\npri func bad()() {\nvar y u32 = double(n:1)\n}", ""},
+		{"pri const x u32 = not_const()", ""},
+		{"pri const x u32 = double(m:1)", ""},
+		{"pri const x u32 = double(n:1, m:2)", ""},
+		// Const funcs have one out-param and no side effects.
+		{"pri const x u32 = 0\npri const func two()(a u32, b u32) {\n}", ""},
+		{"pri const x u32 = 0\npri const func bang!()(a u32) {\n}", ""},
+		{"pri const x u32 = 0\npri const func pointer(p ptr u8)(a u32) {\n}", ""},
+		{"pri const x u32 = 0\npri const func stat()(a u32) {\nreturn error \"bad\"\n}", ""},
+		{"pri const x u32 = 0\npri struct s(n u32)\npri const func s.m()(a u32) {\n}", ""},
+	}
+
+	const funcs = `
+pri error "bad"
+
+pri const func double(n u32)(x u32) {
+	out.x = in.n * 2
+}
+
+pri const func squares(n u32[..4])(x[4] u8) {
+	var i u32
+	while i < in.n {
+		out.x[i] = (i * i) as u8
+		i += 1
+	}
+}
+
+pri const func sum(a[4] u8)(x u8) {
+	var i u32
+	while i < 4 {
+		out.x += in.a[i]
+		i += 1
+	}
+}
+
+pri const func wrap(n u32)(x u32) {
+	out.x = in.n ~+ 2
+}
+
+pri const func fib(n u32)(x u32) {
+	if in.n < 2 {
+		out.x = in.n
+		return
+	}
+	out.x = fib(n:in.n - 1) + fib(n:in.n - 2)
+}
+
+pri const func labeled()(x u32) {
+	var i u32
+	var j u32
+	while:outer i < 10 {
+		i += 1
+		j = 0
+		while j < 10 {
+			j += 1
+			if j == 2 {
+				continue:outer
+			}
+			if i == 7 {
+				break:outer
+			}
+			out.x += 1
+		}
+	}
+	out.x += i - 1
+}
+
+pri const func sw(n u32)(x u32) {
+	switch in.n {
+	case 0..9 {
+		out.x = 1
+	}
+	case 10 {
+		out.x = 10
+	}
+	else {
+		out.x = 2
+	}
+	}
+}
+
+pri const func oob()(x u32) {
+	var a[4] u32
+	var i u32 = 4
+	out.x = a[i]
+}
+
+pri const func forever()(x u32) {
+	while true {
+		out.x = 1
+	}
+}
+
+pri const func recurse(n u32)(x u32) {
+	out.x = recurse(n:in.n)
+}
+
+pri const func asserts(n u32)(x u32) {
+	assert in.n < 2
+	out.x = in.n
+}
+
+pri func not_const()(x u32) {
+}
+`
+
+	for _, tc := range testCases {
+		tm, c, err := checkSource(t, nil, "packageid \"test\"\n"+tc.decls+"\n"+funcs)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%q: got nil error, want non-nil", tc.decls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: Check: %v", tc.decls, err)
+			continue
+		}
+		if got := c.Consts()[tm.ByName("x")].Value.String(tm); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.decls, got, tc.want)
+		}
+	}
+}
+
+func TestStructFields(t *testing.T) {
+	testCases := []struct {
+		decls  string
+		wantOK bool
+	}{
+		{"pri struct bar?(n u32)\npri struct foo?(b bar)", true},
+		{"pri struct bar?(n u32)\npri struct foo?(b[8] bar)", true},
+		{"pri struct bar?(n u32)\npri struct foo?(b[2][3] bar)", true},
+		{"pri struct bar?(n u32)\npri struct foo?(b[8] bar = 0)", false},
+		{"pri struct bar?(n u32)\npri struct foo?(b bar = 0)", false},
+		{"pri struct bar?(n u32)\npri struct foo?(b[8] baz)", false},
+		{"pri struct foo?(b[8] foo)", false},
+		{"pri struct bar?(f[2] foo)\npri struct foo?(b[8] bar)", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\n" + tc.decls + "\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.decls, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		// Exhaustiveness.
+		{"switch in.x {\ncase 0x00..0x7F {\n}\ncase 0x80..0xFF {\n}\n}", true},
+		{"switch in.x {\ncase 0x00..0x7F {\n}\ncase 0x81..0xFF {\n}\n}", false},
+		{"switch in.x {\ncase 0x00..0x7F {\n}\n}", false},
+		{"switch in.x {\ncase 0x00..0x7F {\n}\nelse {\n}\n}", true},
+		{"switch in.z {\ncase 0, 1 {\n}\ncase 2..3 {\n}\n}", true},
+		{"switch in.z {\ncase 0, 1 {\n}\ncase 2..4 {\n}\n}", false},
+
+		// Case values.
+		{"switch in.x {\ncase 1, 1 {\n}\nelse {\n}\n}", false},
+		{"switch in.x {\ncase 1..5 {\n}\ncase 5 {\n}\nelse {\n}\n}", false},
+		{"switch in.x {\ncase 5..1 {\n}\nelse {\n}\n}", false},
+		{"var y u8\nswitch in.x {\ncase y {\n}\nelse {\n}\n}", false},
+		{"var b bool\nswitch b {\ncase 1 {\n}\nelse {\n}\n}", false},
+
+		// Facts within each case body.
+		{"var y u8[..0x7F]\nswitch in.x {\ncase 0x00..0x7F {\ny = in.x\n}\nelse {\n}\n}", true},
+		{"var y u8[..0x7F]\nswitch in.x {\ncase 0x00..0x80 {\ny = in.x\n}\nelse {\n}\n}", false},
+		{"var y u8[..0x7F]\nswitch in.x {\ncase 0x21 {\ny = in.x\n}\nelse {\n}\n}", true},
+		{"var y u8[..0x7F]\nswitch in.x {\ncase 0x21 {\n}\nelse {\ny = in.x\n}\n}", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npri func foo(x u8, z u8[..3])() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestEnum(t *testing.T) {
+	const color = "pri enum color u8(\nred = 0,\ngreen = 1,\nblue = 2,\n)"
+	testCases := []struct {
+		decl   string
+		body   string
+		wantOK bool
+	}{
+		// Declarations.
+		{color, "", true},
+		{"pri enum color u8(\nred = 0,\ngreen = 0,\n)", "", false},
+		{"pri enum color u8(\nred = 0,\nred = 1,\n)", "", false},
+		{"pri enum color u8(\nred = 0,\ngreen = 0x100,\n)", "", false},
+		{"pri enum color u8[..3](\nred = 0,\n)", "", false},
+		{"pri enum color bool(\nred = 0,\n)", "", false},
+		{"pri enum color u8(\n)", "", false},
+		{"pri enum color u8(\nred = 0,\nblue = 2,\n)", "", false},
+		{"pri enum color u8(\nred = 2,\ngreen = 0,\nblue = 1,\n)", "", true},
+		{"pri const k u8 = 1\npri enum color u8(\nred = k,\n)", "", false},
+
+		// Members.
+		{color, "var y u8\ny = color.blue", true},
+		{color, "var y u8\ny = color.purple", false},
+		{color, "var y u8[..1]\ny = color.blue", false},
+		{color, "var y u8[..2]\ny = in.c", true},
+		{color, "var c color[..1]", false},
+
+		// Aliasing: an enum type is its backing type refined to the members'
+		// range, so integers proven to be in that range are assignable.
+		{color, "var c color\nc = 1", true},
+		{color, "var c color\nc = 3", false},
+		{color, "var c color\nif in.x <= 2 {\nc = in.x\n}", true},
+		{color, "var c color\nc = in.x", false},
+
+		// Consts.
+		{color + "\npri const k color = color.green", "var y u8[..2] = k", true},
+		{color + "\npri const k color = 3", "", false},
+		{color + "\npri const k[2] color = $(color.red, color.blue)", "var y u8[..2] = k[1]", true},
+
+		// Conversions.
+		{color, "var c color\nif in.x <= 2 {\nc = in.x as color\n}", true},
+		{color, "var c color\nc = in.x as color", false},
+
+		// Exhaustive switches.
+		{color, "switch in.c {\ncase color.red, color.green {\n}\ncase color.blue {\n}\n}", true},
+		{color, "switch in.c {\ncase color.red, color.green {\n}\n}", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\n" + tc.decl + "\npri func foo(x u8, c color)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q %q: got ok=%t (err=%v), want ok=%t", tc.decl, tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	const state = "pri union state(\na(length u32, tab[4] u8[..3]),\nb(done bool),\nc(),\n)"
+	testCases := []struct {
+		decls  string
+		body   string
+		wantOK bool
+	}{
+		// Declarations.
+		{state, "", true},
+		{"pri union state(\n)", "", false},
+		{"pri union state(\na(),\na(),\n)", "", false},
+		{"pri union state(\na(length u32),\nb(length u32),\n)", "", false},
+		{"pri union state(\na(kind u32),\n)", "", false},
+		{"pri union state(\na(length u32 = 1),\n)", "", false},
+		{"pri union state(\na(tab[4] u8[1..3]),\n)", "", false},
+		{"pri union state(\na(z status),\n)", "", false},
+		{state, "var v state[..1]", false},
+
+		// Tags.
+		{state, "this.s.kind = state.a\nassert this.s.kind == state.a", true},
+		{state, "this.s.kind = state.d", false},
+		{state, "this.s.kind = 3", false},
+		{state, "this.s.kind = in.x", false},
+		{state, "this.s.kind += 1", false},
+
+		// Fields.
+		{state, "this.s.length = 1", false},
+		{state, "this.s.kind = state.a\nthis.s.length = 1", true},
+		{state, "this.s.kind = state.a\nthis.s.done = true", false},
+		{state, "this.s.kind = state.a\nthis.s.length = 1\nthis.s.kind = state.b\nthis.s.length = 1", false},
+		{state, "this.s.kind = state.a\nthis.s.length = 7\nassert this.s.length == 7", true},
+		{state, "this.s.kind = state.a\nthis.s.length = 7\nthis.s.kind = state.a\nassert this.s.length == 7", false},
+		{state, "this.s.kind = state.a\nthis.s.tab[in.x] = 0", false},
+		{state, "this.s.kind = state.a\nif in.x < 4 {\nthis.s.tab[in.x] = 3\n}", true},
+		{state, "if this.s.kind == state.a {\nthis.s.length = 1\n}", true},
+		{state, "if this.s.kind == state.b {\nthis.s.length = 1\n}", false},
+		{state, "switch this.s.kind {\ncase state.a {\nthis.s.length = 1\n}\ncase state.b {\nthis.s.done = true\n}\ncase state.c {\n}\n}", true},
+		{state, "switch this.s.kind {\ncase state.a, state.b {\nthis.s.length = 1\n}\nelse {\n}\n}", false},
+
+		// Calls and suspensions can change the tag.
+		{state, "this.s.kind = state.a\nthis.decode_header?(src:in.src)\nthis.s.length = 1", false},
+		{state, "this.s.kind = state.a\nthis.decode_header?(src:in.src)\nassert this.s.kind == state.a", false},
+		{state, "this.decode_header?(src:in.src)\nthis.s.kind = state.a\nthis.s.length = 1", true},
+		{state, "var v state\nv.kind = state.a\nthis.decode_header?(src:in.src)\nv.length = 1", true},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\n" + tc.decls + "\npri struct foo?(\ns state,\n)\n" +
+			"pri func foo.decode_header?(src reader1)() {\nthis.s.kind = state.b\n}\n" +
+			"pri func foo.bar?(src reader1, x u8)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q %q: got ok=%t (err=%v), want ok=%t", tc.decls, tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestBuf2(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		{"var w u64 = in.b.width()\nvar h u64 = in.b.height()\nvar f u32 = in.b.pixel_format()", true},
+		{"var z u32\nvar w u64 = z.width()", false},
+
+		// Rows.
+		{"var r[] u8 = in.b.row(y:in.y)", false},
+		{"var r[] u8\nif in.y < in.b.height() {\nr = in.b.row(y:in.y)\n}", true},
+		{"var r[] u8\nif in.y < in.b.height() {\nr = in.b.row(x:in.y)\n}", false},
+		{"var r[] u8\nif in.y <= in.b.height() {\nr = in.b.row(y:in.y)\n}", false},
+		{"var r[] u8\nif in.y < in.b.height() {\nr = in.b.row(y:in.y)\nr[in.x] = 7\n}", false},
+		{"var r[] u8\nif in.y < in.b.height() {\nr = in.b.row(y:in.y)\nif in.x < r.length() {\nr[in.x] = 7\n}\n}", true},
+
+		// Pixels.
+		{"in.b.set_u8(x:in.x, y:in.y, v:7)", false},
+		{"if in.y < in.b.height() {\nin.b.set_u8(x:in.x, y:in.y, v:7)\n}", false},
+		{"if in.x < in.b.width() {\nin.b.set_u8(x:in.x, y:in.y, v:7)\n}", false},
+		{"if (in.x < in.b.width()) and (in.y < in.b.height()) {\nin.b.set_u8(x:in.x, y:in.y, v:7)\n}", true},
+		{"if (in.x < in.b.width()) and (in.y < in.b.height()) {\nin.b.set_u8(x:in.x, y:in.y, v:256)\n}", false},
+		{"if (in.x < in.b.width()) and (in.y < in.b.height()) {\nin.b.set_u8(x:in.x, y:in.y, v:in.x)\n}", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npri func foo(b buf2, x u64, y u64)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestBitreader(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		{"var n u32[..63] = this.br.n_bits()", true},
+		{"var n u32[..62] = this.br.n_bits()", false},
+
+		// Peeking and taking bits needs enough buffered bits.
+		{"var x u32 = this.br.peek_bits(n:3)", false},
+		{"this.br.refill?(src:in.src, n:3)\nvar x u32[..7] = this.br.peek_bits(n:3)", true},
+		{"this.br.refill?(src:in.src, n:3)\nvar x u32[..3] = this.br.peek_bits(n:3)", false},
+		{"this.br.refill?(src:in.src, n:3)\nvar x u32 = this.br.peek_bits(n:4)", false},
+		{"this.br.refill?(src:in.src, n:9)\nvar x u32 = this.br.take_bits!(n:4)\nx = this.br.take_bits!(n:5)", true},
+		{"this.br.refill?(src:in.src, n:9)\nvar x u32 = this.br.take_bits!(n:4)\nx = this.br.take_bits!(n:6)", false},
+		{"this.br.refill?(src:in.src, n:in.k)\nvar x u32 = this.br.take_bits!(n:in.k)", true},
+		{"this.br.refill?(src:in.src, n:in.k)\nvar x u32 = this.br.take_bits!(n:in.k)\nx = this.br.take_bits!(n:in.k)", false},
+		{"this.br.refill?(src:in.src, n:9)\nvar x u32 = this.br.take_bits(n:4)", false},
+		{"this.br.refill?(src:in.src, n:9)\nvar x u32 = this.br.peek_bits!(n:4)", false},
+
+		// Argument bounds.
+		{"this.br.refill?(src:in.src, n:56)", true},
+		{"this.br.refill?(src:in.src, n:57)", false},
+		{"this.br.refill?(src:in.src, n:in.j)", false},
+		{"this.br.refill?(src:in.src, n:40)\nvar x u32 = this.br.take_bits!(n:33)", false},
+		{"this.br.refill(src:in.src, n:3)", false},
+		{"this.br.refill?(src:in.k, n:3)", false},
+		{"this.br.refill?(n:3, src:in.src)", false},
+
+		// Modes.
+		{"this.br.set_msb_first!(v:true)", true},
+		{"this.br.set_msb_first!(v:1)", false},
+		{"var x u32 = in.k.peek_bits(n:0)", false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npri struct foo(br bitreader)\n" +
+			"pri func foo.bar?(src reader1, j u32, k u32[..20])() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestSlices(t *testing.T) {
+	testCases := []struct {
+		body   string
+		wantOK bool
+	}{
+		// Indexing.
+		{"var x u16 = in.s[in.i]", false},
+		{"var x u16\nif in.i < in.s.length() {\nx = in.s[in.i]\n}", true},
+		{"var x u32\nif in.i < in.s.length() {\nx = in.s[in.i]\n}", false},
+		{"var x u32\nif in.i < in.t.length() {\nx = in.t[in.i]\n}", true},
+
+		// Subslicing.
+		{"var r[] u16 = in.s[1:]", false},
+		{"var r[] u16\nif in.i <= in.s.length() {\nr = in.s[in.i:]\n}", true},
+		{"var r[] u32\nif in.i <= in.s.length() {\nr = in.s[in.i:]\n}", false},
+		{"var r[] u16 = in.s.suffix(up_to:4)", true},
+		{"var r[] u32 = in.s", false},
+		{"var a[4] u16[..3]\nvar r[] u16[..3] = a[0:]", true},
+		{"var r[] u16[..3] = in.s", false},
+		{"var a[4] u16[..3]\nvar r[] u16 = a[0:]", false},
+		{"var a[4] u16[..3]\nvar r[] u16[..3] = a[0:]\nr = in.s", false},
+
+		// Copying.
+		{"var n u64 = in.s.copy_from_slice(s:in.s[0:])", true},
+		{"var n u64 = in.s.copy_from_slice(s:in.t)", false},
+		{"var a[4] u16[..3]\nvar n u64 = a[0:].copy_from_slice(s:in.s)", false},
+		{"var a[4] u16[..3]\nvar b[4] u16[..2]\nvar n u64 = a[0:].copy_from_slice(s:b[0:])", true},
+		{"var a[4] u16[..3]\nvar b[4] u16[1..4]\nvar n u64 = a[0:].copy_from_slice(s:b[0:])", false},
+		{"var b[4] u16[..2]\nvar n u64 = in.s.copy_from_slice(s:b[0:])", true},
+		{"var a[2][2] u16[..3]\nvar b[2][2] u16\nvar n u64 = a[0:].copy_from_slice(s:b[0:])", false},
+		{"var a[2][2] u16[..3]\nvar b[2][2] u16[..3]\nvar n u64 = a[0:].copy_from_slice(s:b[0:])", true},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npri func foo(s[] u16, t[] u32, i u64)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, nil, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q: got ok=%t (err=%v), want ok=%t", tc.body, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestTermination(t *testing.T) {
+	testCases := []struct {
+		body               string
+		requireTermination bool
+		wantOK             bool
+	}{
+		{"var i u32\nwhile i < 10 {\ni += 1\n}", true, true},
+		{"var i u32\nwhile i < 10 {\nif i == 3 {\ncontinue\n}\ni += 1\n}", false, true},
+		{"var i u32\nwhile i < 10 {\nif i == 3 {\ncontinue\n}\ni += 1\n}", true, false},
+		{"var n u32 = 10\nwhile n > 0, dec n {\nn -= 1\n}", false, true},
+		{"var n u32 = 10\nwhile n > 0, dec n {\nif n > 5 {\nn -= 1\n}\n}", false, false},
+		{"var i u32\nvar j u32\nwhile i < 10, dec (10 - i) + j {\nj = 0\ni += 1\n}", false, false},
+		{"while true {\nbreak\n}", true, true},
+		{"while true {\nvar x u8 = in.src.read_u8?()\n}", true, true},
+		{"var i u32\nwhile i < 10 {\ni += 1\nwhile true {\nbreak\n}\n}", true, true},
+		{"var i u32\nvar b bool\nwhile i < 10 {\ni += 1\nwhile b {\ni = 0\nb = false\n}\n}", true, false},
+	}
+
+	for _, tc := range testCases {
+		src := "pri func foo?(src reader1)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, &Options{RequireTermination: tc.requireTermination}, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q, requireTermination=%t: got ok=%t (err=%v), want ok=%t",
+				tc.body, tc.requireTermination, gotOK, err, tc.wantOK)
+		}
+	}
+}
+
+func TestReturnSuspension(t *testing.T) {
+	testCases := []struct {
+		body               string
+		requireTermination bool
+		wantOK             bool
+	}{
+		// The coroutine resumes after the return, so what follows is
+		// reachable.
+		{"return suspension \"short write\"\nassert false", false, false},
+		{"if in.x >= 5 {\nreturn suspension \"short write\"\n}\nassert in.x < 5", false, false},
+		{"if in.x >= 5 {\nreturn\n}\nassert in.x < 5", false, true},
+
+		// Facts about locals survive, but not facts about this, in or local
+		// slices, which are reset on resume.
+		{"var i u32 = 3\nreturn suspension \"short write\"\nassert i == 3", false, true},
+		{"if in.x < 5 {\nreturn suspension \"short write\"\nassert in.x < 5\n}", false, false},
+		{"if this.n < 5 {\nreturn suspension \"short write\"\nassert this.n < 5\n}", false, false},
+		{"var s[] u8 = this.a[0:]\nif s.length() > 2 {\ns[2] = 0\n}", false, true},
+		{"var s[] u8 = this.a[0:]\nif s.length() > 2 {\nreturn suspension \"short write\"\ns[2] = 0\n}", false, false},
+
+		// Returning a suspension ends the call, which counts as progress.
+		{"var i u32\nwhile i < 10 {\nreturn suspension \"short write\"\n}", true, true},
+		{"var i u32\nwhile i < 10 {\nif i == 3 {\nreturn suspension \"short write\"\n}\n}", true, false},
+	}
+
+	for _, tc := range testCases {
+		src := "packageid \"test\"\npri struct foo?(n u32, a[4] u8)\n" +
+			"pri func foo.bar?(src reader1, x u32)() {\n" + tc.body + "\n}\n"
+		_, _, err := checkSource(t, &Options{RequireTermination: tc.requireTermination}, src)
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("%q, requireTermination=%t: got ok=%t (err=%v), want ok=%t",
+				tc.body, tc.requireTermination, gotOK, err, tc.wantOK)
+		}
+	}
+}
@@ -0,0 +1,649 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"fmt"
+	"math/big"
+
+	a "github.com/google/puffs/lang/ast"
+	t "github.com/google/puffs/lang/token"
+)
+
+// constFuncFuel is how many statements, including each loop iteration, can be
+// executed when evaluating a const's value. It bounds how long the checker
+// takes if a const func does not terminate.
+const constFuncFuel = 1 << 20
+
+// maxConstFuncCallDepth is how deeply const func calls can be nested.
+const maxConstFuncCallDepth = 64
+
+// evalValue is a value computed at check time by evaluating const funcs. A
+// number or boolean (with false and true being 0 and 1) is held in n. An array
+// is held in elems, one evalValue per array element.
+type evalValue struct {
+	n     *big.Int
+	elems []evalValue
+}
+
+// clone returns a deep copy of v, as assigning an array copies it.
+func (v evalValue) clone() evalValue {
+	if v.n != nil {
+		return v
+	}
+	elems := make([]evalValue, len(v.elems))
+	for i, o := range v.elems {
+		elems[i] = o.clone()
+	}
+	return evalValue{elems: elems}
+}
+
+// evalJump is how executing a statement transfers control. A zero evalJump
+// means to carry on to the next statement. Otherwise, keyword is t.KeyBreak or
+// t.KeyContinue, for the loop target, or t.KeyReturn.
+type evalJump struct {
+	keyword t.Key
+	target  a.Loop
+}
+
+// evalFrame holds a const func call's in-params, out-params and local
+// variables.
+type evalFrame struct {
+	f    *a.Func
+	in   map[t.ID]*evalValue
+	out  map[t.ID]*evalValue
+	vars map[t.ID]*evalValue
+}
+
+// evaluator evaluates const func calls. It does not have to re-do the type
+// checker's work, but it replaces the bounds checker: every value is checked,
+// as it is computed, to be within its type's bounds.
+type evaluator struct {
+	q     *checker
+	fuel  int
+	depth int
+
+	// inFunc is whether an error has been annotated with the const func that
+	// it occurred in, so that it is not re-annotated by every caller.
+	inFunc bool
+}
+
+// evalConstValue evaluates n, a const value that calls const funcs.
+func (q *checker) evalConstValue(n *a.Expr) (evalValue, error) {
+	e := &evaluator{
+		q:    q,
+		fuel: constFuncFuel,
+	}
+	return e.evalExpr(nil, n)
+}
+
+// evalValueExpr returns v as a const value: a number or a "$(etc)" list.
+func (q *checker) evalValueExpr(v evalValue) (*a.Expr, error) {
+	if v.n != nil {
+		return q.constExpr(v.n)
+	}
+	args := make([]*a.Node, len(v.elems))
+	for i, o := range v.elems {
+		x, err := q.evalValueExpr(o)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = x.Node()
+	}
+	x := a.NewExpr(a.FlagsTypeChecked, t.IDDollar, 0, nil, nil, nil, args)
+	x.SetMType(typeExprList)
+	return x, nil
+}
+
+func (e *evaluator) zeroValue(typ *a.TypeExpr) (evalValue, error) {
+	switch typ.Decorator().Key() {
+	case 0:
+		if typ.IsNumType() || typ.IsBool() {
+			return evalValue{n: zero}, nil
+		}
+	case t.KeyOpenBracket:
+		length := typ.ArrayLength().ConstValue()
+		if length == nil || length.Sign() < 0 || length.Cmp(ffff) > 0 {
+			break
+		}
+		elems := make([]evalValue, length.Int64())
+		for i := range elems {
+			v, err := e.zeroValue(typ.Inner())
+			if err != nil {
+				return evalValue{}, err
+			}
+			elems[i] = v
+		}
+		return evalValue{elems: elems}, nil
+	}
+	return evalValue{}, fmt.Errorf("check: const funcs cannot use the type %q", typ.String(e.q.tm))
+}
+
+// checkValue checks that v, the value of n, is within typ's bounds.
+func (e *evaluator) checkValue(n *a.Expr, v evalValue, typ *a.TypeExpr) error {
+	if v.n == nil {
+		if typ.Decorator().Key() != t.KeyOpenBracket {
+			return nil
+		}
+		for _, o := range v.elems {
+			if err := e.checkValue(n, o, typ.Inner()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	nMin, nMax, err := e.q.bcheckTypeExpr(typ)
+	if err != nil {
+		return err
+	}
+	if (nMin != nil && v.n.Cmp(nMin) < 0) || (nMax != nil && v.n.Cmp(nMax) > 0) {
+		return fmt.Errorf("check: value %v of %q is not within [%v..%v]",
+			v.n, n.String(e.q.tm), nMin, nMax)
+	}
+	return nil
+}
+
+func (e *evaluator) call(fr *evalFrame, n *a.Expr) (evalValue, error) {
+	f := e.q.c.funcs[t.QID{0, n.LHS().Expr().ID1()}]
+	if f.Func == nil || !f.Func.Const() {
+		return evalValue{}, fmt.Errorf("check: %q is not a const func call", n.String(e.q.tm))
+	}
+	if e.depth == maxConstFuncCallDepth {
+		return evalValue{}, fmt.Errorf("check: const func call depth too large")
+	}
+
+	callee := &evalFrame{
+		f:    f.Func,
+		in:   map[t.ID]*evalValue{},
+		out:  map[t.ID]*evalValue{},
+		vars: map[t.ID]*evalValue{},
+	}
+	for i, o := range n.Args() {
+		o := o.Arg()
+		v, err := e.evalExpr(fr, o.Value())
+		if err != nil {
+			return evalValue{}, err
+		}
+		field := f.Func.In().Fields()[i].Field()
+		if err := e.checkValue(o.Value(), v, field.XType()); err != nil {
+			return evalValue{}, err
+		}
+		v = v.clone()
+		callee.in[field.Name()] = &v
+	}
+	for _, o := range f.Func.Out().Fields() {
+		o := o.Field()
+		v, err := e.zeroValue(o.XType())
+		if err != nil {
+			return evalValue{}, err
+		}
+		callee.out[o.Name()] = &v
+	}
+	for name, typ := range f.LocalVars {
+		if name == t.IDIn || name == t.IDOut {
+			continue
+		}
+		v, err := e.zeroValue(typ)
+		if err != nil {
+			return evalValue{}, err
+		}
+		callee.vars[name] = &v
+	}
+
+	e.depth++
+	_, err := e.execBlock(callee, f.Func.Body())
+	e.depth--
+	if err != nil {
+		if !e.inFunc {
+			e.inFunc = true
+			err = fmt.Errorf("%v in const func %q", err, f.Func.Name().String(e.q.tm))
+		}
+		return evalValue{}, err
+	}
+	return *callee.out[f.Func.Out().Fields()[0].Field().Name()], nil
+}
+
+func (e *evaluator) execBlock(fr *evalFrame, block []*a.Node) (evalJump, error) {
+	for _, o := range block {
+		if j, err := e.execStatement(fr, o); err != nil || j.keyword != 0 {
+			return j, err
+		}
+	}
+	return evalJump{}, nil
+}
+
+func (e *evaluator) execStatement(fr *evalFrame, n *a.Node) (evalJump, error) {
+	if e.fuel == 0 {
+		return evalJump{}, fmt.Errorf("check: const func evaluation ran out of fuel")
+	}
+	e.fuel--
+
+	switch n.Kind() {
+	case a.KAssert:
+		n := n.Assert()
+		if n.Keyword().Key() != t.KeyAssert {
+			return evalJump{}, nil
+		}
+		v, err := e.evalExpr(fr, n.Condition())
+		if err != nil {
+			return evalJump{}, err
+		}
+		if v.n.Sign() == 0 {
+			return evalJump{}, fmt.Errorf("check: assertion %q failed", n.Condition().String(e.q.tm))
+		}
+		return evalJump{}, nil
+
+	case a.KAssign:
+		return evalJump{}, e.execAssign(fr, n.Assign())
+
+	case a.KExpr:
+		_, err := e.evalExpr(fr, n.Expr())
+		return evalJump{}, err
+
+	case a.KIf:
+		for n := n.If(); n != nil; n = n.ElseIf() {
+			v, err := e.evalExpr(fr, n.Condition())
+			if err != nil {
+				return evalJump{}, err
+			}
+			if v.n.Sign() != 0 {
+				return e.execBlock(fr, n.BodyIfTrue())
+			}
+			if n.ElseIf() == nil {
+				return e.execBlock(fr, n.BodyIfFalse())
+			}
+		}
+		return evalJump{}, nil
+
+	case a.KJump:
+		n := n.Jump()
+		return evalJump{keyword: n.Keyword().Key(), target: n.JumpTarget()}, nil
+
+	case a.KReturn:
+		return evalJump{keyword: t.KeyReturn}, nil
+
+	case a.KSwitch:
+		return e.execSwitch(fr, n.Switch())
+
+	case a.KVar:
+		n := n.Var()
+		v, err := evalValue{}, error(nil)
+		if value := n.Value(); value != nil {
+			v, err = e.evalExpr(fr, value)
+			if err != nil {
+				return evalJump{}, err
+			}
+			if err := e.checkValue(value, v, n.XType()); err != nil {
+				return evalJump{}, err
+			}
+			v = v.clone()
+		} else if v, err = e.zeroValue(n.XType()); err != nil {
+			return evalJump{}, err
+		}
+		fr.vars[n.Name()] = &v
+		return evalJump{}, nil
+
+	case a.KWhile:
+		n := n.While()
+		for {
+			if e.fuel == 0 {
+				return evalJump{}, fmt.Errorf("check: const func evaluation ran out of fuel")
+			}
+			e.fuel--
+
+			v, err := e.evalExpr(fr, n.Condition())
+			if err != nil {
+				return evalJump{}, err
+			}
+			if v.n.Sign() == 0 {
+				return evalJump{}, nil
+			}
+			j, err := e.execBlock(fr, n.Body())
+			if err != nil {
+				return evalJump{}, err
+			}
+			if j.keyword == 0 {
+				continue
+			}
+			if j.keyword == t.KeyReturn || j.target.Node() != n.Node() {
+				return j, nil
+			}
+			if j.keyword == t.KeyBreak {
+				return evalJump{}, nil
+			}
+		}
+	}
+	return evalJump{}, fmt.Errorf("check: unsupported ast.Kind (%s) in const func %q",
+		n.Kind(), fr.f.Name().String(e.q.tm))
+}
+
+func (e *evaluator) execAssign(fr *evalFrame, n *a.Assign) error {
+	lhs, rhs := n.LHS(), n.RHS()
+	v, err := e.evalExpr(fr, rhs)
+	if err != nil {
+		return err
+	}
+	if op := n.Operator(); op.Key() != t.KeyEq {
+		l, err := e.evalExpr(fr, lhs)
+		if err != nil {
+			return err
+		}
+		x := a.NewExpr(0, op.BinaryForm(), 0, lhs.Node(), nil, rhs.Node(), nil)
+		ncv, err := evalConstValueBinaryOp(e.q.tm, x, l.n, v.n)
+		if err != nil {
+			return err
+		}
+		switch x.ID0().Key() {
+		case t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+			ncv = saturateConstValue(ncv, lhs.MType().Name().Key())
+		default:
+			if tildeOps[0xFF&x.ID0().Key()] {
+				ncv = wrapConstValue(ncv, lhs.MType().Name().Key())
+			}
+		}
+		v = evalValue{n: ncv}
+	}
+	if err := e.checkValue(lhs, v, lhs.MType()); err != nil {
+		return err
+	}
+	p, err := e.lvalue(fr, lhs)
+	if err != nil {
+		return err
+	}
+	*p = v.clone()
+	return nil
+}
+
+func (e *evaluator) execSwitch(fr *evalFrame, n *a.Switch) (evalJump, error) {
+	v, err := e.evalExpr(fr, n.Scrutinee())
+	if err != nil {
+		return evalJump{}, err
+	}
+	for _, c := range n.Cases() {
+		c := c.Case()
+		for _, o := range c.Values() {
+			o := o.Expr()
+			lo, hi := o.ConstValue(), o.ConstValue()
+			if o.ID0().Key() == t.KeyDotDot {
+				lo, hi = o.LHS().Expr().ConstValue(), o.RHS().Expr().ConstValue()
+			}
+			if v.n.Cmp(lo) >= 0 && v.n.Cmp(hi) <= 0 {
+				return e.execBlock(fr, c.Body())
+			}
+		}
+	}
+	return e.execBlock(fr, n.BodyIfElse())
+}
+
+// lvalue returns the storage that the assignee n refers to: a local variable,
+// an in- or out-param, or an element of one of those.
+func (e *evaluator) lvalue(fr *evalFrame, n *a.Expr) (*evalValue, error) {
+	switch n.ID0().Key() {
+	case 0:
+		if p := fr.vars[n.ID1()]; p != nil {
+			return p, nil
+		}
+	case t.KeyDot:
+		if p := e.param(fr, n); p != nil {
+			return p, nil
+		}
+	case t.KeyOpenBracket:
+		p, err := e.lvalue(fr, n.LHS().Expr())
+		if err != nil {
+			return nil, err
+		}
+		return e.index(fr, n, p)
+	}
+	return nil, fmt.Errorf("check: cannot assign to %q in a const func", n.String(e.q.tm))
+}
+
+// param returns the in- or out-param that n, such as "in.x", refers to.
+func (e *evaluator) param(fr *evalFrame, n *a.Expr) *evalValue {
+	if fr == nil {
+		return nil
+	}
+	lhs := n.LHS().Expr()
+	if lhs.ID0() != 0 {
+		return nil
+	}
+	switch lhs.ID1().Key() {
+	case t.KeyIn:
+		return fr.in[n.ID1()]
+	case t.KeyOut:
+		return fr.out[n.ID1()]
+	}
+	return nil
+}
+
+// index returns the element of the array p that the index expression n refers
+// to.
+func (e *evaluator) index(fr *evalFrame, n *a.Expr, p *evalValue) (*evalValue, error) {
+	i, err := e.evalExpr(fr, n.RHS().Expr())
+	if err != nil {
+		return nil, err
+	}
+	if i.n.Sign() < 0 || i.n.Cmp(big.NewInt(int64(len(p.elems)))) >= 0 {
+		return nil, fmt.Errorf("check: index %v of %q is not within [0..%d]",
+			i.n, n.String(e.q.tm), len(p.elems)-1)
+	}
+	return &p.elems[i.n.Int64()], nil
+}
+
+func (e *evaluator) evalExpr(fr *evalFrame, n *a.Expr) (evalValue, error) {
+	if cv := n.ConstValue(); cv != nil {
+		return evalValue{n: cv}, nil
+	}
+	v, err := e.evalExpr1(fr, n)
+	if err != nil {
+		return evalValue{}, err
+	}
+	if typ := n.MType(); v.n != nil && typ.IsNumType() {
+		if err := e.checkValue(n, v, typ); err != nil {
+			return evalValue{}, err
+		}
+	}
+	return v, nil
+}
+
+func (e *evaluator) evalExpr1(fr *evalFrame, n *a.Expr) (evalValue, error) {
+	switch op := n.ID0(); op.Flags() & (t.FlagsUnaryOp | t.FlagsBinaryOp | t.FlagsAssociativeOp) {
+	case t.FlagsUnaryOp:
+		v, err := e.evalExpr(fr, n.RHS().Expr())
+		if err != nil {
+			return evalValue{}, err
+		}
+		switch op.Key() {
+		case t.KeyXUnaryPlus:
+			return v, nil
+		case t.KeyXUnaryMinus:
+			return evalValue{n: neg(v.n)}, nil
+		case t.KeyXUnaryNot:
+			return evalValue{n: btoi(v.n.Sign() == 0)}, nil
+		}
+
+	case t.FlagsBinaryOp:
+		return e.evalBinaryOp(fr, n)
+
+	case t.FlagsAssociativeOp:
+		return e.evalAssociativeOp(fr, n)
+
+	case 0:
+		return e.evalOther(fr, n)
+	}
+	return evalValue{}, fmt.Errorf("check: cannot evaluate %q in a const func", n.String(e.q.tm))
+}
+
+func (e *evaluator) evalBinaryOp(fr *evalFrame, n *a.Expr) (evalValue, error) {
+	op := n.ID0()
+	lhs := n.LHS().Expr()
+	l, err := e.evalExpr(fr, lhs)
+	if err != nil {
+		return evalValue{}, err
+	}
+
+	switch op.Key() {
+	case t.KeyXBinaryAs:
+		return l, nil
+	case t.KeyXBinaryTildeAs:
+		return evalValue{n: wrapConstValue(l.n, n.RHS().TypeExpr().Name().Key())}, nil
+	case t.KeyXBinaryAnd:
+		if l.n.Sign() == 0 {
+			return l, nil
+		}
+		return e.evalExpr(fr, n.RHS().Expr())
+	case t.KeyXBinaryOr:
+		if l.n.Sign() != 0 {
+			return l, nil
+		}
+		return e.evalExpr(fr, n.RHS().Expr())
+	}
+
+	rhs := n.RHS().Expr()
+	r, err := e.evalExpr(fr, rhs)
+	if err != nil {
+		return evalValue{}, err
+	}
+	ncv, err := evalConstValueBinaryOp(e.q.tm, n, l.n, r.n)
+	if err != nil {
+		return evalValue{}, err
+	}
+	switch op.Key() {
+	case t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+		ncv = saturateConstValue(ncv, nonIdealType(lhs.MType(), rhs.MType()).Name().Key())
+	default:
+		if tildeOps[0xFF&op.Key()] {
+			ncv = wrapConstValue(ncv, nonIdealType(lhs.MType(), rhs.MType()).Name().Key())
+		}
+	}
+	return evalValue{n: ncv}, nil
+}
+
+func (e *evaluator) evalAssociativeOp(fr *evalFrame, n *a.Expr) (evalValue, error) {
+	op := n.ID0().AmbiguousForm().BinaryForm()
+	acc := (*big.Int)(nil)
+	for _, o := range n.Args() {
+		v, err := e.evalExpr(fr, o.Expr())
+		if err != nil {
+			return evalValue{}, err
+		}
+		if acc == nil {
+			acc = v.n
+			continue
+		}
+		switch op.Key() {
+		case t.KeyXBinaryAnd:
+			if acc.Sign() == 0 {
+				return evalValue{n: acc}, nil
+			}
+			acc = v.n
+			continue
+		case t.KeyXBinaryOr:
+			if acc.Sign() != 0 {
+				return evalValue{n: acc}, nil
+			}
+			acc = v.n
+			continue
+		}
+		x := a.NewExpr(0, op, 0, nil, nil, nil, nil)
+		if acc, err = evalConstValueBinaryOp(e.q.tm, x, acc, v.n); err != nil {
+			return evalValue{}, err
+		}
+	}
+	return evalValue{n: acc}, nil
+}
+
+func (e *evaluator) evalOther(fr *evalFrame, n *a.Expr) (evalValue, error) {
+	switch n.ID0().Key() {
+	case 0:
+		id1 := n.ID1()
+		if id1.IsStrLiteral() {
+			return e.constListValue(n)
+		}
+		if fr != nil {
+			if p := fr.vars[id1]; p != nil {
+				return p.clone(), nil
+			}
+		}
+		if c, ok := e.q.c.consts[id1]; ok {
+			if c.Value == nil {
+				return evalValue{}, fmt.Errorf("check: const %q is used before it is evaluated",
+					id1.String(e.q.tm))
+			}
+			return e.constListValue(c.Value)
+		}
+
+	case t.KeyOpenParen:
+		return e.call(fr, n)
+
+	case t.KeyOpenBracket:
+		lhs, err := e.evalExpr(fr, n.LHS().Expr())
+		if err != nil {
+			return evalValue{}, err
+		}
+		p, err := e.index(fr, n, &lhs)
+		if err != nil {
+			return evalValue{}, err
+		}
+		return *p, nil
+
+	case t.KeyDot:
+		if p := e.param(fr, n); p != nil {
+			return p.clone(), nil
+		}
+
+	case t.KeyDollar:
+		elems := make([]evalValue, len(n.Args()))
+		for i, o := range n.Args() {
+			v, err := e.evalExpr(fr, o.Expr())
+			if err != nil {
+				return evalValue{}, err
+			}
+			elems[i] = v
+		}
+		return evalValue{elems: elems}, nil
+	}
+	return evalValue{}, fmt.Errorf("check: cannot evaluate %q in a const func", n.String(e.q.tm))
+}
+
+// constListValue returns the value of n, a checked const value such as "$(1,
+// 2)", "\"GIF8\"" or "0x20".
+func (e *evaluator) constListValue(n *a.Expr) (evalValue, error) {
+	if n.ID0().Key() == t.KeyDollar {
+		elems := make([]evalValue, len(n.Args()))
+		for i, o := range n.Args() {
+			v, err := e.constListValue(o.Expr())
+			if err != nil {
+				return evalValue{}, err
+			}
+			elems[i] = v
+		}
+		return evalValue{elems: elems}, nil
+	}
+	if n.ID0() == 0 && n.ID1().IsStrLiteral() {
+		s, ok := t.Unescape(n.ID1().String(e.q.tm))
+		if !ok {
+			return evalValue{}, fmt.Errorf("check: invalid string literal %q", n.String(e.q.tm))
+		}
+		elems := make([]evalValue, len(s))
+		for i := range elems {
+			elems[i] = evalValue{n: big.NewInt(int64(s[i]))}
+		}
+		return evalValue{elems: elems}, nil
+	}
+	if cv := n.ConstValue(); cv != nil {
+		return evalValue{n: cv}, nil
+	}
+	return evalValue{}, fmt.Errorf("check: invalid const value %q", n.String(e.q.tm))
+}
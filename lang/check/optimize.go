@@ -236,16 +236,37 @@ func (q *checker) optimizeSuspendible(n *a.Expr, depth uint32) error {
 		return nil
 	}
 
-	if nMethod < t.Key(len(ioMethodAdvances)) {
-		if advance := ioMethodAdvances[nMethod]; advance != nil {
-			return q.optimizeIOMethodAdvance(n, nReceiver, advance)
-		}
+	if need, advance := ioMethodAdvance(q.tm, n); need != nil {
+		return q.optimizeIOMethodAdvance(n, nReceiver, need, advance)
 	}
 
 	return nil
 }
 
-func (q *checker) optimizeIOMethodAdvance(n *a.Expr, receiver *a.Expr, advance *big.Int) error {
+// ioMethodAdvance returns how many bytes the I/O method call n needs to be
+// available, and how many bytes it reads or writes, or nil if those are not
+// constants. A peek needs bytes to be available, but does not advance past
+// them.
+func ioMethodAdvance(tm *t.Map, n *a.Expr) (need *big.Int, advance *big.Int) {
+	if m, ok := ioMethod(tm, n); ok {
+		need = big.NewInt(int64(m.NBytes))
+		if m.Op == "peek" {
+			return need, zero
+		}
+		return need, need
+	}
+	if _, method, args := splitReceiverMethodArgs(n); method == t.KeyReadMatch && len(args) != 0 {
+		if advance := readMatchLength(args[0].Arg().Value()); advance != nil {
+			return advance, advance
+		}
+	}
+	return nil, nil
+}
+
+// optimizeIOMethodAdvance marks the I/O method call n as proven not to suspend
+// if the facts show that at least need bytes are available, and updates those
+// facts for the call advancing by advance bytes.
+func (q *checker) optimizeIOMethodAdvance(n *a.Expr, receiver *a.Expr, need *big.Int, advance *big.Int) error {
 	return q.facts.update(func(x *a.Expr) (*a.Expr, error) {
 		op := x.ID0().Key()
 		if op != t.KeyXBinaryGreaterEq && op != t.KeyXBinaryGreaterThan {
@@ -279,7 +300,7 @@ func (q *checker) optimizeIOMethodAdvance(n *a.Expr, receiver *a.Expr, advance *
 			op = t.KeyXBinaryGreaterEq
 			rcv = big.NewInt(0).Add(rcv, one)
 		}
-		if rcv.Cmp(advance) < 0 {
+		if rcv.Cmp(need) < 0 {
 			return nil, nil
 		}
 		rcv = big.NewInt(0).Sub(rcv, advance)
@@ -302,20 +323,3 @@ func (q *checker) optimizeIOMethodAdvance(n *a.Expr, receiver *a.Expr, advance *
 	})
 }
 
-var ioMethodAdvances = [256]*big.Int{
-	t.KeyReadU8:    one,
-	t.KeyReadU16BE: two,
-	t.KeyReadU16LE: two,
-	t.KeyReadU32BE: four,
-	t.KeyReadU32LE: four,
-	t.KeyReadU64BE: eight,
-	t.KeyReadU64LE: eight,
-
-	t.KeyWriteU8:    one,
-	t.KeyWriteU16BE: two,
-	t.KeyWriteU16LE: two,
-	t.KeyWriteU32BE: four,
-	t.KeyWriteU32LE: four,
-	t.KeyWriteU64BE: eight,
-	t.KeyWriteU64LE: eight,
-}
@@ -0,0 +1,550 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"fmt"
+	"math/big"
+
+	a "github.com/google/puffs/lang/ast"
+	t "github.com/google/puffs/lang/token"
+)
+
+// A while loop terminates if, on every path from the start of its body to an
+// explicit or implicit continue, either:
+//  - its measure strictly decreases,
+//  - it reads from a reader1 or writes to a writer1, which either consumes
+//    some of a finite amount of input (or output space) or suspends, or
+//  - it returns a suspension, which ends the call.
+//
+// The measure is the loop's "dec etc" expression, if it has one. Otherwise,
+// for a "while x < y" style loop, it is the implicit "y - x". Either way, it
+// has to be a sum or difference of integer-typed variables and constants.
+// Puffs' bounds checking already proves that every variable stays within its
+// type's range, so the measure is always bounded below.
+//
+// A loop with an explicit "dec" that cannot be proven to terminate is a check
+// error. A loop without one is only an error if RequireTermination is set.
+
+// measureTerm is a variable (or field) in a linear measure.
+type measureTerm struct {
+	x   *a.Expr
+	neg bool // Whether the measure is "etc - x" instead of "etc + x".
+}
+
+// loopProgress is how a loop's measure terms have changed, and whether any
+// I/O has happened, along the current path through that loop's body.
+type loopProgress struct {
+	loop     *a.While
+	required bool
+	terms    []measureTerm
+	// deltas[i] holds the [min, max] change of terms[i]. A nil bound is
+	// unbounded.
+	deltas   [][2]*big.Int
+	advanced bool
+}
+
+func (p *loopProgress) clone() *loopProgress {
+	o := *p
+	o.deltas = append([][2]*big.Int(nil), p.deltas...)
+	return &o
+}
+
+func cloneProgress(ps []*loopProgress) []*loopProgress {
+	ret := make([]*loopProgress, len(ps))
+	for i, p := range ps {
+		ret[i] = p.clone()
+	}
+	return ret
+}
+
+// mergeProgress returns the worst case of the given paths. Each path must have
+// the same loops, in the same order.
+func mergeProgress(paths [][]*loopProgress) []*loopProgress {
+	if len(paths) == 0 {
+		return nil
+	}
+	ret := cloneProgress(paths[0])
+	for _, path := range paths[1:] {
+		for i, p := range path {
+			r := ret[i]
+			r.advanced = r.advanced && p.advanced
+			for j, d := range p.deltas {
+				lo, hi := r.deltas[j][0], r.deltas[j][1]
+				if lo != nil && d[0] != nil {
+					lo = min(lo, d[0])
+				} else {
+					lo = nil
+				}
+				if hi != nil && d[1] != nil {
+					hi = max(hi, d[1])
+				} else {
+					hi = nil
+				}
+				r.deltas[j] = [2]*big.Int{lo, hi}
+			}
+		}
+	}
+	return ret
+}
+
+// parseMeasure returns n, a sum or difference of terms, as a list of terms. A
+// nil list and false means that n is not of that form. Constants are dropped,
+// as they do not affect whether a measure decreases.
+func parseMeasure(n *a.Expr, neg bool, terms []measureTerm) ([]measureTerm, bool) {
+	if n.ConstValue() != nil {
+		return terms, true
+	}
+	switch n.ID0().Key() {
+	case 0, t.KeyDot:
+		if !isMeasureTerm(n) {
+			return nil, false
+		}
+		return append(terms, measureTerm{x: n, neg: neg}), true
+	case t.KeyXBinaryPlus, t.KeyXBinaryMinus:
+		terms, ok := parseMeasure(n.LHS().Expr(), neg, terms)
+		if !ok {
+			return nil, false
+		}
+		return parseMeasure(n.RHS().Expr(), neg != (n.ID0().Key() == t.KeyXBinaryMinus), terms)
+	case t.KeyXAssociativePlus:
+		for _, o := range n.Args() {
+			var ok bool
+			if terms, ok = parseMeasure(o.Expr(), neg, terms); !ok {
+				return nil, false
+			}
+		}
+		return terms, true
+	case t.KeyXUnaryPlus:
+		return parseMeasure(n.RHS().Expr(), neg, terms)
+	case t.KeyXUnaryMinus:
+		return parseMeasure(n.RHS().Expr(), !neg, terms)
+	}
+	return nil, false
+}
+
+// isMeasureTerm returns whether n is an integer-typed "x" or "x.y.z".
+func isMeasureTerm(n *a.Expr) bool {
+	if typ := n.MType(); typ == nil || !typ.IsNumType() {
+		return false
+	}
+	for ; n.ID0().Key() == t.KeyDot; n = n.LHS().Expr() {
+	}
+	return n.ID0() == 0
+}
+
+// measureTermRoot returns the "x" in "x.y.z".
+func measureTermRoot(n *a.Expr) t.ID {
+	for ; n.ID0().Key() == t.KeyDot; n = n.LHS().Expr() {
+	}
+	return n.ID1()
+}
+
+// implicitMeasure returns the implied measure of a "while x < y" style loop:
+// "y - x". It returns nil if there is no such measure.
+func implicitMeasure(cond *a.Expr) []measureTerm {
+	op, lhs, rhs := parseBinaryOp(cond)
+	switch op.Key() {
+	case t.KeyXBinaryLessThan, t.KeyXBinaryLessEq:
+	case t.KeyXBinaryGreaterThan, t.KeyXBinaryGreaterEq:
+		lhs, rhs = rhs, lhs
+	default:
+		return nil
+	}
+	terms, ok := parseMeasure(rhs, false, nil)
+	if !ok {
+		return nil
+	}
+	terms, ok = parseMeasure(lhs, true, terms)
+	if !ok {
+		return nil
+	}
+	return terms
+}
+
+func (q *checker) proveTermination(block []*a.Node) error {
+	_, _, err := q.terminateBlock(block, nil)
+	return err
+}
+
+// terminateBlock walks block, updating the progress of each enclosing loop.
+// It returns whether the block can fall through to the next statement, and if
+// so, the progress at that point.
+func (q *checker) terminateBlock(block []*a.Node, ps []*loopProgress) ([]*loopProgress, bool, error) {
+	for _, o := range block {
+		var fallsThrough bool
+		var err error
+		ps, fallsThrough, err = q.terminateStatement(o, ps)
+		if err != nil {
+			return nil, false, err
+		}
+		if !fallsThrough {
+			return nil, false, nil
+		}
+	}
+	return ps, true, nil
+}
+
+func (q *checker) terminateStatement(n *a.Node, ps []*loopProgress) ([]*loopProgress, bool, error) {
+	q.errFilename, q.errLine = n.Raw().FilenameLine()
+
+	switch n.Kind() {
+	case a.KAssert:
+		return ps, true, nil
+
+	case a.KAssign:
+		n := n.Assign()
+		q.terminateExpr(n.LHS(), ps)
+		q.terminateExpr(n.RHS(), ps)
+		q.terminateAssignment(n.LHS(), n.Operator(), n.RHS(), ps)
+		return ps, true, nil
+
+	case a.KExpr:
+		q.terminateExpr(n.Expr(), ps)
+		return ps, true, nil
+
+	case a.KIf:
+		paths := [][]*loopProgress(nil)
+		for n := n.If(); n != nil; n = n.ElseIf() {
+			q.terminateExpr(n.Condition(), ps)
+			if p, fallsThrough, err := q.terminateBlock(n.BodyIfTrue(), cloneProgress(ps)); err != nil {
+				return nil, false, err
+			} else if fallsThrough {
+				paths = append(paths, p)
+			}
+			if bif := n.BodyIfFalse(); len(bif) > 0 {
+				if p, fallsThrough, err := q.terminateBlock(bif, cloneProgress(ps)); err != nil {
+					return nil, false, err
+				} else if fallsThrough {
+					paths = append(paths, p)
+				}
+				break
+			}
+			if n.ElseIf() == nil {
+				paths = append(paths, ps)
+			}
+		}
+		return mergeProgress(paths), len(paths) > 0, nil
+
+	case a.KSwitch:
+		n := n.Switch()
+		q.terminateExpr(n.Scrutinee(), ps)
+		paths := [][]*loopProgress(nil)
+		for _, c := range n.Cases() {
+			if p, fallsThrough, err := q.terminateBlock(c.Case().Body(), cloneProgress(ps)); err != nil {
+				return nil, false, err
+			} else if fallsThrough {
+				paths = append(paths, p)
+			}
+		}
+		if n.HasElse() {
+			if p, fallsThrough, err := q.terminateBlock(n.BodyIfElse(), cloneProgress(ps)); err != nil {
+				return nil, false, err
+			} else if fallsThrough {
+				paths = append(paths, p)
+			}
+		}
+		return mergeProgress(paths), len(paths) > 0, nil
+
+	case a.KIterate:
+		n := n.Iterate()
+		for _, o := range n.Variables() {
+			if v := o.Var().Value(); v != nil {
+				q.terminateExpr(v, ps)
+			}
+		}
+		ps = q.terminateWiden(n.Body(), ps)
+		if _, _, err := q.terminateBlock(n.Body(), cloneProgress(ps)); err != nil {
+			return nil, false, err
+		}
+		return ps, true, nil
+
+	case a.KJump:
+		n := n.Jump()
+		if n.Keyword().Key() == t.KeyContinue {
+			for _, p := range ps {
+				if a.Loop(p.loop) == n.JumpTarget() {
+					return nil, false, q.terminateContinue(p)
+				}
+			}
+		}
+		return nil, false, nil
+
+	case a.KReturn:
+		if v := n.Return().Value(); v != nil {
+			q.terminateExpr(v, ps)
+		}
+		if isSuspension(n) {
+			// Returning a suspension ends this call, and the coroutine only
+			// resumes after it when called again. A path through a loop body
+			// that suspends can therefore only be taken once per call: the
+			// next time that it suspends, the call ends. Like I/O that
+			// suspends, it counts as progress.
+			for _, p := range ps {
+				p.advanced = true
+			}
+			return ps, true, nil
+		}
+		return nil, false, nil
+
+	case a.KVar:
+		n := n.Var()
+		if v := n.Value(); v != nil {
+			q.terminateExpr(v, ps)
+		}
+		q.terminateInvalidate(ps, func(x *a.Expr) bool {
+			return measureTermRoot(x) == n.Name()
+		})
+		return ps, true, nil
+
+	case a.KWhile:
+		return q.terminateWhile(n.While(), ps)
+	}
+	return nil, false, fmt.Errorf("check: unrecognized ast.Kind (%s) for terminateStatement", n.Kind())
+}
+
+func (q *checker) terminateWhile(n *a.While, ps []*loopProgress) ([]*loopProgress, bool, error) {
+	cv := n.Condition().ConstValue()
+	if cv != nil && cv.Cmp(zero) == 0 {
+		// We effectively have a "while false { etc }" loop. The body never
+		// runs.
+		return ps, true, nil
+	}
+	q.terminateExpr(n.Condition(), ps)
+
+	// From the enclosing loops' point of view, the body runs zero or more
+	// times, so any of their terms that it could change are unbounded.
+	ps = q.terminateWiden(n.Body(), ps)
+
+	p := &loopProgress{
+		loop:     n,
+		required: q.c.requireTermination,
+	}
+	if m := n.Measure(); m != nil {
+		terms, ok := parseMeasure(m, false, nil)
+		if !ok {
+			return nil, false, fmt.Errorf("check: cannot prove termination: dec measure %q is not "+
+				"a sum or difference of variables and constants", m.String(q.tm))
+		}
+		p.required = true
+		p.terms = terms
+	} else if cv == nil {
+		p.terms = implicitMeasure(n.Condition())
+	}
+	p.deltas = make([][2]*big.Int, len(p.terms))
+	for i := range p.deltas {
+		p.deltas[i] = [2]*big.Int{zero, zero}
+	}
+
+	// Check the implicit continue after the body.
+	if body, fallsThrough, err := q.terminateBlock(n.Body(), append(cloneProgress(ps), p)); err != nil {
+		return nil, false, err
+	} else if fallsThrough {
+		q.errFilename, q.errLine = n.Node().Raw().FilenameLine()
+		if err := q.terminateContinue(body[len(body)-1]); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if cv != nil && cv.Cmp(one) == 0 && !n.HasBreak() {
+		// We effectively have a "while true { etc }" loop without an explicit
+		// break. It never exits naturally.
+		return nil, false, nil
+	}
+	return ps, true, nil
+}
+
+// terminateContinue checks that p's loop has made progress on reaching an
+// explicit or implicit continue.
+func (q *checker) terminateContinue(p *loopProgress) error {
+	if p.advanced {
+		return nil
+	}
+	if len(p.terms) > 0 {
+		sum, ok := big.NewInt(0), true
+		for i, term := range p.terms {
+			d := p.deltas[i][1]
+			if term.neg {
+				if d = p.deltas[i][0]; d != nil {
+					d = neg(d)
+				}
+			}
+			if d == nil {
+				ok = false
+				break
+			}
+			sum.Add(sum, d)
+		}
+		if ok && sum.Sign() < 0 {
+			return nil
+		}
+	}
+	if !p.required {
+		return nil
+	}
+	if m := p.loop.Measure(); m != nil {
+		return fmt.Errorf("check: cannot prove termination: dec measure %q does not strictly "+
+			"decrease on every path through the loop body", m.String(q.tm))
+	}
+	return fmt.Errorf("check: cannot prove termination of \"while %s\": no dec measure, and not "+
+		"every path through the loop body reads or writes", p.loop.Condition().String(q.tm))
+}
+
+func (q *checker) terminateAssignment(lhs *a.Expr, op t.ID, rhs *a.Expr, ps []*loopProgress) {
+	for _, p := range ps {
+		for i, term := range p.terms {
+			if !term.x.Eq(lhs) {
+				if term.x.Mentions(lhs) {
+					p.deltas[i] = [2]*big.Int{}
+				}
+				continue
+			}
+			d := p.deltas[i]
+			switch op.Key() {
+			case t.KeyPlusEq, t.KeyMinusEq:
+				rMin, rMax := q.terminateBounds(rhs)
+				if op.Key() == t.KeyMinusEq {
+					rMin, rMax = negOrNil(rMax), negOrNil(rMin)
+				}
+				p.deltas[i] = [2]*big.Int{addOrNil(d[0], rMin), addOrNil(d[1], rMax)}
+			default:
+				p.deltas[i] = [2]*big.Int{}
+			}
+		}
+	}
+}
+
+// terminateBounds returns the bounds of n, based only on its type. Unlike
+// bcheckExpr, it does not use facts, as the termination proof does not track
+// them.
+func (q *checker) terminateBounds(n *a.Expr) (*big.Int, *big.Int) {
+	if cv := n.ConstValue(); cv != nil {
+		return cv, cv
+	}
+	nMin, nMax, err := q.bcheckTypeExpr(n.MType())
+	if err != nil {
+		return nil, nil
+	}
+	return nMin, nMax
+}
+
+// terminateExpr notes any I/O in n, and any calls that could modify "this".
+func (q *checker) terminateExpr(n *a.Expr, ps []*loopProgress) {
+	if len(ps) == 0 {
+		return
+	}
+	advanced, impure := false, false
+	n.Node().Walk(func(o *a.Node) error {
+		if o.Kind() != a.KExpr {
+			return nil
+		}
+		o1 := o.Expr()
+		if isIOAdvance(q.tm, o1) {
+			advanced = true
+		} else if o1.CallImpure() {
+			impure = true
+		}
+		return nil
+	})
+	if advanced {
+		for _, p := range ps {
+			p.advanced = true
+		}
+	}
+	if impure {
+		q.terminateInvalidate(ps, func(x *a.Expr) bool {
+			return measureTermRoot(x).Key() == t.KeyThis
+		})
+	}
+}
+
+// isIOAdvance returns whether n is a call like "in.src.read_u8?()" that either
+// advances a reader1 or writer1 or suspends.
+func isIOAdvance(tm *t.Map, n *a.Expr) bool {
+	if !n.CallSuspendible() {
+		return false
+	}
+	receiver, _, _ := splitReceiverMethodArgs(n)
+	if receiver == nil {
+		return false
+	}
+	if _, advance := ioMethodAdvance(tm, n); advance == nil || advance.Sign() == 0 {
+		return false
+	}
+	typ := receiver.MType()
+	if typ == nil || typ.Decorator() != 0 {
+		return false
+	}
+	key := typ.Name().Key()
+	return key == t.KeyReader1 || key == t.KeyWriter1
+}
+
+// terminateWiden returns ps after marking as unbounded every term that block
+// could change.
+func (q *checker) terminateWiden(block []*a.Node, ps []*loopProgress) []*loopProgress {
+	if len(ps) == 0 {
+		return ps
+	}
+	ps = cloneProgress(ps)
+	for _, o := range block {
+		o.Walk(func(o *a.Node) error {
+			switch o.Kind() {
+			case a.KAssign:
+				lhs := o.Assign().LHS()
+				q.terminateInvalidate(ps, func(x *a.Expr) bool {
+					return x.Mentions(lhs)
+				})
+			case a.KVar:
+				name := o.Var().Name()
+				q.terminateInvalidate(ps, func(x *a.Expr) bool {
+					return measureTermRoot(x) == name
+				})
+			case a.KExpr:
+				if o.Expr().CallImpure() && !isIOAdvance(q.tm, o.Expr()) {
+					q.terminateInvalidate(ps, func(x *a.Expr) bool {
+						return measureTermRoot(x).Key() == t.KeyThis
+					})
+				}
+			}
+			return nil
+		})
+	}
+	return ps
+}
+
+// terminateInvalidate marks as unbounded every term that matches f.
+func (q *checker) terminateInvalidate(ps []*loopProgress, f func(*a.Expr) bool) {
+	for _, p := range ps {
+		for i, term := range p.terms {
+			if f(term.x) {
+				p.deltas[i] = [2]*big.Int{}
+			}
+		}
+	}
+}
+
+func addOrNil(i, j *big.Int) *big.Int {
+	if i == nil || j == nil {
+		return nil
+	}
+	return big.NewInt(0).Add(i, j)
+}
+
+func negOrNil(i *big.Int) *big.Int {
+	if i == nil {
+		return nil
+	}
+	return neg(i)
+}
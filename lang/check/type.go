@@ -17,6 +17,7 @@ package check
 import (
 	"fmt"
 	"math/big"
+	"sort"
 
 	"github.com/google/puffs/lang/builtin"
 
@@ -47,6 +48,17 @@ func (q *checker) tcheckVars(block []*a.Node) error {
 				return err
 			}
 
+		case a.KSwitch:
+			o := o.Switch()
+			for _, c := range o.Cases() {
+				if err := q.tcheckVars(c.Case().Body()); err != nil {
+					return err
+				}
+			}
+			if err := q.tcheckVars(o.BodyIfElse()); err != nil {
+				return err
+			}
+
 		case a.KVar:
 			o := o.Var()
 			name := o.Name()
@@ -166,6 +178,11 @@ func (q *checker) tcheckStatement(n *a.Node) error {
 			// This needs the context of what func we're in.
 		}
 
+	case a.KSwitch:
+		if err := q.tcheckSwitch(n.Switch()); err != nil {
+			return err
+		}
+
 	case a.KVar:
 		n := n.Var()
 		if !n.XType().Node().TypeChecked() {
@@ -192,7 +209,8 @@ func (q *checker) tcheckStatement(n *a.Node) error {
 						n.Name().String(q.tm), lTyp.String(q.tm), value.String(q.tm), rTyp.String(q.tm))
 				}
 
-			} else if (rTyp.IsIdeal() && lTyp.IsNumType()) || lTyp.EqIgnoringRefinements(rTyp) {
+			} else if (rTyp.IsIdeal() && lTyp.IsNumType()) ||
+				(lTyp.EqIgnoringRefinements(rTyp) && q.elementRefinementsFit(lTyp, rTyp)) {
 				// No-op.
 
 			} else {
@@ -225,11 +243,125 @@ func (q *checker) tcheckStatement(n *a.Node) error {
 	return nil
 }
 
+func (q *checker) tcheckSwitch(n *a.Switch) error {
+	scrutinee := n.Scrutinee()
+	if err := q.tcheckExpr(scrutinee, 0); err != nil {
+		return err
+	}
+	sTyp := scrutinee.MType()
+	if !sTyp.IsNumType() || sTyp.IsIdeal() {
+		return fmt.Errorf("check: switch scrutinee %q, of type %q, does not have an integer type",
+			scrutinee.String(q.tm), sTyp.String(q.tm))
+	}
+
+	for _, c := range n.Cases() {
+		q.errFilename, q.errLine = c.Raw().FilenameLine()
+		for _, o := range c.Case().Values() {
+			o := o.Expr()
+			if o.ID0().Key() != t.KeyDotDot {
+				if err := q.tcheckCaseValue(o, sTyp); err != nil {
+					return err
+				}
+				continue
+			}
+			lhs, rhs := o.LHS().Expr(), o.RHS().Expr()
+			if err := q.tcheckCaseValue(lhs, sTyp); err != nil {
+				return err
+			}
+			if err := q.tcheckCaseValue(rhs, sTyp); err != nil {
+				return err
+			}
+			if lhs.ConstValue().Cmp(rhs.ConstValue()) > 0 {
+				return fmt.Errorf("check: case range %q is empty", o.String(q.tm))
+			}
+			o.SetMType(sTyp)
+			o.Node().SetTypeChecked()
+		}
+		for _, o := range c.Case().Body() {
+			if err := q.tcheckStatement(o); err != nil {
+				return err
+			}
+		}
+		c.SetTypeChecked()
+	}
+	for _, o := range n.BodyIfElse() {
+		if err := q.tcheckStatement(o); err != nil {
+			return err
+		}
+	}
+
+	ranges := caseRanges(n)
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i-1].hi.Cmp(ranges[i].lo) >= 0 {
+			q.errFilename, q.errLine = n.Node().Raw().FilenameLine()
+			return fmt.Errorf("check: case values %q and %q overlap",
+				ranges[i-1].value.String(q.tm), ranges[i].value.String(q.tm))
+		}
+	}
+	return nil
+}
+
+func (q *checker) tcheckCaseValue(n *a.Expr, sTyp *a.TypeExpr) error {
+	if err := q.tcheckExpr(n, 0); err != nil {
+		return err
+	}
+	if n.ConstValue() == nil {
+		return fmt.Errorf("check: case value %q is not constant", n.String(q.tm))
+	}
+	if typ := n.MType(); !typ.IsIdeal() && !typ.EqIgnoringRefinements(sTyp) {
+		return fmt.Errorf("check: case value %q, of type %q, does not match switch type %q",
+			n.String(q.tm), typ.String(q.tm), sTyp.String(q.tm))
+	}
+	return nil
+}
+
+// caseRange is an inclusive range of a switch statement's case values. For
+// a single value, like "0x21", lo and hi are equal.
+type caseRange struct {
+	lo, hi *big.Int
+	value  *a.Expr
+}
+
+// caseRanges returns the switch statement's type checked case values, sorted
+// by their lower bound.
+func caseRanges(n *a.Switch) []caseRange {
+	ranges := []caseRange(nil)
+	for _, c := range n.Cases() {
+		for _, o := range c.Case().Values() {
+			o := o.Expr()
+			if o.ID0().Key() == t.KeyDotDot {
+				ranges = append(ranges, caseRange{
+					lo:    o.LHS().Expr().ConstValue(),
+					hi:    o.RHS().Expr().ConstValue(),
+					value: o,
+				})
+			} else {
+				ranges = append(ranges, caseRange{lo: o.ConstValue(), hi: o.ConstValue(), value: o})
+			}
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].lo.Cmp(ranges[j].lo) < 0 })
+	return ranges
+}
+
 func (q *checker) tcheckAssert(n *a.Assert) error {
 	cond := n.Condition()
 	if err := q.tcheckExpr(cond, 0); err != nil {
 		return err
 	}
+	if n.Keyword().Key() == t.KeyDec {
+		if !cond.MType().IsNumType() {
+			return fmt.Errorf("check: dec measure %q, of type %q, does not have a numeric type",
+				cond.String(q.tm), cond.MType().String(q.tm))
+		}
+		if !cond.Pure() {
+			return fmt.Errorf("check: dec measure %q is not pure", cond.String(q.tm))
+		}
+		if n.Reason() != 0 {
+			return fmt.Errorf("check: dec measure %q cannot have a via reason", cond.String(q.tm))
+		}
+		return nil
+	}
 	if !cond.MType().IsBool() {
 		return fmt.Errorf("check: assert condition %q, of type %q, does not have a boolean type",
 			cond.String(q.tm), cond.MType().String(q.tm))
@@ -256,8 +388,22 @@ func (q *checker) tcheckAssign(n *a.Assign) error {
 	lTyp := lhs.MType()
 	rTyp := rhs.MType()
 
+	if _, ok := q.unionForTag(lhs); ok {
+		// Code generation evaluates the union twice: once to zero its fields
+		// and once to set its tag.
+		if n.Operator().Key() != t.KeyEq {
+			return fmt.Errorf("check: assignment %q: union tag %q can only be assigned with \"=\"",
+				n.Operator().String(q.tm), lhs.String(q.tm))
+		}
+		if lhs.Impure() {
+			return fmt.Errorf("check: assignment %q: union tag %q is not pure",
+				n.Operator().String(q.tm), lhs.String(q.tm))
+		}
+	}
+
 	if n.Operator().Key() == t.KeyEq {
-		if (rTyp.IsIdeal() && lTyp.IsNumType()) || lTyp.EqIgnoringRefinements(rTyp) {
+		if (rTyp.IsIdeal() && lTyp.IsNumType()) ||
+			(lTyp.EqIgnoringRefinements(rTyp) && q.elementRefinementsFit(lTyp, rTyp)) {
 			return nil
 		}
 		return fmt.Errorf("check: cannot assign %q of type %q to %q of type %q",
@@ -269,18 +415,25 @@ func (q *checker) tcheckAssign(n *a.Assign) error {
 			n.Operator().String(q.tm), lhs.String(q.tm), lTyp.String(q.tm))
 	}
 
+	if tildeOps[0xFF&n.Operator().BinaryForm().Key()] {
+		if !lTyp.IsUnsignedInteger() && !lTyp.IsSignedInteger() {
+			return fmt.Errorf("check: assignment %q: %q, of type %q, does not have integer type",
+				n.Operator().String(q.tm), lhs.String(q.tm), lTyp.String(q.tm))
+		}
+		// Code generation may evaluate the assignee twice.
+		if lhs.Impure() {
+			return fmt.Errorf("check: assignment %q: assignee %q is not pure",
+				n.Operator().String(q.tm), lhs.String(q.tm))
+		}
+	}
+
 	switch n.Operator().Key() {
-	case t.KeyShiftLEq, t.KeyShiftREq:
+	case t.KeyShiftLEq, t.KeyShiftREq, t.KeyTildeShiftLEq:
 		if !rTyp.IsNumTypeOrIdeal() {
 			return fmt.Errorf("check: assignment %q: shift %q, of type %q, does not have numeric type",
 				n.Operator().String(q.tm), rhs.String(q.tm), rTyp.String(q.tm))
 		}
 		return nil
-	case t.KeyTildePlusEq:
-		if !lTyp.IsUnsignedInteger() {
-			return fmt.Errorf("check: assignment %q: %q, of type %q, does not have unsigned integer type",
-				n.Operator().String(q.tm), lhs.String(q.tm), lTyp.String(q.tm))
-		}
 	}
 
 	if rTyp.IsIdeal() || lTyp.EqIgnoringRefinements(rTyp) {
@@ -364,6 +517,21 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 			n.SetMType(typeExprIdeal)
 			return nil
 
+		} else if id1.IsStrLiteral() {
+			// A string literal is an array of bytes, such as "GIF8" being a
+			// [4] u8. It has no ConstValue, as that is a single number.
+			s, ok := t.Unescape(id1.String(q.tm))
+			if !ok {
+				return fmt.Errorf("check: invalid string literal %q", id1.String(q.tm))
+			}
+			length := big.NewInt(int64(len(s)))
+			lengthExpr, err := q.constExpr(length)
+			if err != nil {
+				return err
+			}
+			n.SetMType(a.NewTypeExpr(t.IDOpenBracket, 0, lengthExpr, nil, typeExprU8))
+			return nil
+
 		} else if id1.IsIdent() {
 			if q.f.LocalVars != nil {
 				if typ, ok := q.f.LocalVars[id1]; ok {
@@ -402,27 +570,40 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 	case t.KeyOpenParen, t.KeyTry:
 		// n is a function call.
 
+		if lhs := n.LHS().Expr(); lhs.ID0() == 0 && lhs.ID1().IsIdent() {
+			return q.tcheckConstFuncCall(n, depth)
+		}
+
 		// TODO: be consistent about type-checking n.LHS().Expr() or
 		// n.LHS().Expr().LHS().Expr(). Doing this properly will probably
 		// require a TypeExpr being able to express function and method types.
 
-		// TODO: delete this hack that only matches "in.src.read_u8?()" etc.
-		if isInSrc(q.tm, n, t.KeyReadU8, 0) || isInSrc(q.tm, n, t.KeyUnreadU8, 0) ||
-			isInSrc(q.tm, n, t.KeyReadU16BE, 0) || isInSrc(q.tm, n, t.KeyReadU32BE, 0) ||
-			isInSrc(q.tm, n, t.KeyReadU32BE, 0) || isInSrc(q.tm, n, t.KeyReadU32LE, 0) ||
+		// TODO: delete this hack that only matches "in.dst.limit(l:etc)" etc.
+		if isThatMethod(q.tm, n, t.KeyLimit, 1) {
+			return q.tcheckLimit(n, depth)
+		}
+
+		// TODO: delete this hack that only matches "in.src.read_u16be?()" etc.
+		if m, ok := ioMethod(q.tm, n); ok {
+			return q.tcheckIOMethod(n, m, depth)
+		}
+
+		// TODO: delete this hack that only matches "in.src.unread_u8?()" etc.
+		if isInSrc(q.tm, n, t.KeyUnreadU8, 0) ||
 			isInSrc(q.tm, n, t.KeySkip32, 1) || isInSrc(q.tm, n, t.KeySinceMark, 0) ||
-			isInSrc(q.tm, n, t.KeyMark, 0) || isInSrc(q.tm, n, t.KeyLimit, 1) ||
+			isInSrc(q.tm, n, t.KeyMark, 0) ||
 			isInDst(q.tm, n, t.KeyCopyFromSlice, 1) || isInDst(q.tm, n, t.KeyCopyFromSlice32, 2) ||
 			isInDst(q.tm, n, t.KeyCopyFromReader32, 2) || isInDst(q.tm, n, t.KeyCopyFromHistory32, 2) ||
-			isInDst(q.tm, n, t.KeyWriteU8, 1) || isInDst(q.tm, n, t.KeySinceMark, 0) ||
+			isInDst(q.tm, n, t.KeySinceMark, 0) ||
 			isInDst(q.tm, n, t.KeyMark, 0) || isInDst(q.tm, n, t.KeyIsMarked, 0) ||
+			isInSrc(q.tm, n, t.KeyReadMatch, 1) || isInSrc(q.tm, n, t.KeyReadMatch, 2) ||
 			isThisMethod(q.tm, n, "decode_header", 1) || isThisMethod(q.tm, n, "decode_lsd", 1) ||
 			isThisMethod(q.tm, n, "decode_extension", 1) || isThisMethod(q.tm, n, "decode_id", 2) ||
 			isThisMethod(q.tm, n, "decode_uncompressed", 2) || isThisMethod(q.tm, n, "decode_blocks", 2) ||
 			isThisMethod(q.tm, n, "decode_huffman_slow", 2) || isThisMethod(q.tm, n, "decode_huffman_fast", 2) ||
 			isThisMethod(q.tm, n, "init_fixed_huffman", 0) || isThisMethod(q.tm, n, "init_dynamic_huffman", 1) ||
 			isThisMethod(q.tm, n, "init_huff", 4) ||
-			isThatMethod(q.tm, n, t.KeyMark, 0) || isThatMethod(q.tm, n, t.KeyLimit, 1) ||
+			isThatMethod(q.tm, n, t.KeyMark, 0) ||
 			isThatMethod(q.tm, n, t.KeySinceMark, 0) {
 
 			if err := q.tcheckExpr(n.LHS().Expr(), depth); err != nil {
@@ -433,12 +614,15 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 					return err
 				}
 			}
+			if isInSrc(q.tm, n, t.KeyReadMatch, 1) || isInSrc(q.tm, n, t.KeyReadMatch, 2) {
+				if err := q.tcheckReadMatch(n); err != nil {
+					return err
+				}
+			}
 			if n.ID0().Key() == t.KeyTry {
 				n.SetMType(typeExprStatus)
-			} else if isInSrc(q.tm, n, t.KeyReadU32BE, 0) || isInSrc(q.tm, n, t.KeyReadU32LE, 0) {
-				n.SetMType(typeExprPlaceholder32) // HACK.
-			} else if isInSrc(q.tm, n, t.KeyReadU16BE, 0) || isInSrc(q.tm, n, t.KeyReadU16LE, 0) {
-				n.SetMType(typeExprPlaceholder16) // HACK.
+			} else if isInSrc(q.tm, n, t.KeyReadMatch, 1) {
+				n.SetMType(typeExprBool)
 			} else if isInSrc(q.tm, n, t.KeySinceMark, 0) || isInDst(q.tm, n, t.KeySinceMark, 0) {
 				n.SetMType(typeExprSliceU8) // HACK.
 			} else if isInDst(q.tm, n, t.KeyCopyFromSlice, 1) {
@@ -469,6 +653,12 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 			n.SetMType(foo.MType().Unrefined())
 			return nil
 		}
+		// TODO: delete this hack that only matches "foo.clz()" etc.
+		if isThatMethod(q.tm, n, t.KeyClz, 0) || isThatMethod(q.tm, n, t.KeyCtz, 0) ||
+			isThatMethod(q.tm, n, t.KeyPopcount, 0) || isThatMethod(q.tm, n, t.KeyReverseBits, 1) ||
+			isThatMethod(q.tm, n, t.KeyRotateLeft, 1) || isThatMethod(q.tm, n, t.KeyByteSwap, 0) {
+			return q.tcheckBitMethod(n, depth)
+		}
 		// TODO: delete this hack that only matches "foo.is_suspension(etc)".
 		if isThatMethod(q.tm, n, t.KeyIsError, 0) ||
 			isThatMethod(q.tm, n, t.KeyIsOK, 0) ||
@@ -500,8 +690,12 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 					return err
 				}
 			}
-			// TODO: don't assume that the slice is a slice of u8.
-			n.SetMType(typeExprSliceU8)
+			fTyp := foo.MType()
+			if fTyp.Decorator().Key() != t.KeyColon {
+				return fmt.Errorf("check: %q, of type %q, does not have a slice type",
+					foo.String(q.tm), fTyp.String(q.tm))
+			}
+			n.SetMType(fTyp)
 			return nil
 		}
 		// TODO: delete this hack that only matches "foo.set_literal_width(etc)".
@@ -541,9 +735,28 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 					return err
 				}
 			}
+			if isThatMethod(q.tm, n, t.KeyCopyFromSlice, 1) {
+				if err := q.tcheckCopyFromSlice(n); err != nil {
+					return err
+				}
+			}
 			n.SetMType(typeExprU64)
 			return nil
 		}
+		// TODO: delete this hack that only matches "foo.width()", "foo.row(etc)"
+		// etc. for a buf2 foo.
+		if isBuf2, err := q.tcheckBuf2Receiver(n, depth); err != nil {
+			return err
+		} else if isBuf2 {
+			return q.tcheckBuf2Method(n, depth)
+		}
+		// TODO: delete this hack that only matches "foo.take_bits!(etc)" etc.
+		// for a bitreader foo.
+		if isThatMethod(q.tm, n, t.KeyNBits, 0) || isThatMethod(q.tm, n, t.KeyPeekBits, 1) ||
+			isThatMethod(q.tm, n, t.KeyTakeBits, 1) || isThatMethod(q.tm, n, t.KeyRefill, 2) ||
+			isThatMethod(q.tm, n, t.KeySetMSBFirst, 1) {
+			return q.tcheckBitreaderMethod(n, depth)
+		}
 		// TODO: delete this hack that only matches "foo.update(etc)".
 		if isThatMethod(q.tm, n, q.tm.ByName("update").Key(), 1) {
 			foo := n.LHS().Expr().LHS().Expr()
@@ -661,6 +874,184 @@ func (q *checker) tcheckExprOther(n *a.Expr, depth uint32) error {
 		n.ID0().Key(), n.String(q.tm))
 }
 
+// tcheckConstFuncCall checks "foo(x:etc)", a call to the const func foo. As
+// such calls are evaluated at check time, not run time, they are only allowed
+// in const values and in other const funcs.
+func (q *checker) tcheckConstFuncCall(n *a.Expr, depth uint32) error {
+	lhs := n.LHS().Expr()
+	name := lhs.ID1()
+	f := q.c.funcs[t.QID{0, name}].Func
+	if f == nil {
+		return fmt.Errorf("check: unrecognized function %q", name.String(q.tm))
+	}
+	if !f.Const() {
+		return fmt.Errorf("check: cannot call %q, which is not a const func", name.String(q.tm))
+	}
+	if q.f.Func != nil && !q.f.Func.Const() {
+		return fmt.Errorf("check: cannot call const func %q outside of a const value or const func",
+			name.String(q.tm))
+	}
+	if n.ID0().Key() == t.KeyTry || n.CallImpure() {
+		return fmt.Errorf("check: const func %q is pure, but %q calls it as impure",
+			name.String(q.tm), n.String(q.tm))
+	}
+
+	inFields := f.In().Fields()
+	if len(n.Args()) != len(inFields) {
+		return fmt.Errorf("check: %q has %d arguments but const func %q takes %d",
+			n.String(q.tm), len(n.Args()), name.String(q.tm), len(inFields))
+	}
+	for i, o := range n.Args() {
+		o := o.Arg()
+		field := inFields[i].Field()
+		if o.Name() != field.Name() {
+			return fmt.Errorf("check: %q's argument %d is named %q, want %q",
+				n.String(q.tm), i, o.Name().String(q.tm), field.Name().String(q.tm))
+		}
+		if err := q.tcheckArg(o, depth); err != nil {
+			return err
+		}
+		lTyp, rTyp := field.XType(), o.Value().MType()
+		if !(rTyp.IsIdeal() && lTyp.IsNumType()) && !lTyp.EqIgnoringRefinements(rTyp) {
+			return fmt.Errorf("check: cannot pass %q of type %q as %q of type %q",
+				o.Value().String(q.tm), rTyp.String(q.tm), field.Name().String(q.tm), lTyp.String(q.tm))
+		}
+	}
+	n.LHS().SetTypeChecked()
+	lhs.SetMType(typeExprPlaceholder) // HACK.
+	n.SetMType(f.Out().Fields()[0].Field().XType())
+	return nil
+}
+
+// tcheckReadMatch checks the arguments of "in.src.read_match?(s:etc)", which
+// returns whether the next len(s) bytes equal s, and of
+// "in.src.read_match?(s:etc, err:error etc)", which fails with that error if
+// they do not. Either way, it reads exactly len(s) bytes.
+func (q *checker) tcheckReadMatch(n *a.Expr) error {
+	args := n.Args()
+	if o := args[0].Arg(); o.Name() != q.tm.ByName("s") {
+		return fmt.Errorf("check: read_match's first argument is %q, want \"s\"", o.Name().String(q.tm))
+	} else if readMatchLength(o.Value()) == nil {
+		return fmt.Errorf("check: read_match's s argument %q is not a non-empty [N] u8",
+			o.Value().String(q.tm))
+	}
+	if len(args) == 2 {
+		if o := args[1].Arg(); o.Name() != q.tm.ByName("err") {
+			return fmt.Errorf("check: read_match's second argument is %q, want \"err\"", o.Name().String(q.tm))
+		} else if o.Value().ID0().Key() != t.KeyError {
+			return fmt.Errorf("check: read_match's err argument %q is not an error literal",
+				o.Value().String(q.tm))
+		}
+	}
+	return nil
+}
+
+// readMatchLength returns the number of bytes that "in.src.read_match?(s:x)"
+// reads, or nil if x is not an array of u8 with a positive, constant length.
+func readMatchLength(x *a.Expr) *big.Int {
+	typ := x.MType()
+	if typ == nil || typ.Decorator().Key() != t.KeyOpenBracket {
+		return nil
+	}
+	if inner := typ.Inner(); inner.Decorator() != 0 || inner.Name().Key() != t.KeyU8 {
+		return nil
+	}
+	if cv := typ.ArrayLength().ConstValue(); cv != nil && cv.Sign() > 0 {
+		return cv
+	}
+	return nil
+}
+
+// tcheckCopyFromSlice checks that, for "foo.copy_from_slice(s:bar)" where foo
+// is a slice, bar is a slice whose elements can be stored in foo's elements.
+func (q *checker) tcheckCopyFromSlice(n *a.Expr) error {
+	foo := n.LHS().Expr().LHS().Expr()
+	fTyp := foo.MType()
+	if fTyp.Decorator().Key() != t.KeyColon {
+		return nil
+	}
+	bar := n.Args()[0].Arg().Value()
+	bTyp := bar.MType()
+	if bTyp.Decorator().Key() != t.KeyColon || !q.refinementsFit(fTyp.Inner(), bTyp.Inner()) {
+		return fmt.Errorf("check: cannot copy %q, of type %q, to %q, of type %q",
+			bar.String(q.tm), bTyp.String(q.tm), foo.String(q.tm), fTyp.String(q.tm))
+	}
+	return nil
+}
+
+// refinementsFit returns whether every value of type src is a value of type
+// dst: the two types are equal ignoring refinements and, at each level of the
+// types, such as the "u8[..3]" in "[4] u8[..3]", src's bounds are within
+// dst's. Copying elements does not check them, so an element copied to a
+// refined dst has to be in range already.
+func (q *checker) refinementsFit(dst *a.TypeExpr, src *a.TypeExpr) bool {
+	if !dst.EqIgnoringRefinements(src) {
+		return false
+	}
+	for ; dst != nil && src != nil; dst, src = dst.Inner(), src.Inner() {
+		dMin, dMax, err := q.bcheckTypeExpr(dst)
+		if err != nil {
+			return false
+		}
+		if dMin == nil || dMax == nil {
+			continue
+		}
+		sMin, sMax, err := q.bcheckTypeExpr(src)
+		if err != nil || sMin == nil || sMax == nil || sMin.Cmp(dMin) < 0 || sMax.Cmp(dMax) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// elementRefinementsFit returns whether a value of type rTyp, equal to lTyp
+// ignoring refinements, can be assigned to lTyp as far as the refinements of
+// their elements go. Bounds checking covers lTyp's own refinement, such as the
+// "[..3]" in "u8[..3]", but not those of an array's or slice's elements. A
+// slice aliases its elements, so their refinements have to fit both ways.
+func (q *checker) elementRefinementsFit(lTyp *a.TypeExpr, rTyp *a.TypeExpr) bool {
+	switch lTyp.Decorator().Key() {
+	case t.KeyOpenBracket:
+		return q.refinementsFit(lTyp.Inner(), rTyp.Inner())
+	case t.KeyColon:
+		return q.refinementsFit(lTyp.Inner(), rTyp.Inner()) && q.refinementsFit(rTyp.Inner(), lTyp.Inner())
+	}
+	return true
+}
+
+// ioMethod returns the built-in I/O method that n calls, if n is
+// "in.src.read_u16be?()", "in.dst.write_u8?(x:etc)" or similar. Reads and
+// peeks take no arguments and writes take one.
+func ioMethod(tm *t.Map, n *a.Expr) (m builtin.IOMethod, ok bool) {
+	if n.ID0().Key() != t.KeyOpenParen {
+		return builtin.IOMethod{}, false
+	}
+	lhs := n.LHS().Expr()
+	if lhs.ID0().Key() != t.KeyDot {
+		return builtin.IOMethod{}, false
+	}
+	m, ok = builtin.IOMethodMap[lhs.ID1().String(tm)]
+	if !ok {
+		return builtin.IOMethod{}, false
+	}
+	receiver, nArgs := "src", 0
+	if m.Op == "write" {
+		receiver, nArgs = "dst", 1
+	}
+	if len(n.Args()) != nArgs {
+		return builtin.IOMethod{}, false
+	}
+	lhs = lhs.LHS().Expr()
+	if lhs.ID0().Key() != t.KeyDot || lhs.ID1() != tm.ByName(receiver) {
+		return builtin.IOMethod{}, false
+	}
+	lhs = lhs.LHS().Expr()
+	if lhs.ID0() != 0 || lhs.ID1().Key() != t.KeyIn {
+		return builtin.IOMethod{}, false
+	}
+	return m, true
+}
+
 func isInSrc(tm *t.Map, n *a.Expr, methodName t.Key, nArgs int) bool {
 	callSuspendible := methodName != t.KeySinceMark &&
 		methodName != t.KeyMark &&
@@ -733,8 +1124,307 @@ func isThatMethod(tm *t.Map, n *a.Expr, methodName t.Key, nArgs int) bool {
 	return n.ID0().Key() == t.KeyDot && n.ID1().Key() == methodName
 }
 
+// buf2ArgNames are the argument names of the buf2 methods that take arguments.
+var buf2ArgNames = [256][]string{
+	t.KeyRow:   {"y"},
+	t.KeySetU8: {"x", "y", "v"},
+}
+
+// tcheckBuf2Receiver returns whether n is a call to a buf2 method: whether n
+// has the name and number of arguments of a buf2 method, such as
+// "foo.row(y:etc)", and foo has type buf2. If n has such a name, it type
+// checks foo.
+func (q *checker) tcheckBuf2Receiver(n *a.Expr, depth uint32) (bool, error) {
+	if !isBuf2MethodName(q.tm, n) {
+		return false, nil
+	}
+	foo := n.LHS().Expr().LHS().Expr()
+	if err := q.tcheckExpr(foo, depth); err != nil {
+		return false, err
+	}
+	return isBuf2(foo.MType()), nil
+}
+
+// isBuf2MethodName returns whether n has the name and number of arguments of
+// a buf2 method, regardless of its receiver's type.
+func isBuf2MethodName(tm *t.Map, n *a.Expr) bool {
+	return isThatMethod(tm, n, t.KeyWidth, 0) || isThatMethod(tm, n, t.KeyHeight, 0) ||
+		isThatMethod(tm, n, t.KeyPixelFormat, 0) || isThatMethod(tm, n, t.KeyRow, 1) ||
+		isThatMethod(tm, n, t.KeySetU8, 3)
+}
+
+func isBuf2(typ *a.TypeExpr) bool {
+	return typ != nil && typ.Decorator() == 0 && typ.Name().Key() == t.KeyBuf2
+}
+
+// tcheckBuf2Method type checks a call to a buf2 method: "foo.width()",
+// "foo.height()", "foo.pixel_format()", "foo.row(y:etc)" or
+// "foo.set_u8(x:etc, y:etc, v:etc)". The caller, via tcheckBuf2Receiver, has
+// already type checked foo.
+func (q *checker) tcheckBuf2Method(n *a.Expr, depth uint32) error {
+	n.LHS().SetTypeChecked()
+	n.LHS().Expr().SetMType(typeExprPlaceholder) // HACK.
+
+	method := n.LHS().Expr().ID1().Key()
+	for i, o := range n.Args() {
+		o := o.Arg()
+		if got, want := o.Name().String(q.tm), buf2ArgNames[method][i]; got != want {
+			return fmt.Errorf("check: %q has argument %q, want %q", n.String(q.tm), got, want)
+		}
+		if err := q.tcheckArg(o, depth); err != nil {
+			return err
+		}
+		if typ := o.Value().MType(); !typ.IsNumTypeOrIdeal() {
+			return fmt.Errorf("check: argument %q, of type %q, does not have numeric type",
+				o.Value().String(q.tm), typ.String(q.tm))
+		}
+	}
+
+	switch method {
+	case t.KeyWidth, t.KeyHeight:
+		n.SetMType(typeExprU64)
+	case t.KeyPixelFormat:
+		n.SetMType(typeExprU32)
+	case t.KeyRow:
+		n.SetMType(typeExprSliceU8)
+	case t.KeySetU8:
+		if v := n.Args()[2].Arg().Value(); !v.MType().IsIdeal() &&
+			!v.MType().EqIgnoringRefinements(typeExprU8) {
+			return fmt.Errorf("check: %q is not a u8", v.String(q.tm))
+		}
+		n.SetMType(typeExprPlaceholder) // HACK.
+	}
+	return nil
+}
+
+// tcheckBitMethod type checks a call to a bit manipulation method of an
+// unsigned integer: "foo.clz()", "foo.ctz()", "foo.popcount()",
+// "foo.reverse_bits(n:etc)", "foo.rotate_left(n:etc)" or "foo.byte_swap()".
+// The result has foo's (unrefined) type.
+func (q *checker) tcheckBitMethod(n *a.Expr, depth uint32) error {
+	foo := n.LHS().Expr().LHS().Expr()
+	if err := q.tcheckExpr(foo, depth); err != nil {
+		return err
+	}
+	typ := foo.MType()
+	if !typ.IsUnsignedInteger() {
+		return fmt.Errorf("check: %q, of type %q, does not have an unsigned integer type",
+			foo.String(q.tm), typ.String(q.tm))
+	}
+	method := n.LHS().Expr().ID1()
+	if method.Key() == t.KeyByteSwap && typ.Name().Key() == t.KeyU8 {
+		return fmt.Errorf("check: %q, of type %q, has no bytes to swap", foo.String(q.tm), typ.String(q.tm))
+	}
+	n.LHS().SetTypeChecked()
+	n.LHS().Expr().SetMType(typeExprPlaceholder) // HACK.
+
+	for _, o := range n.Args() {
+		o := o.Arg()
+		if got := o.Name().String(q.tm); got != "n" {
+			return fmt.Errorf("check: %q has argument %q, want \"n\"", n.String(q.tm), got)
+		}
+		if err := q.tcheckArg(o, depth); err != nil {
+			return err
+		}
+		if aTyp := o.Value().MType(); !aTyp.IsNumTypeOrIdeal() {
+			return fmt.Errorf("check: argument %q, of type %q, does not have numeric type",
+				o.Value().String(q.tm), aTyp.String(q.tm))
+		}
+	}
+	n.SetMType(typ.Unrefined())
+	return nil
+}
+
+// bitreaderArgNames are the argument names of the bitreader methods that take
+// arguments.
+var bitreaderArgNames = [256][]string{
+	t.KeyPeekBits:    {"n"},
+	t.KeyTakeBits:    {"n"},
+	t.KeyRefill:      {"src", "n"},
+	t.KeySetMSBFirst: {"v"},
+}
+
+// tcheckBitreaderMethod type checks a call to a bitreader method:
+// "foo.n_bits()", "foo.peek_bits(n:etc)", "foo.take_bits!(n:etc)",
+// "foo.refill?(src:etc, n:etc)" or "foo.set_msb_first!(v:etc)".
+func (q *checker) tcheckBitreaderMethod(n *a.Expr, depth uint32) error {
+	foo := n.LHS().Expr().LHS().Expr()
+	if err := q.tcheckExpr(foo, depth); err != nil {
+		return err
+	}
+	if typ := foo.MType(); typ.Decorator() != 0 || typ.Name().Key() != t.KeyBitreader {
+		return fmt.Errorf("check: %q, of type %q, is not a bitreader", foo.String(q.tm), typ.String(q.tm))
+	}
+	n.LHS().SetTypeChecked()
+	n.LHS().Expr().SetMType(typeExprPlaceholder) // HACK.
+
+	method := n.LHS().Expr().ID1().Key()
+	punctuation := ""
+	switch method {
+	case t.KeyTakeBits, t.KeySetMSBFirst:
+		punctuation = "!"
+	case t.KeyRefill:
+		punctuation = "?"
+	}
+	ok := false
+	switch punctuation {
+	case "":
+		ok = !n.CallImpure()
+	case "!":
+		ok = n.CallImpure() && !n.CallSuspendible()
+	case "?":
+		ok = n.CallSuspendible()
+	}
+	if !ok {
+		return fmt.Errorf("check: %q should be called as \"%s%s(etc)\"", n.String(q.tm),
+			n.LHS().Expr().String(q.tm), punctuation)
+	}
+
+	for i, o := range n.Args() {
+		o := o.Arg()
+		if got, want := o.Name().String(q.tm), bitreaderArgNames[method][i]; got != want {
+			return fmt.Errorf("check: %q has argument %q, want %q", n.String(q.tm), got, want)
+		}
+		if err := q.tcheckArg(o, depth); err != nil {
+			return err
+		}
+		v := o.Value()
+		if v.Impure() {
+			return fmt.Errorf("check: argument %q is not pure", v.String(q.tm))
+		}
+		typ := v.MType()
+		switch o.Name().String(q.tm) {
+		case "n":
+			if !typ.IsNumTypeOrIdeal() {
+				return fmt.Errorf("check: argument %q, of type %q, does not have numeric type",
+					v.String(q.tm), typ.String(q.tm))
+			}
+		case "src":
+			if typ.Decorator() != 0 || typ.Name().Key() != t.KeyReader1 {
+				return fmt.Errorf("check: argument %q, of type %q, is not a reader1",
+					v.String(q.tm), typ.String(q.tm))
+			}
+		case "v":
+			if !typ.IsBool() {
+				return fmt.Errorf("check: argument %q, of type %q, is not a bool",
+					v.String(q.tm), typ.String(q.tm))
+			}
+		}
+	}
+
+	switch method {
+	case t.KeyNBits, t.KeyPeekBits, t.KeyTakeBits:
+		n.SetMType(typeExprU32)
+	default:
+		n.SetMType(typeExprPlaceholder) // HACK.
+	}
+	return nil
+}
+
+// tcheckIOMethod type checks a call to a built-in I/O method m, such as
+// "in.src.peek_u16be?()" or "in.dst.write_i32le?(x:etc)". Its type is the type
+// of the integer peeked at or read. Writes are typed like other statement-like
+// calls.
+func (q *checker) tcheckIOMethod(n *a.Expr, m builtin.IOMethod, depth uint32) error {
+	if !n.CallSuspendible() {
+		return fmt.Errorf("check: %q should be called as \"%s?(etc)\"", n.String(q.tm), n.LHS().Expr().String(q.tm))
+	}
+	if err := q.tcheckExpr(n.LHS().Expr(), depth); err != nil {
+		return err
+	}
+	if m.Op != "write" {
+		n.SetMType(a.NewTypeExpr(0, q.tm.ByName(m.TypeName()), nil, nil, nil))
+		return nil
+	}
+
+	o := n.Args()[0].Arg()
+	if got := o.Name().String(q.tm); got != "x" {
+		return fmt.Errorf("check: %q has argument %q, want \"x\"", n.String(q.tm), got)
+	}
+	if err := q.tcheckArg(o, depth); err != nil {
+		return err
+	}
+	if typ := o.Value().MType(); !typ.IsNumTypeOrIdeal() {
+		return fmt.Errorf("check: argument %q, of type %q, does not have numeric type",
+			o.Value().String(q.tm), typ.String(q.tm))
+	}
+	n.SetMType(typeExprPlaceholder) // HACK.
+	return nil
+}
+
+// tcheckLimit type checks a "foo.limit(l:etc)" call, where foo is a reader1 or
+// a writer1 and etc is a u64. The result has foo's type: a view of foo that
+// reads or writes at most etc more bytes.
+func (q *checker) tcheckLimit(n *a.Expr, depth uint32) error {
+	if n.ID0().Key() != t.KeyOpenParen || n.CallImpure() {
+		return fmt.Errorf("check: %q should be called as \"%s(etc)\"", n.String(q.tm), n.LHS().Expr().String(q.tm))
+	}
+	foo := n.LHS().Expr().LHS().Expr()
+	if err := q.tcheckExpr(foo, depth); err != nil {
+		return err
+	}
+	typ := foo.MType()
+	if k := typ.Name().Key(); typ.Decorator() != 0 || (k != t.KeyReader1 && k != t.KeyWriter1) {
+		return fmt.Errorf("check: %q, of type %q, is not a reader1 or writer1", foo.String(q.tm), typ.String(q.tm))
+	}
+	n.LHS().SetTypeChecked()
+	n.LHS().Expr().SetMType(typeExprPlaceholder) // HACK.
+
+	o := n.Args()[0].Arg()
+	if got := o.Name().String(q.tm); got != "l" {
+		return fmt.Errorf("check: %q has argument %q, want \"l\"", n.String(q.tm), got)
+	}
+	if err := q.tcheckArg(o, depth); err != nil {
+		return err
+	}
+	if v := o.Value(); !v.MType().IsIdeal() && !v.MType().EqIgnoringRefinements(typeExprU64) {
+		return fmt.Errorf("check: argument %q, of type %q, is not a u64", v.String(q.tm), v.MType().String(q.tm))
+	}
+	n.SetMType(typ)
+	return nil
+}
+
+// constExpr returns a type checked expression, of ideal type, for the constant
+// value x.
+func (q *checker) constExpr(x *big.Int) (*a.Expr, error) {
+	id, err := q.tm.Insert(x.String())
+	if err != nil {
+		return nil, err
+	}
+	o := a.NewExpr(a.FlagsTypeChecked, 0, id, nil, nil, nil, nil)
+	o.SetConstValue(x)
+	o.SetMType(typeExprIdeal)
+	return o, nil
+}
+
 func (q *checker) tcheckDot(n *a.Expr, depth uint32) error {
 	lhs := n.LHS().Expr()
+	if e, ok := q.enumForExpr(lhs); ok {
+		// n is an enum member, such as "block_type.uncompressed".
+		cv := e.Values[n.ID1()]
+		if cv == nil {
+			return fmt.Errorf("check: no member named %q found in enum %q",
+				n.ID1().String(q.tm), e.ID.String(q.tm))
+		}
+		lhs.SetMType(e.XType)
+		lhs.Node().SetTypeChecked()
+		n.SetConstValue(cv)
+		n.SetMType(e.XType)
+		return nil
+	}
+	if u, ok := q.unionForExpr(lhs); ok {
+		// n is a union variant's tag value, such as "block_state.stored".
+		cv := u.Tags[n.ID1()]
+		if cv == nil {
+			return fmt.Errorf("check: no variant named %q found in union %q",
+				n.ID1().String(q.tm), u.ID.String(q.tm))
+		}
+		lhs.SetMType(u.TagType)
+		lhs.Node().SetTypeChecked()
+		n.SetConstValue(cv)
+		n.SetMType(u.TagType)
+		return nil
+	}
 	if err := q.tcheckExpr(lhs, depth); err != nil {
 		return err
 	}
@@ -747,6 +1437,22 @@ func (q *checker) tcheckDot(n *a.Expr, depth uint32) error {
 		return fmt.Errorf("check: unsupported decorator for tcheckDot")
 	}
 
+	if u, ok := q.c.unions[lTyp.Name()]; ok {
+		// n is a union's tag, "kind", or one of its variants' fields. The
+		// bounds checker, not the type checker, ensures that a field is only
+		// used when its variant is the union's current kind.
+		if n.ID1().Key() == t.KeyKind {
+			n.SetMType(u.TagType)
+			return nil
+		}
+		if f := u.Fields[n.ID1()]; f != nil {
+			n.SetMType(f.XType())
+			return nil
+		}
+		return fmt.Errorf("check: no field named %q found in union type %q for expression %q",
+			n.ID1().String(q.tm), lTyp.Name().String(q.tm), n.String(q.tm))
+	}
+
 	s := (*a.Struct)(nil)
 	if q.f.Func != nil {
 		switch name := lTyp.Name(); name.Key() {
@@ -754,9 +1460,9 @@ func (q *checker) tcheckDot(n *a.Expr, depth uint32) error {
 			s = q.f.Func.In()
 		case t.KeyOut:
 			s = q.f.Func.Out()
-		case t.KeyReader1, t.KeyWriter1:
+		case t.KeyReader1, t.KeyWriter1, t.KeyBuf2, t.KeyBitreader:
 			// TODO: remove this hack and be more principled about the built-in
-			// buf1, reader1, writer1 types.
+			// buf1, reader1, writer1, buf2 and bitreader types.
 			//
 			// Another hack is using typeExprPlaceholder until a TypeExpr can
 			// represent function types.
@@ -788,6 +1494,46 @@ func (q *checker) tcheckDot(n *a.Expr, depth uint32) error {
 		n.ID1().String(q.tm), lTyp.Name().String(q.tm), n.String(q.tm))
 }
 
+// unionForExpr returns the union named by n, if n is an identifier that names
+// a union, not a local variable.
+func (q *checker) unionForExpr(n *a.Expr) (Union, bool) {
+	if n.ID0() != 0 || !n.ID1().IsIdent() {
+		return Union{}, false
+	}
+	if _, ok := q.f.LocalVars[n.ID1()]; ok {
+		return Union{}, false
+	}
+	u, ok := q.c.unions[n.ID1()]
+	return u, ok
+}
+
+// unionForTag returns the union whose tag is n, if n is like "x.kind" for a
+// union-typed x.
+func (q *checker) unionForTag(n *a.Expr) (Union, bool) {
+	if n.ID0().Key() != t.KeyDot || n.ID1().Key() != t.KeyKind {
+		return Union{}, false
+	}
+	lTyp := n.LHS().Expr().MType()
+	if lTyp == nil || lTyp.Decorator() != 0 {
+		return Union{}, false
+	}
+	u, ok := q.c.unions[lTyp.Name()]
+	return u, ok
+}
+
+// enumForExpr returns the enum named by n, if n is an identifier that names an
+// enum, not a local variable.
+func (q *checker) enumForExpr(n *a.Expr) (Enum, bool) {
+	if n.ID0() != 0 || !n.ID1().IsIdent() {
+		return Enum{}, false
+	}
+	if _, ok := q.f.LocalVars[n.ID1()]; ok {
+		return Enum{}, false
+	}
+	e, ok := q.c.enums[n.ID1()]
+	return e, ok
+}
+
 func (q *checker) tcheckExprUnaryOp(n *a.Expr, depth uint32) error {
 	rhs := n.RHS().Expr()
 	if err := q.tcheckExpr(rhs, depth); err != nil {
@@ -842,11 +1588,21 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 	}
 	lTyp := lhs.MType()
 	op := n.ID0()
-	if op.Key() == t.KeyXBinaryAs {
+	if op.Key() == t.KeyXBinaryAs || op.Key() == t.KeyXBinaryTildeAs {
 		rhs := n.RHS().TypeExpr()
 		if err := q.tcheckTypeExpr(rhs, 0); err != nil {
 			return err
 		}
+		if op.Key() == t.KeyXBinaryTildeAs && lTyp.IsNumTypeOrIdeal() {
+			if rhs.IsRefined() || (!rhs.IsUnsignedInteger() && !rhs.IsSignedInteger()) {
+				return fmt.Errorf("check: cannot truncate expression %q as type %q; "+
+					"it does not have an unrefined integer type",
+					lhs.String(q.tm), rhs.String(q.tm))
+			}
+			if cv := lhs.ConstValue(); cv != nil {
+				n.SetConstValue(wrapConstValue(cv, rhs.Name().Key()))
+			}
+		}
 		if lTyp.IsNumTypeOrIdeal() && rhs.IsNumType() {
 			n.SetMType(rhs)
 			return nil
@@ -892,7 +1648,7 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 				lTyp.String(q.tm), rTyp.String(q.tm),
 			)
 		}
-	case t.KeyXBinaryShiftL, t.KeyXBinaryShiftR:
+	case t.KeyXBinaryShiftL, t.KeyXBinaryShiftR, t.KeyXBinaryTildeShiftL:
 		if lTyp.IsIdeal() && !rTyp.IsIdeal() {
 			return fmt.Errorf("check: binary %q: %q and %q, of types %q and %q; "+
 				"cannot shift an ideal number by a non-ideal number",
@@ -903,21 +1659,20 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 		}
 	}
 
-	switch op.Key() {
-	case t.KeyXBinaryTildePlus:
+	if tildeOps[0xFF&op.Key()] {
 		typ := lTyp
-		if typ.IsIdeal() {
+		if typ.IsIdeal() && op.Key() != t.KeyXBinaryTildeShiftL {
 			typ = rTyp
-			if typ.IsIdeal() {
-				return fmt.Errorf("check: binary %q: %q and %q, of types %q and %q, do not have non-ideal types",
-					op.AmbiguousForm().String(q.tm),
-					lhs.String(q.tm), rhs.String(q.tm),
-					lTyp.String(q.tm), rTyp.String(q.tm),
-				)
-			}
 		}
-		if !typ.IsUnsignedInteger() {
-			return fmt.Errorf("check: binary %q: %q and %q, of types %q and %q, do not have unsigned integer types",
+		if typ.IsIdeal() {
+			return fmt.Errorf("check: binary %q: %q and %q, of types %q and %q, do not have non-ideal types",
+				op.AmbiguousForm().String(q.tm),
+				lhs.String(q.tm), rhs.String(q.tm),
+				lTyp.String(q.tm), rTyp.String(q.tm),
+			)
+		}
+		if !typ.IsUnsignedInteger() && !typ.IsSignedInteger() {
+			return fmt.Errorf("check: binary %q: %q and %q, of types %q and %q, do not have integer types",
 				op.AmbiguousForm().String(q.tm),
 				lhs.String(q.tm), rhs.String(q.tm),
 				lTyp.String(q.tm), rTyp.String(q.tm),
@@ -930,6 +1685,14 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 		if err != nil {
 			return err
 		}
+		switch op.Key() {
+		case t.KeyXBinaryTildeSatPlus, t.KeyXBinaryTildeSatMinus:
+			ncv = saturateConstValue(ncv, nonIdealType(lTyp, rTyp).Name().Key())
+		default:
+			if tildeOps[0xFF&op.Key()] {
+				ncv = wrapConstValue(ncv, nonIdealType(lTyp, rTyp).Name().Key())
+			}
+		}
 		n.SetConstValue(ncv)
 	}
 
@@ -946,11 +1709,11 @@ func (q *checker) tcheckExprBinaryOp(n *a.Expr, depth uint32) error {
 
 func evalConstValueBinaryOp(tm *t.Map, n *a.Expr, l *big.Int, r *big.Int) (*big.Int, error) {
 	switch n.ID0().Key() {
-	case t.KeyXBinaryPlus:
+	case t.KeyXBinaryPlus, t.KeyXBinaryTildePlus, t.KeyXBinaryTildeSatPlus:
 		return big.NewInt(0).Add(l, r), nil
-	case t.KeyXBinaryMinus:
+	case t.KeyXBinaryMinus, t.KeyXBinaryTildeMinus, t.KeyXBinaryTildeSatMinus:
 		return big.NewInt(0).Sub(l, r), nil
-	case t.KeyXBinaryStar:
+	case t.KeyXBinaryStar, t.KeyXBinaryTildeStar:
 		return big.NewInt(0).Mul(l, r), nil
 	case t.KeyXBinarySlash:
 		if r.Cmp(zero) == 0 {
@@ -959,7 +1722,7 @@ func evalConstValueBinaryOp(tm *t.Map, n *a.Expr, l *big.Int, r *big.Int) (*big.
 		// TODO: decide on Euclidean division vs other definitions. See "go doc
 		// math/big int.divmod" for details.
 		return big.NewInt(0).Div(l, r), nil
-	case t.KeyXBinaryShiftL:
+	case t.KeyXBinaryShiftL, t.KeyXBinaryTildeShiftL:
 		if r.Cmp(zero) < 0 || r.Cmp(ffff) > 0 {
 			return nil, fmt.Errorf("check: shift %q out of range in const expression %q",
 				n.RHS().Expr().String(tm), n.String(tm))
@@ -1000,12 +1763,38 @@ func evalConstValueBinaryOp(tm *t.Map, n *a.Expr, l *big.Int, r *big.Int) (*big.
 		return btoi((l.Cmp(zero) != 0) && (r.Cmp(zero) != 0)), nil
 	case t.KeyXBinaryOr:
 		return btoi((l.Cmp(zero) != 0) || (r.Cmp(zero) != 0)), nil
-	case t.KeyXBinaryTildePlus:
-		return nil, fmt.Errorf("check: cannot apply ~+ operator to ideal numbers")
 	}
 	return nil, fmt.Errorf("check: unrecognized token.Key (0x%02X) for evalConstValueBinaryOp", n.ID0().Key())
 }
 
+// nonIdealType returns whichever of lTyp and rTyp is not ideal, preferring
+// lTyp.
+func nonIdealType(lTyp *a.TypeExpr, rTyp *a.TypeExpr) *a.TypeExpr {
+	if lTyp.IsIdeal() {
+		return rTyp
+	}
+	return lTyp
+}
+
+// wrapConstValue returns v modulo the range of the numeric type with the given
+// key. For example, wrapping 0x1234 to u8 gives 0x34 and wrapping 0xFF to i8
+// gives -1.
+func wrapConstValue(v *big.Int, key t.Key) *big.Int {
+	b := numTypeBounds[key]
+	width := big.NewInt(0).Sub(b[1], b[0])
+	width.Add(width, one)
+	z := big.NewInt(0).Sub(v, b[0])
+	z.Mod(z, width)
+	return z.Add(z, b[0])
+}
+
+// saturateConstValue returns v clamped to the range of the numeric type with
+// the given key.
+func saturateConstValue(v *big.Int, key t.Key) *big.Int {
+	b := numTypeBounds[key]
+	return min(max(v, b[0]), b[1])
+}
+
 func (q *checker) tcheckExprAssociativeOp(n *a.Expr, depth uint32) error {
 	switch n.ID0().Key() {
 	case t.KeyXAssociativePlus, t.KeyXAssociativeStar,
@@ -1088,7 +1877,7 @@ swtch:
 			// TODO: reject. You can only refine numeric types.
 		}
 		switch n.Name().Key() {
-		case t.KeyBool, t.KeyStatus, t.KeyReader1, t.KeyWriter1:
+		case t.KeyBool, t.KeyStatus, t.KeyReader1, t.KeyWriter1, t.KeyBuf2, t.KeyBitreader:
 			break swtch
 		}
 		for _, s := range q.c.structs {
@@ -1096,6 +1885,19 @@ swtch:
 				break swtch
 			}
 		}
+		if e, ok := q.c.enums[n.Name()]; ok {
+			if n.Min() != nil || n.Max() != nil {
+				return fmt.Errorf("check: enum type %q cannot be refined", n.String(q.tm))
+			}
+			n.Resolve(e.XType)
+			break swtch
+		}
+		if _, ok := q.c.unions[n.Name()]; ok {
+			if n.Min() != nil || n.Max() != nil {
+				return fmt.Errorf("check: union type %q cannot be refined", n.String(q.tm))
+			}
+			break swtch
+		}
 		return fmt.Errorf("check: %q is not a type", n.Name().String(q.tm))
 
 	case t.KeyOpenBracket:
@@ -1121,6 +1923,17 @@ swtch:
 	return nil
 }
 
+// tildeOps are the wrapping and saturating binary operators, other than "~as".
+// Their results are always within the range of their non-ideal operand's type.
+var tildeOps = [256]bool{
+	t.KeyXBinaryTildePlus:     true,
+	t.KeyXBinaryTildeMinus:    true,
+	t.KeyXBinaryTildeStar:     true,
+	t.KeyXBinaryTildeShiftL:   true,
+	t.KeyXBinaryTildeSatPlus:  true,
+	t.KeyXBinaryTildeSatMinus: true,
+}
+
 var comparisonOps = [256]bool{
 	t.KeyXBinaryNotEq:       true,
 	t.KeyXBinaryLessThan:    true,
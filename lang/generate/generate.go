@@ -27,11 +27,65 @@ import (
 	"github.com/google/puffs/lang/token"
 )
 
-type Generator func(packageName string, tm *token.Map, c *check.Checker, files []*ast.File) ([]byte, error)
+// Flag defaults and usage messages for the Options, and for checking, which
+// are common to each "puffs-foo gen" command and to "puffs gen".
+const (
+	CoverageDefault = false
+	CoverageUsage   = `whether to generate code that counts how often each statement runs`
+
+	DebugChecksDefault = false
+	DebugChecksUsage   = `whether to generate run time checks of what the compile time checker proved`
+
+	FuzzTargetsDefault = false
+	FuzzTargetsUsage   = `whether to also generate a fuzz target for each public decoder method`
+
+	LineDirectivesDefault = false
+	LineDirectivesUsage   = `whether to generate #line directives that map generated code back to Puffs source code`
+
+	RequireTerminationDefault = false
+	RequireTerminationUsage   = `whether to require every while loop to be proven to terminate`
+
+	SizeProbesDefault = false
+	SizeProbesUsage   = `whether to also generate a program that prints each public struct's sizeof, broken down by part`
+)
+
+// Options are the code generation options that are common to each target
+// language.
+type Options struct {
+	// DebugChecks is whether to generate code that re-checks, at run time, the
+	// facts that were proved at compile time, such as assertions and index
+	// bounds. A failed check is a bug in the checker, not the Puffs code.
+	DebugChecks bool
+
+	// LineDirectives is whether to generate directives, such as C's #line,
+	// that map the generated code back to the Puffs source code, for
+	// debuggers, sanitizers and profilers.
+	LineDirectives bool
+
+	// Coverage is whether to generate code that counts how often each
+	// statement runs, for the "puffs cover" command.
+	Coverage bool
+
+	// FuzzTargets is whether to also generate a fuzz target for each public
+	// decoder method, for the "puffs fuzz" command.
+	FuzzTargets bool
+
+	// SizeProbes is whether to also generate a program that prints the size
+	// of each public struct's parts, for the "puffs sizes" command.
+	SizeProbes bool
+}
+
+type Generator func(packageName string, tm *token.Map, c *check.Checker, files []*ast.File, opts *Options) ([]byte, error)
 
 func Do(args []string, g Generator) error {
 	flags := flag.FlagSet{}
 	packageName := flags.String("package_name", "", "the package name of the Puffs input code")
+	requireTermination := flags.Bool("require_termination", RequireTerminationDefault, RequireTerminationUsage)
+	debugChecks := flags.Bool("debug_checks", DebugChecksDefault, DebugChecksUsage)
+	lineDirectives := flags.Bool("line_directives", LineDirectivesDefault, LineDirectivesUsage)
+	coverage := flags.Bool("coverage", CoverageDefault, CoverageUsage)
+	fuzzTargets := flags.Bool("fuzz_targets", FuzzTargetsDefault, FuzzTargetsUsage)
+	sizeProbes := flags.Bool("size_probes", SizeProbesDefault, SizeProbesUsage)
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -47,12 +101,20 @@ func Do(args []string, g Generator) error {
 		return err
 	}
 
-	c, err := check.Check(tm, files...)
+	c, err := check.CheckWithOptions(tm, &check.Options{
+		RequireTermination: *requireTermination,
+	}, files...)
 	if err != nil {
 		return err
 	}
 
-	out, err := g(pkgName, tm, c, files)
+	out, err := g(pkgName, tm, c, files, &Options{
+		DebugChecks:    *debugChecks,
+		LineDirectives: *lineDirectives,
+		Coverage:       *coverage,
+		FuzzTargets:    *fuzzTargets,
+		SizeProbes:     *sizeProbes,
+	})
 	if err != nil {
 		return err
 	}
@@ -118,6 +118,10 @@ func (p *parser) parseTopLevelDecl() (*a.Node, error) {
 		fallthrough
 	case t.KeyPri:
 		p.src = p.src[1:]
+		if p.peek1().Key() == t.KeyConst && len(p.src) > 1 && p.src[1].ID.Key() == t.KeyFunc {
+			flags |= a.FlagsConst
+			p.src = p.src[1:]
+		}
 		switch p.peek1().Key() {
 		case t.KeyConst:
 			p.src = p.src[1:]
@@ -134,18 +138,9 @@ func (p *parser) parseTopLevelDecl() (*a.Node, error) {
 					p.tm.ByID(id), p.filename, p.line())
 			}
 			p.src = p.src[1:]
-			value := (*a.Expr)(nil)
-			// TODO: parse lists of lists.
-			if p.peek1().Key() == t.KeyDollar {
-				value, err = p.parseDollarExpr()
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				value, err = p.parseExpr()
-				if err != nil {
-					return nil, err
-				}
+			value, err := p.parseConstValue()
+			if err != nil {
+				return nil, err
 			}
 			if x := p.peek1().Key(); x != t.KeySemicolon {
 				got := p.tm.ByKey(x)
@@ -191,7 +186,7 @@ func (p *parser) parseTopLevelDecl() (*a.Node, error) {
 				if err != nil {
 					return nil, err
 				}
-				if err := p.assertsSorted(asserts); err != nil {
+				if err := p.assertsSorted(asserts, false); err != nil {
 					return nil, err
 				}
 			}
@@ -224,6 +219,31 @@ func (p *parser) parseTopLevelDecl() (*a.Node, error) {
 			p.src = p.src[1:]
 			return a.NewStatus(flags, p.filename, line, keyword, message).Node(), nil
 
+		case t.KeyEnum:
+			p.src = p.src[1:]
+			name, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			if name.IsBuiltIn() {
+				return nil, fmt.Errorf(`parse: built-in %q used for enum name at %s:%d`,
+					p.tm.ByID(name), p.filename, p.line())
+			}
+			typ, err := p.parseTypeExpr()
+			if err != nil {
+				return nil, err
+			}
+			members, err := p.parseList(t.KeyCloseParen, (*parser).parseEnumMemberNode)
+			if err != nil {
+				return nil, err
+			}
+			if x := p.peek1().Key(); x != t.KeySemicolon {
+				got := p.tm.ByKey(x)
+				return nil, fmt.Errorf(`parse: expected (implicit) ";", got %q at %s:%d`, got, p.filename, p.line())
+			}
+			p.src = p.src[1:]
+			return a.NewEnum(flags, p.filename, line, name, typ, members).Node(), nil
+
 		case t.KeyStruct:
 			p.src = p.src[1:]
 			name, err := p.parseIdent()
@@ -248,6 +268,27 @@ func (p *parser) parseTopLevelDecl() (*a.Node, error) {
 			}
 			p.src = p.src[1:]
 			return a.NewStruct(flags, p.filename, line, name, fields).Node(), nil
+
+		case t.KeyUnion:
+			p.src = p.src[1:]
+			name, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			if name.IsBuiltIn() {
+				return nil, fmt.Errorf(`parse: built-in %q used for union name at %s:%d`,
+					p.tm.ByID(name), p.filename, p.line())
+			}
+			variants, err := p.parseList(t.KeyCloseParen, (*parser).parseUnionVariantNode)
+			if err != nil {
+				return nil, err
+			}
+			if x := p.peek1().Key(); x != t.KeySemicolon {
+				got := p.tm.ByKey(x)
+				return nil, fmt.Errorf(`parse: expected (implicit) ";", got %q at %s:%d`, got, p.filename, p.line())
+			}
+			p.src = p.src[1:]
+			return a.NewUnion(flags, p.filename, line, name, variants).Node(), nil
 		}
 	}
 	return nil, fmt.Errorf(`parse: unrecognized top level declaration at %s:%d`, p.filename, line)
@@ -475,29 +516,43 @@ func (p *parser) parseBlock() ([]*a.Node, error) {
 	return nil, fmt.Errorf(`parse: expected "}" at %s:%d`, p.filename, p.line())
 }
 
-func (p *parser) assertsSorted(asserts []*a.Node) error {
-	seenInv, seenPost := false, false
+func (p *parser) assertsSorted(asserts []*a.Node, allowDec bool) error {
+	seenInv, seenDec, seenPost := false, false, false
 	for _, a := range asserts {
 		switch a.Assert().Keyword().Key() {
 		case t.KeyAssert:
 			return fmt.Errorf(`parse: assertion chain cannot contain "assert", `+
-				`only "pre", "inv" and "post" at %s:%d`, p.filename, p.line())
+				`only "pre", "inv", "dec" and "post" at %s:%d`, p.filename, p.line())
 		case t.KeyPre:
-			if seenPost || seenInv {
+			if seenPost || seenDec || seenInv {
 				break
 			}
 			continue
 		case t.KeyInv:
-			if seenPost {
+			if seenPost || seenDec {
 				break
 			}
 			seenInv = true
 			continue
+		case t.KeyDec:
+			if !allowDec {
+				return fmt.Errorf(`parse: "dec" is only valid in a "while" loop's assertion chain at %s:%d`,
+					p.filename, p.line())
+			}
+			if seenDec {
+				return fmt.Errorf(`parse: assertion chain contains more than one "dec" at %s:%d`,
+					p.filename, p.line())
+			}
+			if seenPost {
+				break
+			}
+			seenDec = true
+			continue
 		default:
 			seenPost = true
 			continue
 		}
-		return fmt.Errorf(`parse: assertion chain not in "pre", "inv", "post" order at %s:%d`,
+		return fmt.Errorf(`parse: assertion chain not in "pre", "inv", "dec", "post" order at %s:%d`,
 			p.filename, p.line())
 	}
 	return nil
@@ -505,7 +560,7 @@ func (p *parser) assertsSorted(asserts []*a.Node) error {
 
 func (p *parser) parseAssertNode() (*a.Node, error) {
 	switch x := p.peek1(); x.Key() {
-	case t.KeyAssert, t.KeyPre, t.KeyInv, t.KeyPost:
+	case t.KeyAssert, t.KeyPre, t.KeyInv, t.KeyDec, t.KeyPost:
 		p.src = p.src[1:]
 		condition, err := p.parseExpr()
 		if err != nil {
@@ -602,7 +657,7 @@ func (p *parser) parseStatement1() (*a.Node, error) {
 		if err != nil {
 			return nil, err
 		}
-		asserts, err := p.parseAsserts()
+		asserts, err := p.parseAsserts(false)
 		if err != nil {
 			return nil, err
 		}
@@ -623,6 +678,10 @@ func (p *parser) parseStatement1() (*a.Node, error) {
 		}
 		return a.NewReturn(value).Node(), nil
 
+	case t.KeySwitch:
+		o, err := p.parseSwitch()
+		return o.Node(), err
+
 	case t.KeyVar:
 		p.src = p.src[1:]
 		return p.parseVar(false)
@@ -637,7 +696,7 @@ func (p *parser) parseStatement1() (*a.Node, error) {
 		if err != nil {
 			return nil, err
 		}
-		asserts, err := p.parseAsserts()
+		asserts, err := p.parseAsserts(true)
 		if err != nil {
 			return nil, err
 		}
@@ -665,7 +724,7 @@ func (p *parser) parseStatement1() (*a.Node, error) {
 	return lhs.Node(), nil
 }
 
-func (p *parser) parseAsserts() ([]*a.Node, error) {
+func (p *parser) parseAsserts(allowDec bool) ([]*a.Node, error) {
 	asserts := []*a.Node(nil)
 	if p.peek1().Key() == t.KeyComma {
 		p.src = p.src[1:]
@@ -673,7 +732,7 @@ func (p *parser) parseAsserts() ([]*a.Node, error) {
 		if asserts, err = p.parseList(t.KeyOpenCurly, (*parser).parseAssertNode); err != nil {
 			return nil, err
 		}
-		if err := p.assertsSorted(asserts); err != nil {
+		if err := p.assertsSorted(asserts, allowDec); err != nil {
 			return nil, err
 		}
 	}
@@ -712,6 +771,101 @@ func (p *parser) parseIf() (*a.If, error) {
 	return a.NewIf(condition, elseIf, bodyIfTrue, bodyIfFalse), nil
 }
 
+func (p *parser) parseSwitch() (*a.Switch, error) {
+	if x := p.peek1().Key(); x != t.KeySwitch {
+		got := p.tm.ByKey(x)
+		return nil, fmt.Errorf(`parse: expected "switch", got %q at %s:%d`, got, p.filename, p.line())
+	}
+	p.src = p.src[1:]
+	scrutinee, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if x := p.peek1().Key(); x != t.KeyOpenCurly {
+		got := p.tm.ByKey(x)
+		return nil, fmt.Errorf(`parse: expected "{", got %q at %s:%d`, got, p.filename, p.line())
+	}
+	p.src = p.src[1:]
+
+	cases, hasElse, bodyIfElse := []*a.Node(nil), false, []*a.Node(nil)
+	for {
+		switch x := p.peek1().Key(); x {
+		case t.KeyCase:
+			if hasElse {
+				return nil, fmt.Errorf(`parse: "case" after "else" at %s:%d`, p.filename, p.line())
+			}
+			line := p.src[0].Line
+			p.src = p.src[1:]
+			values, err := p.parseCaseValues()
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+			n := a.NewCase(values, body).Node()
+			n.Raw().SetFilenameLine(p.filename, line)
+			cases = append(cases, n)
+
+		case t.KeyElse:
+			if hasElse {
+				return nil, fmt.Errorf(`parse: duplicate "else" at %s:%d`, p.filename, p.line())
+			}
+			p.src = p.src[1:]
+			hasElse = true
+			bodyIfElse, err = p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+
+		case t.KeyCloseCurly:
+			p.src = p.src[1:]
+			if len(cases) == 0 {
+				return nil, fmt.Errorf(`parse: "switch" has no "case" at %s:%d`, p.filename, p.line())
+			}
+			return a.NewSwitch(scrutinee, cases, hasElse, bodyIfElse), nil
+
+		default:
+			got := p.tm.ByKey(x)
+			return nil, fmt.Errorf(`parse: expected "case", "else" or "}", got %q at %s:%d`,
+				got, p.filename, p.line())
+		}
+
+		if x := p.peek1().Key(); x != t.KeySemicolon {
+			got := p.tm.ByKey(x)
+			return nil, fmt.Errorf(`parse: expected (implicit) ";", got %q at %s:%d`, got, p.filename, p.line())
+		}
+		p.src = p.src[1:]
+	}
+}
+
+// parseCaseValues parses a switch case's comma-separated values, each of
+// which is either a single value, like "0x21", or a range, like "0x80..0xFF".
+func (p *parser) parseCaseValues() ([]*a.Node, error) {
+	values := []*a.Node(nil)
+	for {
+		lhs, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek1().Key() == t.KeyDotDot {
+			p.src = p.src[1:]
+			rhs, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			lhs = a.NewExpr(0, t.IDDotDot, 0, lhs.Node(), nil, rhs.Node(), nil)
+		}
+		values = append(values, lhs.Node())
+
+		if p.peek1().Key() != t.KeyComma {
+			return values, nil
+		}
+		p.src = p.src[1:]
+	}
+}
+
 func (p *parser) parseArgNode() (*a.Node, error) {
 	name, err := p.parseIdent()
 	if err != nil {
@@ -729,6 +883,41 @@ func (p *parser) parseArgNode() (*a.Node, error) {
 	return a.NewArg(name, value).Node(), nil
 }
 
+func (p *parser) parseEnumMemberNode() (*a.Node, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	if x := p.peek1().Key(); x != t.KeyEq {
+		got := p.tm.ByKey(x)
+		return nil, fmt.Errorf(`parse: expected "=", got %q at %s:%d`, got, p.filename, p.line())
+	}
+	p.src = p.src[1:]
+	value, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return a.NewArg(name, value).Node(), nil
+}
+
+// parseUnionVariantNode parses "name(fields)", such as "stored(length u32)".
+func (p *parser) parseUnionVariantNode() (*a.Node, error) {
+	line := p.line()
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	if name.IsBuiltIn() {
+		return nil, fmt.Errorf(`parse: built-in %q used for union variant name at %s:%d`,
+			p.tm.ByID(name), p.filename, p.line())
+	}
+	fields, err := p.parseList(t.KeyCloseParen, (*parser).parseFieldNode)
+	if err != nil {
+		return nil, err
+	}
+	return a.NewStruct(0, p.filename, line, name, fields).Node(), nil
+}
+
 func (p *parser) parseIterateVariableNode() (*a.Node, error) {
 	return p.parseVar(true)
 }
@@ -782,13 +971,30 @@ func (p *parser) parseDollarExpr() (*a.Expr, error) {
 		return nil, fmt.Errorf(`parse: expected "$", got %q at %s:%d`, got, p.filename, p.line())
 	}
 	p.src = p.src[1:]
-	args, err := p.parseList(t.KeyCloseParen, (*parser).parseExprNode)
+	args, err := p.parseList(t.KeyCloseParen, (*parser).parseConstValueNode)
 	if err != nil {
 		return nil, err
 	}
 	return a.NewExpr(0, t.IDDollar, 0, nil, nil, nil, args), nil
 }
 
+// parseConstValue parses a const's value: either an expression or a "$(etc)"
+// list, whose elements can themselves be lists, such as "$($(1, 2), $(3, 4))".
+func (p *parser) parseConstValue() (*a.Expr, error) {
+	if p.peek1().Key() == t.KeyDollar {
+		return p.parseDollarExpr()
+	}
+	return p.parseExpr()
+}
+
+func (p *parser) parseConstValueNode() (*a.Node, error) {
+	n, err := p.parseConstValue()
+	if err != nil {
+		return nil, err
+	}
+	return n.Node(), err
+}
+
 func (p *parser) parseTryExpr() (*a.Expr, error) {
 	if x := p.peek1().Key(); x != t.KeyTry {
 		got := p.tm.ByKey(x)
@@ -823,7 +1029,7 @@ func (p *parser) parseExpr() (*a.Expr, error) {
 	if x := p.peek1(); x.IsBinaryOp() {
 		p.src = p.src[1:]
 		rhs := (*a.Node)(nil)
-		if x.Key() == t.KeyAs {
+		if x.Key() == t.KeyAs || x.Key() == t.KeyTildeAs {
 			o, err := p.parseTypeExpr()
 			if err != nil {
 				return nil, err
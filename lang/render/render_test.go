@@ -0,0 +1,73 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/puffs/lang/token"
+)
+
+func TestRenderSwitch(t *testing.T) {
+	// A switch's arms, like any other block, are indented one level deeper
+	// than the "switch" line, whatever their indentation in the source.
+	const want = "" +
+		"pri func foo(x u8)() {\n" +
+		"\tswitch in.x {\n" +
+		"\t\t// Small values.\n" +
+		"\t\tcase 0x01, 0x02 {\n" +
+		"\t\t}\n" +
+		"\t\tcase 0x03..0x09 {  // Larger values.\n" +
+		"\t\t\treturn\n" +
+		"\t\t}\n" +
+		"\t\telse {\n" +
+		"\t\t\treturn\n" +
+		"\t\t}\n" +
+		"\t}\n" +
+		"}\n"
+
+	testCases := []string{
+		want,
+		"pri func foo(x u8)() {\n" +
+			"switch in.x {\n" +
+			"// Small values.\n" +
+			"case 0x01, 0x02 {\n" +
+			"}\n" +
+			"    case 0x03..0x09 {  // Larger values.\n" +
+			"return\n" +
+			"}\n" +
+			"else {\n" +
+			"\t\t\t\treturn\n" +
+			"}\n" +
+			"}\n" +
+			"}\n",
+	}
+
+	for _, src := range testCases {
+		tm := &token.Map{}
+		tokens, comments, err := token.Tokenize(tm, "test.puffs", []byte(src))
+		if err != nil {
+			t.Fatalf("%q: Tokenize: %v", src, err)
+		}
+		buf := &bytes.Buffer{}
+		if err := Render(buf, tm, tokens, comments); err != nil {
+			t.Fatalf("%q: Render: %v", src, err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%q:\ngot:\n%s\nwant:\n%s", src, got, want)
+		}
+	}
+}
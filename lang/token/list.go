@@ -141,8 +141,8 @@ func (t Token) IsXAssociativeOp() bool { return t.ID.Key().isXOp() && t.ID.IsAss
 //  - Zero is invalid.
 //  - [0x01, 0x0F] are reserved for implementation details.
 //  - [0x10, 0x1F] are squiggly punctuation, such as "(", ")" and ";".
-//  - [0x20, 0x2F] are squiggly assignments, such as "=" and "+=".
-//  - [0x30, 0x4F] are operators, such as "+", "==" and "not".
+//  - [0x20, 0x31] are squiggly assignments, such as "=" and "+=".
+//  - [0x32, 0x4F] are operators, such as "+", "==" and "not".
 //  - [0x50, 0x6F] are keywords, such as "if" and "return".
 //  - [0x70, 0x77] are literals, such as "false" and "true".
 //  - [0x78, 0xCF] are identifiers, such as "bool", "u32" and "read_u8".
@@ -189,6 +189,12 @@ const (
 	KeyPercentEq   = Key(IDPercentEq >> KeyShift)
 	KeyTildePlusEq = Key(IDTildePlusEq >> KeyShift)
 
+	KeyTildeMinusEq    = Key(IDTildeMinusEq >> KeyShift)
+	KeyTildeStarEq     = Key(IDTildeStarEq >> KeyShift)
+	KeyTildeShiftLEq   = Key(IDTildeShiftLEq >> KeyShift)
+	KeyTildeSatPlusEq  = Key(IDTildeSatPlusEq >> KeyShift)
+	KeyTildeSatMinusEq = Key(IDTildeSatMinusEq >> KeyShift)
+
 	KeyPlus      = Key(IDPlus >> KeyShift)
 	KeyMinus     = Key(IDMinus >> KeyShift)
 	KeyStar      = Key(IDStar >> KeyShift)
@@ -202,6 +208,13 @@ const (
 	KeyPercent   = Key(IDPercent >> KeyShift)
 	KeyTildePlus = Key(IDTildePlus >> KeyShift)
 
+	KeyTildeMinus    = Key(IDTildeMinus >> KeyShift)
+	KeyTildeStar     = Key(IDTildeStar >> KeyShift)
+	KeyTildeShiftL   = Key(IDTildeShiftL >> KeyShift)
+	KeyTildeSatPlus  = Key(IDTildeSatPlus >> KeyShift)
+	KeyTildeSatMinus = Key(IDTildeSatMinus >> KeyShift)
+	KeyTildeAs       = Key(IDTildeAs >> KeyShift)
+
 	KeyNotEq       = Key(IDNotEq >> KeyShift)
 	KeyLessThan    = Key(IDLessThan >> KeyShift)
 	KeyLessEq      = Key(IDLessEq >> KeyShift)
@@ -243,6 +256,11 @@ const (
 	KeyConst      = Key(IDConst >> KeyShift)
 	KeyTry        = Key(IDTry >> KeyShift)
 	KeyIterate    = Key(IDIterate >> KeyShift)
+	KeyDec        = Key(IDDec >> KeyShift)
+	KeySwitch     = Key(IDSwitch >> KeyShift)
+	KeyCase       = Key(IDCase >> KeyShift)
+	KeyEnum       = Key(IDEnum >> KeyShift)
+	KeyUnion      = Key(IDUnion >> KeyShift)
 
 	KeyFalse = Key(IDFalse >> KeyShift)
 	KeyTrue  = Key(IDTrue >> KeyShift)
@@ -253,21 +271,22 @@ const (
 	KeyIn         = Key(IDIn >> KeyShift)
 	KeyOut        = Key(IDOut >> KeyShift)
 
-	KeyI8      = Key(IDI8 >> KeyShift)
-	KeyI16     = Key(IDI16 >> KeyShift)
-	KeyI32     = Key(IDI32 >> KeyShift)
-	KeyI64     = Key(IDI64 >> KeyShift)
-	KeyU8      = Key(IDU8 >> KeyShift)
-	KeyU16     = Key(IDU16 >> KeyShift)
-	KeyU32     = Key(IDU32 >> KeyShift)
-	KeyU64     = Key(IDU64 >> KeyShift)
-	KeyUsize   = Key(IDUsize >> KeyShift)
-	KeyBool    = Key(IDBool >> KeyShift)
-	KeyBuf1    = Key(IDBuf1 >> KeyShift)
-	KeyReader1 = Key(IDReader1 >> KeyShift)
-	KeyWriter1 = Key(IDWriter1 >> KeyShift)
-	KeyBuf2    = Key(IDBuf2 >> KeyShift)
-	KeyStatus  = Key(IDStatus >> KeyShift)
+	KeyI8        = Key(IDI8 >> KeyShift)
+	KeyI16       = Key(IDI16 >> KeyShift)
+	KeyI32       = Key(IDI32 >> KeyShift)
+	KeyI64       = Key(IDI64 >> KeyShift)
+	KeyU8        = Key(IDU8 >> KeyShift)
+	KeyU16       = Key(IDU16 >> KeyShift)
+	KeyU32       = Key(IDU32 >> KeyShift)
+	KeyU64       = Key(IDU64 >> KeyShift)
+	KeyUsize     = Key(IDUsize >> KeyShift)
+	KeyBool      = Key(IDBool >> KeyShift)
+	KeyBuf1      = Key(IDBuf1 >> KeyShift)
+	KeyReader1   = Key(IDReader1 >> KeyShift)
+	KeyWriter1   = Key(IDWriter1 >> KeyShift)
+	KeyBuf2      = Key(IDBuf2 >> KeyShift)
+	KeyStatus    = Key(IDStatus >> KeyShift)
+	KeyBitreader = Key(IDBitreader >> KeyShift)
 
 	KeyMark       = Key(IDMark >> KeyShift)
 	KeyReadU8     = Key(IDReadU8 >> KeyShift)
@@ -304,6 +323,24 @@ const (
 	KeyHighBits          = Key(IDHighBits >> KeyShift)
 	KeyUnreadU8          = Key(IDUnreadU8 >> KeyShift)
 	KeyIsMarked          = Key(IDIsMarked >> KeyShift)
+	KeyReadMatch         = Key(IDReadMatch >> KeyShift)
+	KeyKind              = Key(IDKind >> KeyShift)
+	KeyWidth             = Key(IDWidth >> KeyShift)
+	KeyHeight            = Key(IDHeight >> KeyShift)
+	KeyRow               = Key(IDRow >> KeyShift)
+	KeySetU8             = Key(IDSetU8 >> KeyShift)
+	KeyPixelFormat       = Key(IDPixelFormat >> KeyShift)
+	KeyClz               = Key(IDClz >> KeyShift)
+	KeyCtz               = Key(IDCtz >> KeyShift)
+	KeyPopcount          = Key(IDPopcount >> KeyShift)
+	KeyReverseBits       = Key(IDReverseBits >> KeyShift)
+	KeyRotateLeft        = Key(IDRotateLeft >> KeyShift)
+	KeyByteSwap          = Key(IDByteSwap >> KeyShift)
+	KeyNBits             = Key(IDNBits >> KeyShift)
+	KeyPeekBits          = Key(IDPeekBits >> KeyShift)
+	KeyTakeBits          = Key(IDTakeBits >> KeyShift)
+	KeyRefill            = Key(IDRefill >> KeyShift)
+	KeySetMSBFirst       = Key(IDSetMSBFirst >> KeyShift)
 
 	KeyXUnaryPlus  = Key(IDXUnaryPlus >> KeyShift)
 	KeyXUnaryMinus = Key(IDXUnaryMinus >> KeyShift)
@@ -333,6 +370,13 @@ const (
 	KeyXBinaryAs          = Key(IDXBinaryAs >> KeyShift)
 	KeyXBinaryTildePlus   = Key(IDXBinaryTildePlus >> KeyShift)
 
+	KeyXBinaryTildeMinus    = Key(IDXBinaryTildeMinus >> KeyShift)
+	KeyXBinaryTildeStar     = Key(IDXBinaryTildeStar >> KeyShift)
+	KeyXBinaryTildeShiftL   = Key(IDXBinaryTildeShiftL >> KeyShift)
+	KeyXBinaryTildeSatPlus  = Key(IDXBinaryTildeSatPlus >> KeyShift)
+	KeyXBinaryTildeSatMinus = Key(IDXBinaryTildeSatMinus >> KeyShift)
+	KeyXBinaryTildeAs       = Key(IDXBinaryTildeAs >> KeyShift)
+
 	KeyXAssociativePlus = Key(IDXAssociativePlus >> KeyShift)
 	KeyXAssociativeStar = Key(IDXAssociativeStar >> KeyShift)
 	KeyXAssociativeAmp  = Key(IDXAssociativeAmp >> KeyShift)
@@ -377,33 +421,49 @@ const (
 	IDPercentEq   = ID(0x2B<<KeyShift | FlagsAssign)
 	IDTildePlusEq = ID(0x2C<<KeyShift | FlagsAssign)
 
-	IDPlus      = ID(0x31<<KeyShift | FlagsBinaryOp | FlagsUnaryOp | FlagsAssociativeOp)
-	IDMinus     = ID(0x32<<KeyShift | FlagsBinaryOp | FlagsUnaryOp)
-	IDStar      = ID(0x33<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDSlash     = ID(0x34<<KeyShift | FlagsBinaryOp)
-	IDShiftL    = ID(0x35<<KeyShift | FlagsBinaryOp)
-	IDShiftR    = ID(0x36<<KeyShift | FlagsBinaryOp)
-	IDAmp       = ID(0x37<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDAmpHat    = ID(0x38<<KeyShift | FlagsBinaryOp)
-	IDPipe      = ID(0x39<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDHat       = ID(0x3A<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDPercent   = ID(0x3B<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDTildePlus = ID(0x3C<<KeyShift | FlagsBinaryOp) // TODO: FlagsAssociativeOp?
-
-	IDNotEq       = ID(0x40<<KeyShift | FlagsBinaryOp)
-	IDLessThan    = ID(0x41<<KeyShift | FlagsBinaryOp)
-	IDLessEq      = ID(0x42<<KeyShift | FlagsBinaryOp)
-	IDEqEq        = ID(0x43<<KeyShift | FlagsBinaryOp)
-	IDGreaterEq   = ID(0x44<<KeyShift | FlagsBinaryOp)
-	IDGreaterThan = ID(0x45<<KeyShift | FlagsBinaryOp)
+	IDTildeMinusEq    = ID(0x2D<<KeyShift | FlagsAssign)
+	IDTildeStarEq     = ID(0x2E<<KeyShift | FlagsAssign)
+	IDTildeShiftLEq   = ID(0x2F<<KeyShift | FlagsAssign)
+	IDTildeSatPlusEq  = ID(0x30<<KeyShift | FlagsAssign)
+	IDTildeSatMinusEq = ID(0x31<<KeyShift | FlagsAssign)
+
+	IDPlus      = ID(0x32<<KeyShift | FlagsBinaryOp | FlagsUnaryOp | FlagsAssociativeOp)
+	IDMinus     = ID(0x33<<KeyShift | FlagsBinaryOp | FlagsUnaryOp)
+	IDStar      = ID(0x34<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDSlash     = ID(0x35<<KeyShift | FlagsBinaryOp)
+	IDShiftL    = ID(0x36<<KeyShift | FlagsBinaryOp)
+	IDShiftR    = ID(0x37<<KeyShift | FlagsBinaryOp)
+	IDAmp       = ID(0x38<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDAmpHat    = ID(0x39<<KeyShift | FlagsBinaryOp)
+	IDPipe      = ID(0x3A<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDHat       = ID(0x3B<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDPercent   = ID(0x3C<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDTildePlus = ID(0x3D<<KeyShift | FlagsBinaryOp) // TODO: FlagsAssociativeOp?
+
+	// The "~" operators wrap around (modular arithmetic), except for the
+	// "~sat" operators, which saturate (clamp) to the type's range. "~as" is
+	// a truncating, not checked, conversion.
+	IDTildeMinus    = ID(0x3E<<KeyShift | FlagsBinaryOp)
+	IDTildeStar     = ID(0x3F<<KeyShift | FlagsBinaryOp)
+	IDTildeShiftL   = ID(0x40<<KeyShift | FlagsBinaryOp)
+	IDTildeSatPlus  = ID(0x41<<KeyShift | FlagsBinaryOp)
+	IDTildeSatMinus = ID(0x42<<KeyShift | FlagsBinaryOp)
+	IDTildeAs       = ID(0x43<<KeyShift | FlagsBinaryOp)
+
+	IDNotEq       = ID(0x44<<KeyShift | FlagsBinaryOp)
+	IDLessThan    = ID(0x45<<KeyShift | FlagsBinaryOp)
+	IDLessEq      = ID(0x46<<KeyShift | FlagsBinaryOp)
+	IDEqEq        = ID(0x47<<KeyShift | FlagsBinaryOp)
+	IDGreaterEq   = ID(0x48<<KeyShift | FlagsBinaryOp)
+	IDGreaterThan = ID(0x49<<KeyShift | FlagsBinaryOp)
 
 	// TODO: sort these by name, when the list has stabilized.
-	IDAnd   = ID(0x48<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDOr    = ID(0x49<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
-	IDNot   = ID(0x4A<<KeyShift | FlagsUnaryOp)
-	IDAs    = ID(0x4B<<KeyShift | FlagsBinaryOp)
-	IDRef   = ID(0x4C<<KeyShift | FlagsUnaryOp)
-	IDDeref = ID(0x4D<<KeyShift | FlagsUnaryOp)
+	IDAnd   = ID(0x4A<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDOr    = ID(0x4B<<KeyShift | FlagsBinaryOp | FlagsAssociativeOp)
+	IDNot   = ID(0x4C<<KeyShift | FlagsUnaryOp)
+	IDAs    = ID(0x4D<<KeyShift | FlagsBinaryOp)
+	IDRef   = ID(0x4E<<KeyShift | FlagsUnaryOp)
+	IDDeref = ID(0x4F<<KeyShift | FlagsUnaryOp)
 
 	// TODO: sort these by name, when the list has stabilized.
 	IDFunc       = ID(0x50<<KeyShift | FlagsOther)
@@ -431,6 +491,11 @@ const (
 	IDConst      = ID(0x66<<KeyShift | FlagsOther)
 	IDTry        = ID(0x67<<KeyShift | FlagsOther)
 	IDIterate    = ID(0x68<<KeyShift | FlagsOther)
+	IDDec        = ID(0x69<<KeyShift | FlagsOther)
+	IDSwitch     = ID(0x6A<<KeyShift | FlagsOther)
+	IDCase       = ID(0x6B<<KeyShift | FlagsOther)
+	IDEnum       = ID(0x6C<<KeyShift | FlagsOther)
+	IDUnion      = ID(0x6D<<KeyShift | FlagsOther)
 
 	IDFalse = ID(0x70<<KeyShift | FlagsLiteral | FlagsImplicitSemicolon)
 	IDTrue  = ID(0x71<<KeyShift | FlagsLiteral | FlagsImplicitSemicolon)
@@ -441,21 +506,22 @@ const (
 	IDIn         = ID(0x7A<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 	IDOut        = ID(0x7B<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 
-	IDI8      = ID(0x80<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDI16     = ID(0x81<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDI32     = ID(0x82<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDI64     = ID(0x83<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDU8      = ID(0x84<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDU16     = ID(0x85<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDU32     = ID(0x86<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDU64     = ID(0x87<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDUsize   = ID(0x88<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
-	IDBool    = ID(0x89<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
-	IDBuf1    = ID(0x8A<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
-	IDReader1 = ID(0x8B<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
-	IDWriter1 = ID(0x8C<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
-	IDBuf2    = ID(0x8D<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
-	IDStatus  = ID(0x8E<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDI8        = ID(0x80<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDI16       = ID(0x81<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDI32       = ID(0x82<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDI64       = ID(0x83<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDU8        = ID(0x84<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDU16       = ID(0x85<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDU32       = ID(0x86<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDU64       = ID(0x87<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDUsize     = ID(0x88<<KeyShift | FlagsIdent | FlagsImplicitSemicolon | FlagsNumType)
+	IDBool      = ID(0x89<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDBuf1      = ID(0x8A<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDReader1   = ID(0x8B<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDWriter1   = ID(0x8C<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDBuf2      = ID(0x8D<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDStatus    = ID(0x8E<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDBitreader = ID(0x8F<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 
 	IDMark       = ID(0x90<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 	IDReadU8     = ID(0x91<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
@@ -492,6 +558,24 @@ const (
 	IDHighBits          = ID(0xAF<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 	IDUnreadU8          = ID(0xB0<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 	IDIsMarked          = ID(0xB1<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDReadMatch         = ID(0xB2<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDKind              = ID(0xB3<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDWidth             = ID(0xB4<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDHeight            = ID(0xB5<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDRow               = ID(0xB6<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDSetU8             = ID(0xB7<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDPixelFormat       = ID(0xB8<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDClz               = ID(0xB9<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDCtz               = ID(0xBA<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDPopcount          = ID(0xBB<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDReverseBits       = ID(0xBC<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDRotateLeft        = ID(0xBD<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDByteSwap          = ID(0xBE<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDNBits             = ID(0xBF<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDPeekBits          = ID(0xC0<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDTakeBits          = ID(0xC1<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDRefill            = ID(0xC2<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
+	IDSetMSBFirst       = ID(0xC3<<KeyShift | FlagsIdent | FlagsImplicitSemicolon)
 )
 
 // The IDXFoo IDs are not returned by the tokenizer. They are used by the
@@ -528,13 +612,20 @@ const (
 	IDXBinaryAs          = ID(0xEB<<KeyShift | FlagsBinaryOp)
 	IDXBinaryTildePlus   = ID(0xEC<<KeyShift | FlagsBinaryOp)
 
-	IDXAssociativePlus = ID(0xF0<<KeyShift | FlagsAssociativeOp)
-	IDXAssociativeStar = ID(0xF1<<KeyShift | FlagsAssociativeOp)
-	IDXAssociativeAmp  = ID(0xF2<<KeyShift | FlagsAssociativeOp)
-	IDXAssociativePipe = ID(0xF3<<KeyShift | FlagsAssociativeOp)
-	IDXAssociativeHat  = ID(0xF4<<KeyShift | FlagsAssociativeOp)
-	IDXAssociativeAnd  = ID(0xF5<<KeyShift | FlagsAssociativeOp)
-	IDXAssociativeOr   = ID(0xF6<<KeyShift | FlagsAssociativeOp)
+	IDXBinaryTildeMinus    = ID(0xED<<KeyShift | FlagsBinaryOp)
+	IDXBinaryTildeStar     = ID(0xEE<<KeyShift | FlagsBinaryOp)
+	IDXBinaryTildeShiftL   = ID(0xEF<<KeyShift | FlagsBinaryOp)
+	IDXBinaryTildeSatPlus  = ID(0xF0<<KeyShift | FlagsBinaryOp)
+	IDXBinaryTildeSatMinus = ID(0xF1<<KeyShift | FlagsBinaryOp)
+	IDXBinaryTildeAs       = ID(0xF2<<KeyShift | FlagsBinaryOp)
+
+	IDXAssociativePlus = ID(0xF8<<KeyShift | FlagsAssociativeOp)
+	IDXAssociativeStar = ID(0xF9<<KeyShift | FlagsAssociativeOp)
+	IDXAssociativeAmp  = ID(0xFA<<KeyShift | FlagsAssociativeOp)
+	IDXAssociativePipe = ID(0xFB<<KeyShift | FlagsAssociativeOp)
+	IDXAssociativeHat  = ID(0xFC<<KeyShift | FlagsAssociativeOp)
+	IDXAssociativeAnd  = ID(0xFD<<KeyShift | FlagsAssociativeOp)
+	IDXAssociativeOr   = ID(0xFE<<KeyShift | FlagsAssociativeOp)
 )
 
 var builtInsByKey = [nBuiltInKeys]struct {
@@ -582,6 +673,12 @@ var builtInsByKey = [nBuiltInKeys]struct {
 	KeyPercentEq:   {"%=", IDPercentEq},
 	KeyTildePlusEq: {"~+=", IDTildePlusEq},
 
+	KeyTildeMinusEq:    {"~-=", IDTildeMinusEq},
+	KeyTildeStarEq:     {"~*=", IDTildeStarEq},
+	KeyTildeShiftLEq:   {"~<<=", IDTildeShiftLEq},
+	KeyTildeSatPlusEq:  {"~sat+=", IDTildeSatPlusEq},
+	KeyTildeSatMinusEq: {"~sat-=", IDTildeSatMinusEq},
+
 	KeyPlus:      {"+", IDPlus},
 	KeyMinus:     {"-", IDMinus},
 	KeyStar:      {"*", IDStar},
@@ -593,7 +690,14 @@ var builtInsByKey = [nBuiltInKeys]struct {
 	KeyPipe:      {"|", IDPipe},
 	KeyHat:       {"^", IDHat},
 	KeyPercent:   {"%", IDPercent},
-	KeyTildePlus: {"~+", IDPercent},
+	KeyTildePlus: {"~+", IDTildePlus},
+
+	KeyTildeMinus:    {"~-", IDTildeMinus},
+	KeyTildeStar:     {"~*", IDTildeStar},
+	KeyTildeShiftL:   {"~<<", IDTildeShiftL},
+	KeyTildeSatPlus:  {"~sat+", IDTildeSatPlus},
+	KeyTildeSatMinus: {"~sat-", IDTildeSatMinus},
+	KeyTildeAs:       {"~as", IDTildeAs},
 
 	KeyNotEq:       {"!=", IDNotEq},
 	KeyLessThan:    {"<", IDLessThan},
@@ -602,11 +706,10 @@ var builtInsByKey = [nBuiltInKeys]struct {
 	KeyGreaterEq:   {">=", IDGreaterEq},
 	KeyGreaterThan: {">", IDGreaterThan},
 
-	KeyAnd: {"and", IDAnd},
-	KeyOr:  {"or", IDOr},
-	KeyNot: {"not", IDNot},
-	KeyAs:  {"as", IDAs},
-	// TODO: do we need a "~as" form of the as operator, similar to "~+".
+	KeyAnd:   {"and", IDAnd},
+	KeyOr:    {"or", IDOr},
+	KeyNot:   {"not", IDNot},
+	KeyAs:    {"as", IDAs},
 	KeyRef:   {"ref", IDRef},
 	KeyDeref: {"deref", IDDeref},
 
@@ -635,6 +738,11 @@ var builtInsByKey = [nBuiltInKeys]struct {
 	KeyConst:      {"const", IDConst},
 	KeyTry:        {"try", IDTry},
 	KeyIterate:    {"iterate", IDIterate},
+	KeyDec:        {"dec", IDDec},
+	KeySwitch:     {"switch", IDSwitch},
+	KeyCase:       {"case", IDCase},
+	KeyEnum:       {"enum", IDEnum},
+	KeyUnion:      {"union", IDUnion},
 
 	KeyFalse: {"false", IDFalse},
 	KeyTrue:  {"true", IDTrue},
@@ -647,21 +755,22 @@ var builtInsByKey = [nBuiltInKeys]struct {
 
 	// Change MaxIntBits if a future update adds an i128 or u128 type.
 
-	KeyI8:      {"i8", IDI8},
-	KeyI16:     {"i16", IDI16},
-	KeyI32:     {"i32", IDI32},
-	KeyI64:     {"i64", IDI64},
-	KeyU8:      {"u8", IDU8},
-	KeyU16:     {"u16", IDU16},
-	KeyU32:     {"u32", IDU32},
-	KeyU64:     {"u64", IDU64},
-	KeyUsize:   {"usize", IDUsize},
-	KeyBool:    {"bool", IDBool},
-	KeyBuf1:    {"buf1", IDBuf1},
-	KeyReader1: {"reader1", IDReader1},
-	KeyWriter1: {"writer1", IDWriter1},
-	KeyBuf2:    {"buf2", IDBuf2},
-	KeyStatus:  {"status", IDStatus},
+	KeyI8:        {"i8", IDI8},
+	KeyI16:       {"i16", IDI16},
+	KeyI32:       {"i32", IDI32},
+	KeyI64:       {"i64", IDI64},
+	KeyU8:        {"u8", IDU8},
+	KeyU16:       {"u16", IDU16},
+	KeyU32:       {"u32", IDU32},
+	KeyU64:       {"u64", IDU64},
+	KeyUsize:     {"usize", IDUsize},
+	KeyBool:      {"bool", IDBool},
+	KeyBuf1:      {"buf1", IDBuf1},
+	KeyReader1:   {"reader1", IDReader1},
+	KeyWriter1:   {"writer1", IDWriter1},
+	KeyBuf2:      {"buf2", IDBuf2},
+	KeyStatus:    {"status", IDStatus},
+	KeyBitreader: {"bitreader", IDBitreader},
 
 	KeyMark:       {"mark", IDMark},
 	KeyReadU8:     {"read_u8", IDReadU8},
@@ -698,6 +807,24 @@ var builtInsByKey = [nBuiltInKeys]struct {
 	KeyHighBits:          {"high_bits", IDHighBits},
 	KeyUnreadU8:          {"unread_u8", IDUnreadU8},
 	KeyIsMarked:          {"is_marked", IDIsMarked},
+	KeyReadMatch:         {"read_match", IDReadMatch},
+	KeyKind:              {"kind", IDKind},
+	KeyWidth:             {"width", IDWidth},
+	KeyHeight:            {"height", IDHeight},
+	KeyRow:               {"row", IDRow},
+	KeySetU8:             {"set_u8", IDSetU8},
+	KeyPixelFormat:       {"pixel_format", IDPixelFormat},
+	KeyClz:               {"clz", IDClz},
+	KeyCtz:               {"ctz", IDCtz},
+	KeyPopcount:          {"popcount", IDPopcount},
+	KeyReverseBits:       {"reverse_bits", IDReverseBits},
+	KeyRotateLeft:        {"rotate_left", IDRotateLeft},
+	KeyByteSwap:          {"byte_swap", IDByteSwap},
+	KeyNBits:             {"n_bits", IDNBits},
+	KeyPeekBits:          {"peek_bits", IDPeekBits},
+	KeyTakeBits:          {"take_bits", IDTakeBits},
+	KeyRefill:            {"refill", IDRefill},
+	KeySetMSBFirst:       {"set_msb_first", IDSetMSBFirst},
 }
 
 var builtInsByName = map[string]ID{}
@@ -796,8 +923,19 @@ var lexers = [256][]suffixLexer{
 		{"", IDGreaterThan},
 	},
 	'~': {
+		{"sat+=", IDTildeSatPlusEq},
+		{"sat+", IDTildeSatPlus},
+		{"sat-=", IDTildeSatMinusEq},
+		{"sat-", IDTildeSatMinus},
+		{"<<=", IDTildeShiftLEq},
+		{"<<", IDTildeShiftL},
 		{"+=", IDTildePlusEq},
 		{"+", IDTildePlus},
+		{"-=", IDTildeMinusEq},
+		{"-", IDTildeMinus},
+		{"*=", IDTildeStarEq},
+		{"*", IDTildeStar},
+		{"as", IDTildeAs},
 	},
 }
 
@@ -830,6 +968,13 @@ var ambiguousForms = [256]ID{
 	KeyXBinaryAs:          IDAs,
 	KeyXBinaryTildePlus:   IDTildePlus,
 
+	KeyXBinaryTildeMinus:    IDTildeMinus,
+	KeyXBinaryTildeStar:     IDTildeStar,
+	KeyXBinaryTildeShiftL:   IDTildeShiftL,
+	KeyXBinaryTildeSatPlus:  IDTildeSatPlus,
+	KeyXBinaryTildeSatMinus: IDTildeSatMinus,
+	KeyXBinaryTildeAs:       IDTildeAs,
+
 	KeyXAssociativePlus: IDPlus,
 	KeyXAssociativeStar: IDStar,
 	KeyXAssociativeAmp:  IDAmp,
@@ -861,6 +1006,12 @@ var binaryForms = [256]ID{
 	KeyPercentEq:   IDXBinaryPercent,
 	KeyTildePlusEq: IDXBinaryTildePlus,
 
+	KeyTildeMinusEq:    IDXBinaryTildeMinus,
+	KeyTildeStarEq:     IDXBinaryTildeStar,
+	KeyTildeShiftLEq:   IDXBinaryTildeShiftL,
+	KeyTildeSatPlusEq:  IDXBinaryTildeSatPlus,
+	KeyTildeSatMinusEq: IDXBinaryTildeSatMinus,
+
 	KeyPlus:        IDXBinaryPlus,
 	KeyMinus:       IDXBinaryMinus,
 	KeyStar:        IDXBinaryStar,
@@ -882,6 +1033,13 @@ var binaryForms = [256]ID{
 	KeyOr:          IDXBinaryOr,
 	KeyAs:          IDXBinaryAs,
 	KeyTildePlus:   IDXBinaryTildePlus,
+
+	KeyTildeMinus:    IDXBinaryTildeMinus,
+	KeyTildeStar:     IDXBinaryTildeStar,
+	KeyTildeShiftL:   IDXBinaryTildeShiftL,
+	KeyTildeSatPlus:  IDXBinaryTildeSatPlus,
+	KeyTildeSatMinus: IDXBinaryTildeSatMinus,
+	KeyTildeAs:       IDXBinaryTildeAs,
 }
 
 var associativeForms = [256]ID{
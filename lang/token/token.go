@@ -24,11 +24,126 @@ const (
 	maxTokenSize = 1023
 )
 
+// Unescape returns the bytes denoted by a double-quoted string literal, such
+// as `"GIF8\x39a"`. The escape sequences are `\"`, `\\`, `\a`, `\b`, `\f`,
+// `\n`, `\r`, `\t`, `\v` and `\xHH` for exactly two hexadecimal digits.
 func Unescape(s string) (unescaped string, ok bool) {
 	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
 		return "", false
 	}
-	return s[1 : len(s)-1], true
+	s = s[1 : len(s)-1]
+	buf := []byte(nil)
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c == '"' {
+			return "", false
+		} else if c != '\\' {
+			buf = append(buf, c)
+			i++
+			continue
+		}
+		x, n := unescape1(s[i:])
+		if n == 0 {
+			return "", false
+		}
+		buf = append(buf, x)
+		i += n
+	}
+	return string(buf), true
+}
+
+// unescape1 decodes the escape sequence at the start of s, which starts with a
+// backslash. It returns the decoded byte and the length of that sequence, or a
+// zero length if s does not start with a valid escape sequence.
+func unescape1(s string) (x byte, n int) {
+	if len(s) < 2 {
+		return 0, 0
+	}
+	if s[1] == 'x' {
+		if len(s) < 4 || !hexaNumeric(s[2]) || !hexaNumeric(s[3]) {
+			return 0, 0
+		}
+		return hexValue(s[2])<<4 | hexValue(s[3]), 4
+	}
+	if x = unescapes[s[1]]; x == 0 {
+		return 0, 0
+	}
+	return x, 2
+}
+
+// Escape is the inverse of Unescape. It returns the canonical double-quoted
+// string literal for the bytes s: printable ASCII is unchanged, other than
+// `"` and `\`, and other bytes use the named escapes such as `\n` where
+// available and `\xHH`, with upper case hexadecimal digits, otherwise.
+func Escape(s string) string {
+	buf := make([]byte, 0, len(s)+2)
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' {
+			buf = append(buf, '\\', c)
+		} else if ' ' <= c && c <= '~' {
+			buf = append(buf, c)
+		} else if e := escapes[c]; e != 0 {
+			buf = append(buf, '\\', e)
+		} else {
+			buf = append(buf, '\\', 'x', upperHex[c>>4], upperHex[c&15])
+		}
+	}
+	buf = append(buf, '"')
+	return string(buf)
+}
+
+const upperHex = "0123456789ABCDEF"
+
+var unescapes = [256]byte{
+	'"':  '"',
+	'\\': '\\',
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+}
+
+var escapes = [256]byte{
+	'\a': 'a',
+	'\b': 'b',
+	'\f': 'f',
+	'\n': 'n',
+	'\r': 'r',
+	'\t': 't',
+	'\v': 'v',
+}
+
+// canonicalNumber returns the canonical form of a numeric literal, such as
+// "0xABCD_EF01" for "0Xabcd_ef01": the base prefix is lower case and any
+// hexadecimal digits are upper case. An '_' digit separator is only valid
+// between two digits. It returns false if s is not a valid numeric literal.
+func canonicalNumber(s []byte, isDigit func(byte) bool) (string, bool) {
+	buf := append([]byte(nil), s...)
+	digits := buf
+	if len(buf) >= 2 && buf[0] == '0' && (buf[1] == 'x' || buf[1] == 'X' || buf[1] == 'b' || buf[1] == 'B') {
+		buf[1] |= 0x20
+		digits = buf[2:]
+	}
+	if len(digits) == 0 {
+		return "", false
+	}
+	for i, c := range digits {
+		if c == '_' {
+			if i == 0 || i == len(digits)-1 || digits[i-1] == '_' {
+				return "", false
+			}
+		} else if !isDigit(c) {
+			return "", false
+		} else if 'a' <= c && c <= 'f' {
+			digits[i] = c - 0x20
+		}
+	}
+	return string(buf), true
 }
 
 type Map struct {
@@ -104,10 +219,21 @@ func hexaNumeric(c byte) bool {
 	return ('A' <= c && c <= 'F') || ('a' <= c && c <= 'f') || ('0' <= c && c <= '9')
 }
 
+func binaryNumeric(c byte) bool {
+	return (c == '0') || (c == '1')
+}
+
 func numeric(c byte) bool {
 	return ('0' <= c && c <= '9')
 }
 
+func hexValue(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return (c | 0x20) - ('a' - 10)
+}
+
 func hasPrefix(a []byte, s string) bool {
 	if len(s) == 0 {
 		return true
@@ -144,9 +270,6 @@ loop:
 			continue
 		}
 
-		// TODO: recognize escapes such as `\t`, `\"` and `\\`. For now, we
-		// assume that strings don't contain control bytes or backslashes.
-		// Neither should be necessary to parse `use "foo/bar"` lines.
 		if c == '"' {
 			j := i + 1
 			for ; j < len(src); j++ {
@@ -156,20 +279,36 @@ loop:
 					break
 				}
 				if c == '\\' {
-					return nil, nil, fmt.Errorf("token: backslash in string at %s:%d", filename, line)
-				}
-				if c == '\n' {
+					k := j + 4
+					if k > len(src) {
+						k = len(src)
+					}
+					_, n := unescape1(string(src[j:k]))
+					if n == 0 {
+						return nil, nil, fmt.Errorf("token: invalid escape in string at %s:%d", filename, line)
+					}
+					j += n - 1
+				} else if c == '\n' {
 					return nil, nil, fmt.Errorf("token: expected final '\"' in string at %s:%d", filename, line)
-				}
-				if c < ' ' {
+				} else if c < ' ' {
 					return nil, nil, fmt.Errorf("token: control character in string at %s:%d", filename, line)
 				}
 				// The -1 is because we still haven't seen the final '"'.
-				if j-i == maxTokenSize-1 {
+				if j-i >= maxTokenSize-1 {
 					return nil, nil, fmt.Errorf("token: string too long at %s:%d", filename, line)
 				}
 			}
-			id, err := m.Insert(string(src[i:j]))
+			// Equivalent string literals, such as "\x41" and "A", are inserted
+			// in their canonical form, so that they share the same ID.
+			unescaped, ok := Unescape(string(src[i:j]))
+			if !ok {
+				return nil, nil, fmt.Errorf("token: expected final '\"' in string at %s:%d", filename, line)
+			}
+			s := Escape(unescaped)
+			if len(s) > maxTokenSize {
+				return nil, nil, fmt.Errorf("token: string too long at %s:%d", filename, line)
+			}
+			id, err := m.Insert(s)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -195,23 +334,26 @@ loop:
 		}
 
 		if numeric(c) {
-			// TODO: 0b11 binary numbers.
-			//
-			// TODO: allow underscores like 0b1000_0000_1111?
 			j, isDigit := i+1, numeric
 			if c == '0' && j < len(src) {
 				if next := src[j]; next == 'x' || next == 'X' {
 					j, isDigit = j+1, hexaNumeric
-				} else if numeric(next) {
+				} else if next == 'b' || next == 'B' {
+					j, isDigit = j+1, binaryNumeric
+				} else if numeric(next) || next == '_' {
 					return nil, nil, fmt.Errorf("token: legacy octal syntax at %s:%d", filename, line)
 				}
 			}
-			for ; j < len(src) && isDigit(src[j]); j++ {
+			for ; j < len(src) && alphaNumeric(src[j]); j++ {
 				if j-i == maxTokenSize {
 					return nil, nil, fmt.Errorf("token: constant too long at %s:%d", filename, line)
 				}
 			}
-			id, err := m.Insert(string(src[i:j]))
+			s, ok := canonicalNumber(src[i:j], isDigit)
+			if !ok {
+				return nil, nil, fmt.Errorf("token: invalid constant %q at %s:%d", src[i:j], filename, line)
+			}
+			id, err := m.Insert(s)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -0,0 +1,91 @@
+// Copyright 2017 The Puffs Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token_test
+
+import (
+	"testing"
+
+	"github.com/google/puffs/lang/token"
+)
+
+func TestLiterals(t *testing.T) {
+	testCases := []struct {
+		src  string
+		want string // The canonical form, or "" if src is invalid.
+	}{
+		{`0`, `0`},
+		{`1_000`, `1_000`},
+		{`0b1010`, `0b1010`},
+		{`0B1000_0000`, `0b1000_0000`},
+		{`0xabCD_ef01`, `0xABCD_EF01`},
+		{`0X7F`, `0x7F`},
+
+		{`0755`, ``},
+		{`0_1`, ``},
+		{`1__0`, ``},
+		{`1_`, ``},
+		{`0x`, ``},
+		{`0x_1`, ``},
+		{`0b102`, ``},
+		{`0x1G`, ``},
+		{`12ab`, ``},
+
+		{`""`, `""`},
+		{`"abc"`, `"abc"`},
+		{`"\x41\x62c"`, `"Abc"`},
+		{`"\x89PNG\r\n\x1a\n"`, `"\x89PNG\r\n\x1A\n"`},
+		{`"a\"b\\c"`, `"a\"b\\c"`},
+		{`"\a\b\f\t\v\x00\x7f"`, `"\a\b\f\t\v\x00\x7F"`},
+
+		{`"\q"`, ``},
+		{`"\x4"`, ``},
+		{`"\x4g"`, ``},
+		{`"abc`, ``},
+		{`"abc\"`, ``},
+		{"\"a\tb\"", ``},
+	}
+
+	for _, tc := range testCases {
+		tm := &token.Map{}
+		tokens, _, err := token.Tokenize(tm, "test.puffs", []byte(tc.src))
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: Tokenize: got nil error, want non-nil", tc.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Tokenize: %v", tc.src, err)
+			continue
+		}
+		if len(tokens) != 1 {
+			t.Errorf("%s: got %d tokens, want 1", tc.src, len(tokens))
+			continue
+		}
+		if got := tm.ByToken(tokens[0]); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		s := string([]byte{byte(i), 'a', byte(255 - i)})
+		got, ok := token.Unescape(token.Escape(s))
+		if !ok || got != s {
+			t.Errorf("%q: round trip: got %q, %t", s, got, ok)
+		}
+	}
+}
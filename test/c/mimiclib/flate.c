@@ -48,8 +48,12 @@ const char* mimic_bench_crc32(puffs_base__buf1* dst,
 
 unsigned int mimic_flate_read_func(void* ctx, unsigned char** buf) {
   puffs_base__buf1* src = (puffs_base__buf1*)(ctx);
+  // Hand over all of the remaining input, at most once, so that a truncated
+  // stream is an error instead of re-reading the same bytes.
   *buf = src->ptr + src->ri;
-  return src->wi - src->ri;
+  unsigned int n = src->wi - src->ri;
+  src->ri = src->wi;
+  return n;
 }
 
 int mimic_flate_write_func(void* ctx, unsigned char* ptr, unsigned int len) {
@@ -86,13 +90,14 @@ const char* mimic_flate_decode(puffs_base__buf1* dst,
     goto cleanup1;
   }
 
-  size_t readable = src->wi - src->ri;
+  // Give back what mimic_flate_read_func handed over but inflateBack did not
+  // consume.
   size_t r_remaining = z.avail_in;
-  if (readable < r_remaining) {
+  if (src->ri < r_remaining) {
     ret = "inconsistent avail_in";
     goto cleanup1;
   }
-  src->ri += readable - r_remaining;
+  src->ri -= r_remaining;
 
 cleanup1:;
   int ibe_err = inflateBackEnd(&z);
@@ -104,21 +109,18 @@ cleanup0:;
   return ret;
 }
 
-const char* mimic_gzip_zlib_decode(puffs_base__buf1* dst,
-                                   puffs_base__buf1* src,
-                                   uint64_t wlimit,
-                                   uint64_t rlimit,
-                                   bool gzip_instead_of_zlib) {
+// mimic_inflate_decode decodes with zlib's inflate, whose window_bits argument
+// selects between the raw flate, gzip and zlib formats. See inflateInit2 in
+// the zlib manual, or in zlib.h, for details about its magic constants.
+const char* mimic_inflate_decode(puffs_base__buf1* dst,
+                                 puffs_base__buf1* src,
+                                 uint64_t wlimit,
+                                 uint64_t rlimit,
+                                 int window_bits) {
   // TODO: don't ignore wlimit and rlimit.
   const char* ret = NULL;
 
   z_stream z = {0};
-  int window_bits = 15;
-  if (gzip_instead_of_zlib) {
-    // See inflateInit2 in the zlib manual, or in zlib.h, for details about
-    // this magic constant.
-    window_bits |= 16;
-  }
   int ii2_err = inflateInit2(&z, window_bits);
   if (ii2_err != Z_OK) {
     ret = "inflateInit2 failed";
@@ -166,12 +168,23 @@ const char* mimic_gzip_decode(puffs_base__buf1* dst,
                               puffs_base__buf1* src,
                               uint64_t wlimit,
                               uint64_t rlimit) {
-  return mimic_gzip_zlib_decode(dst, src, wlimit, rlimit, true);
+  return mimic_inflate_decode(dst, src, wlimit, rlimit, 15 | 16);
 }
 
 const char* mimic_zlib_decode(puffs_base__buf1* dst,
                               puffs_base__buf1* src,
                               uint64_t wlimit,
                               uint64_t rlimit) {
-  return mimic_gzip_zlib_decode(dst, src, wlimit, rlimit, false);
+  return mimic_inflate_decode(dst, src, wlimit, rlimit, 15);
+}
+
+// mimic_strict_flate_decode is like mimic_flate_decode, but uses inflate
+// instead of inflateBack. inflateBack does not track how much of its window
+// has been written, so it accepts some distances that reach back before the
+// start of the output, which inflate (and Puffs) reject as invalid.
+const char* mimic_strict_flate_decode(puffs_base__buf1* dst,
+                                      puffs_base__buf1* src,
+                                      uint64_t wlimit,
+                                      uint64_t rlimit) {
+  return mimic_inflate_decode(dst, src, wlimit, rlimit, -15);
 }
@@ -192,12 +192,12 @@ const char* puffs_flate_decode(puffs_base__buf1* dst,
   while (true) {
     puffs_base__writer1 dst_writer = {.buf = dst};
     if (wlimit) {
-      wlim = wlimit;
+      wlim = io_limit(wlimit);
       dst_writer.private_impl.limit.ptr_to_len = &wlim;
     }
     puffs_base__reader1 src_reader = {.buf = src};
     if (rlimit) {
-      rlim = rlimit;
+      rlim = io_limit(rlimit);
       src_reader.private_impl.limit.ptr_to_len = &rlim;
     }
 
@@ -227,12 +227,12 @@ const char* puffs_zlib_decode(puffs_base__buf1* dst,
   while (true) {
     puffs_base__writer1 dst_writer = {.buf = dst};
     if (wlimit) {
-      wlim = wlimit;
+      wlim = io_limit(wlimit);
       dst_writer.private_impl.limit.ptr_to_len = &wlim;
     }
     puffs_base__reader1 src_reader = {.buf = src};
     if (rlimit) {
-      rlim = rlimit;
+      rlim = io_limit(rlimit);
       src_reader.private_impl.limit.ptr_to_len = &rlim;
     }
 
@@ -940,7 +940,20 @@ proc benches[] = {
     NULL,
 };
 
+// Differentials are only run by "puffs test -differential", which requires the
+// mimic libraries.
+differential differentials[] = {
+#ifdef PUFFS_MIMIC
+
+    {"flate_decode", puffs_flate_decode, mimic_strict_flate_decode},  //
+    {"zlib_decode", puffs_zlib_decode, mimic_zlib_decode},            //
+
+#endif  // PUFFS_MIMIC
+
+    {NULL, NULL, NULL},
+};
+
 int main(int argc, char** argv) {
   proc_filename = "std/flate.c";
-  return test_main(argc, argv, tests, benches);
+  return test_main(argc, argv, tests, benches, differentials);
 }
@@ -79,6 +79,61 @@ void test_basic_bad_receiver() {
   }
 }
 
+// The buf2 tests check the puffs_base__buf2 functions that Puffs code's
+// "b.row(y:y)" and "b.set_u8(x:x, y:y, v:v)" calls are generated as. The rows
+// are narrower than the stride, and the bytes between them should be left
+// alone.
+
+void test_basic_buf2_row() {
+  CHECK_FOCUS(__func__);
+  uint8_t data[12] = {0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11};
+  puffs_base__buf2 b = ((puffs_base__buf2){
+      .ptr = data,
+      .width = 3,
+      .height = 3,
+      .stride = 4,
+  });
+  size_t y;
+  for (y = 0; y < b.height; y++) {
+    puffs_base__slice_u8 row = puffs_base__buf2__row(b, y);
+    if (row.len != 3) {
+      FAIL("row %zu: len: got %zu, want %d", y, row.len, 3);
+      return;
+    }
+    if (row.ptr[0] != 4 * y) {
+      FAIL("row %zu: ptr[0]: got %d, want %zu", y, row.ptr[0], 4 * y);
+      return;
+    }
+  }
+}
+
+void test_basic_buf2_set_u8() {
+  CHECK_FOCUS(__func__);
+  uint8_t data[12] = {0};
+  puffs_base__buf2 b = ((puffs_base__buf2){
+      .ptr = data,
+      .width = 3,
+      .height = 3,
+      .stride = 4,
+  });
+  size_t x;
+  size_t y;
+  for (y = 0; y < b.height; y++) {
+    for (x = 0; x < b.width; x++) {
+      puffs_base__buf2__set_u8(b, x, y, 0x10 * y + x + 1);
+    }
+  }
+  const char* want = "\x01\x02\x03\x00\x11\x12\x13\x00\x21\x22\x23\x00";
+  size_t i;
+  for (i = 0; i < 12; i++) {
+    if (data[i] != (uint8_t)(want[i])) {
+      FAIL("data[%zu]: got 0x%02X, want 0x%02X", i, data[i],
+           (uint8_t)(want[i]));
+      return;
+    }
+  }
+}
+
 void test_basic_initializer_not_called() {
   CHECK_FOCUS(__func__);
   puffs_gif__lzw_decoder dec = {{0}};
@@ -191,12 +246,12 @@ void test_basic_sub_struct_initializer() {
 
 // ---------------- LZW Tests
 
-bool do_test_puffs_gif_lzw_decode(const char* src_filename,
-                                  uint64_t src_size,
-                                  const char* want_filename,
-                                  uint64_t want_size,
-                                  uint64_t wlimit,
-                                  uint64_t rlimit) {
+bool do_test_puffs_gif_lzw_decode1(const char* src_filename,
+                                   uint64_t src_size,
+                                   const char* want_filename,
+                                   uint64_t want_size,
+                                   uint64_t wlimit,
+                                   uint64_t rlimit) {
   puffs_base__buf1 got = {.ptr = global_got_buffer, .len = BUFFER_SIZE};
   puffs_base__buf1 want = {.ptr = global_want_buffer, .len = BUFFER_SIZE};
   puffs_base__buf1 src = {.ptr = global_src_buffer, .len = BUFFER_SIZE};
@@ -234,12 +289,12 @@ bool do_test_puffs_gif_lzw_decode(const char* src_filename,
     num_iters++;
     puffs_base__writer1 got_writer = {.buf = &got};
     if (wlimit) {
-      wlim = wlimit;
+      wlim = io_limit(wlimit);
       got_writer.private_impl.limit.ptr_to_len = &wlim;
     }
     puffs_base__reader1 src_reader = {.buf = &src};
     if (rlimit) {
-      rlim = rlimit;
+      rlim = io_limit(rlimit);
       src_reader.private_impl.limit.ptr_to_len = &rlim;
     }
     size_t old_wi = got.wi;
@@ -280,7 +335,9 @@ bool do_test_puffs_gif_lzw_decode(const char* src_filename,
     }
   }
 
-  if (wlimit || rlimit) {
+  if ((wlimit == STRESS_IO_RANDOM) || (rlimit == STRESS_IO_RANDOM)) {
+    // No-op. A random limit can be large enough to need only one iteration.
+  } else if (wlimit || rlimit) {
     if (num_iters <= 1) {
       FAIL("num_iters: got %d, want > 1", num_iters);
       return false;
@@ -295,6 +352,27 @@ bool do_test_puffs_gif_lzw_decode(const char* src_filename,
   return buf1s_equal("", &got, &want);
 }
 
+bool do_test_puffs_gif_lzw_decode(const char* src_filename,
+                                  uint64_t src_size,
+                                  const char* want_filename,
+                                  uint64_t want_size,
+                                  uint64_t wlimit,
+                                  uint64_t rlimit) {
+  if (!do_test_puffs_gif_lzw_decode1(src_filename, src_size, want_filename,
+                                     want_size, wlimit, rlimit)) {
+    return false;
+  }
+  io_limits* l;
+  for (l = stress_io_next(NULL); l; l = stress_io_next(l)) {
+    if (!do_test_puffs_gif_lzw_decode1(src_filename, src_size, want_filename,
+                                       want_size, l->wlimit, l->rlimit)) {
+      stress_io_fail(l);
+      return false;
+    }
+  }
+  return true;
+}
+
 void test_puffs_gif_lzw_decode_many_big_reads() {
   CHECK_FOCUS(__func__);
   do_test_puffs_gif_lzw_decode("../../testdata/bricks-gray.indexes.giflzw",
@@ -396,11 +474,11 @@ const char* puffs_gif_decode(puffs_base__buf1* dst, puffs_base__buf1* src) {
   return NULL;
 }
 
-bool do_test_puffs_gif_decode(const char* filename,
-                              const char* palette_filename,
-                              const char* indexes_filename,
-                              uint64_t wlimit,
-                              uint64_t rlimit) {
+bool do_test_puffs_gif_decode1(const char* filename,
+                               const char* palette_filename,
+                               const char* indexes_filename,
+                               uint64_t wlimit,
+                               uint64_t rlimit) {
   puffs_base__buf1 got = {.ptr = global_got_buffer, .len = BUFFER_SIZE};
   puffs_base__buf1 src = {.ptr = global_src_buffer, .len = BUFFER_SIZE};
 
@@ -417,12 +495,12 @@ bool do_test_puffs_gif_decode(const char* filename,
     num_iters++;
     puffs_base__writer1 got_writer = {.buf = &got};
     if (wlimit) {
-      wlim = wlimit;
+      wlim = io_limit(wlimit);
       got_writer.private_impl.limit.ptr_to_len = &wlim;
     }
     puffs_base__reader1 src_reader = {.buf = &src};
     if (rlimit) {
-      rlim = rlimit;
+      rlim = io_limit(rlimit);
       src_reader.private_impl.limit.ptr_to_len = &rlim;
     }
     size_t old_wi = got.wi;
@@ -463,7 +541,9 @@ bool do_test_puffs_gif_decode(const char* filename,
     }
   }
 
-  if (wlimit || rlimit) {
+  if ((wlimit == STRESS_IO_RANDOM) || (rlimit == STRESS_IO_RANDOM)) {
+    // No-op. A random limit can be large enough to need only one iteration.
+  } else if (wlimit || rlimit) {
     if (num_iters <= 1) {
       FAIL("num_iters: got %d, want > 1", num_iters);
       return false;
@@ -503,6 +583,26 @@ bool do_test_puffs_gif_decode(const char* filename,
   return buf1s_equal("indexes ", &got, &ind_want);
 }
 
+bool do_test_puffs_gif_decode(const char* filename,
+                              const char* palette_filename,
+                              const char* indexes_filename,
+                              uint64_t wlimit,
+                              uint64_t rlimit) {
+  if (!do_test_puffs_gif_decode1(filename, palette_filename, indexes_filename,
+                                 wlimit, rlimit)) {
+    return false;
+  }
+  io_limits* l;
+  for (l = stress_io_next(NULL); l; l = stress_io_next(l)) {
+    if (!do_test_puffs_gif_decode1(filename, palette_filename,
+                                   indexes_filename, l->wlimit, l->rlimit)) {
+      stress_io_fail(l);
+      return false;
+    }
+  }
+  return true;
+}
+
 void test_puffs_gif_decode_input_is_a_gif() {
   CHECK_FOCUS(__func__);
   do_test_puffs_gif_decode("../../testdata/bricks-dither.gif",
@@ -526,6 +626,15 @@ void test_puffs_gif_decode_input_is_a_gif_many_medium_reads() {
                                   // decode_extension skip32 call.
 }
 
+void test_puffs_gif_decode_input_is_a_gif_many_tiny_reads() {
+  CHECK_FOCUS(__func__);
+  do_test_puffs_gif_decode("../../testdata/bricks-dither.gif",
+                           "../../testdata/bricks-dither.palette",
+                           "../../testdata/bricks-dither.indexes", 0,
+                           3);  // 3 tickles being in the middle of a
+                                // decode_header read_match call.
+}
+
 void test_puffs_gif_decode_input_is_a_gif_many_small_writes_reads() {
   CHECK_FOCUS(__func__);
   do_test_puffs_gif_decode("../../testdata/bricks-dither.gif",
@@ -717,6 +826,8 @@ proc tests[] = {
     // Basic Tests
     test_basic_bad_argument_out_of_range,  //
     test_basic_bad_receiver,               //
+    test_basic_buf2_row,                   //
+    test_basic_buf2_set_u8,                //
     test_basic_initializer_not_called,     //
     test_basic_puffs_version_bad,          //
     test_basic_puffs_version_good,         //
@@ -736,6 +847,7 @@ proc tests[] = {
     test_puffs_gif_decode_input_is_a_gif_many_big_reads,           //
     test_puffs_gif_decode_input_is_a_gif_many_medium_reads,        //
     test_puffs_gif_decode_input_is_a_gif_many_small_writes_reads,  //
+    test_puffs_gif_decode_input_is_a_gif_many_tiny_reads,          //
     test_puffs_gif_decode_input_is_a_png,                          //
 
 #ifdef PUFFS_MIMIC
@@ -779,7 +891,40 @@ proc benches[] = {
     NULL,
 };
 
+#ifdef PUFFS_MIMIC
+
+// These adapt the GIF decoders to the differential function type.
+
+const char* puffs_gif_decode_differential(puffs_base__buf1* dst,
+                                          puffs_base__buf1* src,
+                                          uint64_t wlimit,
+                                          uint64_t rlimit) {
+  return puffs_gif_decode(dst, src);
+}
+
+const char* mimic_gif_decode_differential(puffs_base__buf1* dst,
+                                          puffs_base__buf1* src,
+                                          uint64_t wlimit,
+                                          uint64_t rlimit) {
+  return mimic_gif_decode(dst, src);
+}
+
+#endif  // PUFFS_MIMIC
+
+// Differentials are only run by "puffs test -differential", which requires the
+// mimic libraries.
+differential differentials[] = {
+#ifdef PUFFS_MIMIC
+
+    {"gif_decode", puffs_gif_decode_differential,
+     mimic_gif_decode_differential},  //
+
+#endif  // PUFFS_MIMIC
+
+    {NULL, NULL, NULL},
+};
+
 int main(int argc, char** argv) {
   proc_filename = "std/gif.c";
-  return test_main(argc, argv, tests, benches);
+  return test_main(argc, argv, tests, benches, differentials);
 }
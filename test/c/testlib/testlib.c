@@ -14,10 +14,12 @@
 
 #include <errno.h>
 #include <inttypes.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <sys/time.h>
+#include <time.h>
 
 #define BUFFER_SIZE (64 * 1024 * 1024)
 #define PALETTE_BUFFER_SIZE (4 * 245)
@@ -41,6 +43,7 @@ const char* proc_filename = "";
 const char* proc_funcname = "";
 const char* focus = "";
 bool in_focus = false;
+bool stress_io = false;
 
 #define CHECK_FOCUS(funcname) \
   proc_funcname = funcname;   \
@@ -180,11 +183,41 @@ void bench_finish(uint64_t reps, uint64_t n_bytes) {
   fflush(stdout);
 }
 
+// PUFFS_TESTLIB_SANITIZE is defined by "puffs test -sanitize=etc", which also
+// configures the sanitizers to abort on their first finding. The sanitizer
+// prints the details, and this SIGABRT handler names the test that failed.
+#ifdef PUFFS_TESTLIB_SANITIZE
+void sanitizer_abort_handler(int sig) {
+  printf("%-16s%-8sFAIL %s: sanitizer finding (details above)\n",
+         proc_filename, cc, proc_funcname);
+  fflush(stdout);
+  // Let the abort continue, now that the handler is reset to the default.
+  signal(SIGABRT, SIG_DFL);
+}
+#endif
+
 typedef void (*proc)();
 
-int test_main(int argc, char** argv, proc* tests, proc* benches) {
+// differential is a pair of codec functions, one using Puffs and one using a
+// mimic library, that should agree on every input. It is defined below.
+typedef struct differential_struct differential;
+
+int run_differentials(differential* differentials,
+                      int argc,
+                      char** argv,
+                      int64_t millis,
+                      const char* findings);
+
+int test_main(int argc,
+              char** argv,
+              proc* tests,
+              proc* benches,
+              differential* differentials) {
   bool bench = false;
   int proc_reps = 5;
+  bool differential_mode = false;
+  int64_t differential_millis = 1000;
+  const char* findings = "";
 
   int i;
   for (i = 1; i < argc; i++) {
@@ -193,9 +226,27 @@ int test_main(int argc, char** argv, proc* tests, proc* benches) {
     if (!strcmp(arg, "-bench")) {
       bench = true;
 
+    } else if (!strcmp(arg, "-differential")) {
+      differential_mode = true;
+
+    } else if ((arg_len >= 10) && !strncmp(arg, "-findings=", 10)) {
+      findings = arg + 10;
+
     } else if ((arg_len >= 7) && !strncmp(arg, "-focus=", 7)) {
       focus = arg + 7;
 
+    } else if (!strcmp(arg, "-stress_io")) {
+      stress_io = true;
+
+    } else if ((arg_len >= 8) && !strncmp(arg, "-millis=", 8)) {
+      char* end = NULL;
+      long long int n = strtoll(arg + 8, &end, 10);
+      if (!arg[8] || *end || (n < 0)) {
+        fprintf(stderr, "invalid -millis=N value\n");
+        return 1;
+      }
+      differential_millis = n;
+
     } else if ((arg_len >= 6) && !strncmp(arg, "-reps=", 6)) {
       arg += 6;
       if (!*arg) {
@@ -214,12 +265,33 @@ int test_main(int argc, char** argv, proc* tests, proc* benches) {
       }
       proc_reps = n;
 
+    } else if (differential_mode && (arg[0] != '-')) {
+      // A seed input for run_differentials.
+
     } else {
       fprintf(stderr, "unknown flag \"%s\"\n", arg);
       return 1;
     }
   }
 
+  if (differential_mode) {
+    if (bench) {
+      fprintf(stderr, "-bench and -differential are incompatible\n");
+      return 1;
+    }
+#ifdef PUFFS_BASE_HEADER_H
+    return run_differentials(differentials, argc, argv, differential_millis,
+                             findings);
+#else
+    fprintf(stderr, "-differential requires puffs_base__buf1\n");
+    return 1;
+#endif
+  }
+
+#ifdef PUFFS_TESTLIB_SANITIZE
+  signal(SIGABRT, sanitizer_abort_handler);
+#endif
+
   proc* procs = tests;
   if (!bench) {
     proc_reps = 1;
@@ -255,6 +327,9 @@ int test_main(int argc, char** argv, proc* tests, proc* benches) {
       }
     }
   }
+  // Any further sanitizer findings, such as leaks reported at exit, are not
+  // attributable to a single test.
+  proc_funcname = "(after all tests)";
   if (bench) {
     printf("# %-16s%-8s(%d benchmarks run, 1+%d reps per benchmark)\n",
            proc_filename, cc, tests_run, proc_reps - 1);
@@ -405,6 +480,102 @@ bool buf1s_equal(const char* prefix,
 // calculating a benchmark's MB/s throughput number.
 //
 // Decoders typically use tc_dst. Encoders and hashes typically use tc_src.
+// ---------------- Stress I/O
+
+// Stress I/O testing, "puffs test -stress_io", re-runs each test, after its
+// regular run, with each of the stress_io_limits in turn, so that coroutines
+// suspend and resume at as many suspension points as possible. Each re-run
+// must produce the same output as the regular run.
+
+// STRESS_IO_RANDOM is a wlimit or rlimit value meaning a pseudo-random limit,
+// chosen afresh by io_limit for each call to the coroutine.
+#define STRESS_IO_RANDOM UINT64_MAX
+
+typedef struct {
+  uint64_t wlimit;
+  uint64_t rlimit;
+} io_limits;
+
+// stress_io_limits is terminated by a zero element, which would otherwise
+// mean no limits.
+io_limits stress_io_limits[] = {
+    {1, 1},                                //
+    {1, 0},                                //
+    {0, 1},                                //
+    {STRESS_IO_RANDOM, 0},                 //
+    {0, STRESS_IO_RANDOM},                 //
+    {STRESS_IO_RANDOM, STRESS_IO_RANDOM},  //
+    {0, 0},                                //
+};
+
+uint64_t io_limit_rng = 0;
+
+// io_limit returns the limit to use for the next call to a coroutine: limit
+// itself or, for STRESS_IO_RANDOM, a pseudo-random limit in the range
+// [1..4096], biased towards small limits.
+uint64_t io_limit(uint64_t limit) {
+  if (limit != STRESS_IO_RANDOM) {
+    return limit;
+  }
+  uint64_t x = io_limit_rng;
+  x ^= x << 13;
+  x ^= x >> 7;
+  x ^= x << 17;
+  io_limit_rng = x;
+  static const uint64_t maxes[4] = {1, 16, 256, 4096};
+  return 1 + ((x >> 2) % maxes[x & 3]);
+}
+
+// stress_io_next returns the stress_io_limits element after l, or the first
+// one if l is NULL. It returns NULL if there are no more, or if not stress
+// testing. The random limits are seeded by the test name, so that a failure
+// is reproducible.
+io_limits* stress_io_next(io_limits* l) {
+  if (!stress_io) {
+    return NULL;
+  }
+  l = l ? (l + 1) : stress_io_limits;
+  if (!l->wlimit && !l->rlimit) {
+    return NULL;
+  }
+  uint64_t h = 0xcbf29ce484222325ull;
+  const char* p;
+  for (p = proc_funcname; *p; p++) {
+    h ^= (uint8_t)(*p);
+    h *= 0x100000001b3ull;
+  }
+  io_limit_rng = h ? h : 1;
+  return l;
+}
+
+void stress_io_format_limit(char* buf, size_t len, uint64_t limit) {
+  if (limit == STRESS_IO_RANDOM) {
+    snprintf(buf, len, "random");
+  } else if (limit == 0) {
+    snprintf(buf, len, "none");
+  } else {
+    snprintf(buf, len, "%" PRIu64, limit);
+  }
+}
+
+// stress_io_fail prefixes fail_msg with the limits that the test failed with.
+void stress_io_fail(io_limits* l) {
+  char w[32];
+  char r[32];
+  stress_io_format_limit(w, sizeof(w), l->wlimit);
+  stress_io_format_limit(r, sizeof(r), l->rlimit);
+  char prefix[128];
+  int n = snprintf(prefix, sizeof(prefix), "stress_io (wlimit=%s, rlimit=%s): ",
+                   w, r);
+  size_t old_len = strlen(fail_msg);
+  if (old_len + n >= sizeof(fail_msg)) {
+    old_len = sizeof(fail_msg) - n - 1;
+  }
+  memmove(fail_msg + n, fail_msg, old_len);
+  memmove(fail_msg, prefix, n);
+  fail_msg[n + old_len] = 0;
+}
+
 typedef enum {
   tc_neither = 0,
   tc_dst = 1,
@@ -489,6 +660,24 @@ bool proc_buf1_buf1(const char* (*codec_func)(puffs_base__buf1*,
   if (!buf1s_equal("", &got, &want)) {
     return false;
   }
+
+  // The regular run's output, got, matches want, so comparing the stress
+  // I/O runs' output with want also compares it with the regular run.
+  io_limits* l;
+  for (l = stress_io_next(NULL); l; l = stress_io_next(l)) {
+    got.wi = 0;
+    src.ri = gt->src_offset0;
+    const char* s = codec_func(&got, &src, l->wlimit, l->rlimit);
+    if (s) {
+      FAIL("%s", s);
+      stress_io_fail(l);
+      return false;
+    }
+    if (!buf1s_equal("", &got, &want)) {
+      stress_io_fail(l);
+      return false;
+    }
+  }
   return true;
 }
 
@@ -514,4 +703,242 @@ bool do_test_buf1_buf1(const char* (*codec_func)(puffs_base__buf1*,
   return proc_buf1_buf1(codec_func, tc_neither, gt, wlimit, rlimit, 1, false);
 }
 
+// ---------------- Differential Testing
+
+// Differential testing, "puffs test -differential", runs both functions of
+// each differential on the same inputs: first each named file, unchanged, and
+// then randomly mutated versions of them (or entirely random bytes) until the
+// time is up. The two functions disagree if one accepts an input that the
+// other rejects, or if both accept it but their output differs. Such an input
+// is saved to the findings directory, if given, as a repro file.
+
+struct differential_struct {
+  const char* name;
+  const char* (*puffs_func)(puffs_base__buf1*,
+                            puffs_base__buf1*,
+                            uint64_t,
+                            uint64_t);
+  const char* (*mimic_func)(puffs_base__buf1*,
+                            puffs_base__buf1*,
+                            uint64_t,
+                            uint64_t);
+};
+
+// DIFFERENTIAL_MAX_LEN is the maximum input length. Longer files are
+// truncated, which also keeps the decoded output within BUFFER_SIZE.
+#define DIFFERENTIAL_MAX_LEN (64 * 1024)
+
+uint8_t global_differential_buffer[DIFFERENTIAL_MAX_LEN];
+
+uint64_t differential_rng = 0;
+
+uint64_t differential_random() {
+  uint64_t x = differential_rng;
+  x ^= x << 13;
+  x ^= x >> 7;
+  x ^= x << 17;
+  differential_rng = x;
+  return x;
+}
+
+// differential_mutate writes a mutated copy of seed to ptr, returning its
+// length.
+size_t differential_mutate(uint8_t* ptr,
+                           const uint8_t* seed,
+                           size_t seed_len) {
+  size_t n = 0;
+  if (!seed || (differential_random() % 16 == 0)) {
+    n = differential_random() % 1024;
+    size_t i;
+    for (i = 0; i < n; i++) {
+      ptr[i] = (uint8_t)(differential_random());
+    }
+    return n;
+  }
+
+  n = seed_len;
+  memmove(ptr, seed, n);
+  int num_mutations = 1 + (differential_random() % 8);
+  int m;
+  for (m = 0; (m < num_mutations) && (n > 0); m++) {
+    size_t i = differential_random() % n;
+    switch (differential_random() % 5) {
+      case 0:  // Flip a bit.
+        ptr[i] ^= (uint8_t)(1 << (differential_random() % 8));
+        break;
+      case 1:  // Set a random byte.
+        ptr[i] = (uint8_t)(differential_random());
+        break;
+      case 2:  // Set an interesting byte.
+        ptr[i] = "\x00\x01\x7F\x80\xFF"[differential_random() % 5];
+        break;
+      case 3:  // Delete a range.
+      {
+        size_t j = i + (differential_random() % (n - i));
+        memmove(ptr + i, ptr + j, n - j);
+        n -= j - i;
+        break;
+      }
+      default:  // Truncate.
+        n = i;
+        break;
+    }
+  }
+  return n;
+}
+
+bool differential_save(const char* findings,
+                       differential* d,
+                       const uint8_t* ptr,
+                       size_t len,
+                       char* filename,
+                       size_t filename_len) {
+  if (!*findings) {
+    return false;
+  }
+  uint64_t h = 0xcbf29ce484222325ull;
+  size_t i;
+  for (i = 0; i < len; i++) {
+    h ^= ptr[i];
+    h *= 0x100000001b3ull;
+  }
+  snprintf(filename, filename_len, "%s/%s-%016" PRIx64, findings, d->name, h);
+  FILE* f = fopen(filename, "w");
+  if (!f) {
+    return false;
+  }
+  bool ok = fwrite(ptr, 1, len, f) == len;
+  return (fclose(f) == 0) && ok;
+}
+
+// differential_check runs both of d's functions on the input, returning
+// whether they agree. If not, it sets fail_msg.
+bool differential_check(differential* d,
+                        const uint8_t* ptr,
+                        size_t len,
+                        const char* findings) {
+  puffs_base__buf1 src = {.ptr = global_src_buffer, .len = BUFFER_SIZE};
+  puffs_base__buf1 got = {.ptr = global_got_buffer, .len = BUFFER_SIZE};
+  puffs_base__buf1 want = {.ptr = global_want_buffer, .len = BUFFER_SIZE};
+
+  memmove(src.ptr, ptr, len);
+  src.wi = len;
+  src.closed = true;
+  const char* got_msg = d->puffs_func(&got, &src, 0, 0);
+
+  memmove(src.ptr, ptr, len);
+  src.ri = 0;
+  const char* want_msg = d->mimic_func(&want, &src, 0, 0);
+
+  if (!got_msg != !want_msg) {
+    FAIL("puffs %s but mimic %s", got_msg ? got_msg : "accepted the input",
+         want_msg ? want_msg : "accepted the input");
+  } else if (got_msg || buf1s_equal("", &got, &want)) {
+    return true;
+  }
+
+  char filename[4096];
+  char* msg = fail_msg + strlen(fail_msg);
+  if (differential_save(findings, d, ptr, len, filename, sizeof(filename))) {
+    INCR_FAIL(msg, "\nrepro saved to %s", filename);
+  } else {
+    INCR_FAIL(msg, "\nrepro not saved (%zu bytes)", len);
+  }
+  return false;
+}
+
+int run_differentials(differential* differentials,
+                      int argc,
+                      char** argv,
+                      int64_t millis,
+                      const char* findings) {
+  int ret = 1;
+
+  // Read the seed inputs, the non-flag arguments, each truncated to
+  // DIFFERENTIAL_MAX_LEN. The extra, NULL, seed is for entirely random input.
+  const char** filenames = calloc(argc + 1, sizeof(const char*));
+  uint8_t** seeds = calloc(argc + 1, sizeof(uint8_t*));
+  size_t* seed_lens = calloc(argc + 1, sizeof(size_t));
+  int num_seeds = 0;
+  if (!filenames || !seeds || !seed_lens) {
+    fprintf(stderr, "out of memory\n");
+    goto cleanup;
+  }
+  int i;
+  for (i = 1; i < argc; i++) {
+    if (argv[i][0] == '-') {
+      continue;
+    }
+    filenames[num_seeds] = argv[i];
+    seeds[num_seeds] = malloc(DIFFERENTIAL_MAX_LEN);
+    FILE* f = fopen(argv[i], "r");
+    if (!f || !seeds[num_seeds]) {
+      fprintf(stderr, "could not read \"%s\"\n", argv[i]);
+      if (f) {
+        fclose(f);
+      }
+      num_seeds++;
+      goto cleanup;
+    }
+    seed_lens[num_seeds] = fread(seeds[num_seeds], 1, DIFFERENTIAL_MAX_LEN, f);
+    fclose(f);
+    num_seeds++;
+  }
+
+  struct timeval tv;
+  gettimeofday(&tv, NULL);
+  differential_rng = (((uint64_t)(tv.tv_sec) << 20) ^ (uint64_t)(tv.tv_usec));
+  differential_rng |= 1;
+
+  uint64_t num_inputs = 0;
+  differential* d;
+  for (d = differentials; d->name; d++) {
+    proc_funcname = d->name;
+    if (!check_focus()) {
+      continue;
+    }
+    for (i = 0; i < num_seeds; i++) {
+      num_inputs++;
+      if (!differential_check(d, seeds[i], seed_lens[i], findings)) {
+        printf("%-16s%-8sFAIL %s: %s: %s\n", proc_filename, cc, d->name,
+               filenames[i], fail_msg);
+        goto cleanup;
+      }
+    }
+    bench_start();
+    while (true) {
+      struct timeval now;
+      gettimeofday(&now, NULL);
+      int64_t elapsed =
+          (int64_t)(now.tv_sec - bench_start_tv.tv_sec) * 1000 +
+          (int64_t)(now.tv_usec - bench_start_tv.tv_usec) / 1000;
+      if (elapsed >= millis) {
+        break;
+      }
+      size_t j = differential_random() % (num_seeds + 1);
+      size_t n = differential_mutate(global_differential_buffer, seeds[j],
+                                     seed_lens[j]);
+      num_inputs++;
+      if (!differential_check(d, global_differential_buffer, n, findings)) {
+        printf("%-16s%-8sFAIL %s: %s\n", proc_filename, cc, d->name,
+               fail_msg);
+        goto cleanup;
+      }
+    }
+    tests_run++;
+  }
+  printf("%-16s%-8sPASS (%d differentials run, %" PRIu64 " inputs)\n",
+         proc_filename, cc, tests_run, num_inputs);
+  ret = 0;
+
+cleanup:
+  for (i = 0; i < num_seeds; i++) {
+    free(seeds[i]);
+  }
+  free(seed_lens);
+  free(seeds);
+  free(filenames);
+  return ret;
+}
+
 #endif  // PUFFS_BASE_HEADER_H